@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
@@ -29,12 +30,15 @@ import (
 	"github.com/sipeed/picoclaw/pkg/cron"
 	"github.com/sipeed/picoclaw/pkg/devices"
 	"github.com/sipeed/picoclaw/pkg/heartbeat"
+	"github.com/sipeed/picoclaw/pkg/hooks"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/migrate"
+	"github.com/sipeed/picoclaw/pkg/outbox"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
@@ -140,6 +144,10 @@ func main() {
 		authCmd()
 	case "cron":
 		cronCmd()
+	case "usage":
+		usageCmd()
+	case "outbox":
+		outboxCmd()
 	case "skills":
 		if len(os.Args) < 3 {
 			skillsHelp()
@@ -209,6 +217,8 @@ func printHelp() {
 	fmt.Println("  gateway     Start picoclaw gateway")
 	fmt.Println("  status      Show picoclaw status")
 	fmt.Println("  cron        Manage scheduled tasks")
+	fmt.Println("  usage       Show LLM token usage and cost")
+	fmt.Println("  outbox      Inspect and replay failed deliveries")
 	fmt.Println("  migrate     Migrate from OpenClaw to PicoClaw")
 	fmt.Println("  skills      Manage skills (install, list, remove)")
 	fmt.Println("  version     Show version information")
@@ -371,6 +381,9 @@ func migrateHelp() {
 func agentCmd() {
 	message := ""
 	sessionKey := "cli:default"
+	recordPath := ""
+	replayPath := ""
+	replayLoose := false
 
 	args := os.Args[2:]
 	for i := 0; i < len(args); i++ {
@@ -388,20 +401,58 @@ func agentCmd() {
 				sessionKey = args[i+1]
 				i++
 			}
+		case "--record":
+			if i+1 < len(args) {
+				recordPath = args[i+1]
+				i++
+			}
+		case "--replay":
+			if i+1 < len(args) {
+				replayPath = args[i+1]
+				i++
+			}
+		case "--replay-loose":
+			replayLoose = true
 		}
 	}
 
+	if recordPath != "" && replayPath != "" {
+		fmt.Println("Error: --record and --replay cannot be used together")
+		os.Exit(1)
+	}
+
 	cfg, err := loadConfig()
 	if err != nil {
 		fmt.Printf("Error loading config: %v\n", err)
 		os.Exit(1)
 	}
 
-	provider, err := providers.CreateProvider(cfg)
+	var provider providers.LLMProvider
+	if replayPath != "" {
+		var replay *providers.ReplayProvider
+		replay, err = providers.NewReplayProvider(replayPath)
+		if err == nil {
+			replay.SetStrict(!replayLoose)
+			provider = replay
+		}
+	} else {
+		provider, err = providers.CreateProvider(cfg)
+	}
 	if err != nil {
 		fmt.Printf("Error creating provider: %v\n", err)
 		os.Exit(1)
 	}
+	if recordPath != "" {
+		provider, err = providers.NewRecordingProvider(provider, recordPath)
+		if err != nil {
+			fmt.Printf("Error creating recorder: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if recordPath != "" || replayPath != "" {
+		// Routed models would bypass the cassette
+		cfg.Agents.Routing = config.RoutingConfig{}
+	}
 
 	msgBus := bus.NewMessageBus()
 	agentLoop := agent.NewAgentLoop(cfg, msgBus, provider)
@@ -538,7 +589,11 @@ func gatewayCmd() {
 		os.Exit(1)
 	}
 
-	msgBus := bus.NewMessageBus()
+	msgBus, err := newGatewayBus(cfg)
+	if err != nil {
+		fmt.Printf("Error creating message bus: %v\n", err)
+		os.Exit(1)
+	}
 	agentLoop := agent.NewAgentLoop(cfg, msgBus, provider)
 
 	// Print agent startup info
@@ -627,6 +682,9 @@ func gatewayCmd() {
 	}
 
 	fmt.Printf("✓ Gateway started on %s:%d\n", cfg.Gateway.Host, cfg.Gateway.Port)
+	if _, ok := channelManager.GetChannel("web"); ok {
+		fmt.Printf("✓ Web chat on http://%s:%d/\n", cfg.Gateway.Host, cfg.Gateway.Port)
+	}
 	fmt.Println("Press Ctrl+C to stop")
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -674,6 +732,28 @@ func gatewayCmd() {
 	fmt.Println("✓ Gateway stopped")
 }
 
+// newGatewayBus creates the message bus with the configured queue bound,
+// overflow policy, disk spool, hooks and debouncing.
+func newGatewayBus(cfg *config.Config) (*bus.MessageBus, error) {
+	opts := bus.InboundOptions{
+		Capacity:     cfg.Bus.QueueSize,
+		Overflow:     bus.OverflowPolicy(cfg.Bus.Overflow),
+		RejectNotice: cfg.Bus.RejectMessage,
+		Debounce:     cfg.Bus.Debounce.WindowFor,
+	}
+	if cfg.Bus.Spool {
+		opts.SpoolDir = filepath.Join(cfg.WorkspacePath(), "inbox")
+	}
+	msgBus, err := bus.NewMessageBusWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := hooks.Register(msgBus, cfg.Bus.Hooks); err != nil {
+		return nil, err
+	}
+	return msgBus, nil
+}
+
 func statusCmd() {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -733,6 +813,16 @@ func statusCmd() {
 			fmt.Println("vLLM/Local: not set")
 		}
 
+		registry := providers.NewRegistry(cfg)
+		for _, name := range registry.Names() {
+			if _, ok := cfg.Providers.Named[name]; !ok {
+				continue
+			}
+			if spec, ok := registry.Spec(name); ok {
+				fmt.Printf("%s (%s): ✓ %s\n", name, spec.Type, spec.APIBase)
+			}
+		}
+
 		store, _ := auth.LoadStore()
 		if store != nil && len(store.Credentials) > 0 {
 			fmt.Println("\nOAuth/Token Auth:")
@@ -1210,6 +1300,224 @@ func cronEnableCmd(storePath string, disable bool) {
 	}
 }
 
+func usageCmd() {
+	groupBy := "day"
+	days := 30
+
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--by", "-b":
+			if i+1 < len(args) {
+				groupBy = args[i+1]
+				i++
+			}
+		case "--days", "-n":
+			if i+1 < len(args) {
+				fmt.Sscanf(args[i+1], "%d", &days)
+				i++
+			}
+		case "--help", "-h":
+			usageHelp()
+			return
+		default:
+			fmt.Printf("Unknown flag: %s\n", args[i])
+			usageHelp()
+			return
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+
+	ledgerPath := filepath.Join(cfg.WorkspacePath(), "usage", "ledger.jsonl")
+	records, err := usage.LoadRecords(ledgerPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No usage recorded yet.")
+			return
+		}
+		fmt.Printf("Error reading usage ledger: %v\n", err)
+		return
+	}
+
+	if days < 1 {
+		days = 1
+	}
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -(days - 1))
+
+	rows, err := usage.Summarize(records, groupBy, since)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if len(rows) == 0 {
+		fmt.Printf("No usage in the last %d days.\n", days)
+		return
+	}
+
+	fmt.Printf("\nUsage by %s (last %d days):\n", groupBy, days)
+	fmt.Printf("  %-36s %7s %12s %12s %12s %10s\n", strings.ToUpper(groupBy), "CALLS", "PROMPT", "COMPLETION", "TOTAL", "COST")
+	var total usage.Summary
+	for _, row := range rows {
+		fmt.Printf("  %-36s %7d %12d %12d %12d %10.4f\n", row.Key, row.Calls, row.PromptTokens, row.CompletionTokens, row.TotalTokens, row.Cost)
+		total.Calls += row.Calls
+		total.PromptTokens += row.PromptTokens
+		total.CompletionTokens += row.CompletionTokens
+		total.TotalTokens += row.TotalTokens
+		total.Cost += row.Cost
+	}
+	fmt.Printf("  %-36s %7d %12d %12d %12d %10.4f\n", "TOTAL", total.Calls, total.PromptTokens, total.CompletionTokens, total.TotalTokens, total.Cost)
+}
+
+func usageHelp() {
+	fmt.Println("\nUsage report:")
+	fmt.Println("  picoclaw usage [options]")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  -b, --by <group>   Group by day, user, model or channel (default: day)")
+	fmt.Println("  -n, --days <n>     Number of days to include (default: 30)")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  picoclaw usage")
+	fmt.Println("  picoclaw usage --by user --days 7")
+	fmt.Println("  picoclaw usage --by model")
+}
+
+func outboxCmd() {
+	if len(os.Args) < 3 {
+		outboxHelp()
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+
+	store, err := outbox.NewStore(filepath.Join(cfg.WorkspacePath(), "outbox"))
+	if err != nil {
+		fmt.Printf("Error opening outbox: %v\n", err)
+		return
+	}
+
+	switch os.Args[2] {
+	case "list":
+		outboxListCmd(store)
+	case "show":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: picoclaw outbox show <id>")
+			return
+		}
+		outboxShowCmd(store, os.Args[3])
+	case "replay", "purge":
+		if len(os.Args) < 4 {
+			fmt.Printf("Usage: picoclaw outbox %s <id>|--all\n", os.Args[2])
+			return
+		}
+		outboxApplyCmd(store, os.Args[2], os.Args[3])
+	case "--help", "-h":
+		outboxHelp()
+	default:
+		fmt.Printf("Unknown outbox command: %s\n", os.Args[2])
+		outboxHelp()
+	}
+}
+
+func outboxListCmd(store *outbox.Store) {
+	pending, err := store.Pending()
+	if err != nil {
+		fmt.Printf("Error reading outbox: %v\n", err)
+		return
+	}
+	dead, err := store.Dead()
+	if err != nil {
+		fmt.Printf("Error reading outbox: %v\n", err)
+		return
+	}
+
+	fmt.Printf("\nPending deliveries: %d\n", len(pending))
+	for _, e := range pending {
+		fmt.Printf("  %s  %s:%s  attempts=%d\n", e.ID, e.Message.Channel, e.Message.ChatID, e.Attempts)
+	}
+
+	fmt.Printf("\nFailed deliveries: %d\n", len(dead))
+	for _, e := range dead {
+		fmt.Printf("  %s  %s:%s  attempts=%d  failed=%s\n", e.ID, e.Message.Channel, e.Message.ChatID,
+			e.Attempts, e.FailedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("      %s\n", e.LastError)
+	}
+}
+
+func outboxShowCmd(store *outbox.Store, id string) {
+	dead, err := store.Dead()
+	if err != nil {
+		fmt.Printf("Error reading outbox: %v\n", err)
+		return
+	}
+	for _, e := range dead {
+		if e.ID != id {
+			continue
+		}
+		data, _ := json.MarshalIndent(e, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Printf("No failed delivery %s\n", id)
+}
+
+// outboxApplyCmd replays or purges one failed delivery, or all of them.
+func outboxApplyCmd(store *outbox.Store, action, id string) {
+	apply := store.Replay
+	if action == "purge" {
+		apply = store.Purge
+	}
+
+	ids := []string{id}
+	if id == "--all" {
+		dead, err := store.Dead()
+		if err != nil {
+			fmt.Printf("Error reading outbox: %v\n", err)
+			return
+		}
+		ids = ids[:0]
+		for _, e := range dead {
+			ids = append(ids, e.ID)
+		}
+	}
+
+	done := 0
+	for _, id := range ids {
+		if err := apply(id); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		done++
+	}
+	if action == "replay" {
+		fmt.Printf("✓ Requeued %d delivery(s); a running gateway retries them within a minute\n", done)
+	} else {
+		fmt.Printf("✓ Purged %d delivery(s)\n", done)
+	}
+}
+
+func outboxHelp() {
+	fmt.Println("\nOutbox commands:")
+	fmt.Println("  list                  List pending and failed deliveries")
+	fmt.Println("  show <id>             Show a failed delivery in full")
+	fmt.Println("  replay <id>|--all     Requeue failed deliveries")
+	fmt.Println("  purge <id>|--all      Delete failed deliveries")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  picoclaw outbox list")
+	fmt.Println("  picoclaw outbox replay --all")
+}
+
 func skillsHelp() {
 	fmt.Println("\nSkills commands:")
 	fmt.Println("  list                    List installed skills")
@@ -22,6 +22,7 @@ type ContextBuilder struct {
 	tools        *tools.ToolRegistry // Direct reference to tool registry
 	cache        *ContextCache       // Cache for static content
 	budget       *ContextBudget      // Token budget for context management
+	now          func() time.Time    // Clock for the Current Time section
 }
 
 func getGlobalConfigDir() string {
@@ -44,6 +45,7 @@ func NewContextBuilder(workspace string) *ContextBuilder {
 		skillsLoader: skills.NewSkillsLoader(workspace, globalSkillsDir, builtinSkillsDir),
 		memory:       NewMemoryStore(workspace),
 		cache:        NewContextCache(workspace),
+		now:          time.Now,
 	}
 }
 
@@ -69,8 +71,9 @@ func (cb *ContextBuilder) SetModel(model string) {
 	}
 }
 
+// getIdentity returns the opening of the system prompt. It must not vary
+// per turn or per chat: providers cache the prompt up to its end.
 func (cb *ContextBuilder) getIdentity() string {
-	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
 	runtime := fmt.Sprintf("%s %s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
@@ -81,9 +84,6 @@ func (cb *ContextBuilder) getIdentity() string {
 
 You are picoclaw, a helpful AI assistant.
 
-## Current Time
-%s
-
 ## Runtime
 %s
 
@@ -102,7 +102,7 @@ Your workspace is at: %s
 2. **Be helpful and accurate** - When using tools, briefly explain what you're doing.
 
 3. **Memory** - When remembering something, write to %s/memory/MEMORY.md`,
-		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
+		runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
 }
 
 // extractPeerName extracts a human-readable peer name from channel metadata.
@@ -315,21 +315,6 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 
 	systemPrompt := cb.BuildSystemPromptWithBudget(cb.budget)
 
-	// Add Current Session info if provided
-	if channel != "" && chatID != "" {
-		sessionInfo := fmt.Sprintf("\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
-
-		// Extract peer name from metadata (channel-agnostic)
-		if metadata != nil {
-			peerName := extractPeerName(metadata)
-			if peerName != "" {
-				sessionInfo += fmt.Sprintf("\nPeer: %s", peerName)
-			}
-		}
-
-		systemPrompt += sessionInfo
-	}
-
 	// Log system prompt summary for debugging (debug mode only)
 	logger.DebugCF("agent", "System prompt built",
 		map[string]interface{}{
@@ -348,10 +333,6 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 			"preview": preview,
 		})
 
-	if summary != "" {
-		systemPrompt += "\n\n## Summary of Previous Conversation\n\n" + summary
-	}
-
 	//This fix prevents the session memory from LLM failure due to elimination of toolu_IDs required from LLM
 	// --- INICIO DEL FIX ---
 	//Diegox-17
@@ -363,10 +344,12 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 	//Diegox-17
 	// --- FIN DEL FIX ---
 
-	messages = append(messages, providers.Message{
-		Role:    "system",
-		Content: systemPrompt,
-	})
+	// The time, session and summary change per turn and per chat, so they
+	// follow the cached prompt in a block of their own
+	messages = append(messages,
+		providers.Message{Role: "system", Content: systemPrompt},
+		providers.Message{Role: "system", Content: cb.buildTurnContext(summary, channel, chatID, metadata)},
+	)
 
 	messages = append(messages, history...)
 
@@ -377,6 +360,26 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 	return messages
 }
 
+// buildTurnContext returns the part of the system prompt that changes
+// between turns: the current time, the chat and the conversation summary.
+func (cb *ContextBuilder) buildTurnContext(summary, channel, chatID string, metadata map[string]string) string {
+	turn := "## Current Time\n" + cb.now().Format("2006-01-02 15:04 (Monday)")
+
+	if channel != "" && chatID != "" {
+		turn += fmt.Sprintf("\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
+
+		// Extract peer name from metadata (channel-agnostic)
+		if peerName := extractPeerName(metadata); peerName != "" {
+			turn += fmt.Sprintf("\nPeer: %s", peerName)
+		}
+	}
+
+	if summary != "" {
+		turn += "\n\n## Summary of Previous Conversation\n\n" + summary
+	}
+	return turn
+}
+
 func (cb *ContextBuilder) AddToolResult(messages []providers.Message, toolCallID, toolName, result string) []providers.Message {
 	messages = append(messages, providers.Message{
 		Role:       "tool",
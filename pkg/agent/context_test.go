@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildMessages_StablePrefix(t *testing.T) {
+	cb := NewContextBuilder(t.TempDir())
+	start := time.Date(2026, 10, 18, 9, 41, 0, 0, time.Local)
+
+	cb.now = func() time.Time { return start }
+	first := cb.BuildMessages(nil, "", "hi", nil, "telegram", "1", nil)
+	cb.now = func() time.Time { return start.Add(time.Minute) }
+	second := cb.BuildMessages(nil, "we talked about taxes", "hi", nil, "discord", "2", map[string]string{"username": "ana"})
+
+	// The cached prefix is the first system message
+	if first[0].Content != second[0].Content {
+		t.Error("stable system prompt differs between turns")
+	}
+	if prompt := first[0].Content.(string); strings.Contains(prompt, "09:41") || strings.Contains(prompt, "Chat ID") {
+		t.Errorf("stable system prompt carries per-turn context")
+	}
+
+	turn := second[1].Content.(string)
+	for _, want := range []string{"09:42", "Chat ID: 2", "Peer: ana", "we talked about taxes"} {
+		if !strings.Contains(turn, want) {
+			t.Errorf("turn context missing %q:\n%s", want, turn)
+		}
+	}
+}
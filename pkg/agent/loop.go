@@ -25,17 +25,18 @@ import (
 	"github.com/sipeed/picoclaw/pkg/session"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/usage"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
 type AgentLoop struct {
 	bus            *bus.MessageBus
-	provider       providers.LLMProvider
+	router         *modelRouter // Picks provider/model per task, channel or chat
 	workspace      string
-	model          string
 	maxTokens      int     // Maximum output tokens per request
 	temperature    float64 // Temperature for LLM sampling
-	contextWindow  int     // Maximum context window size in tokens
+	reasoning      reasoningOptions
+	contextWindow  int // Maximum context window size in tokens
 	contextBudget  *ContextBudget
 	maxIterations  int
 	sessions       *session.SessionManager
@@ -43,7 +44,10 @@ type AgentLoop struct {
 	contextBuilder *ContextBuilder
 	tools          *tools.ToolRegistry
 	running        atomic.Bool
-	summarizing    sync.Map // Tracks which sessions are currently being summarized
+	summarizing    sync.Map      // Tracks which sessions are currently being summarized
+	ledger         *usage.Ledger // Records every LLM call; nil when usage tracking is disabled
+	budget         *usage.Budget
+	budgetMessage  string
 
 	// Token estimation calibration (running average)
 	tokenCalibrationRatio atomic.Value // stores float64: actual_tokens / estimated_tokens
@@ -53,8 +57,10 @@ type AgentLoop struct {
 // processOptions configures how a message is processed
 type processOptions struct {
 	SessionKey         string            // Session identifier for history/context
+	Task               string            // Routing task (TaskChat, TaskHeartbeat); empty means chat
 	Channel            string            // Target channel for tool execution
 	ChatID             string            // Target chat ID for tool execution
+	SenderID           string            // Sender of the message, for usage accounting
 	UserMessage        string            // User message content (may include prefix)
 	Media              []string          // Media file paths (images, audio, etc.)
 	Metadata           map[string]string // Channel-specific metadata (username, display_name, etc.)
@@ -95,17 +101,30 @@ func createToolRegistry(workspace string, restrict bool, cfg *config.Config, msg
 	registry.Register(tools.NewI2CTool())
 	registry.Register(tools.NewSPITool())
 
+	// MQTT tool, on its own broker or the MQTT channel's
+	if cfg.Tools.MQTT.Enabled {
+		mqttOpts := tools.MQTTToolOptions{
+			Broker:      cfg.Tools.MQTT.Broker,
+			Username:    cfg.Tools.MQTT.Username,
+			Password:    cfg.Tools.MQTT.Password,
+			AllowTopics: cfg.Tools.MQTT.AllowTopics,
+		}
+		if mqttOpts.Broker == "" {
+			mqttOpts.Broker = cfg.Channels.MQTT.Broker
+			mqttOpts.Username = cfg.Channels.MQTT.Username
+			mqttOpts.Password = cfg.Channels.MQTT.Password
+		}
+		registry.Register(tools.NewMQTTTool(mqttOpts))
+	}
+
 	// Message tool - available to both agent and subagent
 	// Subagent uses it to communicate directly with user
 	messageTool := tools.NewMessageTool()
-	messageTool.SetSendCallback(func(channel, chatID, content string) error {
-		msgBus.PublishOutbound(bus.OutboundMessage{
-			Channel: channel,
-			ChatID:  chatID,
-			Content: content,
-		})
+	messageTool.SetSendCallback(func(msg bus.OutboundMessage) error {
+		msgBus.PublishOutbound(msg)
 		return nil
 	})
+	messageTool.SetWorkspace(workspace, restrict)
 	registry.Register(messageTool)
 
 	return registry
@@ -120,8 +139,19 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	// Create tool registry for main agent
 	toolsRegistry := createToolRegistry(workspace, restrict, cfg, msgBus)
 
+	router := newModelRouter(cfg, provider)
+
+	var ledger *usage.Ledger
+	var budget *usage.Budget
+	if cfg.Usage.Enabled {
+		ledger = usage.NewLedger(workspace, cfg.Usage.Pricing)
+		budget = usage.NewBudget(ledger, cfg.Usage.Budgets)
+	}
+
 	// Create subagent manager with its own tool registry
-	subagentManager := tools.NewSubagentManager(provider, cfg.Agents.Defaults.Model, workspace, msgBus)
+	subagentRoute := router.forTask(TaskSubagent)
+	subagentManager := tools.NewSubagentManager(subagentRoute.provider, subagentRoute.model, workspace, msgBus)
+	subagentManager.SetUsage(subagentRoute.providerName, ledger, budget)
 	subagentTools := createToolRegistry(workspace, restrict, cfg, msgBus)
 	// Subagent doesn't need spawn/subagent tools to avoid recursion
 	subagentManager.SetTools(subagentTools)
@@ -151,12 +181,16 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	contextBuilder.SetModel(cfg.Agents.Defaults.Model)
 
 	return &AgentLoop{
-		bus:            msgBus,
-		provider:       provider,
-		workspace:      workspace,
-		model:          cfg.Agents.Defaults.Model,
-		maxTokens:      cfg.Agents.Defaults.MaxTokens,
-		temperature:    cfg.Agents.Defaults.Temperature,
+		bus:         msgBus,
+		router:      router,
+		workspace:   workspace,
+		maxTokens:   cfg.Agents.Defaults.MaxTokens,
+		temperature: cfg.Agents.Defaults.Temperature,
+		reasoning: reasoningOptions{
+			effort: cfg.Agents.Defaults.ReasoningEffort,
+			budget: cfg.Agents.Defaults.ThinkingBudget,
+			show:   cfg.Agents.Defaults.ShowReasoning,
+		},
 		contextWindow:  contextWindow,
 		contextBudget:  contextBudget,
 		maxIterations:  cfg.Agents.Defaults.MaxToolIterations,
@@ -165,6 +199,9 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 		contextBuilder: contextBuilder,
 		tools:          toolsRegistry,
 		summarizing:    sync.Map{},
+		ledger:         ledger,
+		budget:         budget,
+		budgetMessage:  cfg.Usage.BudgetMessage,
 	}
 }
 
@@ -180,34 +217,49 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 			if !ok {
 				continue
 			}
-
-			response, err := al.processMessage(ctx, msg)
-			if err != nil {
-				response = fmt.Sprintf("Error processing message: %v", err)
+			al.handleInbound(ctx, msg)
+			// A turn cut short by shutdown is handled again after a restart
+			if ctx.Err() == nil {
+				al.bus.AckInbound(msg)
 			}
+		}
+	}
 
-			if response != "" {
-				// Check if the message tool already sent a response during this round.
-				// If so, skip publishing to avoid duplicate messages to the user.
-				alreadySent := false
-				if tool, ok := al.tools.Get("message"); ok {
-					if mt, ok := tool.(*tools.MessageTool); ok {
-						alreadySent = mt.HasSentInRound()
-					}
-				}
+	return nil
+}
 
-				if !alreadySent {
-					al.bus.PublishOutbound(bus.OutboundMessage{
-						Channel: msg.Channel,
-						ChatID:  msg.ChatID,
-						Content: response,
-					})
-				}
+// handleInbound runs one inbound message as a turn and publishes its reply.
+// The turn ends only after the reply is on the bus, so channels waiting on
+// EventTurnEnd have seen every message the turn produced.
+func (al *AgentLoop) handleInbound(ctx context.Context, msg bus.InboundMessage) {
+	endTurn := al.beginTurn(msg.Channel, msg.ChatID)
+
+	response, err := al.processMessage(ctx, msg)
+	if err != nil {
+		response = fmt.Sprintf("Error processing message: %v", err)
+	}
+
+	if response != "" {
+		// Check if the message tool already sent a response during this round.
+		// If so, skip publishing to avoid duplicate messages to the user.
+		alreadySent := false
+		if tool, ok := al.tools.Get("message"); ok {
+			if mt, ok := tool.(*tools.MessageTool); ok {
+				alreadySent = mt.HasSentInRound()
 			}
 		}
+
+		if !alreadySent {
+			al.bus.PublishOutbound(bus.OutboundMessage{
+				Channel:       msg.Channel,
+				ChatID:        msg.ChatID,
+				Content:       response,
+				InteractionID: msg.Metadata["interaction_id"],
+			})
+		}
 	}
 
-	return nil
+	endTurn(err)
 }
 
 func (al *AgentLoop) Stop() {
@@ -243,14 +295,19 @@ func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sess
 		SessionKey: sessionKey,
 	}
 
-	return al.processMessage(ctx, msg)
+	endTurn := al.beginTurn(channel, chatID)
+	response, err := al.processMessage(ctx, msg)
+	endTurn(err)
+	return response, err
 }
 
 // ProcessHeartbeat processes a heartbeat request without session history.
 // Each heartbeat is independent and doesn't accumulate context.
 func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, chatID string) (string, error) {
-	return al.runAgentLoop(ctx, processOptions{
+	endTurn := al.beginTurn(channel, chatID)
+	response, err := al.runAgentLoop(ctx, processOptions{
 		SessionKey:         "heartbeat",
+		Task:               TaskHeartbeat,
 		Channel:            channel,
 		ChatID:             chatID,
 		UserMessage:        content,
@@ -260,6 +317,8 @@ func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, cha
 		NoHistory:          true, // Don't load session history for heartbeat
 		DisableMessageTool: true, // Disable message tool - heartbeats should only communicate via subagents
 	})
+	endTurn(err)
+	return response, err
 }
 
 func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage) (string, error) {
@@ -283,11 +342,26 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		return al.processSystemMessage(ctx, msg)
 	}
 
+	// Refuse politely instead of calling the provider once the sender or
+	// channel is over budget. Cron jobs are not attributed to a sender.
+	if al.budget != nil && msg.SenderID != "cron" {
+		if over, period := al.budget.Check(msg.Channel, msg.SenderID); over {
+			logger.InfoCF("agent", "Usage budget exceeded",
+				map[string]interface{}{
+					"channel":   msg.Channel,
+					"sender_id": msg.SenderID,
+					"period":    period,
+				})
+			return usage.BudgetMessage(al.budgetMessage, period), nil
+		}
+	}
+
 	// Process as user message
 	return al.runAgentLoop(ctx, processOptions{
 		SessionKey:      msg.SessionKey,
 		Channel:         msg.Channel,
 		ChatID:          msg.ChatID,
+		SenderID:        msg.SenderID,
 		UserMessage:     msg.Content,
 		Media:           msg.Media,
 		Metadata:        msg.Metadata,
@@ -352,8 +426,9 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 // runAgentLoop is the core message processing logic.
 // It handles context building, LLM calls, tool execution, and response handling.
 func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (string, error) {
-	// 0. Validate model and invalidate cache if model changed
-	al.contextBuilder.SetModel(al.model)
+	// 0. Pick the model for this turn; invalidate cache if model changed
+	route := al.routeFor(opts)
+	al.contextBuilder.SetModel(route.model)
 
 	// 1. Record last channel for heartbeat notifications (skip internal channels)
 	if opts.Channel != "" && opts.ChatID != "" {
@@ -367,7 +442,7 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 	}
 
 	// 2. Update tool contexts
-	al.updateToolContexts(opts.Channel, opts.ChatID)
+	al.updateToolContexts(opts.Channel, opts.ChatID, opts.SenderID, opts.Metadata["message_id"])
 
 	// 3. Disable message tool if requested (for heartbeat mode)
 	// During heartbeat, the agent should not send messages directly.
@@ -403,7 +478,7 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 	al.sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
 
 	// 6. Run LLM iteration loop
-	finalContent, iteration, err := al.runLLMIteration(ctx, messages, opts)
+	finalContent, reasoning, iteration, err := al.runLLMIteration(ctx, route, messages, opts)
 	if err != nil {
 		return "", err
 	}
@@ -425,12 +500,18 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		al.maybeSummarize(opts.SessionKey)
 	}
 
+	// Reasoning is shown to the user but never stored in history
+	if al.reasoning.show && reasoning != "" {
+		finalContent = formatReasoning(reasoning) + finalContent
+	}
+
 	// 10. Optional: send response via bus
 	if opts.SendResponse {
 		al.bus.PublishOutbound(bus.OutboundMessage{
-			Channel: opts.Channel,
-			ChatID:  opts.ChatID,
-			Content: finalContent,
+			Channel:       opts.Channel,
+			ChatID:        opts.ChatID,
+			Content:       finalContent,
+			InteractionID: opts.Metadata["interaction_id"],
 		})
 	}
 
@@ -446,11 +527,39 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 	return finalContent, nil
 }
 
+// recordUsage writes one LLM call to the usage ledger. rec carries the
+// attribution (task, session, sender); model, provider and tokens come from
+// the route and the response.
+func (al *AgentLoop) recordUsage(route modelRoute, rec usage.Record, info *providers.UsageInfo) {
+	if al.ledger == nil || info == nil {
+		return
+	}
+	rec.Provider = route.providerName
+	rec.Model = route.model
+	rec.PromptTokens = info.PromptTokens
+	rec.CompletionTokens = info.CompletionTokens
+	rec.CacheReadTokens = info.CacheReadTokens
+	rec.CacheWriteTokens = info.CacheWriteTokens
+	rec.TotalTokens = info.TotalTokens
+	if err := al.ledger.Record(rec); err != nil {
+		logger.WarnCF("agent", "Failed to record usage", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// routeFor returns the provider/model for a turn: heartbeats use the
+// heartbeat route, everything else the channel/chat route.
+func (al *AgentLoop) routeFor(opts processOptions) modelRoute {
+	if opts.Task == TaskHeartbeat {
+		return al.router.forTask(TaskHeartbeat)
+	}
+	return al.router.forChat(opts.Channel, opts.ChatID)
+}
+
 // runLLMIteration executes the LLM call loop with tool handling.
-// Returns the final content, iteration count, and any error.
-func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.Message, opts processOptions) (string, int, error) {
+// Returns the final content, the reasoning behind it, iteration count, and any error.
+func (al *AgentLoop) runLLMIteration(ctx context.Context, route modelRoute, messages []providers.Message, opts processOptions) (string, string, int, error) {
 	iteration := 0
-	var finalContent string
+	var finalContent, finalReasoning string
 
 	for iteration < al.maxIterations {
 		iteration++
@@ -468,7 +577,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
 				"iteration":         iteration,
-				"model":             al.model,
+				"model":             route.model,
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
 				"max_tokens":        al.maxTokens,
@@ -485,11 +594,19 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 
 		// Call LLM
-		response, err := al.provider.Chat(ctx, messages, providerToolDefs, al.model, map[string]interface{}{
+		llmOpts := map[string]interface{}{
 			"max_tokens":            al.maxTokens,
 			"temperature":           al.temperature,
 			"enable_prompt_caching": true, // Enable Anthropic prompt caching for cost reduction
-		})
+		}
+		al.reasoning.apply(llmOpts)
+		// Providers that can stream pass the text on as it is written
+		if opts.ChatID != "" && al.bus.HasEventHandlers(opts.Channel) {
+			llmOpts["on_text_delta"] = func(delta string) {
+				al.publishEvent(opts, bus.AgentEvent{Type: bus.EventTextDelta, Content: delta})
+			}
+		}
+		response, err := route.provider.Chat(ctx, messages, providerToolDefs, route.model, llmOpts)
 
 		if err != nil {
 			logger.ErrorCF("agent", "LLM call failed",
@@ -497,8 +614,20 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 					"iteration": iteration,
 					"error":     err.Error(),
 				})
-			return "", iteration, fmt.Errorf("LLM call failed: %w", err)
+			return "", "", iteration, fmt.Errorf("LLM call failed: %w", err)
+		}
+
+		task := opts.Task
+		if task == "" {
+			task = TaskChat
 		}
+		al.recordUsage(route, usage.Record{
+			Task:       task,
+			SessionKey: opts.SessionKey,
+			Channel:    opts.Channel,
+			ChatID:     opts.ChatID,
+			SenderID:   opts.SenderID,
+		}, response.Usage)
 
 		// Log token usage (actual vs estimated) and calibrate estimation
 		if response.Usage != nil {
@@ -548,17 +677,20 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 
 			logger.InfoCF("agent", "Token usage",
 				map[string]interface{}{
-					"iteration":  iteration,
-					"prompt":     fmt.Sprintf("%d est / %d actual", estimated, actual),
-					"error":      errorStr,
-					"completion": response.Usage.CompletionTokens,
-					"total":      response.Usage.TotalTokens,
+					"iteration":   iteration,
+					"prompt":      fmt.Sprintf("%d est / %d actual", estimated, actual),
+					"error":       errorStr,
+					"completion":  response.Usage.CompletionTokens,
+					"total":       response.Usage.TotalTokens,
+					"cache_read":  response.Usage.CacheReadTokens,
+					"cache_write": response.Usage.CacheWriteTokens,
 				})
 		}
 
 		// Check if no tool calls - we're done
 		if len(response.ToolCalls) == 0 {
 			finalContent = response.Content
+			finalReasoning = response.Reasoning
 			logger.InfoCF("agent", "LLM response without tool calls (direct answer)",
 				map[string]interface{}{
 					"iteration":     iteration,
@@ -580,9 +712,13 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 
 		// Build assistant message with tool calls
+		// Reasoning rides along so providers can replay it (Claude thinking
+		// signatures, DeepSeek reasoning_content) on the next iteration
 		assistantMsg := providers.Message{
-			Role:    "assistant",
-			Content: response.Content,
+			Role:             "assistant",
+			Content:          response.Content,
+			ReasoningContent: response.Reasoning,
+			ReasoningBlocks:  response.ReasoningBlocks,
 		}
 		for _, tc := range response.ToolCalls {
 			argumentsJSON, _ := json.Marshal(tc.Arguments)
@@ -598,9 +734,16 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 		}
 		messages = append(messages, assistantMsg)
+		if response.Content != "" {
+			al.publishEvent(opts, bus.AgentEvent{Type: bus.EventText, Content: response.Content})
+		}
 
-		// Save assistant message with tool calls to session
-		al.sessions.AddFullMessage(opts.SessionKey, assistantMsg)
+		// Save assistant message with tool calls to session. Reasoning stays
+		// in this turn's messages only; history never replays it
+		stored := assistantMsg
+		stored.ReasoningContent = ""
+		stored.ReasoningBlocks = nil
+		al.sessions.AddFullMessage(opts.SessionKey, stored)
 
 		// Execute tool calls
 		for _, tc := range response.ToolCalls {
@@ -629,7 +772,16 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				}
 			}
 
+			al.publishEvent(opts, bus.AgentEvent{Type: bus.EventToolCall, Tool: tc.Name, Args: argsPreview})
 			toolResult := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback)
+			resultEvent := bus.AgentEvent{Type: bus.EventToolResult, Tool: tc.Name}
+			if toolResult.IsError {
+				resultEvent.Error = utils.Truncate(toolResult.ForLLM, 200)
+				if resultEvent.Error == "" && toolResult.Err != nil {
+					resultEvent.Error = toolResult.Err.Error()
+				}
+			}
+			al.publishEvent(opts, resultEvent)
 
 			// Send ForUser content to user immediately if not Silent
 			if !toolResult.Silent && toolResult.ForUser != "" && opts.SendResponse {
@@ -663,26 +815,60 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		}
 	}
 
-	return finalContent, iteration, nil
+	return finalContent, finalReasoning, iteration, nil
+}
+
+// beginTurn reports the start of a turn on a chat and returns the function
+// that reports its end.
+func (al *AgentLoop) beginTurn(channel, chatID string) func(err error) {
+	opts := processOptions{Channel: channel, ChatID: chatID}
+	al.publishEvent(opts, bus.AgentEvent{Type: bus.EventTurnStart})
+	return func(err error) {
+		ev := bus.AgentEvent{Type: bus.EventTurnEnd}
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		al.publishEvent(opts, ev)
+	}
+}
+
+// publishEvent reports turn progress to the channel the turn came from.
+func (al *AgentLoop) publishEvent(opts processOptions, ev bus.AgentEvent) {
+	if opts.Channel == "" || opts.ChatID == "" {
+		return
+	}
+	ev.Channel = opts.Channel
+	ev.ChatID = opts.ChatID
+	al.bus.PublishEvent(ev)
 }
 
 // updateToolContexts updates the context for tools that need channel/chatID info.
-func (al *AgentLoop) updateToolContexts(channel, chatID string) {
+// senderID is who sent the inbound message and messageID its platform ID, if any.
+func (al *AgentLoop) updateToolContexts(channel, chatID, senderID, messageID string) {
 	// Use ContextualTool interface instead of type assertions
 	if tool, ok := al.tools.Get("message"); ok {
 		if mt, ok := tool.(tools.ContextualTool); ok {
 			mt.SetContext(channel, chatID)
 		}
+		if mt, ok := tool.(*tools.MessageTool); ok {
+			mt.SetCurrentMessageID(messageID)
+		}
 	}
 	if tool, ok := al.tools.Get("spawn"); ok {
 		if st, ok := tool.(tools.ContextualTool); ok {
 			st.SetContext(channel, chatID)
 		}
+		if st, ok := tool.(*tools.SpawnTool); ok {
+			st.SetSender(senderID)
+		}
 	}
 	if tool, ok := al.tools.Get("subagent"); ok {
 		if st, ok := tool.(tools.ContextualTool); ok {
 			st.SetContext(channel, chatID)
 		}
+		if st, ok := tool.(*tools.SubagentTool); ok {
+			st.SetSender(senderID)
+		}
 	}
 }
 
@@ -839,11 +1025,13 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 		// Merge them
 		mergePrompt := fmt.Sprintf("Merge these two conversation summaries into one cohesive summary:\n\n1: %s\n\n2: %s", s1, s2)
-		resp, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, al.model, map[string]interface{}{
+		route := al.router.forTask(TaskSummarize)
+		resp, err := route.provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, route.model, map[string]interface{}{
 			"max_tokens":  1024,
 			"temperature": 0.3,
 		})
 		if err == nil {
+			al.recordUsage(route, usage.Record{Task: TaskSummarize, SessionKey: sessionKey}, resp.Usage)
 			finalSummary = resp.Content
 		} else {
 			finalSummary = s1 + " " + s2
@@ -874,13 +1062,15 @@ func (al *AgentLoop) summarizeBatch(ctx context.Context, batch []providers.Messa
 		prompt += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
 	}
 
-	response, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, al.model, map[string]interface{}{
+	route := al.router.forTask(TaskSummarize)
+	response, err := route.provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, route.model, map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.3,
 	})
 	if err != nil {
 		return "", err
 	}
+	al.recordUsage(route, usage.Record{Task: TaskSummarize}, response.Usage)
 	return response.Content, nil
 }
 
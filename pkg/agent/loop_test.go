@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -527,3 +528,248 @@ func TestToolResult_UserFacingToolDoesSendMessage(t *testing.T) {
 		t.Errorf("Expected 'Command output: hello world', got: %s", response)
 	}
 }
+
+// TestProcessMessage_BudgetExceeded verifies an over-budget sender gets a polite
+// reply without the provider being called
+func TestProcessMessage_BudgetExceeded(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Usage: config.UsageConfig{
+			Enabled:       true,
+			Budgets:       config.BudgetsConfig{PerSender: config.BudgetLimit{DailyTokens: 10}},
+			BudgetMessage: "Budget used up",
+		},
+	}
+
+	provider := &usageProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "42", ChatID: "42", Content: "hi", SessionKey: "telegram:42"}
+	response, err := al.processMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("processMessage error: %v", err)
+	}
+	if response != "ok" || provider.calls != 1 {
+		t.Fatalf("first message: response=%q calls=%d", response, provider.calls)
+	}
+
+	response, err = al.processMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("processMessage error: %v", err)
+	}
+	if response != "Budget used up" {
+		t.Errorf("response = %q, want budget message", response)
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider called %d times, want 1", provider.calls)
+	}
+
+	// Other senders are unaffected
+	other := msg
+	other.SenderID = "7"
+	if response, _ := al.processMessage(context.Background(), other); response != "ok" {
+		t.Errorf("other sender response = %q, want ok", response)
+	}
+}
+
+// usageProvider reports token usage on every call
+type usageProvider struct {
+	calls int
+}
+
+func (p *usageProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	p.calls++
+	return &providers.LLMResponse{
+		Content: "ok",
+		Usage:   &providers.UsageInfo{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}, nil
+}
+
+func (p *usageProvider) GetDefaultModel() string {
+	return "usage-model"
+}
+
+// toolCallProvider asks for the mock_custom tool once, then answers
+type toolCallProvider struct {
+	calls int
+}
+
+func (p *toolCallProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	p.calls++
+	if p.calls == 1 {
+		return &providers.LLMResponse{
+			Content:   "Let me check.",
+			ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "mock_custom", Arguments: map[string]interface{}{"q": "x"}}},
+		}, nil
+	}
+	return &providers.LLMResponse{Content: "done"}, nil
+}
+
+func (p *toolCallProvider) GetDefaultModel() string {
+	return "tool-model"
+}
+
+// TestHandleInbound_PublishesEvents verifies a turn reports its tool calls
+// to handlers on the message's channel
+func TestHandleInbound_PublishesEvents(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &toolCallProvider{})
+	al.RegisterTool(&mockCustomTool{})
+
+	var events []bus.AgentEvent
+	msgBus.OnEvent("web", func(ev bus.AgentEvent) { events = append(events, ev) })
+
+	msg := bus.InboundMessage{Channel: "web", SenderID: "ana", ChatID: "ana.s1", Content: "hi", SessionKey: "web:ana.s1"}
+	al.handleInbound(context.Background(), msg)
+	if reply, ok := msgBus.SubscribeOutbound(context.Background()); !ok || reply.Content != "done" {
+		t.Fatalf("reply = %+v, %v", reply, ok)
+	}
+
+	var got []string
+	for _, ev := range events {
+		if ev.ChatID != "ana.s1" {
+			t.Errorf("event %s for chat %q", ev.Type, ev.ChatID)
+		}
+		got = append(got, ev.Type+":"+ev.Tool+ev.Content)
+	}
+	want := []string{"turn_start:", "text:Let me check.", "tool_call:mock_custom", "tool_result:mock_custom", "turn_end:"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if events[2].Args != `{"q":"x"}` {
+		t.Errorf("tool_call args = %q", events[2].Args)
+	}
+}
+
+// reasoningProvider thinks before a tool call and records what it is sent
+type reasoningProvider struct {
+	calls [][]providers.Message
+}
+
+func (p *reasoningProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	p.calls = append(p.calls, append([]providers.Message(nil), messages...))
+	if len(p.calls) == 1 {
+		return &providers.LLMResponse{
+			Reasoning:       "need to look it up",
+			ReasoningBlocks: []providers.ReasoningBlock{{Type: "thinking", Thinking: "need to look it up", Signature: "sig"}},
+			ToolCalls:       []providers.ToolCall{{ID: "call_1", Name: "mock_custom", Arguments: map[string]interface{}{}}},
+		}, nil
+	}
+	return &providers.LLMResponse{Content: "done"}, nil
+}
+
+func (p *reasoningProvider) GetDefaultModel() string {
+	return "reasoning-model"
+}
+
+// TestProcessMessage_ReasoningNotStored verifies reasoning is replayed within
+// the turn but kept out of session history
+func TestProcessMessage_ReasoningNotStored(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	provider := &reasoningProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	al.RegisterTool(&mockCustomTool{})
+
+	msg := bus.InboundMessage{Channel: "cli", SenderID: "u", ChatID: "c", Content: "hi", SessionKey: "cli:c"}
+	if _, err := al.processMessage(context.Background(), msg); err != nil {
+		t.Fatalf("processMessage error: %v", err)
+	}
+
+	replayed := false
+	for _, m := range provider.calls[1] {
+		if m.ReasoningContent != "" && len(m.ReasoningBlocks) == 1 {
+			replayed = true
+		}
+	}
+	if !replayed {
+		t.Error("reasoning not replayed on the tool-call follow-up")
+	}
+	for _, m := range al.sessions.GetHistory("cli:c") {
+		if m.ReasoningContent != "" || len(m.ReasoningBlocks) != 0 {
+			t.Errorf("history message %+v keeps reasoning", m)
+		}
+	}
+}
+
+// streamingProvider streams its answer when asked to
+type streamingProvider struct {
+	streamed bool
+}
+
+func (p *streamingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	if onDelta, ok := opts["on_text_delta"].(func(string)); ok {
+		p.streamed = true
+		onDelta("Hel")
+		onDelta("lo")
+	}
+	return &providers.LLMResponse{Content: "Hello"}, nil
+}
+
+func (p *streamingProvider) GetDefaultModel() string {
+	return "stream-model"
+}
+
+// TestProcessMessage_StreamsOnlyWhenWatched verifies text is streamed as
+// events to channels that listen for them, and not requested otherwise
+func TestProcessMessage_StreamsOnlyWhenWatched(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	msgBus := bus.NewMessageBus()
+	provider := &streamingProvider{}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	var deltas []string
+	msgBus.OnEvent("web", func(ev bus.AgentEvent) {
+		if ev.Type == bus.EventTextDelta {
+			deltas = append(deltas, ev.Content)
+		}
+	})
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "1", ChatID: "1", Content: "hi", SessionKey: "telegram:1"}
+	if _, err := al.processMessage(context.Background(), msg); err != nil || provider.streamed {
+		t.Fatalf("telegram turn streamed = %v, err %v", provider.streamed, err)
+	}
+
+	msg = bus.InboundMessage{Channel: "web", SenderID: "ana", ChatID: "ana.s1", Content: "hi", SessionKey: "web:ana.s1"}
+	if _, err := al.processMessage(context.Background(), msg); err != nil {
+		t.Fatalf("processMessage error: %v", err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("deltas = %v, want Hel|lo", deltas)
+	}
+}
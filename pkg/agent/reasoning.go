@@ -0,0 +1,43 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// reasoningPreviewLen caps the collapsed reasoning shown in replies.
+const reasoningPreviewLen = 280
+
+// reasoningOptions holds the agents.defaults reasoning settings.
+type reasoningOptions struct {
+	effort string // reasoning_effort passed to OpenAI-style providers
+	budget int    // Claude extended thinking budget in tokens
+	show   bool   // Prefix replies with a collapsed reasoning summary
+}
+
+// apply adds the reasoning request options to a Chat options map.
+func (r reasoningOptions) apply(opts map[string]interface{}) {
+	if r.effort != "" {
+		opts["reasoning_effort"] = r.effort
+	}
+	if r.budget > 0 {
+		opts["thinking_budget"] = r.budget
+	}
+}
+
+// formatReasoning collapses the model's reasoning into a single quoted line
+// placed above the reply.
+func formatReasoning(reasoning string) string {
+	collapsed := strings.Join(strings.Fields(reasoning), " ")
+	if collapsed == "" {
+		return ""
+	}
+	return "> 💭 " + utils.Truncate(collapsed, reasoningPreviewLen) + "\n\n"
+}
@@ -0,0 +1,130 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Tasks that can be routed to their own model.
+const (
+	TaskChat      = "chat"
+	TaskSummarize = "summarize"
+	TaskHeartbeat = "heartbeat"
+	TaskSubagent  = "subagent"
+)
+
+// modelRoute is a resolved route: the provider to call and the model ID to pass.
+type modelRoute struct {
+	providerName string // Registry name, empty for the default provider
+	provider     providers.LLMProvider
+	model        string
+}
+
+// modelRouter picks the provider/model for each LLM call based on
+// agents.routing. Routes that don't resolve fall back to the default.
+type modelRouter struct {
+	cfg             *config.Config
+	defaultProvider string
+	fallback        modelRoute
+
+	mu       sync.Mutex
+	registry *providers.Registry
+	resolved map[config.ModelRoute]modelRoute
+}
+
+func newModelRouter(cfg *config.Config, provider providers.LLMProvider) *modelRouter {
+	return &modelRouter{
+		cfg:             cfg,
+		defaultProvider: cfg.Agents.Defaults.Provider,
+		fallback: modelRoute{
+			providerName: cfg.Agents.Defaults.Provider,
+			provider:     provider,
+			model:        cfg.Agents.Defaults.Model,
+		},
+		resolved: make(map[config.ModelRoute]modelRoute),
+	}
+}
+
+// forTask returns the route for a background task (summarize, heartbeat,
+// subagent). Unrouted tasks use the default model.
+func (r *modelRouter) forTask(task string) modelRoute {
+	routing := r.cfg.Agents.Routing
+	switch task {
+	case TaskSummarize:
+		return r.resolve(routing.Summarize)
+	case TaskHeartbeat:
+		return r.resolve(routing.Heartbeat)
+	case TaskSubagent:
+		return r.resolve(routing.Subagent)
+	}
+	return r.fallback
+}
+
+// forChat returns the route for a user conversation. A per-chat override
+// beats a per-channel override, which beats the default.
+func (r *modelRouter) forChat(channel, chatID string) modelRoute {
+	routing := r.cfg.Agents.Routing
+	if route, ok := routing.Chats[channel+":"+chatID]; ok && !route.IsZero() {
+		return r.resolve(route)
+	}
+	if route, ok := routing.Channels[channel]; ok && !route.IsZero() {
+		return r.resolve(route)
+	}
+	return r.fallback
+}
+
+func (r *modelRouter) resolve(route config.ModelRoute) modelRoute {
+	if route.IsZero() {
+		return r.fallback
+	}
+
+	model := route.Model
+	if model == "" {
+		model = r.fallback.model
+	}
+
+	providerName := route.Provider
+	if providerName == "" {
+		// Same provider, different model. Without an explicitly named
+		// default provider there is nothing to look up.
+		if r.defaultProvider == "" {
+			return modelRoute{providerName: r.fallback.providerName, provider: r.fallback.provider, model: model}
+		}
+		providerName = r.defaultProvider
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := config.ModelRoute{Provider: providerName, Model: model}
+	if cached, ok := r.resolved[key]; ok {
+		return cached
+	}
+
+	if r.registry == nil {
+		r.registry = providers.NewRegistry(r.cfg)
+	}
+	provider, upstream, err := r.registry.Resolve(providerName, model)
+	if err != nil {
+		logger.WarnCF("agent", fmt.Sprintf("Model route %s/%s unavailable, using default: %v", providerName, model, err),
+			map[string]interface{}{
+				"provider": providerName,
+				"model":    model,
+			})
+		return r.fallback
+	}
+
+	resolved := modelRoute{providerName: providerName, provider: provider, model: upstream}
+	r.resolved[key] = resolved
+	return resolved
+}
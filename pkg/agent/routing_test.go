@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// modelRecordingProvider records the model passed to each Chat call
+type modelRecordingProvider struct {
+	mu     sync.Mutex
+	models []string
+}
+
+func (p *modelRecordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	p.mu.Lock()
+	p.models = append(p.models, model)
+	p.mu.Unlock()
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (p *modelRecordingProvider) GetDefaultModel() string {
+	return "recording-model"
+}
+
+func (p *modelRecordingProvider) last() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.models) == 0 {
+		return ""
+	}
+	return p.models[len(p.models)-1]
+}
+
+func newRoutingTestLoop(t *testing.T, routing config.RoutingConfig) (*AgentLoop, *modelRecordingProvider) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "strong-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+			Routing: routing,
+		},
+	}
+	provider := &modelRecordingProvider{}
+	return NewAgentLoop(cfg, bus.NewMessageBus(), provider), provider
+}
+
+func TestRouting_ChatOverridesChannel(t *testing.T) {
+	al, provider := newRoutingTestLoop(t, config.RoutingConfig{
+		Channels: map[string]config.ModelRoute{"telegram": {Model: "channel-model"}},
+		Chats:    map[string]config.ModelRoute{"telegram:42": {Model: "chat-model"}},
+	})
+	ctx := context.Background()
+
+	if _, err := al.ProcessDirectWithChannel(ctx, "hi", "s1", "telegram", "7"); err != nil {
+		t.Fatalf("ProcessDirectWithChannel error: %v", err)
+	}
+	if got := provider.last(); got != "channel-model" {
+		t.Errorf("telegram:7 model = %q, want channel-model", got)
+	}
+
+	if _, err := al.ProcessDirectWithChannel(ctx, "hi", "s2", "telegram", "42"); err != nil {
+		t.Fatalf("ProcessDirectWithChannel error: %v", err)
+	}
+	if got := provider.last(); got != "chat-model" {
+		t.Errorf("telegram:42 model = %q, want chat-model", got)
+	}
+
+	if _, err := al.ProcessDirectWithChannel(ctx, "hi", "s3", "discord", "1"); err != nil {
+		t.Fatalf("ProcessDirectWithChannel error: %v", err)
+	}
+	if got := provider.last(); got != "strong-model" {
+		t.Errorf("discord model = %q, want strong-model", got)
+	}
+}
+
+func TestRouting_HeartbeatAndSummarize(t *testing.T) {
+	al, provider := newRoutingTestLoop(t, config.RoutingConfig{
+		Heartbeat: config.ModelRoute{Model: "cheap-heartbeat"},
+		Summarize: config.ModelRoute{Model: "cheap-summary"},
+	})
+	ctx := context.Background()
+
+	if _, err := al.ProcessHeartbeat(ctx, "check", "cli", "direct"); err != nil {
+		t.Fatalf("ProcessHeartbeat error: %v", err)
+	}
+	if got := provider.last(); got != "cheap-heartbeat" {
+		t.Errorf("heartbeat model = %q, want cheap-heartbeat", got)
+	}
+
+	if _, err := al.summarizeBatch(ctx, []providers.Message{{Role: "user", Content: "hello"}}, ""); err != nil {
+		t.Fatalf("summarizeBatch error: %v", err)
+	}
+	if got := provider.last(); got != "cheap-summary" {
+		t.Errorf("summarize model = %q, want cheap-summary", got)
+	}
+}
+
+func TestRouting_UnknownProviderFallsBack(t *testing.T) {
+	al, _ := newRoutingTestLoop(t, config.RoutingConfig{
+		Subagent: config.ModelRoute{Provider: "missing", Model: "x"},
+	})
+
+	route := al.router.forTask(TaskSubagent)
+	if route.model != "strong-model" {
+		t.Errorf("fallback model = %q, want strong-model", route.model)
+	}
+}
@@ -2,39 +2,378 @@ package bus
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// OverflowPolicy decides what PublishInbound does when the inbound queue
+// is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits until the agent frees a slot.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest discards the oldest message of the channel with
+	// the most queued messages.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowReject refuses the message and tells the sender.
+	OverflowReject OverflowPolicy = "reject"
+)
+
+const (
+	defaultInboundCapacity = 100
+	defaultRejectNotice    = "I'm handling too many messages right now. Please try again in a moment."
+)
+
+var (
+	ErrInboundFull    = errors.New("inbound queue is full")
+	ErrBusClosed      = errors.New("message bus is closed")
+	ErrInboundDropped = errors.New("inbound message dropped by a hook")
 )
 
+// InboundOptions configures the inbound queue.
+type InboundOptions struct {
+	Capacity     int            // Messages queued across all channels; 0 means 100
+	Overflow     OverflowPolicy // Empty means OverflowBlock
+	SpoolDir     string         // Persist queued messages here; empty keeps them in memory only
+	RejectNotice string         // Sent to the sender under OverflowReject
+
+	// Debounce returns how long to wait for more messages from the same
+	// sender before handing a turn to the agent; nil or 0 disables it.
+	Debounce func(channel string) time.Duration
+}
+
+// InboundStats is a snapshot of the inbound queue.
+type InboundStats struct {
+	Depth     int            `json:"depth"`
+	Capacity  int            `json:"capacity"`
+	HighWater int            `json:"high_water"`
+	Channels  map[string]int `json:"channels"`
+	Published uint64         `json:"published"`
+	Dropped   uint64         `json:"dropped"`
+	Rejected  uint64         `json:"rejected"`
+}
+
 type MessageBus struct {
-	inbound  chan InboundMessage
 	outbound chan OutboundMessage
 	handlers map[string]MessageHandler
 	mu       sync.RWMutex
+
+	inboundHooks  []namedHook[InboundMessage]
+	outboundHooks []namedHook[OutboundMessage]
+	eventHandlers map[string][]EventHandler
+	debounce      *debouncer
+
+	// Inbound messages wait in one queue per channel, served round-robin
+	// so a busy channel can't starve the others.
+	opts    InboundOptions
+	spool   *spool
+	inMu    sync.Mutex
+	notFull *sync.Cond
+	wake    chan struct{}
+	queues  map[string][]spooledMessage
+	order   []string // channels with queued messages, next to serve first
+	depth   int
+	closed  bool
+	stats   InboundStats
 }
 
 func NewMessageBus() *MessageBus {
-	return &MessageBus{
-		inbound:  make(chan InboundMessage, 100),
+	mb, _ := NewMessageBusWithOptions(InboundOptions{})
+	return mb
+}
+
+// NewMessageBusWithOptions creates a bus with a bounded inbound queue.
+// With a SpoolDir, messages left from a previous run are queued again.
+func NewMessageBusWithOptions(opts InboundOptions) (*MessageBus, error) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = defaultInboundCapacity
+	}
+	switch opts.Overflow {
+	case "":
+		opts.Overflow = OverflowBlock
+	case OverflowBlock, OverflowDropOldest, OverflowReject:
+	default:
+		return nil, fmt.Errorf("unknown overflow policy %q", opts.Overflow)
+	}
+	if opts.RejectNotice == "" {
+		opts.RejectNotice = defaultRejectNotice
+	}
+
+	mb := &MessageBus{
 		outbound: make(chan OutboundMessage, 100),
 		handlers: make(map[string]MessageHandler),
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		queues:   make(map[string][]spooledMessage),
+	}
+	mb.notFull = sync.NewCond(&mb.inMu)
+	mb.stats.Capacity = opts.Capacity
+	if opts.Debounce != nil {
+		mb.debounce = newDebouncer(opts.Debounce, mb.enqueue)
+	}
+
+	if opts.SpoolDir != "" {
+		s, err := newSpool(opts.SpoolDir)
+		if err != nil {
+			return nil, err
+		}
+		pending, err := s.load()
+		if err != nil {
+			return nil, err
+		}
+		mb.spool = s
+		for _, sm := range pending {
+			mb.push(sm)
+		}
+		if len(pending) > 0 {
+			logger.InfoCF("bus", "Restored spooled inbound messages", map[string]interface{}{
+				"count": len(pending),
+			})
+		}
+	}
+	return mb, nil
+}
+
+// PublishInbound runs msg through the inbound hooks and queues the result
+// for the agent, merging quick follow-ups from the same sender when
+// debouncing is on. When the queue is full it blocks, drops the oldest
+// message of the busiest channel, or returns ErrInboundFull, depending on
+// the overflow policy. Internal channels are never dropped or rejected.
+// When the hooks drop the message, it returns ErrInboundDropped.
+func (mb *MessageBus) PublishInbound(msg InboundMessage) error {
+	mb.mu.RLock()
+	hooks := mb.inboundHooks
+	mb.mu.RUnlock()
+	if len(hooks) == 0 {
+		return mb.accept(msg)
 	}
+
+	msgs := runHooks(hooks, msg, func(m InboundMessage) string { return m.Channel })
+	if len(msgs) == 0 {
+		return ErrInboundDropped
+	}
+	var firstErr error
+	for _, m := range msgs {
+		if err := mb.accept(m); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-func (mb *MessageBus) PublishInbound(msg InboundMessage) {
-	mb.inbound <- msg
+// accept queues msg, through the debouncer if one is configured.
+func (mb *MessageBus) accept(msg InboundMessage) error {
+	if mb.debounce != nil {
+		return mb.debounce.publish(msg)
+	}
+	return mb.enqueue(msg)
 }
 
+func (mb *MessageBus) enqueue(msg InboundMessage) error {
+	mb.inMu.Lock()
+	defer mb.inMu.Unlock()
+
+	if mb.closed {
+		return ErrBusClosed
+	}
+	if mb.depth >= mb.opts.Capacity && !constants.IsInternalChannel(msg.Channel) {
+		switch mb.opts.Overflow {
+		case OverflowDropOldest:
+			mb.dropOldest()
+		case OverflowReject:
+			mb.stats.Rejected++
+			logger.WarnCF("bus", "Inbound queue full, rejecting message", map[string]interface{}{
+				"channel": msg.Channel,
+				"chat_id": msg.ChatID,
+				"depth":   mb.depth,
+			})
+			notice := OutboundMessage{Channel: msg.Channel, ChatID: msg.ChatID, Content: mb.opts.RejectNotice}
+			go mb.PublishOutbound(notice)
+			return ErrInboundFull
+		default:
+			for mb.depth >= mb.opts.Capacity && !mb.closed {
+				mb.notFull.Wait()
+			}
+			if mb.closed {
+				return ErrBusClosed
+			}
+		}
+	}
+
+	sm := spooledMessage{msg: msg}
+	if mb.spool != nil {
+		sm.id = mb.spool.newID()
+		if err := mb.spool.write(sm.id, msg); err != nil {
+			// Still deliver it, just not durably
+			logger.WarnCF("bus", "Failed to spool inbound message", map[string]interface{}{
+				"channel": msg.Channel,
+				"error":   err.Error(),
+			})
+			sm.id = ""
+		}
+	}
+	mb.push(sm)
+	mb.stats.Published++
+	return nil
+}
+
+// ConsumeInbound takes the next queued message. A spooled message stays on
+// disk until AckInbound, so one that was being handled when the process
+// stopped is delivered again after a restart.
 func (mb *MessageBus) ConsumeInbound(ctx context.Context) (InboundMessage, bool) {
+	for {
+		mb.inMu.Lock()
+		if sm, ok := mb.pop(); ok {
+			if mb.depth > 0 {
+				mb.signal()
+			}
+			mb.notFull.Signal()
+			mb.inMu.Unlock()
+			msg := sm.msg
+			msg.spoolID = sm.id
+			return msg, true
+		}
+		closed := mb.closed
+		mb.inMu.Unlock()
+		if closed {
+			return InboundMessage{}, false
+		}
+
+		select {
+		case <-mb.wake:
+		case <-ctx.Done():
+			return InboundMessage{}, false
+		}
+	}
+}
+
+// AckInbound reports that the agent has finished with msg, removing it from
+// the spool.
+func (mb *MessageBus) AckInbound(msg InboundMessage) {
+	if mb.spool != nil && msg.spoolID != "" {
+		mb.spool.remove(msg.spoolID)
+	}
+}
+
+// InboundStats returns the current queue depth per channel and the
+// overflow counters.
+func (mb *MessageBus) InboundStats() InboundStats {
+	mb.inMu.Lock()
+	defer mb.inMu.Unlock()
+
+	stats := mb.stats
+	stats.Depth = mb.depth
+	stats.Channels = make(map[string]int, len(mb.queues))
+	for channel, q := range mb.queues {
+		stats.Channels[channel] = len(q)
+	}
+	return stats
+}
+
+// push appends sm to its channel's queue. Must be called with inMu held.
+func (mb *MessageBus) push(sm spooledMessage) {
+	channel := sm.msg.Channel
+	if len(mb.queues[channel]) == 0 {
+		mb.order = append(mb.order, channel)
+	}
+	mb.queues[channel] = append(mb.queues[channel], sm)
+	mb.depth++
+	mb.stats.HighWater = max(mb.stats.HighWater, mb.depth)
+	mb.signal()
+}
+
+// pop takes the next message from the channel whose turn it is. Must be
+// called with inMu held.
+func (mb *MessageBus) pop() (spooledMessage, bool) {
+	if len(mb.order) == 0 {
+		return spooledMessage{}, false
+	}
+	channel := mb.order[0]
+	mb.order = mb.order[1:]
+
+	q := mb.queues[channel]
+	sm := q[0]
+	if len(q) > 1 {
+		mb.queues[channel] = q[1:]
+		mb.order = append(mb.order, channel)
+	} else {
+		delete(mb.queues, channel)
+	}
+	mb.depth--
+	return sm, true
+}
+
+// dropOldest discards the oldest message of the external channel with the
+// most queued messages. Must be called with inMu held.
+func (mb *MessageBus) dropOldest() {
+	busiest := ""
+	for _, channel := range mb.order {
+		if constants.IsInternalChannel(channel) {
+			continue
+		}
+		if busiest == "" || len(mb.queues[channel]) > len(mb.queues[busiest]) {
+			busiest = channel
+		}
+	}
+	if busiest == "" {
+		return
+	}
+
+	q := mb.queues[busiest]
+	dropped := q[0]
+	if len(q) > 1 {
+		mb.queues[busiest] = q[1:]
+	} else {
+		delete(mb.queues, busiest)
+		for i, channel := range mb.order {
+			if channel == busiest {
+				mb.order = append(mb.order[:i], mb.order[i+1:]...)
+				break
+			}
+		}
+	}
+	mb.depth--
+	mb.stats.Dropped++
+	if mb.spool != nil && dropped.id != "" {
+		mb.spool.remove(dropped.id)
+	}
+	logger.WarnCF("bus", "Inbound queue full, dropped oldest message", map[string]interface{}{
+		"channel": busiest,
+		"chat_id": dropped.msg.ChatID,
+	})
+}
+
+// signal wakes a waiting consumer. Must be called with inMu held.
+func (mb *MessageBus) signal() {
+	if mb.closed {
+		return
+	}
 	select {
-	case msg := <-mb.inbound:
-		return msg, true
-	case <-ctx.Done():
-		return InboundMessage{}, false
+	case mb.wake <- struct{}{}:
+	default:
 	}
 }
 
+// PublishOutbound runs msg through the outbound hooks and hands the result
+// to the channel dispatcher.
 func (mb *MessageBus) PublishOutbound(msg OutboundMessage) {
-	mb.outbound <- msg
+	mb.mu.RLock()
+	hooks := mb.outboundHooks
+	mb.mu.RUnlock()
+	if len(hooks) == 0 {
+		mb.outbound <- msg
+		return
+	}
+
+	for _, m := range runHooks(hooks, msg, func(m OutboundMessage) string { return m.Channel }) {
+		mb.outbound <- m
+	}
 }
 
 func (mb *MessageBus) SubscribeOutbound(ctx context.Context) (OutboundMessage, bool) {
@@ -60,6 +399,12 @@ func (mb *MessageBus) GetHandler(channel string) (MessageHandler, bool) {
 }
 
 func (mb *MessageBus) Close() {
-	close(mb.inbound)
+	mb.inMu.Lock()
+	if !mb.closed {
+		mb.closed = true
+		mb.notFull.Broadcast()
+		close(mb.wake)
+	}
+	mb.inMu.Unlock()
 	close(mb.outbound)
 }
@@ -0,0 +1,200 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func consumeN(t *testing.T, mb *MessageBus, n int) []InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var out []InboundMessage
+	for i := 0; i < n; i++ {
+		msg, ok := mb.ConsumeInbound(ctx)
+		if !ok {
+			t.Fatalf("ConsumeInbound returned after %d of %d messages", i, n)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func TestMessageBus_ServesChannelsRoundRobin(t *testing.T) {
+	mb := NewMessageBus()
+	for _, content := range []string{"t1", "t2", "t3"} {
+		mb.PublishInbound(InboundMessage{Channel: "telegram", Content: content})
+	}
+	mb.PublishInbound(InboundMessage{Channel: "slack", Content: "s1"})
+
+	var got []string
+	for _, msg := range consumeN(t, mb, 4) {
+		got = append(got, msg.Content)
+	}
+	want := []string{"t1", "s1", "t2", "t3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMessageBus_DropOldestFromBusiestChannel(t *testing.T) {
+	mb, err := NewMessageBusWithOptions(InboundOptions{Capacity: 3, Overflow: OverflowDropOldest})
+	if err != nil {
+		t.Fatalf("NewMessageBusWithOptions error: %v", err)
+	}
+	mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "t1"})
+	mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "t2"})
+	mb.PublishInbound(InboundMessage{Channel: "slack", Content: "s1"})
+	if err := mb.PublishInbound(InboundMessage{Channel: "slack", Content: "s2"}); err != nil {
+		t.Fatalf("PublishInbound error: %v", err)
+	}
+
+	stats := mb.InboundStats()
+	if stats.Depth != 3 || stats.Dropped != 1 || stats.Channels["telegram"] != 1 {
+		t.Errorf("stats = %+v, want depth 3, 1 dropped, 1 telegram", stats)
+	}
+	if msg := consumeN(t, mb, 1)[0]; msg.Content != "t2" {
+		t.Errorf("first = %q, want t2 (t1 dropped)", msg.Content)
+	}
+}
+
+func TestMessageBus_RejectSendsNotice(t *testing.T) {
+	mb, err := NewMessageBusWithOptions(InboundOptions{Capacity: 1, Overflow: OverflowReject, RejectNotice: "busy"})
+	if err != nil {
+		t.Fatalf("NewMessageBusWithOptions error: %v", err)
+	}
+	mb.PublishInbound(InboundMessage{Channel: "telegram", ChatID: "1", Content: "a"})
+	err = mb.PublishInbound(InboundMessage{Channel: "telegram", ChatID: "2", Content: "b"})
+	if !errors.Is(err, ErrInboundFull) {
+		t.Fatalf("PublishInbound error = %v, want ErrInboundFull", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	notice, ok := mb.SubscribeOutbound(ctx)
+	if !ok || notice.ChatID != "2" || notice.Content != "busy" {
+		t.Errorf("notice = %+v, want busy to chat 2", notice)
+	}
+
+	// Internal channels are never rejected
+	if err := mb.PublishInbound(InboundMessage{Channel: "system", Content: "result"}); err != nil {
+		t.Errorf("system message rejected: %v", err)
+	}
+	if got := mb.InboundStats().Rejected; got != 1 {
+		t.Errorf("Rejected = %d, want 1", got)
+	}
+}
+
+func TestMessageBus_BlockWaitsForConsumer(t *testing.T) {
+	mb, err := NewMessageBusWithOptions(InboundOptions{Capacity: 1})
+	if err != nil {
+		t.Fatalf("NewMessageBusWithOptions error: %v", err)
+	}
+	mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "a"})
+
+	published := make(chan struct{})
+	go func() {
+		mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "b"})
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("PublishInbound returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	consumeN(t, mb, 1)
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("PublishInbound still blocked after a slot was freed")
+	}
+}
+
+func TestMessageBus_SpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	mb, err := NewMessageBusWithOptions(InboundOptions{SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("NewMessageBusWithOptions error: %v", err)
+	}
+	mb.PublishInbound(InboundMessage{Channel: "telegram", ChatID: "1", Content: "first"})
+	mb.PublishInbound(InboundMessage{Channel: "telegram", ChatID: "1", Content: "second"})
+	mb.PublishInbound(InboundMessage{Channel: "telegram", ChatID: "1", Content: "third"})
+	mb.AckInbound(consumeN(t, mb, 1)[0])
+	consumeN(t, mb, 1) // Taken but never acked, as if the process died mid-turn
+
+	restarted, err := NewMessageBusWithOptions(InboundOptions{SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("NewMessageBusWithOptions error: %v", err)
+	}
+	if depth := restarted.InboundStats().Depth; depth != 2 {
+		t.Fatalf("restored depth = %d, want 2", depth)
+	}
+	msgs := consumeN(t, restarted, 2)
+	if msgs[0].Content != "second" || msgs[1].Content != "third" {
+		t.Errorf("restored = %q, %q, want second, third", msgs[0].Content, msgs[1].Content)
+	}
+}
+
+func TestNewMessageBusWithOptions_UnknownPolicy(t *testing.T) {
+	if _, err := NewMessageBusWithOptions(InboundOptions{Overflow: "shrug"}); err == nil {
+		t.Error("expected an error for an unknown overflow policy")
+	}
+}
+
+func TestMessageBus_InboundHooksModifyDropAndFanOut(t *testing.T) {
+	mb := NewMessageBus()
+	mb.UseInbound("drop-spam", func(ctx context.Context, msg InboundMessage) ([]InboundMessage, error) {
+		if msg.Content == "spam" {
+			return nil, nil
+		}
+		return []InboundMessage{msg}, nil
+	})
+	mb.UseInbound("upper", func(ctx context.Context, msg InboundMessage) ([]InboundMessage, error) {
+		msg.Content = strings.ToUpper(msg.Content)
+		return []InboundMessage{msg}, nil
+	})
+	mb.UseInbound("broken", func(ctx context.Context, msg InboundMessage) ([]InboundMessage, error) {
+		return nil, errors.New("boom")
+	})
+
+	if err := mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "spam"}); !errors.Is(err, ErrInboundDropped) {
+		t.Errorf("dropped message error = %v, want ErrInboundDropped", err)
+	}
+	if err := mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "hello"}); err != nil {
+		t.Errorf("PublishInbound error = %v", err)
+	}
+
+	if depth := mb.InboundStats().Depth; depth != 1 {
+		t.Fatalf("depth = %d, want 1", depth)
+	}
+	// A failing hook passes the message on unchanged
+	if msg := consumeN(t, mb, 1)[0]; msg.Content != "HELLO" {
+		t.Errorf("content = %q, want HELLO", msg.Content)
+	}
+}
+
+func TestMessageBus_OutboundHookFansOut(t *testing.T) {
+	mb := NewMessageBus()
+	mb.UseOutbound("archive", func(ctx context.Context, msg OutboundMessage) ([]OutboundMessage, error) {
+		archived := msg
+		archived.Channel = "archive"
+		return []OutboundMessage{msg, archived}, nil
+	})
+	mb.PublishOutbound(OutboundMessage{Channel: "slack", ChatID: "C1", Content: "hi"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, want := range []string{"slack", "archive"} {
+		msg, ok := mb.SubscribeOutbound(ctx)
+		if !ok || msg.Channel != want || msg.Content != "hi" {
+			t.Errorf("got %+v, want %s copy", msg, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package bus
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/constants"
+)
+
+// maxHoldWindows caps how long a steady stream of messages can keep
+// extending the window, as a multiple of the window.
+const maxHoldWindows = 4
+
+// debouncer holds inbound messages per session for a short window and
+// merges consecutive ones from the same sender into a single turn.
+type debouncer struct {
+	window  func(channel string) time.Duration
+	flush   func(InboundMessage) error
+	mu      sync.Mutex
+	pending map[string]*pendingTurn // session key -> held messages
+}
+
+type pendingTurn struct {
+	msg    InboundMessage
+	count  int
+	first  time.Time
+	timer  *time.Timer
+	window time.Duration
+}
+
+func newDebouncer(window func(channel string) time.Duration, flush func(InboundMessage) error) *debouncer {
+	return &debouncer{
+		window:  window,
+		flush:   flush,
+		pending: make(map[string]*pendingTurn),
+	}
+}
+
+// publish holds msg or merges it into the session's held messages. Slash
+// commands, internal channels and channels without a window go straight
+// through, after anything already held for the session.
+func (d *debouncer) publish(msg InboundMessage) error {
+	key := msg.SessionKey
+	if key == "" {
+		key = msg.Channel + ":" + msg.ChatID
+	}
+
+	window := d.window(msg.Channel)
+	if window <= 0 || constants.IsInternalChannel(msg.Channel) || strings.HasPrefix(strings.TrimSpace(msg.Content), "/") {
+		d.flushKey(key)
+		return d.flush(msg)
+	}
+
+	d.mu.Lock()
+	p := d.pending[key]
+	if p != nil && p.msg.SenderID == msg.SenderID {
+		mergeInbound(&p.msg, msg)
+		p.count++
+		// Slide the window, but not past maxHoldWindows from the first message
+		wait := min(window, time.Until(p.first.Add(maxHoldWindows*p.window)))
+		p.timer.Reset(max(wait, 0))
+		d.mu.Unlock()
+		return nil
+	}
+
+	// A different sender ends the previous turn. Taking it out of the map
+	// here makes it ours to flush, even if its timer has already fired
+	if p != nil {
+		p.timer.Stop()
+	}
+	next := &pendingTurn{msg: msg, count: 1, first: time.Now(), window: window}
+	next.timer = time.AfterFunc(window, func() { d.expire(key, next) })
+	d.pending[key] = next
+	d.mu.Unlock()
+
+	if p != nil {
+		d.flush(withCount(p))
+	}
+	return nil
+}
+
+// flushKey publishes whatever is held for key right away.
+func (d *debouncer) flushKey(key string) {
+	d.mu.Lock()
+	p := d.pending[key]
+	if p == nil {
+		d.mu.Unlock()
+		return
+	}
+	p.timer.Stop()
+	delete(d.pending, key)
+	d.mu.Unlock()
+	d.flush(withCount(p))
+}
+
+// expire flushes p when its window ends, unless publish or flushKey
+// already took it out of pending to flush it themselves.
+func (d *debouncer) expire(key string, p *pendingTurn) {
+	d.mu.Lock()
+	if d.pending[key] != p {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.pending, key)
+	d.mu.Unlock()
+	d.flush(withCount(p))
+}
+
+// mergeInbound appends next to msg. Text is joined by newlines, media
+// concatenated, and metadata taken from the latest message so replies
+// target it.
+func mergeInbound(msg *InboundMessage, next InboundMessage) {
+	switch {
+	case next.Content == "":
+	case msg.Content == "":
+		msg.Content = next.Content
+	default:
+		msg.Content += "\n" + next.Content
+	}
+	msg.Media = append(msg.Media, next.Media...)
+	if len(next.Metadata) > 0 {
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]string, len(next.Metadata))
+		}
+		for k, v := range next.Metadata {
+			msg.Metadata[k] = v
+		}
+	}
+}
+
+func withCount(p *pendingTurn) InboundMessage {
+	if p.count > 1 {
+		metadata := make(map[string]string, len(p.msg.Metadata)+1)
+		for k, v := range p.msg.Metadata {
+			metadata[k] = v
+		}
+		metadata["merged_messages"] = strconv.Itoa(p.count)
+		p.msg.Metadata = metadata
+	}
+	return p.msg
+}
@@ -0,0 +1,109 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func debouncedBus(t *testing.T, window time.Duration) *MessageBus {
+	t.Helper()
+	mb, err := NewMessageBusWithOptions(InboundOptions{
+		Debounce: func(channel string) time.Duration {
+			if channel == "slack" {
+				return 0
+			}
+			return window
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewMessageBusWithOptions error: %v", err)
+	}
+	return mb
+}
+
+func TestDebounce_MergesSameSender(t *testing.T) {
+	mb := debouncedBus(t, 50*time.Millisecond)
+	mb.PublishInbound(InboundMessage{Channel: "telegram", SenderID: "1", ChatID: "c", Content: "so I was thinking", Metadata: map[string]string{"message_id": "10"}})
+	mb.PublishInbound(InboundMessage{Channel: "telegram", SenderID: "1", ChatID: "c", Content: "about the trip", Metadata: map[string]string{"message_id": "11"}})
+	mb.PublishInbound(InboundMessage{Channel: "telegram", SenderID: "1", ChatID: "c", Media: []string{"/tmp/photo.jpg"}, Metadata: map[string]string{"message_id": "12"}})
+
+	if depth := mb.InboundStats().Depth; depth != 0 {
+		t.Fatalf("depth during window = %d, want 0", depth)
+	}
+
+	msgs := consumeN(t, mb, 1)
+	msg := msgs[0]
+	if msg.Content != "so I was thinking\nabout the trip" {
+		t.Errorf("Content = %q", msg.Content)
+	}
+	if len(msg.Media) != 1 {
+		t.Errorf("Media = %v, want the photo", msg.Media)
+	}
+	if msg.Metadata["message_id"] != "12" || msg.Metadata["merged_messages"] != "3" {
+		t.Errorf("Metadata = %v, want latest message_id and merged_messages=3", msg.Metadata)
+	}
+}
+
+func TestDebounce_SenderChangeAndCommandsFlush(t *testing.T) {
+	mb := debouncedBus(t, time.Hour)
+	mb.PublishInbound(InboundMessage{Channel: "telegram", SenderID: "1", ChatID: "group", Content: "hi"})
+	mb.PublishInbound(InboundMessage{Channel: "telegram", SenderID: "2", ChatID: "group", Content: "hello"})
+	mb.PublishInbound(InboundMessage{Channel: "telegram", SenderID: "2", ChatID: "group", Content: "/reset"})
+
+	// Sender 1's turn ends when sender 2 speaks; the command flushes
+	// sender 2's turn and goes straight through
+	var got []string
+	for _, msg := range consumeN(t, mb, 3) {
+		got = append(got, msg.Content)
+	}
+	want := []string{"hi", "hello", "/reset"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDebounce_DisabledChannelPassesThrough(t *testing.T) {
+	mb := debouncedBus(t, time.Hour)
+	mb.PublishInbound(InboundMessage{Channel: "slack", SenderID: "U1", ChatID: "C1", Content: "one"})
+	mb.PublishInbound(InboundMessage{Channel: "slack", SenderID: "U1", ChatID: "C1", Content: "two"})
+
+	if depth := mb.InboundStats().Depth; depth != 2 {
+		t.Errorf("depth = %d, want 2", depth)
+	}
+}
+
+func TestDebounce_ConcurrentSendersLoseNothing(t *testing.T) {
+	mb := debouncedBus(t, time.Millisecond)
+	const n = 1000
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mb.PublishInbound(InboundMessage{Channel: "webhook", SenderID: fmt.Sprint(i % 3), ChatID: "c", Content: fmt.Sprint("m", i)})
+		}(i)
+	}
+	defer wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	seen := make(map[string]bool)
+	for len(seen) < n {
+		msg, ok := mb.ConsumeInbound(ctx)
+		if !ok {
+			t.Fatalf("got %d of %d messages", len(seen), n)
+		}
+		for _, line := range strings.Split(msg.Content, "\n") {
+			if seen[line] {
+				t.Errorf("%s delivered twice", line)
+			}
+			seen[line] = true
+		}
+	}
+}
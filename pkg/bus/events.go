@@ -0,0 +1,56 @@
+package bus
+
+// Agent event types.
+const (
+	EventTurnStart  = "turn_start"  // The agent started working on a message
+	EventText       = "text"        // Text the model wrote alongside tool calls
+	EventTextDelta  = "text_delta"  // A piece of the model's text as it streams in
+	EventToolCall   = "tool_call"   // A tool is about to run
+	EventToolResult = "tool_result" // A tool finished; Error is set when it failed
+	EventTurnEnd    = "turn_end"    // The agent finished; every reply of the turn has been published
+)
+
+// AgentEvent reports the progress of an agent turn so channels that can
+// show it live (the web chat) don't have to wait for the final reply.
+// Events are best effort: they are not queued, persisted or retried.
+type AgentEvent struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	ChatID  string `json:"chat_id"`
+	Tool    string `json:"tool,omitempty"`
+	Args    string `json:"args,omitempty"` // JSON arguments, truncated
+	Content string `json:"content,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EventHandler receives agent events. It runs on the agent's goroutine
+// and must not block.
+type EventHandler func(AgentEvent)
+
+// OnEvent registers a handler for the events of turns on a channel.
+func (mb *MessageBus) OnEvent(channel string, handler EventHandler) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.eventHandlers == nil {
+		mb.eventHandlers = make(map[string][]EventHandler)
+	}
+	mb.eventHandlers[channel] = append(mb.eventHandlers[channel], handler)
+}
+
+// HasEventHandlers reports whether anything listens to a channel's events,
+// so the agent can skip work, such as streaming, that nobody would see.
+func (mb *MessageBus) HasEventHandlers(channel string) bool {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	return len(mb.eventHandlers[channel]) > 0
+}
+
+// PublishEvent hands ev to the handlers registered for its channel.
+func (mb *MessageBus) PublishEvent(ev AgentEvent) {
+	mb.mu.RLock()
+	handlers := mb.eventHandlers[ev.Channel]
+	mb.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(ev)
+	}
+}
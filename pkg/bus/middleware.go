@@ -0,0 +1,70 @@
+package bus
+
+import (
+	"context"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// InboundHook sees every message on its way to the agent and returns the
+// messages to pass on: none drops it, one (possibly modified) passes it
+// along, several fan it out. On error the message passes unchanged.
+type InboundHook func(ctx context.Context, msg InboundMessage) ([]InboundMessage, error)
+
+// OutboundHook is the InboundHook counterpart for messages on their way to
+// a channel.
+type OutboundHook func(ctx context.Context, msg OutboundMessage) ([]OutboundMessage, error)
+
+type namedHook[T any] struct {
+	name string
+	hook func(ctx context.Context, msg T) ([]T, error)
+}
+
+// UseInbound appends a hook to the inbound chain. Hooks run in the order
+// they were added, before the message is queued.
+func (mb *MessageBus) UseInbound(name string, hook InboundHook) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.inboundHooks = append(mb.inboundHooks, namedHook[InboundMessage]{name: name, hook: hook})
+}
+
+// UseOutbound appends a hook to the outbound chain. Hooks run in the order
+// they were added, before the message reaches the channel dispatcher.
+func (mb *MessageBus) UseOutbound(name string, hook OutboundHook) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.outboundHooks = append(mb.outboundHooks, namedHook[OutboundMessage]{name: name, hook: hook})
+}
+
+// runHooks passes msg through each hook in turn, feeding every message a
+// hook returns to the next one.
+func runHooks[T any](hooks []namedHook[T], msg T, channel func(T) string) []T {
+	msgs := []T{msg}
+	for _, h := range hooks {
+		var next []T
+		for _, m := range msgs {
+			out, err := h.hook(context.Background(), m)
+			if err != nil {
+				logger.WarnCF("bus", "Hook failed, passing message unchanged", map[string]interface{}{
+					"hook":    h.name,
+					"channel": channel(m),
+					"error":   err.Error(),
+				})
+				next = append(next, m)
+				continue
+			}
+			if len(out) == 0 {
+				logger.DebugCF("bus", "Hook dropped message", map[string]interface{}{
+					"hook":    h.name,
+					"channel": channel(m),
+				})
+			}
+			next = append(next, out...)
+		}
+		msgs = next
+		if len(msgs) == 0 {
+			break
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,94 @@
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spool keeps one JSON file per queued inbound message so messages the
+// agent hasn't picked up yet survive a restart. File names sort in arrival
+// order.
+type spool struct {
+	dir string
+	mu  sync.Mutex
+	seq int
+}
+
+type spooledMessage struct {
+	id  string
+	msg InboundMessage
+}
+
+func newSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating inbound spool: %w", err)
+	}
+	return &spool{dir: dir}, nil
+}
+
+func (s *spool) newID() string {
+	s.mu.Lock()
+	s.seq = (s.seq + 1) % 10000
+	seq := s.seq
+	s.mu.Unlock()
+	return fmt.Sprintf("%d-%04d", time.Now().UnixNano(), seq)
+}
+
+// write saves msg atomically (temp file + rename).
+func (s *spool) write(id string, msg InboundMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, id+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s *spool) remove(id string) {
+	os.Remove(filepath.Join(s.dir, id+".json"))
+}
+
+// load returns the spooled messages, oldest first. Unreadable files are
+// removed rather than retried forever.
+func (s *spool) load() ([]spooledMessage, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+
+	var out []spooledMessage
+	for _, id := range ids {
+		data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+		if err != nil {
+			continue
+		}
+		var msg InboundMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.remove(id)
+			continue
+		}
+		out = append(out, spooledMessage{id: id, msg: msg})
+	}
+	return out, nil
+}
@@ -1,5 +1,12 @@
 package bus
 
+import (
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
 type InboundMessage struct {
 	Channel    string            `json:"channel"`
 	SenderID   string            `json:"sender_id"`
@@ -8,12 +15,127 @@ type InboundMessage struct {
 	Media      []string          `json:"media,omitempty"`
 	SessionKey string            `json:"session_key"`
 	Metadata   map[string]string `json:"metadata,omitempty"`
+
+	spoolID string // Spool file to remove once the agent acks the message
 }
 
 type OutboundMessage struct {
-	Channel string `json:"channel"`
-	ChatID  string `json:"chat_id"`
-	Content string `json:"content"`
+	Channel     string       `json:"channel"`
+	ChatID      string       `json:"chat_id"`
+	Content     string       `json:"content"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// ReplyTo is the platform message ID to reply to or quote.
+	ReplyTo string `json:"reply_to,omitempty"`
+	// EditMessageID replaces the content of a message previously sent by
+	// the bot instead of sending a new one. EditLast targets the bot's
+	// latest message in the chat.
+	EditMessageID string `json:"edit_message_id,omitempty"`
+	// Reactions are emoji added to the ReplyTo message.
+	Reactions []string `json:"reactions,omitempty"`
+	// Buttons are rows of interactive buttons (quick replies on LINE).
+	// Clicks arrive as InboundMessages whose content is the button's Data,
+	// with Metadata["button_data"] set.
+	Buttons [][]Button `json:"buttons,omitempty"`
+	// InteractionID answers a platform interaction that waits for a reply,
+	// such as a Discord slash command. It is copied from the inbound
+	// message's Metadata["interaction_id"].
+	InteractionID string `json:"interaction_id,omitempty"`
+}
+
+// EditLast as OutboundMessage.EditMessageID edits the last message the bot
+// sent to the chat.
+const EditLast = "last"
+
+// Button is an interactive button attached to an outbound message. URL
+// buttons open a link; the others send Data (or Text when Data is empty)
+// back to the agent.
+type Button struct {
+	Text string `json:"text"`
+	Data string `json:"data,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// Payload returns the value reported back when the button is clicked.
+func (b Button) Payload() string {
+	if b.Data != "" {
+		return b.Data
+	}
+	return b.Text
+}
+
+// Attachment is a file sent along with an outbound message.
+type Attachment struct {
+	Path     string `json:"path,omitempty"`      // Local file path
+	URL      string `json:"url,omitempty"`       // Remote URL, used when Path is empty
+	MIMEType string `json:"mime_type,omitempty"` // Detected from the file name when empty
+	Caption  string `json:"caption,omitempty"`
+}
+
+// Attachment kinds returned by Kind.
+const (
+	AttachmentImage = "image"
+	AttachmentAudio = "audio"
+	AttachmentVideo = "video"
+	AttachmentFile  = "file"
+)
+
+// mediaTypes covers media extensions missing from Go's built-in MIME table
+// on systems without /etc/mime.types.
+var mediaTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".mp4":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+}
+
+// FileName returns the base name of the attachment's path or URL.
+func (a Attachment) FileName() string {
+	if a.Path != "" {
+		return filepath.Base(a.Path)
+	}
+	name := a.URL
+	if idx := strings.IndexAny(name, "?#"); idx >= 0 {
+		name = name[:idx]
+	}
+	return path.Base(name)
+}
+
+// MIME returns the attachment's MIME type, guessed from the file extension
+// when not set.
+func (a Attachment) MIME() string {
+	if a.MIMEType != "" {
+		return a.MIMEType
+	}
+	ext := strings.ToLower(filepath.Ext(a.FileName()))
+	if t, ok := mediaTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// Kind classifies the attachment as image, audio, video or file.
+func (a Attachment) Kind() string {
+	m := a.MIME()
+	switch {
+	case strings.HasPrefix(m, "image/"):
+		return AttachmentImage
+	case strings.HasPrefix(m, "audio/"), m == "application/ogg":
+		return AttachmentAudio
+	case strings.HasPrefix(m, "video/"):
+		return AttachmentVideo
+	}
+	return AttachmentFile
 }
 
 type MessageHandler func(InboundMessage) error
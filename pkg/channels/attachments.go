@@ -0,0 +1,114 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// maxAttachmentSize caps attachments read into memory (50 MB, the
+// Telegram bot upload limit).
+const maxAttachmentSize = 50 << 20
+
+// readAttachment returns the attachment's contents, downloading it when
+// only a URL is set.
+func readAttachment(ctx context.Context, a bus.Attachment) ([]byte, error) {
+	if a.Path != "" {
+		info, err := os.Stat(a.Path)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %s: %w", a.Path, err)
+		}
+		if info.Size() > maxAttachmentSize {
+			return nil, fmt.Errorf("attachment %s is too large (%d bytes)", a.Path, info.Size())
+		}
+		return os.ReadFile(a.Path)
+	}
+	if a.URL == "" {
+		return nil, fmt.Errorf("attachment has neither path nor url")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("downloading attachment: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading attachment: status %d", resp.StatusCode)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, maxAttachmentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading attachment: %w", err)
+	}
+	if n > maxAttachmentSize {
+		return nil, fmt.Errorf("attachment %s is too large", a.URL)
+	}
+	return buf.Bytes(), nil
+}
+
+// attachmentText describes an attachment in plain text, for channels (or
+// failures) where it can't be uploaded.
+func attachmentText(a bus.Attachment) string {
+	ref := a.URL
+	if ref == "" {
+		ref = a.FileName()
+	}
+	if a.Caption != "" {
+		return fmt.Sprintf("📎 %s: %s", a.Caption, ref)
+	}
+	return "📎 " + ref
+}
+
+// withAttachmentText appends a text line per attachment to content. Used by
+// channels that can't upload files.
+func withAttachmentText(content string, attachments []bus.Attachment) string {
+	if len(attachments) == 0 {
+		return content
+	}
+	lines := make([]string, 0, len(attachments)+1)
+	if content != "" {
+		lines = append(lines, content, "")
+	}
+	for _, a := range attachments {
+		lines = append(lines, attachmentText(a))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mediaDir is where channels keep received files: the workspace's
+// tmp/picoclaw_media, or the system temp directory without a workspace.
+func mediaDir(workspace string) string {
+	if workspace == "" {
+		return filepath.Join(os.TempDir(), "picoclaw_media")
+	}
+	return filepath.Join(workspace, "tmp", "picoclaw_media")
+}
+
+// saveMedia writes a received file to the media directory under a unique
+// name and returns its path.
+func saveMedia(workspace, name string, data []byte) (string, error) {
+	dir := mediaDir(workspace)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, uuid.New().String()[:8]+"_"+utils.SanitizeFilename(name))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,89 @@
+package channels
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+func TestWithAttachmentText(t *testing.T) {
+	got := withAttachmentText("Done", []bus.Attachment{
+		{Path: "/tmp/out/report.pdf", Caption: "Report"},
+		{URL: "https://example.com/a.png?size=large"},
+	})
+	want := "Done\n\n📎 Report: report.pdf\n📎 https://example.com/a.png?size=large"
+	if got != want {
+		t.Errorf("withAttachmentText() = %q, want %q", got, want)
+	}
+	if got := withAttachmentText("plain", nil); got != "plain" {
+		t.Errorf("withAttachmentText(no attachments) = %q", got)
+	}
+}
+
+func TestBuildLINEMessages(t *testing.T) {
+	messages := buildLINEMessages(bus.OutboundMessage{
+		Content: "Look",
+		Attachments: []bus.Attachment{
+			{URL: "https://example.com/cat.jpg"},
+			{Path: "/tmp/local.png"},
+		},
+	}, "qt")
+	if len(messages) != 2 {
+		t.Fatalf("len(messages) = %d, want text + image", len(messages))
+	}
+	text := messages[0].(map[string]string)
+	if !strings.Contains(text["text"], "local.png") || text["quoteToken"] != "qt" {
+		t.Errorf("text message = %v, want local file listed and quote token", text)
+	}
+	image := messages[1].(map[string]string)
+	if image["type"] != "image" || image["originalContentUrl"] != "https://example.com/cat.jpg" {
+		t.Errorf("image message = %v", image)
+	}
+}
+
+func TestBuildOneBotMessages(t *testing.T) {
+	dir := t.TempDir()
+	voice := filepath.Join(dir, "note.ogg")
+	os.WriteFile(voice, []byte("ogg"), 0644)
+
+	messages := buildOneBotMessages(context.Background(), bus.OutboundMessage{
+		Content: "hi",
+		Attachments: []bus.Attachment{
+			{URL: "https://example.com/cat.jpg"},
+			{Path: voice},
+			{Path: filepath.Join(dir, "data.csv")},
+		},
+	})
+	if len(messages) != 2 {
+		t.Fatalf("len(messages) = %d, want text+image and a separate voice message", len(messages))
+	}
+
+	first := messages[0].([]oneBotSegment)
+	if len(first) != 2 || first[0].Type != "text" || first[1].Type != "image" {
+		t.Fatalf("first message = %+v", first)
+	}
+	if !strings.Contains(first[0].Data["text"], "data.csv") {
+		t.Errorf("text = %q, want unsupported file listed", first[0].Data["text"])
+	}
+	record := messages[1].([]oneBotSegment)
+	if record[0].Type != "record" || !strings.HasPrefix(record[0].Data["file"], "base64://") {
+		t.Errorf("voice message = %+v", record)
+	}
+
+	if plain := buildOneBotMessages(context.Background(), bus.OutboundMessage{Content: "text"}); plain[0] != "text" {
+		t.Errorf("plain message = %v, want string", plain[0])
+	}
+
+	// Files over the size limit are listed instead of loaded
+	huge := filepath.Join(dir, "huge.png")
+	os.WriteFile(huge, nil, 0644)
+	os.Truncate(huge, maxAttachmentSize+1)
+	messages = buildOneBotMessages(context.Background(), bus.OutboundMessage{Attachments: []bus.Attachment{{Path: huge}}})
+	if only := messages[0].([]oneBotSegment); len(only) != 1 || only[0].Type != "text" {
+		t.Errorf("oversized image = %+v, want it listed as text", only)
+	}
+}
@@ -2,10 +2,17 @@ package channels
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+var (
+	ErrSenderNotAllowed = errors.New("sender not allowed")
+	ErrDuplicateMessage = errors.New("duplicate message")
 )
 
 type Channel interface {
@@ -23,6 +30,7 @@ type BaseChannel struct {
 	running   bool
 	name      string
 	allowList []string
+	dedup     *dedupCache
 }
 
 func NewBaseChannel(name string, config interface{}, bus *bus.MessageBus, allowList []string) *BaseChannel {
@@ -32,6 +40,7 @@ func NewBaseChannel(name string, config interface{}, bus *bus.MessageBus, allowL
 		name:      name,
 		allowList: allowList,
 		running:   false,
+		dedup:     newDedupCache(dedupTTL, dedupSize),
 	}
 }
 
@@ -82,9 +91,29 @@ func (c *BaseChannel) IsAllowed(senderID string) bool {
 	return false
 }
 
-func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []string, metadata map[string]string) {
+// HandleMessage publishes an inbound message. Channels set
+// metadata["message_id"] to the platform's message ID; a message whose ID
+// was already handled recently in the same chat is a redelivery and is
+// skipped. IDs are only compared within a chat, since many platforms (and
+// client-chosen IDs) number messages per chat.
+//
+// It returns nil once the message is queued for the agent. Otherwise it
+// returns ErrSenderNotAllowed, ErrDuplicateMessage, or the bus's error
+// (bus.ErrInboundDropped, bus.ErrInboundFull…). Channels that can't tell
+// the sender about a failure are free to ignore it.
+func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []string, metadata map[string]string) error {
 	if !c.IsAllowed(senderID) {
-		return
+		return ErrSenderNotAllowed
+	}
+
+	// Button clicks carry the ID of the message the button is on, which
+	// can be clicked more than once
+	if id := metadata["message_id"]; id != "" && metadata["interaction"] == "" && c.dedup.check(chatID+"/"+id) {
+		logger.DebugCF(c.name, "Duplicate message, skipping", map[string]interface{}{
+			"chat_id":    chatID,
+			"message_id": id,
+		})
+		return ErrDuplicateMessage
 	}
 
 	// Build session key: channel:chatID
@@ -100,7 +129,18 @@ func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []st
 		Metadata:   metadata,
 	}
 
-	c.bus.PublishInbound(msg)
+	return c.bus.PublishInbound(msg)
+}
+
+// HandleButton publishes a button click as an inbound message whose content
+// is the button's payload.
+func (c *BaseChannel) HandleButton(senderID, chatID, payload string, metadata map[string]string) error {
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata["interaction"] = "button"
+	metadata["button_data"] = payload
+	return c.HandleMessage(senderID, chatID, payload, nil, metadata)
 }
 
 func (c *BaseChannel) setRunning(running bool) {
@@ -1,6 +1,12 @@
 package channels
 
-import "testing"
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
 
 func TestBaseChannelIsAllowed(t *testing.T) {
 	tests := []struct {
@@ -50,3 +56,60 @@ func TestBaseChannelIsAllowed(t *testing.T) {
 		})
 	}
 }
+
+func TestBaseChannelHandleMessage_SkipsRedeliveries(t *testing.T) {
+	mb := bus.NewMessageBus()
+	ch := NewBaseChannel("line", nil, mb, nil)
+
+	if err := ch.HandleMessage("u1", "c1", "hello", nil, map[string]string{"message_id": "m1"}); err != nil {
+		t.Fatalf("HandleMessage error = %v", err)
+	}
+	if err := ch.HandleMessage("u1", "c1", "hello", nil, map[string]string{"message_id": "m1"}); !errors.Is(err, ErrDuplicateMessage) {
+		t.Errorf("redelivery error = %v, want ErrDuplicateMessage", err)
+	}
+	ch.HandleMessage("u1", "c1", "again", nil, map[string]string{"message_id": "m2"})
+	ch.HandleMessage("u1", "c1", "no id", nil, nil)
+	ch.HandleMessage("u1", "c1", "no id", nil, nil)
+	// Buttons on one message may be clicked repeatedly
+	ch.HandleButton("u1", "c1", "yes", map[string]string{"message_id": "m2"})
+	ch.HandleButton("u1", "c1", "yes", map[string]string{"message_id": "m2"})
+
+	if depth := mb.InboundStats().Depth; depth != 6 {
+		t.Errorf("queued = %d, want 6", depth)
+	}
+}
+
+func TestBaseChannelHandleMessage_DedupPerChat(t *testing.T) {
+	mb := bus.NewMessageBus()
+	ch := NewBaseChannel("telegram", nil, mb, nil)
+
+	// Telegram numbers messages per chat, so two chats reuse IDs
+	ch.HandleMessage("u1", "100", "hi from 100", nil, map[string]string{"message_id": "7"})
+	ch.HandleMessage("u2", "200", "hi from 200", nil, map[string]string{"message_id": "7"})
+	ch.HandleMessage("u2", "200", "hi from 200", nil, map[string]string{"message_id": "7"})
+
+	if depth := mb.InboundStats().Depth; depth != 2 {
+		t.Errorf("queued = %d, want 2", depth)
+	}
+}
+
+func TestDedupCache_ExpiresAndEvicts(t *testing.T) {
+	d := newDedupCache(time.Hour, 2)
+	if d.check("a") || d.check("b") {
+		t.Fatal("first sightings reported as duplicates")
+	}
+	if !d.check("a") {
+		t.Error("a not remembered")
+	}
+	d.check("c") // evicts a, the oldest
+	if d.check("a") {
+		t.Error("a still remembered after eviction")
+	}
+
+	short := newDedupCache(time.Millisecond, 8)
+	short.check("x")
+	time.Sleep(5 * time.Millisecond)
+	if short.check("x") {
+		t.Error("x still remembered after TTL")
+	}
+}
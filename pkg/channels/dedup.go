@@ -0,0 +1,57 @@
+package channels
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	dedupTTL  = 10 * time.Minute
+	dedupSize = 4096
+)
+
+// dedupCache remembers recently seen platform message IDs so events the
+// platform redelivers (webhook retries, socket reconnects) are handled
+// once. Entries expire after ttl, and at most size are kept.
+type dedupCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+	ring []dedupEntry // insertion order, oldest overwritten first
+	next int
+}
+
+type dedupEntry struct {
+	id string
+	at time.Time
+}
+
+func newDedupCache(ttl time.Duration, size int) *dedupCache {
+	return &dedupCache{
+		ttl:  ttl,
+		seen: make(map[string]time.Time, size),
+		ring: make([]dedupEntry, size),
+	}
+}
+
+// check reports whether id was seen within the TTL, and records it if not.
+func (d *dedupCache) check(id string) bool {
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if at, ok := d.seen[id]; ok && now.Sub(at) < d.ttl {
+		return true
+	}
+
+	// The slot's entry may have been re-recorded since; only drop it if
+	// the map still points at this slot
+	if old := d.ring[d.next]; old.id != "" && d.seen[old.id] == old.at {
+		delete(d.seen, old.id)
+	}
+	d.ring[d.next] = dedupEntry{id: id, at: now}
+	d.seen[id] = now
+	d.next = (d.next + 1) % len(d.ring)
+	return false
+}
@@ -114,7 +114,7 @@ func (c *DingTalkChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	})
 
 	// Use the session webhook to send the reply
-	return c.SendDirectReply(ctx, sessionWebhook, msg.Content)
+	return c.SendDirectReply(ctx, sessionWebhook, plainContent(msg))
 }
 
 // onChatBotMessageReceived implements the IChatBotMessageHandler function signature
@@ -148,6 +148,7 @@ func (c *DingTalkChannel) onChatBotMessageReceived(ctx context.Context, data *ch
 	c.sessionWebhooks.Store(chatID, data.SessionWebhook)
 
 	metadata := map[string]string{
+		"message_id":        data.MsgId,
 		"sender_name":       senderNick,
 		"conversation_id":   data.ConversationId,
 		"conversation_type": data.ConversationType,
@@ -1,15 +1,19 @@
 package channels
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
 	"github.com/sipeed/picoclaw/pkg/utils"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
@@ -17,15 +21,38 @@ import (
 const (
 	transcriptionTimeout = 30 * time.Second
 	sendTimeout          = 10 * time.Second
+	uploadTimeout        = 60 * time.Second
+
+	// discordMaxLength is Discord's message content limit
+	discordMaxLength = 2000
+	// discordCustomIDLimit is the maximum length of a button's custom ID
+	discordCustomIDLimit = 100
+	// discordThreadNameLimit is the maximum length of a thread name
+	discordThreadNameLimit = 100
+	// discordInteractionTTL is how long a deferred interaction response
+	// can still be filled in
+	discordInteractionTTL = 15 * time.Minute
 )
 
 type DiscordChannel struct {
 	*BaseChannel
-	session     *discordgo.Session
-	config      config.DiscordConfig
-	transcriber *voice.GroqTranscriber
-	ctx         context.Context
-	workspace   string
+	session      *discordgo.Session
+	config       config.DiscordConfig
+	transcriber  *voice.GroqTranscriber
+	ctx          context.Context
+	sent         sentMessages
+	payloads     buttonPayloads
+	workspace    string
+	threads      sync.Map // IDs of threads the bot opened
+	interactions sync.Map // interaction ID -> pendingInteraction
+}
+
+// pendingInteraction is a slash command whose deferred response waits for
+// the agent's reply.
+type pendingInteraction struct {
+	interaction *discordgo.Interaction
+	chatID      string
+	expires     time.Time
 }
 
 func NewDiscordChannel(cfg config.DiscordConfig, bus *bus.MessageBus, workspace string) (*DiscordChannel, error) {
@@ -62,6 +89,7 @@ func (c *DiscordChannel) Start(ctx context.Context) error {
 
 	c.ctx = ctx
 	c.session.AddHandler(c.handleMessage)
+	c.session.AddHandler(c.handleInteraction)
 
 	if err := c.session.Open(); err != nil {
 		return fmt.Errorf("failed to open discord session: %w", err)
@@ -77,10 +105,44 @@ func (c *DiscordChannel) Start(ctx context.Context) error {
 		"username": botUser.Username,
 		"user_id":  botUser.ID,
 	})
+	c.registerCommands(botUser.ID)
 
 	return nil
 }
 
+// registerCommands installs the configured slash commands, replacing the
+// bot's global commands. An empty list leaves existing commands alone.
+func (c *DiscordChannel) registerCommands(appID string) {
+	if len(c.config.Commands) == 0 {
+		return
+	}
+	commands := make([]*discordgo.ApplicationCommand, 0, len(c.config.Commands))
+	for _, cmd := range c.config.Commands {
+		name := strings.ToLower(strings.TrimPrefix(cmd.Name, "/"))
+		if name == "" {
+			continue
+		}
+		description := cmd.Description
+		if description == "" {
+			description = name
+		}
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        name,
+			Description: description,
+			Options: []*discordgo.ApplicationCommandOption{{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "text",
+				Description: "What to ask or tell the bot",
+			}},
+		})
+	}
+	if _, err := c.session.ApplicationCommandBulkOverwrite(appID, "", commands); err != nil {
+		logger.WarnCF("discord", "Failed to register slash commands", map[string]any{
+			"error": err.Error(),
+		})
+	}
+}
+
 func (c *DiscordChannel) Stop(ctx context.Context) error {
 	logger.InfoC("discord", "Stopping Discord bot")
 	c.setRunning(false)
@@ -102,15 +164,121 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 		return fmt.Errorf("channel ID is empty")
 	}
 
-	message := msg.Content
+	if len(msg.Reactions) > 0 && msg.ReplyTo != "" {
+		for _, emoji := range msg.Reactions {
+			if err := c.session.MessageReactionAdd(channelID, msg.ReplyTo, emoji); err != nil {
+				logger.WarnCF("discord", "Failed to add reaction", map[string]any{
+					"emoji": emoji,
+					"error": err.Error(),
+				})
+			}
+		}
+		if msg.Content == "" && len(msg.Attachments) == 0 {
+			return nil
+		}
+	}
+
+	// Attachments that can't be read, and captions (Discord files have
+	// none), go into the text
+	content := msg.Content
+	var files []*discordgo.File
+	for _, a := range msg.Attachments {
+		data, err := readAttachment(ctx, a)
+		if err != nil {
+			logger.ErrorCF("discord", "Failed to read attachment, sending as text", map[string]any{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			content = appendContent(content, attachmentText(a))
+			continue
+		}
+		if a.Caption != "" {
+			content = appendContent(content, a.Caption)
+		}
+		files = append(files, &discordgo.File{
+			Name:        a.FileName(),
+			ContentType: a.MIME(),
+			Reader:      bytes.NewReader(data),
+		})
+	}
+
+	// The first piece replies to or edits the target message; files and
+	// buttons go with the last
+	chunks := renderMessage(content, dialectMarkdown, discordMaxLength)
+	if len(chunks) == 0 {
+		chunks = []string{""}
+	}
+	target := c.sent.editTarget(msg)
+	interaction := c.takeInteraction(msg.InteractionID, msg.ChatID)
+	parts := outbox.PartsFrom(ctx)
+	for i, chunk := range chunks {
+		if parts.Skip() {
+			continue
+		}
+		message := &discordgo.MessageSend{Content: chunk}
+		if i == 0 && msg.ReplyTo != "" {
+			message.Reference = &discordgo.MessageReference{
+				MessageID:       msg.ReplyTo,
+				ChannelID:       channelID,
+				FailIfNotExists: new(bool),
+			}
+		}
+		if i == len(chunks)-1 {
+			message.Files = files
+			message.Components = c.components(msg.Buttons)
+		}
+		if i == 0 && interaction != nil {
+			err := c.editInteraction(interaction, msg.ChatID, message)
+			if err == nil {
+				parts.Done()
+				continue
+			}
+			logger.WarnCF("discord", "Failed to answer slash command, sending a message", map[string]any{
+				"error": err.Error(),
+			})
+		}
+		editID := ""
+		if i == 0 {
+			editID = target
+		}
+		if err := c.sendMessage(ctx, channelID, msg.ChatID, editID, message); err != nil {
+			return err
+		}
+		parts.Done()
+	}
+	return nil
+}
+
+// sendMessage sends message, or edits message editID when set and the
+// message has no files.
+func (c *DiscordChannel) sendMessage(ctx context.Context, channelID, chatID, editID string, message *discordgo.MessageSend) error {
+	timeout := sendTimeout
+	if len(message.Files) > 0 {
+		timeout = uploadTimeout
+	}
 
 	// 使用传入的 ctx 进行超时控制
-	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	sendCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	done := make(chan error, 1)
 	go func() {
-		_, err := c.session.ChannelMessageSend(channelID, message)
+		if editID != "" && len(message.Files) == 0 {
+			edit := discordgo.NewMessageEdit(channelID, editID).SetContent(message.Content)
+			if message.Components != nil {
+				edit.Components = &message.Components
+			}
+			if _, err := c.session.ChannelMessageEditComplex(edit); err == nil {
+				c.sent.remember(chatID, editID)
+				done <- nil
+				return
+			}
+			// Fallback to new message if edit fails
+		}
+		sent, err := c.session.ChannelMessageSendComplex(channelID, message)
+		if err == nil {
+			c.sent.remember(chatID, sent.ID)
+		}
 		done <- err
 	}()
 
@@ -125,6 +293,187 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 	}
 }
 
+// takeInteraction returns the deferred slash command a reply answers, if
+// it belongs to the chat and can still be answered.
+func (c *DiscordChannel) takeInteraction(id, chatID string) *discordgo.Interaction {
+	if id == "" {
+		return nil
+	}
+	v, ok := c.interactions.LoadAndDelete(id)
+	if !ok {
+		return nil
+	}
+	pending := v.(pendingInteraction)
+	if pending.chatID != chatID || time.Now().After(pending.expires) {
+		return nil
+	}
+	return pending.interaction
+}
+
+// addInteraction keeps a deferred slash command until its reply, and
+// forgets commands whose reply never came.
+func (c *DiscordChannel) addInteraction(i *discordgo.Interaction) {
+	now := time.Now()
+	c.interactions.Range(func(k, v any) bool {
+		if now.After(v.(pendingInteraction).expires) {
+			c.interactions.Delete(k)
+		}
+		return true
+	})
+	c.interactions.Store(i.ID, pendingInteraction{
+		interaction: i,
+		chatID:      i.ChannelID,
+		expires:     now.Add(discordInteractionTTL),
+	})
+}
+
+// editInteraction fills in a deferred slash command response with message.
+func (c *DiscordChannel) editInteraction(interaction *discordgo.Interaction, chatID string, message *discordgo.MessageSend) error {
+	edit := &discordgo.WebhookEdit{Content: &message.Content, Files: message.Files}
+	if message.Components != nil {
+		edit.Components = &message.Components
+	}
+	sent, err := c.session.InteractionResponseEdit(interaction, edit)
+	if err != nil {
+		return err
+	}
+	c.sent.remember(chatID, sent.ID)
+	return nil
+}
+
+// components converts button rows to Discord action rows (at most five
+// buttons each).
+func (c *DiscordChannel) components(rows [][]bus.Button) []discordgo.MessageComponent {
+	components := make([]discordgo.MessageComponent, 0, len(rows))
+	for _, row := range rows {
+		buttons := make([]discordgo.MessageComponent, 0, len(row))
+		for _, b := range row {
+			if b.URL != "" {
+				buttons = append(buttons, discordgo.Button{Label: b.Text, Style: discordgo.LinkButton, URL: b.URL})
+				continue
+			}
+			buttons = append(buttons, discordgo.Button{
+				Label:    b.Text,
+				Style:    discordgo.PrimaryButton,
+				CustomID: c.payloads.encode(b.Payload(), discordCustomIDLimit),
+			})
+		}
+		components = append(components, discordgo.ActionsRow{Components: buttons})
+	}
+	return components
+}
+
+// handleInteraction turns slash commands and button clicks into inbound
+// messages.
+func (c *DiscordChannel) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i == nil || i.Interaction == nil {
+		return
+	}
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		c.handleCommand(s, i)
+	case discordgo.InteractionMessageComponent:
+		c.handleButton(s, i)
+	}
+}
+
+// handleCommand passes a slash command to the agent as "/name text". The
+// response is deferred, and the agent's reply fills it in.
+func (c *DiscordChannel) handleCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user := interactionUser(i)
+	if user == nil {
+		return
+	}
+	if !c.IsAllowed(user.ID) {
+		logger.DebugCF("discord", "Slash command rejected by allowlist", map[string]any{
+			"user_id": user.ID,
+		})
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "You are not allowed to use this bot.",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	// Acknowledge within Discord's 3 second limit; users see "thinking"
+	// until the reply
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		logger.WarnCF("discord", "Failed to defer slash command", map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.addInteraction(i.Interaction)
+
+	data := i.ApplicationCommandData()
+	content := "/" + data.Name
+	for _, opt := range data.Options {
+		if opt.Type == discordgo.ApplicationCommandOptionString && opt.StringValue() != "" {
+			content += " " + opt.StringValue()
+		}
+	}
+
+	metadata := map[string]string{
+		"message_id":     i.ID,
+		"interaction":    "command",
+		"interaction_id": i.ID,
+		"command":        data.Name,
+		"user_id":        user.ID,
+		"username":       user.Username,
+		"guild_id":       i.GuildID,
+		"channel_id":     i.ChannelID,
+		"is_dm":          fmt.Sprintf("%t", i.GuildID == ""),
+	}
+
+	c.HandleMessage(user.ID, i.ChannelID, content, nil, metadata)
+}
+
+// handleButton turns a button click into an inbound message.
+func (c *DiscordChannel) handleButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	// Acknowledge within Discord's 3 second limit; the reply comes later
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		logger.DebugCF("discord", "Failed to acknowledge interaction", map[string]any{
+			"error": err.Error(),
+		})
+	}
+
+	user := interactionUser(i)
+	if user == nil {
+		return
+	}
+
+	metadata := map[string]string{
+		"user_id":    user.ID,
+		"username":   user.Username,
+		"guild_id":   i.GuildID,
+		"channel_id": i.ChannelID,
+		"is_dm":      fmt.Sprintf("%t", i.GuildID == ""),
+	}
+	if i.Message != nil {
+		metadata["message_id"] = i.Message.ID
+	}
+
+	c.HandleButton(user.ID, i.ChannelID, c.payloads.decode(i.MessageComponentData().CustomID), metadata)
+}
+
+// interactionUser returns who triggered an interaction: the member in a
+// server, the user in a DM.
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
 // appendContent 安全地追加内容到现有文本
 func appendContent(content, suffix string) string {
 	if content == "" {
@@ -138,7 +487,8 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 		return
 	}
 
-	if m.Author.ID == s.State.User.ID {
+	botID := s.State.User.ID
+	if m.Author.ID == botID {
 		return
 	}
 
@@ -156,7 +506,22 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 		senderName += "#" + m.Author.Discriminator
 	}
 
-	content := m.Content
+	content, mentioned := stripDiscordMention(m.Content, botID)
+	if m.ReferencedMessage != nil && m.ReferencedMessage.Author != nil && m.ReferencedMessage.Author.ID == botID {
+		mentioned = true
+	}
+	// Only look the channel up when it matters
+	inThread := m.GuildID != "" && ((c.config.RequireMention && !mentioned) || c.config.AutoThread) &&
+		c.inOwnThread(m.ChannelID, botID)
+	// In servers, skip messages meant for others before downloading anything
+	if m.GuildID != "" && c.config.RequireMention && !mentioned && !inThread {
+		logger.DebugCF("discord", "Ignoring server message not addressed to the bot", map[string]any{
+			"channel_id": m.ChannelID,
+		})
+		return
+	}
+	text := content
+
 	mediaPaths := make([]string, 0, len(m.Attachments))
 	localFiles := make([]string, 0, len(m.Attachments))
 
@@ -233,6 +598,11 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 		"preview":     utils.Truncate(content, 50),
 	})
 
+	chatID := m.ChannelID
+	if m.GuildID != "" && c.config.AutoThread && !inThread {
+		chatID = c.startThread(m, text, senderName)
+	}
+
 	metadata := map[string]string{
 		"message_id":   m.ID,
 		"user_id":      senderID,
@@ -243,7 +613,71 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 		"is_dm":        fmt.Sprintf("%t", m.GuildID == ""),
 	}
 
-	c.HandleMessage(senderID, m.ChannelID, content, mediaPaths, metadata)
+	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+}
+
+// stripDiscordMention removes mentions of userID from content, reporting
+// whether there were any.
+func stripDiscordMention(content, userID string) (string, bool) {
+	stripped := strings.NewReplacer("<@"+userID+">", "", "<@!"+userID+">", "").Replace(content)
+	return strings.TrimSpace(stripped), stripped != content
+}
+
+// inOwnThread reports whether channelID is a thread the bot opened, where
+// the conversation continues without mentions.
+func (c *DiscordChannel) inOwnThread(channelID, botID string) bool {
+	if _, ok := c.threads.Load(channelID); ok {
+		return true
+	}
+	ch := c.channel(channelID)
+	if ch == nil || !ch.IsThread() || ch.OwnerID != botID {
+		return false
+	}
+	c.threads.Store(channelID, struct{}{})
+	return true
+}
+
+// startThread opens a thread on message m, named after its text, and
+// returns the thread's ID. Messages that can't start one (in threads,
+// forums or voice channels) keep their channel.
+func (c *DiscordChannel) startThread(m *discordgo.MessageCreate, text, senderName string) string {
+	ch := c.channel(m.ChannelID)
+	if ch == nil || (ch.Type != discordgo.ChannelTypeGuildText && ch.Type != discordgo.ChannelTypeGuildNews) {
+		return m.ChannelID
+	}
+	name := strings.Join(strings.Fields(text), " ")
+	if name == "" {
+		name = "Chat with " + senderName
+	}
+	thread, err := c.session.MessageThreadStartComplex(m.ChannelID, m.ID, &discordgo.ThreadStart{
+		Name:                utils.Truncate(name, discordThreadNameLimit),
+		AutoArchiveDuration: 1440,
+	})
+	if err != nil {
+		logger.WarnCF("discord", "Failed to open thread, answering in the channel", map[string]any{
+			"channel_id": m.ChannelID,
+			"error":      err.Error(),
+		})
+		return m.ChannelID
+	}
+	c.threads.Store(thread.ID, struct{}{})
+	return thread.ID
+}
+
+// channel looks a channel up in the gateway state, then over REST.
+func (c *DiscordChannel) channel(id string) *discordgo.Channel {
+	if ch, err := c.session.State.Channel(id); err == nil {
+		return ch
+	}
+	ch, err := c.session.Channel(id)
+	if err != nil {
+		logger.DebugCF("discord", "Failed to look up channel", map[string]any{
+			"channel_id": id,
+			"error":      err.Error(),
+		})
+		return nil
+	}
+	return ch
 }
 
 func (c *DiscordChannel) downloadAttachment(url, filename string) string {
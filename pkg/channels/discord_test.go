@@ -0,0 +1,265 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// fakeDiscordAPI stubs the REST endpoints the channel calls and records
+// the last body sent to each "METHOD path".
+type fakeDiscordAPI struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies map[string][]byte
+}
+
+func newFakeDiscordAPI(t *testing.T) *fakeDiscordAPI {
+	t.Helper()
+	f := &fakeDiscordAPI{bodies: map[string][]byte{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v"+discordgo.APIVersion)
+		key := r.Method + " " + path
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.bodies[key] = body
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case key == "GET /channels/general":
+			w.Write([]byte(`{"id":"general","guild_id":"g1","type":0}`))
+		case key == "GET /channels/bot-thread":
+			w.Write([]byte(`{"id":"bot-thread","guild_id":"g1","type":11,"owner_id":"bot"}`))
+		case strings.HasSuffix(path, "/threads"):
+			w.Write([]byte(`{"id":"thread-1","guild_id":"g1","type":11,"owner_id":"bot"}`))
+		case strings.HasSuffix(path, "/callback"):
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(path, "/messages/@original"):
+			w.Write([]byte(`{"id":"reply-1","channel_id":"general"}`))
+		case strings.HasSuffix(path, "/commands"):
+			w.Write([]byte(`[]`))
+		case strings.HasSuffix(path, "/messages"):
+			w.Write([]byte(`{"id":"sent-1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Unknown","code":0}`))
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// requested decodes the JSON body of the last request to key into v,
+// reporting whether there was one.
+func (f *fakeDiscordAPI) requested(key string, v interface{}) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.bodies[key]
+	if ok {
+		json.Unmarshal(body, v)
+	}
+	return ok
+}
+
+// discordRedirect sends every request to the fake API.
+type discordRedirect struct {
+	target *url.URL
+}
+
+func (d discordRedirect) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = d.target.Scheme
+	r.URL.Host = d.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newTestDiscordChannel(t *testing.T, api *fakeDiscordAPI, cfg config.DiscordConfig) (*DiscordChannel, *bus.MessageBus) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	cfg.Token = "test-token"
+	ch, err := NewDiscordChannel(cfg, mb, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiscordChannel error: %v", err)
+	}
+	target, _ := url.Parse(api.URL)
+	ch.session.Client = &http.Client{Transport: discordRedirect{target: target}}
+	ch.session.State.User = &discordgo.User{ID: "bot", Username: "picoclaw"}
+	ch.setRunning(true)
+	return ch, mb
+}
+
+func discordMessage(id, channelID, guildID, content string) *discordgo.MessageCreate {
+	return &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        id,
+		ChannelID: channelID,
+		GuildID:   guildID,
+		Content:   content,
+		Author:    &discordgo.User{ID: "u1", Username: "ann"},
+	}}
+}
+
+func TestDiscordChannel_GuildMentionOnly(t *testing.T) {
+	api := newFakeDiscordAPI(t)
+	ch, mb := newTestDiscordChannel(t, api, config.DiscordConfig{RequireMention: true})
+
+	reply := discordMessage("4", "general", "g1", "and tomorrow?")
+	reply.ReferencedMessage = &discordgo.Message{ID: "3", Author: &discordgo.User{ID: "bot"}}
+	for _, m := range []*discordgo.MessageCreate{
+		discordMessage("1", "general", "g1", "hello everyone"),
+		discordMessage("2", "general", "g1", "<@bot> what's the weather?"),
+		reply,
+		discordMessage("5", "bot-thread", "g1", "thanks"),
+		discordMessage("6", "dm", "", "hi"),
+	} {
+		ch.handleMessage(ch.session, m)
+	}
+
+	want := []struct{ chatID, content string }{
+		{"general", "what's the weather?"},
+		{"general", "and tomorrow?"},
+		{"bot-thread", "thanks"},
+		{"dm", "hi"},
+	}
+	for _, w := range want {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		msg, ok := mb.ConsumeInbound(ctx)
+		cancel()
+		if !ok || msg.ChatID != w.chatID || msg.Content != w.content {
+			t.Fatalf("inbound = %+v, want %q in %s", msg, w.content, w.chatID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if msg, ok := mb.ConsumeInbound(ctx); ok {
+		t.Errorf("unexpected inbound %+v", msg)
+	}
+}
+
+func TestDiscordChannel_AutoThread(t *testing.T) {
+	api := newFakeDiscordAPI(t)
+	ch, mb := newTestDiscordChannel(t, api, config.DiscordConfig{RequireMention: true, AutoThread: true})
+
+	ch.handleMessage(ch.session, discordMessage("1", "general", "g1", "<@!bot> plan   my\ntrip"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "thread-1" || msg.Metadata["channel_id"] != "general" {
+		t.Fatalf("inbound = %+v, want it in the new thread", msg)
+	}
+	var body map[string]interface{}
+	if ok := api.requested("POST /channels/general/messages/1/threads", &body); !ok || body["name"] != "plan my trip" {
+		t.Errorf("thread start = %v, %v", body, ok)
+	}
+
+	// Follow-ups in the thread need no mention and open no new thread
+	ch.handleMessage(ch.session, discordMessage("2", "thread-1", "g1", "by train"))
+	msg, ok = mb.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "thread-1" || msg.Content != "by train" {
+		t.Fatalf("follow-up = %+v", msg)
+	}
+}
+
+func TestDiscordChannel_SlashCommand(t *testing.T) {
+	api := newFakeDiscordAPI(t)
+	ch, mb := newTestDiscordChannel(t, api, config.DiscordConfig{
+		Commands: []config.DiscordCommand{{Name: "/Ask", Description: "Ask something"}},
+	})
+
+	ch.registerCommands("bot")
+	var commands []discordgo.ApplicationCommand
+	if !api.requested("PUT /applications/bot/commands", &commands) {
+		t.Fatal("commands not registered")
+	}
+	if len(commands) != 1 || commands[0].Name != "ask" || len(commands[0].Options) != 1 || commands[0].Options[0].Name != "text" {
+		t.Errorf("commands = %+v", commands)
+	}
+
+	ch.handleInteraction(ch.session, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "i1",
+		AppID:     "bot",
+		Type:      discordgo.InteractionApplicationCommand,
+		ChannelID: "general",
+		GuildID:   "g1",
+		Token:     "itok",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "u1", Username: "ann"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: "ask",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "text", Type: discordgo.ApplicationCommandOptionString, Value: "is it raining?"},
+			},
+		},
+	}})
+
+	var callback map[string]interface{}
+	if ok := api.requested("POST /interactions/i1/itok/callback", &callback); !ok || callback["type"] != float64(discordgo.InteractionResponseDeferredChannelMessageWithSource) {
+		t.Fatalf("callback = %v, want a deferred response", callback)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.Content != "/ask is it raining?" || msg.ChatID != "general" || msg.Metadata["command"] != "ask" || msg.Metadata["interaction_id"] != "i1" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	// Other messages to the channel don't take the pending response
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "general", Content: "Anything else?"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	var sent map[string]interface{}
+	if ok := api.requested("POST /channels/general/messages", &sent); !ok || sent["content"] != "Anything else?" {
+		t.Errorf("plain message = %v, %v", sent, ok)
+	}
+	if api.requested("PATCH /webhooks/bot/itok/messages/@original", nil) {
+		t.Error("plain message answered the slash command")
+	}
+
+	// The reply to the command fills in its deferred response
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "general", Content: "No, sunny", InteractionID: "i1"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	var edit map[string]interface{}
+	if ok := api.requested("PATCH /webhooks/bot/itok/messages/@original", &edit); !ok || edit["content"] != "No, sunny" {
+		t.Errorf("response edit = %v, %v", edit, ok)
+	}
+}
+
+func TestDiscordChannel_ConcurrentSlashCommands(t *testing.T) {
+	api := newFakeDiscordAPI(t)
+	ch, _ := newTestDiscordChannel(t, api, config.DiscordConfig{})
+
+	for _, id := range []string{"i1", "i2"} {
+		ch.handleInteraction(ch.session, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			ID:        id,
+			AppID:     "bot",
+			Type:      discordgo.InteractionApplicationCommand,
+			ChannelID: "general",
+			Token:     id + "-tok",
+			User:      &discordgo.User{ID: "u1"},
+			Data:      discordgo.ApplicationCommandInteractionData{Name: "ask"},
+		}})
+	}
+
+	// Each reply answers its own command, in whatever order they finish
+	for _, id := range []string{"i2", "i1"} {
+		if err := ch.Send(context.Background(), bus.OutboundMessage{ChatID: "general", Content: "answer " + id, InteractionID: id}); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+		var edit map[string]interface{}
+		if ok := api.requested("PATCH /webhooks/bot/"+id+"-tok/messages/@original", &edit); !ok || edit["content"] != "answer "+id {
+			t.Errorf("%s response = %v, %v", id, edit, ok)
+		}
+	}
+}
@@ -0,0 +1,1063 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	emailDialTimeout    = 30 * time.Second
+	emailRetryDelay     = 30 * time.Second
+	emailMaxThreads     = 1000
+	emailMaxReferences  = 20
+	emailDefaultSubject = "Message from picoclaw"
+)
+
+// EmailChannel implements the Channel interface for email. It watches an
+// IMAP mailbox (IDLE, or polling) and replies over SMTP. Each email thread
+// is its own chat, keyed by the thread's first Message-ID, and replies
+// carry In-Reply-To/References so mail clients keep them threaded.
+type EmailChannel struct {
+	*BaseChannel
+	config    config.EmailConfig
+	from      string
+	workspace string
+	stateFile string
+	poll      time.Duration
+	mu        sync.Mutex
+	state     emailState
+	byID      map[string]string // message ID -> chat ID
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// emailState is kept on disk so a restart neither re-reads old mail nor
+// loses track of open threads.
+type emailState struct {
+	UIDValidity uint32                  `json:"uid_validity"`
+	LastUID     uint32                  `json:"last_uid"`
+	Threads     map[string]*emailThread `json:"threads"`
+}
+
+type emailThread struct {
+	Subject    string    `json:"subject"`
+	ReplyTo    string    `json:"reply_to"`   // Address replies are sent to
+	References []string  `json:"references"` // Message IDs, oldest first
+	Updated    time.Time `json:"updated"`
+}
+
+// emailMessage is the part of a parsed email the channel uses.
+type emailMessage struct {
+	From        string
+	ReplyTo     string
+	Subject     string
+	MessageID   string
+	InReplyTo   []string
+	References  []string
+	Text        string
+	Attachments []emailAttachment
+	Automated   bool
+	AuthResults []string // Authentication-Results headers, topmost first
+}
+
+type emailAttachment struct {
+	Name     string
+	MIMEType string
+	Data     []byte
+}
+
+// NewEmailChannel creates a new email channel instance.
+func NewEmailChannel(cfg config.EmailConfig, messageBus *bus.MessageBus, workspace string) (*EmailChannel, error) {
+	if cfg.IMAPHost == "" || cfg.SMTPHost == "" || cfg.Username == "" {
+		return nil, fmt.Errorf("email imap_host, smtp_host and username are required")
+	}
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = "INBOX"
+	}
+
+	from := cfg.FromAddress
+	if from == "" {
+		from = cfg.Username
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("email from_address %q is not an address: %w", from, err)
+	}
+
+	poll := time.Duration(cfg.PollInterval) * time.Second
+	if poll <= 0 {
+		poll = 60 * time.Second
+	}
+
+	stateFile := ""
+	if workspace != "" {
+		stateFile = filepath.Join(workspace, "email", "state.json")
+	}
+
+	// Sender addresses are compared lowercased
+	allowFrom := make([]string, len(cfg.AllowFrom))
+	for i, a := range cfg.AllowFrom {
+		allowFrom[i] = strings.ToLower(a)
+	}
+	base := NewBaseChannel("email", cfg, messageBus, allowFrom)
+
+	return &EmailChannel{
+		BaseChannel: base,
+		config:      cfg,
+		from:        strings.ToLower(addr.Address),
+		workspace:   workspace,
+		stateFile:   stateFile,
+		poll:        poll,
+		state:       emailState{Threads: make(map[string]*emailThread)},
+		byID:        make(map[string]string),
+	}, nil
+}
+
+// Start loads the saved state and starts watching the mailbox.
+func (c *EmailChannel) Start(ctx context.Context) error {
+	logger.InfoC("email", "Starting Email channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.loadState()
+
+	go c.watch()
+
+	c.setRunning(true)
+	logger.InfoC("email", "Email channel started")
+	return nil
+}
+
+// Stop stops watching the mailbox.
+func (c *EmailChannel) Stop(ctx context.Context) error {
+	logger.InfoC("email", "Stopping Email channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.setRunning(false)
+	logger.InfoC("email", "Email channel stopped")
+	return nil
+}
+
+// watch keeps an IMAP session open, reconnecting after failures.
+func (c *EmailChannel) watch() {
+	for c.ctx.Err() == nil {
+		err := c.session()
+		if err == nil || c.ctx.Err() != nil {
+			return
+		}
+		logger.ErrorCF("email", "IMAP session failed, reconnecting", map[string]interface{}{
+			"error": err.Error(),
+		})
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(emailRetryDelay):
+		}
+	}
+}
+
+func (c *EmailChannel) session() error {
+	cl, err := c.dialIMAP()
+	if err != nil {
+		return err
+	}
+
+	// Updates must be drained; a new-mail update cuts the wait short
+	updates := make(chan client.Update, 16)
+	newMail := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer func() {
+		cl.Logout()
+		close(done)
+	}()
+	cl.Updates = updates
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case u := <-updates:
+				if _, ok := u.(*client.MailboxUpdate); ok {
+					select {
+					case newMail <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	status, err := cl.Select(c.config.Mailbox, false)
+	if err != nil {
+		return fmt.Errorf("selecting %s: %w", c.config.Mailbox, err)
+	}
+	c.syncUIDValidity(status)
+
+	logger.InfoCF("email", "Watching mailbox", map[string]interface{}{
+		"mailbox": c.config.Mailbox,
+		"idle":    c.config.UseIDLE,
+	})
+
+	for {
+		if err := c.fetchNew(cl); err != nil {
+			return err
+		}
+		if err := c.wait(cl, newMail); err != nil {
+			return err
+		}
+		if c.ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func (c *EmailChannel) dialIMAP() (*client.Client, error) {
+	addr := net.JoinHostPort(c.config.IMAPHost, strconv.Itoa(c.config.IMAPPort))
+	dialer := &net.Dialer{Timeout: emailDialTimeout}
+	tlsConfig := &tls.Config{ServerName: c.config.IMAPHost}
+
+	var cl *client.Client
+	var err error
+	if c.config.IMAPPort == 993 {
+		cl, err = client.DialWithDialerTLS(dialer, addr, tlsConfig)
+	} else {
+		cl, err = client.DialWithDialer(dialer, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+
+	if !cl.IsTLS() {
+		if ok, _ := cl.SupportStartTLS(); ok {
+			err = cl.StartTLS(tlsConfig)
+		} else if !c.config.AllowInsecure {
+			err = fmt.Errorf("%s offers no TLS; set allow_insecure to log in anyway", addr)
+		}
+		if err != nil {
+			cl.Logout()
+			return nil, err
+		}
+	}
+
+	if err := cl.Login(c.config.Username, c.config.Password); err != nil {
+		cl.Logout()
+		return nil, fmt.Errorf("IMAP login failed: %w", err)
+	}
+	return cl, nil
+}
+
+// syncUIDValidity starts from the mailbox's current end on first run, or
+// when the server renumbered the mailbox, so old mail isn't answered.
+func (c *EmailChannel) syncUIDValidity(status *imap.MailboxStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.UIDValidity == status.UidValidity {
+		return
+	}
+	c.state.UIDValidity = status.UidValidity
+	if status.UidNext > 0 {
+		c.state.LastUID = status.UidNext - 1
+	}
+	logger.InfoCF("email", "Skipping existing mail", map[string]interface{}{
+		"mailbox":  c.config.Mailbox,
+		"last_uid": c.state.LastUID,
+	})
+	c.saveStateLocked()
+}
+
+// wait blocks until new mail is signalled, the poll interval passes, or
+// the channel stops.
+func (c *EmailChannel) wait(cl *client.Client, newMail <-chan struct{}) error {
+	timer := time.NewTimer(c.poll)
+	defer timer.Stop()
+
+	if !c.config.UseIDLE {
+		select {
+		case <-c.ctx.Done():
+		case <-timer.C:
+		}
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- cl.Idle(stop, &client.IdleOptions{PollInterval: c.poll})
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-newMail:
+	case <-timer.C:
+	case <-c.ctx.Done():
+	}
+	close(stop)
+	return <-done
+}
+
+// fetchNew handles unseen messages past the last handled UID and marks
+// them seen.
+func (c *EmailChannel) fetchNew(cl *client.Client) error {
+	c.mu.Lock()
+	lastUID := c.state.LastUID
+	c.mu.Unlock()
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(lastUID+1, 0)
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	uids, err := cl.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("searching mailbox: %w", err)
+	}
+
+	// "n:*" always matches the highest UID, even below n
+	uids = slices.DeleteFunc(uids, func(uid uint32) bool { return uid <= lastUID })
+	if len(uids) == 0 {
+		return nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, len(uids))
+	if err := cl.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages); err != nil {
+		return fmt.Errorf("fetching messages: %w", err)
+	}
+
+	var fetched []*imap.Message
+	for m := range messages {
+		fetched = append(fetched, m)
+	}
+	sort.Slice(fetched, func(i, j int) bool { return fetched[i].Uid < fetched[j].Uid })
+
+	for _, m := range fetched {
+		if body := m.GetBody(section); body != nil {
+			c.handleRaw(body)
+		}
+		c.mu.Lock()
+		if m.Uid > c.state.LastUID {
+			c.state.LastUID = m.Uid
+		}
+		c.saveStateLocked()
+		c.mu.Unlock()
+	}
+
+	flags := []interface{}{imap.SeenFlag}
+	if err := cl.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+		logger.WarnCF("email", "Failed to mark messages seen", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return nil
+}
+
+func (c *EmailChannel) handleRaw(r io.Reader) {
+	msg, err := parseEmail(r)
+	if err != nil {
+		logger.ErrorCF("email", "Failed to parse email", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if msg.From == c.from {
+		return
+	}
+	// Answering auto-replies and mailing lists risks mail loops
+	if msg.Automated {
+		logger.DebugCF("email", "Ignoring automated email", map[string]interface{}{
+			"from":    msg.From,
+			"subject": msg.Subject,
+		})
+		return
+	}
+	// Anyone can write any From: header, so the allowlist only means
+	// something once the receiving server has vouched for the domain
+	if !c.senderAuthenticated(msg) {
+		logger.WarnCF("email", "Ignoring email whose sender failed DKIM/DMARC", map[string]interface{}{
+			"from":    msg.From,
+			"subject": msg.Subject,
+		})
+		return
+	}
+	if !c.IsAllowed(msg.From) {
+		logger.DebugCF("email", "Ignoring email from sender not in allow_from", map[string]interface{}{
+			"from": msg.From,
+		})
+		return
+	}
+
+	chatID, isNew := c.recordInbound(msg)
+
+	content := stripQuotedReply(msg.Text)
+	if isNew && msg.Subject != "" {
+		content = "Subject: " + msg.Subject + "\n\n" + content
+	}
+
+	var mediaPaths []string
+	for _, a := range msg.Attachments {
+		placeholder := fmt.Sprintf("[file: %s]", a.Name)
+		att := bus.Attachment{Path: a.Name, MIMEType: a.MIMEType}
+		switch kind := att.Kind(); kind {
+		case bus.AttachmentImage:
+			placeholder = "[image]"
+		case bus.AttachmentAudio, bus.AttachmentVideo:
+			placeholder = fmt.Sprintf("[%s: %s]", kind, a.Name)
+		}
+		if path := c.saveAttachment(a); path != "" {
+			mediaPaths = append(mediaPaths, path)
+		}
+		content = appendContent(content, placeholder)
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":   "email",
+		"message_id": msg.MessageID,
+		"subject":    msg.Subject,
+	}
+
+	logger.DebugCF("email", "Received email", map[string]interface{}{
+		"from":    msg.From,
+		"chat_id": chatID,
+		"subject": msg.Subject,
+		"preview": utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(msg.From, chatID, content, mediaPaths, metadata)
+}
+
+// senderAuthenticated reports whether the receiving server's
+// Authentication-Results show DMARC or DKIM passing for the From domain.
+// Senders can add Authentication-Results of their own, but servers add
+// theirs on top, so only the topmost header, or those from the configured
+// auth_serv_id, are trusted.
+func (c *EmailChannel) senderAuthenticated(msg *emailMessage) bool {
+	if c.config.AllowUnauthenticated {
+		return true
+	}
+	_, domain, ok := strings.Cut(msg.From, "@")
+	if !ok || domain == "" {
+		return false
+	}
+	for i, header := range msg.AuthResults {
+		servID, results := parseAuthResults(header)
+		if c.config.AuthServID == "" && i > 0 {
+			break
+		}
+		if c.config.AuthServID != "" && !strings.EqualFold(servID, c.config.AuthServID) {
+			continue
+		}
+		for _, r := range results {
+			if r.result != "pass" {
+				continue
+			}
+			switch r.method {
+			case "dmarc":
+				if from := r.props["header.from"]; from == "" || strings.EqualFold(from, domain) {
+					return true
+				}
+			case "dkim":
+				d := strings.ToLower(r.props["header.d"])
+				if d == "" {
+					_, d, _ = strings.Cut(strings.ToLower(r.props["header.i"]), "@")
+				}
+				// Relaxed alignment: the signing domain or a parent of it
+				if d != "" && (domain == d || strings.HasSuffix(domain, "."+d)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// authResult is one "method=result prop=value ..." clause of an
+// Authentication-Results header.
+type authResult struct {
+	method string
+	result string
+	props  map[string]string
+}
+
+var reHeaderComment = regexp.MustCompile(`\([^()]*\)`)
+
+// parseAuthResults splits an Authentication-Results header (RFC 8601)
+// into its authserv-id and results, dropping comments.
+func parseAuthResults(header string) (string, []authResult) {
+	header = reHeaderComment.ReplaceAllString(header, " ")
+	parts := strings.Split(header, ";")
+	servID := ""
+	if fields := strings.Fields(parts[0]); len(fields) > 0 {
+		servID = fields[0]
+	}
+	var results []authResult
+	for _, part := range parts[1:] {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			continue
+		}
+		r := authResult{
+			method: strings.ToLower(method),
+			result: strings.ToLower(result),
+			props:  make(map[string]string),
+		}
+		for _, f := range fields[1:] {
+			if k, v, ok := strings.Cut(f, "="); ok {
+				r.props[strings.ToLower(k)] = strings.Trim(v, `"`)
+			}
+		}
+		results = append(results, r)
+	}
+	return servID, results
+}
+
+// recordInbound files the message under its thread, starting a new one
+// when none of the IDs it references are known. It returns the thread's
+// chat ID and whether the thread is new.
+func (c *EmailChannel) recordInbound(msg *emailMessage) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var related []string
+	for _, id := range append(msg.References, msg.InReplyTo...) {
+		if !slices.Contains(related, id) {
+			related = append(related, id)
+		}
+	}
+	chatID := ""
+	for _, id := range related {
+		if known, ok := c.byID[id]; ok {
+			chatID = known
+			break
+		}
+	}
+
+	isNew := chatID == ""
+	if isNew {
+		root := msg.MessageID
+		if len(related) > 0 {
+			root = related[0]
+		}
+		if root == "" {
+			root = uuid.New().String() + "@picoclaw"
+		}
+		chatID = emailChatID(root)
+	}
+
+	thread := c.state.Threads[chatID]
+	if thread == nil {
+		thread = &emailThread{Subject: msg.Subject, References: related}
+		c.state.Threads[chatID] = thread
+		for _, id := range related {
+			c.byID[id] = chatID
+		}
+	}
+	thread.ReplyTo = msg.ReplyTo
+	c.addReference(chatID, thread, msg.MessageID)
+	c.saveStateLocked()
+	return chatID, isNew
+}
+
+// emailChatID is the chat ID of the thread starting with message ID root.
+// Slashes are replaced since the chat ID ends up in session file names.
+func emailChatID(root string) string {
+	return "<" + strings.NewReplacer("/", "_", `\`, "_").Replace(root) + ">"
+}
+
+// addReference appends id to the thread, keeping the root and the most
+// recent IDs when the list grows long.
+func (c *EmailChannel) addReference(chatID string, thread *emailThread, id string) {
+	if id != "" {
+		thread.References = append(thread.References, id)
+		c.byID[id] = chatID
+	}
+	if n := len(thread.References); n > emailMaxReferences {
+		thread.References = append(thread.References[:1], thread.References[n-emailMaxReferences+1:]...)
+	}
+	thread.Updated = time.Now()
+}
+
+// saveAttachment writes an attachment next to the channels' downloaded
+// media and returns its path.
+func (c *EmailChannel) saveAttachment(a emailAttachment) string {
+	path, err := saveMedia(c.workspace, a.Name, a.Data)
+	if err != nil {
+		logger.ErrorCF("email", "Failed to save attachment", map[string]interface{}{
+			"file":  a.Name,
+			"error": err.Error(),
+		})
+		return ""
+	}
+	return path
+}
+
+// parseEmail reads the headers, the text body (plain text preferred over
+// HTML) and the attachments of an email.
+func parseEmail(r io.Reader) (*emailMessage, error) {
+	mr, err := mail.CreateReader(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, err
+	}
+
+	h := mr.Header
+	msg := &emailMessage{}
+	if from, err := h.AddressList("From"); err == nil && len(from) > 0 {
+		msg.From = strings.ToLower(from[0].Address)
+	}
+	msg.ReplyTo = msg.From
+	if replyTo, err := h.AddressList("Reply-To"); err == nil && len(replyTo) > 0 {
+		msg.ReplyTo = replyTo[0].Address
+	}
+	msg.Subject, _ = h.Subject()
+	msg.MessageID, _ = h.MessageID()
+	msg.InReplyTo, _ = h.MsgIDList("In-Reply-To")
+	msg.References, _ = h.MsgIDList("References")
+	msg.AuthResults = h.Values("Authentication-Results")
+
+	auto := strings.ToLower(h.Get("Auto-Submitted"))
+	precedence := strings.ToLower(h.Get("Precedence"))
+	msg.Automated = (auto != "" && auto != "no") ||
+		precedence == "bulk" || precedence == "list" || precedence == "junk" ||
+		h.Get("List-Id") != ""
+
+	var plain, htmlText string
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if message.IsUnknownCharset(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		data, err := io.ReadAll(io.LimitReader(p.Body, maxAttachmentSize+1))
+		if err != nil {
+			return nil, err
+		}
+
+		switch ph := p.Header.(type) {
+		case *mail.InlineHeader:
+			ct, _, _ := ph.ContentType()
+			switch {
+			case ct == "text/plain" && plain == "":
+				plain = string(data)
+				continue
+			case ct == "text/html" && htmlText == "":
+				htmlText = string(data)
+				continue
+			case strings.HasPrefix(ct, "text/") || strings.HasPrefix(ct, "multipart/"):
+				continue
+			}
+			// Inline images and the like are kept as attachments
+			_, params, _ := ph.ContentType()
+			msg.addAttachment(params["name"], ct, data)
+		case *mail.AttachmentHeader:
+			name, _ := ph.Filename()
+			ct, _, _ := ph.ContentType()
+			msg.addAttachment(name, ct, data)
+		}
+	}
+
+	msg.Text = strings.TrimSpace(plain)
+	if msg.Text == "" && htmlText != "" {
+		msg.Text = htmlToText(htmlText)
+	}
+	return msg, nil
+}
+
+func (m *emailMessage) addAttachment(name, mimeType string, data []byte) {
+	if len(data) > maxAttachmentSize {
+		logger.WarnCF("email", "Attachment too large, skipping", map[string]interface{}{
+			"file": name,
+			"size": len(data),
+		})
+		return
+	}
+	if name == "" {
+		name = "attachment"
+	}
+	if mimeType == "application/octet-stream" {
+		// Let the file name decide
+		mimeType = ""
+	}
+	m.Attachments = append(m.Attachments, emailAttachment{Name: name, MIMEType: mimeType, Data: data})
+}
+
+var (
+	reHTMLDrop  = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)>`)
+	reHTMLBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|blockquote)>`)
+	reHTMLItem  = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	reHTMLTag   = regexp.MustCompile(`<[^>]+>`)
+	reBlankRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText turns an HTML body into readable plain text, keeping
+// paragraph and line breaks.
+func htmlToText(s string) string {
+	s = reHTMLDrop.ReplaceAllString(s, "")
+	s = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+	s = reHTMLItem.ReplaceAllString(s, "• ")
+	s = reHTMLBreak.ReplaceAllString(s, "\n")
+	s = reHTMLTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	s = reBlankRuns.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(s)
+}
+
+var reQuoteHeader = regexp.MustCompile(`(?m)^\s*(On .+ wrote:|-----Original Message-----)\s*$`)
+
+// stripQuotedReply drops the quoted previous message that mail clients
+// add under a reply.
+func stripQuotedReply(text string) string {
+	if loc := reQuoteHeader.FindStringIndex(text); loc != nil {
+		text = text[:loc[0]]
+	}
+	lines := strings.Split(text, "\n")
+	end := len(lines)
+	for end > 0 && (strings.HasPrefix(lines[end-1], ">") || strings.TrimSpace(lines[end-1]) == "") {
+		end--
+	}
+	return strings.TrimSpace(strings.Join(lines[:end], "\n"))
+}
+
+// Send replies in the thread the chat ID names. A chat ID that is a bare
+// address starts a new thread with that recipient.
+func (c *EmailChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("email channel not running")
+	}
+
+	c.mu.Lock()
+	var to, subject, parent string
+	var references []string
+	if thread, ok := c.state.Threads[msg.ChatID]; ok {
+		to = thread.ReplyTo
+		subject = replySubject(thread.Subject)
+		references = append([]string{}, thread.References...)
+		parent = msg.ReplyTo
+		if parent == "" || c.byID[parent] != msg.ChatID {
+			parent = references[len(references)-1]
+		}
+	}
+	c.mu.Unlock()
+
+	if to == "" {
+		addr, err := mail.ParseAddress(msg.ChatID)
+		if err != nil {
+			return fmt.Errorf("unknown email thread or address: %s", msg.ChatID)
+		}
+		to = addr.Address
+		subject = emailSubject(msg.Content)
+	}
+
+	data, messageID, err := c.compose(ctx, to, subject, parent, references, msg)
+	if err != nil {
+		return err
+	}
+	if err := c.sendSMTP(ctx, to, data); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	c.mu.Lock()
+	thread, ok := c.state.Threads[msg.ChatID]
+	if !ok {
+		// A new thread is keyed by the message that starts it
+		thread = &emailThread{Subject: subject, ReplyTo: to}
+		c.state.Threads[emailChatID(messageID)] = thread
+		c.addReference(emailChatID(messageID), thread, messageID)
+	} else {
+		c.addReference(msg.ChatID, thread, messageID)
+	}
+	c.saveStateLocked()
+	c.mu.Unlock()
+
+	logger.DebugCF("email", "Email sent", map[string]interface{}{
+		"to":      to,
+		"subject": subject,
+	})
+	return nil
+}
+
+// compose builds the email: a plain text body plus the attachments. Files
+// that can't be read are listed in the text instead.
+func (c *EmailChannel) compose(ctx context.Context, to, subject, parent string, references []string, msg bus.OutboundMessage) ([]byte, string, error) {
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetAddressList("From", []*mail.Address{{Address: c.from}})
+	h.SetAddressList("To", []*mail.Address{{Address: to}})
+	h.SetSubject(subject)
+	_, domain, _ := strings.Cut(c.from, "@")
+	if err := h.GenerateMessageIDWithHostname(domain); err != nil {
+		return nil, "", err
+	}
+	messageID, _ := h.MessageID()
+	if parent != "" {
+		h.SetMsgIDList("In-Reply-To", []string{parent})
+		h.SetMsgIDList("References", references)
+	}
+	// RFC 3834: lets the other side's autoresponders leave us alone
+	h.Set("Auto-Submitted", "auto-replied")
+
+	text := renderMarkdown(withButtonText(msg.Content, msg.Buttons), dialectPlain)
+
+	type file struct {
+		a    bus.Attachment
+		data []byte
+	}
+	var files []file
+	for _, a := range msg.Attachments {
+		data, err := readAttachment(ctx, a)
+		if err != nil {
+			logger.ErrorCF("email", "Failed to read attachment, sending as text", map[string]interface{}{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			text = appendContent(text, attachmentText(a))
+			continue
+		}
+		files = append(files, file{a, data})
+	}
+
+	var buf bytes.Buffer
+	mw, err := mail.CreateWriter(&buf, h)
+	if err != nil {
+		return nil, "", err
+	}
+	var th mail.InlineHeader
+	th.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	w, err := mw.CreateSingleInline(th)
+	if err != nil {
+		return nil, "", err
+	}
+	io.WriteString(w, text)
+	w.Close()
+
+	for _, f := range files {
+		var ah mail.AttachmentHeader
+		ah.SetContentType(f.a.MIME(), nil)
+		ah.SetFilename(f.a.FileName())
+		w, err := mw.CreateAttachment(ah)
+		if err != nil {
+			return nil, "", err
+		}
+		w.Write(f.data)
+		w.Close()
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), messageID, nil
+}
+
+func replySubject(subject string) string {
+	if subject == "" {
+		return "Re: " + emailDefaultSubject
+	}
+	if strings.HasPrefix(strings.ToLower(subject), "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// emailSubject takes a new email's subject from the first line of its text.
+func emailSubject(content string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	line = strings.TrimSpace(strings.TrimLeft(line, "#*_ "))
+	if line == "" {
+		return emailDefaultSubject
+	}
+	return utils.Truncate(line, 78)
+}
+
+// sendSMTP delivers data to one recipient. Port 465 uses implicit TLS;
+// otherwise STARTTLS is used when the server offers it.
+func (c *EmailChannel) sendSMTP(ctx context.Context, to string, data []byte) error {
+	host := c.config.SMTPHost
+	addr := net.JoinHostPort(host, strconv.Itoa(c.config.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: host}
+
+	dialer := &net.Dialer{Timeout: emailDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if c.config.SMTPPort == 465 {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	sc, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer sc.Close()
+
+	if ok, _ := sc.Extension("STARTTLS"); ok && c.config.SMTPPort != 465 {
+		if err := sc.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if c.config.Password != "" {
+		if ok, _ := sc.Extension("AUTH"); ok {
+			var auth smtp.Auth = smtp.PlainAuth("", c.config.Username, c.config.Password, host)
+			if c.config.AllowInsecure {
+				auth = insecureAuth{auth}
+			}
+			if err := sc.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := sc.Mail(c.from); err != nil {
+		return err
+	}
+	if err := sc.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := sc.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return sc.Quit()
+}
+
+// insecureAuth lets PLAIN auth run over a connection without TLS, which
+// net/smtp only allows for localhost.
+type insecureAuth struct {
+	smtp.Auth
+}
+
+func (a insecureAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	info := *server
+	info.TLS = true
+	return a.Auth.Start(&info)
+}
+
+func (c *EmailChannel) loadState() {
+	if c.stateFile == "" {
+		return
+	}
+	data, err := os.ReadFile(c.stateFile)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var state emailState
+	if err := json.Unmarshal(data, &state); err != nil {
+		logger.WarnCF("email", "Failed to read saved state", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if state.Threads == nil {
+		state.Threads = make(map[string]*emailThread)
+	}
+	c.state = state
+	for chatID, thread := range state.Threads {
+		for _, id := range thread.References {
+			c.byID[id] = chatID
+		}
+	}
+}
+
+// saveStateLocked writes the state atomically, dropping the least
+// recently active threads past emailMaxThreads. c.mu must be held.
+func (c *EmailChannel) saveStateLocked() {
+	if n := len(c.state.Threads); n > emailMaxThreads {
+		ids := make([]string, 0, n)
+		for id := range c.state.Threads {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return c.state.Threads[ids[i]].Updated.Before(c.state.Threads[ids[j]].Updated)
+		})
+		for _, chatID := range ids[:n-emailMaxThreads] {
+			for _, id := range c.state.Threads[chatID].References {
+				if c.byID[id] == chatID {
+					delete(c.byID, id)
+				}
+			}
+			delete(c.state.Threads, chatID)
+		}
+	}
+
+	if c.stateFile == "" {
+		return
+	}
+	data, err := json.Marshal(c.state)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.stateFile), 0700)
+	}
+	if err == nil {
+		tmp := c.stateFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, c.stateFile)
+		}
+	}
+	if err != nil {
+		logger.WarnCF("email", "Failed to save state", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
@@ -3,9 +3,12 @@
 package channels
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -97,7 +100,34 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("chat ID is empty")
 	}
 
-	payload, err := json.Marshal(map[string]string{"text": msg.Content})
+	if msg.Content != "" || len(msg.Attachments) == 0 {
+		text := withButtonText(msg.Content, msg.Buttons)
+		if err := c.sendMessage(ctx, msg.ChatID, larkim.MsgTypeText, map[string]string{"text": text}); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range msg.Attachments {
+		if err := c.sendAttachment(ctx, msg.ChatID, a); err != nil {
+			logger.ErrorCF("feishu", "Failed to upload attachment, sending as text", map[string]interface{}{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			if err := c.sendMessage(ctx, msg.ChatID, larkim.MsgTypeText, map[string]string{"text": attachmentText(a)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.DebugCF("feishu", "Feishu message sent", map[string]interface{}{
+		"chat_id": msg.ChatID,
+	})
+
+	return nil
+}
+
+func (c *FeishuChannel) sendMessage(ctx context.Context, chatID, msgType string, content map[string]string) error {
+	payload, err := json.Marshal(content)
 	if err != nil {
 		return fmt.Errorf("failed to marshal feishu content: %w", err)
 	}
@@ -105,8 +135,8 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	req := larkim.NewCreateMessageReqBuilder().
 		ReceiveIdType(larkim.ReceiveIdTypeChatId).
 		Body(larkim.NewCreateMessageReqBodyBuilder().
-			ReceiveId(msg.ChatID).
-			MsgType(larkim.MsgTypeText).
+			ReceiveId(chatID).
+			MsgType(msgType).
 			Content(string(payload)).
 			Uuid(fmt.Sprintf("picoclaw-%d", time.Now().UnixNano())).
 			Build()).
@@ -121,13 +151,82 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("feishu api error: code=%d msg=%s", resp.Code, resp.Msg)
 	}
 
-	logger.DebugCF("feishu", "Feishu message sent", map[string]interface{}{
-		"chat_id": msg.ChatID,
-	})
+	return nil
+}
+
+// sendAttachment uploads an attachment and sends it as an image, audio
+// (Opus), media (MP4) or file message. Captions follow as text.
+func (c *FeishuChannel) sendAttachment(ctx context.Context, chatID string, a bus.Attachment) error {
+	data, err := readAttachment(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	if a.Kind() == bus.AttachmentImage {
+		resp, err := c.client.Im.V1.Image.Create(ctx, larkim.NewCreateImageReqBuilder().
+			Body(larkim.NewCreateImageReqBodyBuilder().
+				ImageType(larkim.ImageTypeMessage).
+				Image(bytes.NewReader(data)).
+				Build()).
+			Build())
+		if err != nil {
+			return fmt.Errorf("failed to upload feishu image: %w", err)
+		}
+		if !resp.Success() {
+			return fmt.Errorf("feishu api error: code=%d msg=%s", resp.Code, resp.Msg)
+		}
+		err = c.sendMessage(ctx, chatID, larkim.MsgTypeImage, map[string]string{"image_key": stringValue(resp.Data.ImageKey)})
+		if err != nil {
+			return err
+		}
+	} else {
+		fileType, msgType := feishuFileType(a)
+		resp, err := c.client.Im.V1.File.Create(ctx, larkim.NewCreateFileReqBuilder().
+			Body(larkim.NewCreateFileReqBodyBuilder().
+				FileType(fileType).
+				FileName(a.FileName()).
+				File(bytes.NewReader(data)).
+				Build()).
+			Build())
+		if err != nil {
+			return fmt.Errorf("failed to upload feishu file: %w", err)
+		}
+		if !resp.Success() {
+			return fmt.Errorf("feishu api error: code=%d msg=%s", resp.Code, resp.Msg)
+		}
+		err = c.sendMessage(ctx, chatID, msgType, map[string]string{"file_key": stringValue(resp.Data.FileKey)})
+		if err != nil {
+			return err
+		}
+	}
 
+	if a.Caption != "" {
+		return c.sendMessage(ctx, chatID, larkim.MsgTypeText, map[string]string{"text": a.Caption})
+	}
 	return nil
 }
 
+// feishuFileType maps an attachment to the upload file_type and message type.
+func feishuFileType(a bus.Attachment) (string, string) {
+	switch {
+	case a.MIME() == "audio/ogg":
+		return larkim.FileTypeOpus, larkim.MsgTypeAudio
+	case a.MIME() == "video/mp4":
+		return larkim.FileTypeMp4, larkim.MsgTypeMedia
+	}
+	switch strings.ToLower(filepath.Ext(a.FileName())) {
+	case ".pdf":
+		return larkim.FileTypePdf, larkim.MsgTypeFile
+	case ".doc", ".docx":
+		return larkim.FileTypeDoc, larkim.MsgTypeFile
+	case ".xls", ".xlsx":
+		return larkim.FileTypeXls, larkim.MsgTypeFile
+	case ".ppt", ".pptx":
+		return larkim.FileTypePpt, larkim.MsgTypeFile
+	}
+	return larkim.FileTypeStream, larkim.MsgTypeFile
+}
+
 func (c *FeishuChannel) handleMessageReceive(_ context.Context, event *larkim.P2MessageReceiveV1) error {
 	if event == nil || event.Event == nil || event.Event.Message == nil {
 		return nil
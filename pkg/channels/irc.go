@@ -0,0 +1,676 @@
+package channels
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	ircMaxLine = 512 // Bytes per line, including the trailing CRLF
+	// ircPrefixReserve is room for the ":nick!user@host " prefix the server
+	// adds when relaying our messages, less the nick: a 10-byte username
+	// and a 63-byte host.
+	ircPrefixReserve = 1 + 1 + 10 + 1 + 63 + 1
+	ircPingInterval  = 2 * time.Minute
+	ircReadTimeout   = 5 * time.Minute
+	ircMinBackoff    = 2 * time.Second
+	ircMaxBackoff    = 5 * time.Minute
+	// Flood control as in RFC 1459 section 8.10: each line pushes a
+	// timer ircLineCost ahead, and sending waits while the timer is more
+	// than ircFloodWindow ahead of the clock.
+	ircLineCost    = 2 * time.Second
+	ircFloodWindow = 10 * time.Second
+)
+
+// IRCChannel implements the Channel interface for an IRC network. It
+// speaks the client protocol directly: TLS, SASL PLAIN, joining the
+// configured channels and answering private queries.
+type IRCChannel struct {
+	*BaseChannel
+	config config.IRCConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// lineCost and floodWindow are the flood control settings; tests
+	// shorten them.
+	lineCost    time.Duration
+	floodWindow time.Duration
+
+	mu        sync.Mutex
+	conn      net.Conn
+	nick      string    // Current nick, which may differ from the configured one
+	ready     bool      // Registered with the server
+	floodTime time.Time // RFC 1459 message timer
+	writeMu   sync.Mutex
+	sendMu    sync.Mutex // Keeps the lines of one message together
+	addressed sync.Map   // channel -> nick the next reply is addressed to
+}
+
+// ircMessage is one parsed protocol line.
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// Nick returns the nick part of the message prefix.
+func (m ircMessage) Nick() string {
+	nick, _, _ := strings.Cut(m.Prefix, "!")
+	return nick
+}
+
+// Param returns the i-th parameter, or "" when there are fewer.
+func (m ircMessage) Param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// NewIRCChannel creates a new IRC channel instance.
+func NewIRCChannel(cfg config.IRCConfig, messageBus *bus.MessageBus) (*IRCChannel, error) {
+	if cfg.Server == "" || cfg.Nick == "" {
+		return nil, fmt.Errorf("irc server and nick are required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		return nil, fmt.Errorf("irc server must be host:port: %w", err)
+	}
+	if cfg.Username == "" {
+		cfg.Username = cfg.Nick
+	}
+	if cfg.RealName == "" {
+		cfg.RealName = cfg.Nick
+	}
+
+	base := NewBaseChannel("irc", cfg, messageBus, cfg.AllowFrom)
+
+	return &IRCChannel{
+		BaseChannel: base,
+		config:      cfg,
+		nick:        cfg.Nick,
+		lineCost:    ircLineCost,
+		floodWindow: ircFloodWindow,
+	}, nil
+}
+
+// Start launches the connection loop. Connecting happens in the
+// background, so an unreachable server doesn't hold up the gateway.
+func (c *IRCChannel) Start(ctx context.Context) error {
+	logger.InfoCF("irc", "Starting IRC channel", map[string]interface{}{
+		"server": c.config.Server,
+		"nick":   c.config.Nick,
+	})
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	go c.run()
+
+	c.setRunning(true)
+	logger.InfoC("irc", "IRC channel started")
+	return nil
+}
+
+// Stop says goodbye and closes the connection.
+func (c *IRCChannel) Stop(ctx context.Context) error {
+	logger.InfoC("irc", "Stopping IRC channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		c.writeLine(conn, "QUIT :Shutting down")
+		conn.Close()
+	}
+
+	c.setRunning(false)
+	logger.InfoC("irc", "IRC channel stopped")
+	return nil
+}
+
+// run keeps a connection up, reconnecting with exponential backoff. The
+// backoff resets once a connection gets through registration.
+func (c *IRCChannel) run() {
+	backoff := ircMinBackoff
+	for c.ctx.Err() == nil {
+		registered, err := c.session()
+		if c.ctx.Err() != nil {
+			return
+		}
+		if registered {
+			backoff = ircMinBackoff
+		}
+		logger.WarnCF("irc", "Disconnected, reconnecting", map[string]interface{}{
+			"error": fmt.Sprint(err),
+			"retry": backoff.String(),
+		})
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > ircMaxBackoff {
+			backoff = ircMaxBackoff
+		}
+	}
+}
+
+// session runs one connection until it fails. It reports whether the
+// connection got through registration.
+func (c *IRCChannel) session() (bool, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	c.mu.Lock()
+	c.conn = conn
+	c.nick = c.config.Nick
+	c.ready = false
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.ready = false
+		c.mu.Unlock()
+	}()
+
+	// Close the connection when the channel stops, unblocking the reader
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ping := time.NewTicker(ircPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-c.ctx.Done():
+				conn.Close()
+				return
+			case <-ping.C:
+				c.writeLine(conn, "PING :picoclaw")
+			}
+		}
+	}()
+
+	if err := c.register(conn); err != nil {
+		return false, err
+	}
+
+	registered := false
+	reader := bufio.NewReaderSize(conn, ircMaxLine)
+	for {
+		conn.SetReadDeadline(time.Now().Add(ircReadTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return registered, err
+		}
+		msg, ok := parseIRCLine(line)
+		if !ok {
+			continue
+		}
+		if err := c.handleLine(conn, msg); err != nil {
+			return registered, err
+		}
+		if msg.Command == "001" {
+			registered = true
+		}
+	}
+}
+
+func (c *IRCChannel) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if c.config.TLS {
+		host, _, _ := net.SplitHostPort(c.config.Server)
+		return tls.DialWithDialer(dialer, "tcp", c.config.Server, &tls.Config{ServerName: host})
+	}
+	return dialer.DialContext(c.ctx, "tcp", c.config.Server)
+}
+
+// register opens the session: SASL capability negotiation when
+// configured, then PASS, NICK and USER.
+func (c *IRCChannel) register(conn net.Conn) error {
+	if c.config.SASLUser != "" {
+		if err := c.writeLine(conn, "CAP LS 302"); err != nil {
+			return err
+		}
+	}
+	if c.config.Password != "" {
+		if err := c.writeLine(conn, "PASS "+c.config.Password); err != nil {
+			return err
+		}
+	}
+	if err := c.writeLine(conn, "NICK "+c.config.Nick); err != nil {
+		return err
+	}
+	return c.writeLine(conn, fmt.Sprintf("USER %s 0 * :%s", c.config.Username, c.config.RealName))
+}
+
+// handleLine reacts to one server message. An error ends the session.
+func (c *IRCChannel) handleLine(conn net.Conn, msg ircMessage) error {
+	switch msg.Command {
+	case "PING":
+		return c.writeLine(conn, "PONG :"+msg.Param(0))
+
+	case "CAP":
+		return c.handleCap(conn, msg)
+
+	case "AUTHENTICATE":
+		if msg.Param(0) == "+" {
+			payload := c.config.SASLUser + "\x00" + c.config.SASLUser + "\x00" + c.config.SASLPassword
+			return c.writeLine(conn, "AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte(payload)))
+		}
+
+	case "903": // SASL succeeded
+		return c.writeLine(conn, "CAP END")
+
+	case "902", "904", "905", "906": // SASL failed or aborted
+		return fmt.Errorf("SASL authentication failed: %s", msg.Param(len(msg.Params)-1))
+
+	case "001": // Welcome
+		c.mu.Lock()
+		c.nick = msg.Param(0)
+		c.ready = true
+		c.floodTime = time.Time{}
+		c.mu.Unlock()
+		logger.InfoCF("irc", "Registered with server", map[string]interface{}{
+			"nick": msg.Param(0),
+		})
+		for _, channel := range c.config.Channels {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				c.writeLine(conn, "JOIN "+channel)
+			}
+		}
+
+	case "432", "433", "436": // Nick unusable or taken during registration
+		c.mu.Lock()
+		ready := c.ready
+		if !ready {
+			c.nick += "_"
+		}
+		nick := c.nick
+		c.mu.Unlock()
+		if !ready {
+			logger.WarnCF("irc", "Nick unavailable, trying another", map[string]interface{}{
+				"nick": nick,
+			})
+			return c.writeLine(conn, "NICK "+nick)
+		}
+
+	case "NICK":
+		c.mu.Lock()
+		if ircEqualFold(msg.Nick(), c.nick) {
+			c.nick = msg.Param(0)
+		}
+		c.mu.Unlock()
+
+	case "JOIN":
+		if ircEqualFold(msg.Nick(), c.currentNick()) {
+			logger.InfoCF("irc", "Joined channel", map[string]interface{}{
+				"channel": msg.Param(0),
+			})
+		}
+
+	case "KICK":
+		if ircEqualFold(msg.Param(1), c.currentNick()) {
+			logger.WarnCF("irc", "Kicked from channel", map[string]interface{}{
+				"channel": msg.Param(0),
+				"by":      msg.Nick(),
+				"reason":  msg.Param(2),
+			})
+		}
+
+	case "471", "473", "474", "475": // Can't join: full, invite only, banned, bad key
+		logger.WarnCF("irc", "Cannot join channel", map[string]interface{}{
+			"channel": msg.Param(1),
+			"reason":  msg.Param(2),
+		})
+
+	case "ERROR":
+		return fmt.Errorf("server closed the link: %s", msg.Param(0))
+
+	case "PRIVMSG":
+		c.handlePrivmsg(msg)
+	}
+	return nil
+}
+
+// handleCap requests SASL when the server offers it, and ends
+// negotiation otherwise.
+func (c *IRCChannel) handleCap(conn net.Conn, msg ircMessage) error {
+	switch msg.Param(1) {
+	case "LS":
+		// Multi-line LS replies mark all but the last line with "*"
+		caps := msg.Param(len(msg.Params) - 1)
+		offered := false
+		for _, capability := range strings.Fields(caps) {
+			name, values, _ := strings.Cut(capability, "=")
+			if name == "sasl" && (values == "" || strings.Contains(","+values+",", ",PLAIN,")) {
+				offered = true
+			}
+		}
+		if offered {
+			return c.writeLine(conn, "CAP REQ :sasl")
+		}
+		if msg.Param(2) != "*" {
+			logger.WarnC("irc", "Server does not offer SASL PLAIN, continuing without it")
+			return c.writeLine(conn, "CAP END")
+		}
+	case "ACK":
+		if strings.Contains(msg.Param(2), "sasl") {
+			return c.writeLine(conn, "AUTHENTICATE PLAIN")
+		}
+		return c.writeLine(conn, "CAP END")
+	case "NAK":
+		logger.WarnC("irc", "Server refused SASL, continuing without it")
+		return c.writeLine(conn, "CAP END")
+	}
+	return nil
+}
+
+func (c *IRCChannel) handlePrivmsg(msg ircMessage) {
+	sender := msg.Nick()
+	target := msg.Param(0)
+	text := msg.Param(1)
+	nick := c.currentNick()
+	if sender == "" || target == "" || ircEqualFold(sender, nick) {
+		return
+	}
+
+	// CTCP: only ACTION ("/me") carries a message
+	if strings.HasPrefix(text, "\x01") {
+		command, rest, _ := strings.Cut(strings.Trim(text, "\x01"), " ")
+		if command != "ACTION" {
+			return
+		}
+		text = "* " + sender + " " + rest
+	}
+	text = strings.TrimSpace(stripIRCFormatting(text))
+	if text == "" {
+		return
+	}
+
+	isChannel := strings.ContainsAny(target[:1], "#&+!")
+	chatID := sender
+	if isChannel {
+		chatID = target
+		if c.config.RequireMention {
+			rest, ok := ircAddressed(text, nick)
+			if !ok {
+				return
+			}
+			text = rest
+		}
+	}
+
+	// The user part of the hostmask lets allow_from match "user@host",
+	// which is harder to spoof than a nick
+	senderID := sender
+	if _, hostmask, ok := strings.Cut(msg.Prefix, "!"); ok {
+		senderID = sender + "|" + hostmask
+	}
+	if !c.IsAllowed(senderID) {
+		return
+	}
+	if isChannel {
+		c.addressed.Store(chatID, sender)
+	}
+
+	metadata := map[string]string{
+		"platform": "irc",
+		"hostmask": msg.Prefix,
+		"is_dm":    fmt.Sprint(!isChannel),
+	}
+
+	logger.DebugCF("irc", "Received message", map[string]interface{}{
+		"sender":  sender,
+		"chat_id": chatID,
+		"preview": utils.Truncate(text, 50),
+	})
+
+	c.HandleMessage(senderID, chatID, text, nil, metadata)
+}
+
+// Send delivers a message as PRIVMSG lines, split to fit the protocol's
+// line limit and paced to stay under the server's flood limits. In
+// channels, the reply to a message is addressed to its sender by nick.
+func (c *IRCChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("irc channel not running")
+	}
+	target := msg.ChatID
+	if target == "" || strings.ContainsAny(target, " \r\n") {
+		return fmt.Errorf("invalid irc target: %s", msg.ChatID)
+	}
+
+	c.mu.Lock()
+	conn, ready, nick := c.conn, c.ready, c.nick
+	c.mu.Unlock()
+	if conn == nil || !ready {
+		return fmt.Errorf("irc not connected")
+	}
+
+	text := renderMarkdown(withButtonText(msg.Content, msg.Buttons), dialectPlain)
+	text = withAttachmentText(text, msg.Attachments)
+	if to, ok := c.addressed.LoadAndDelete(target); ok {
+		text = to.(string) + ": " + text
+	}
+
+	limit := ircMaxLine - 2 - len("PRIVMSG "+target+" :") - len(nick) - ircPrefixReserve
+	lines := splitIRCText(text, limit)
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	parts := outbox.PartsFrom(ctx)
+	for _, line := range lines {
+		if parts.Skip() {
+			continue
+		}
+		if err := c.throttle(ctx); err != nil {
+			return err
+		}
+		if err := c.writeLine(conn, "PRIVMSG "+target+" :"+line); err != nil {
+			return fmt.Errorf("irc send: %w", err)
+		}
+		parts.Done()
+	}
+
+	logger.DebugCF("irc", "Message sent", map[string]interface{}{
+		"target": target,
+		"lines":  len(lines),
+	})
+	return nil
+}
+
+// throttle waits until another line can go out without flooding.
+func (c *IRCChannel) throttle(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	if c.floodTime.Before(now) {
+		c.floodTime = now
+	}
+	wait := c.floodTime.Sub(now) - c.floodWindow
+	c.floodTime = c.floodTime.Add(c.lineCost)
+	c.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+func (c *IRCChannel) writeLine(conn net.Conn, line string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func (c *IRCChannel) currentNick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nick
+}
+
+// parseIRCLine parses "[@tags] [:prefix] COMMAND params [:trailing]".
+func parseIRCLine(line string) (ircMessage, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	var msg ircMessage
+	if strings.HasPrefix(line, ":") {
+		msg.Prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	line = strings.TrimLeft(line, " ")
+	msg.Command, line, _ = strings.Cut(line, " ")
+	if msg.Command == "" {
+		return msg, false
+	}
+	msg.Command = strings.ToUpper(msg.Command)
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") {
+			msg.Params = append(msg.Params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param != "" {
+			msg.Params = append(msg.Params, param)
+		}
+	}
+	return msg, true
+}
+
+// ircAddressed reports whether text is addressed to nick: it starts with
+// "nick:", "nick," or "@nick", or mentions nick as a word. The leading
+// address is removed from the returned text.
+func ircAddressed(text, nick string) (string, bool) {
+	lowerText, lowerNick := ircLower(text), ircLower(nick)
+	for _, prefix := range []string{lowerNick, "@" + lowerNick} {
+		if !strings.HasPrefix(lowerText, prefix) {
+			continue
+		}
+		rest := text[len(prefix):]
+		if rest == "" || strings.ContainsAny(rest[:1], ":, ") {
+			return strings.TrimLeft(rest, ":, "), true
+		}
+	}
+	for i := 0; ; {
+		idx := strings.Index(lowerText[i:], lowerNick)
+		if idx < 0 {
+			return text, false
+		}
+		start, end := i+idx, i+idx+len(lowerNick)
+		if (start == 0 || !isNickByte(lowerText[start-1])) && (end == len(lowerText) || !isNickByte(lowerText[end])) {
+			return text, true
+		}
+		i = start + 1
+	}
+}
+
+// ircLower lowercases ASCII letters and the RFC 1459 bracket pairs, so
+// "Pico[m]" and "pico{m}" are the same nick.
+func ircLower(s string) string {
+	b := []byte(s)
+	for i, ch := range b {
+		switch {
+		case ch >= 'A' && ch <= 'Z':
+			b[i] = ch + 'a' - 'A'
+		case ch == '[':
+			b[i] = '{'
+		case ch == ']':
+			b[i] = '}'
+		case ch == '\\':
+			b[i] = '|'
+		case ch == '~':
+			b[i] = '^'
+		}
+	}
+	return string(b)
+}
+
+func ircEqualFold(a, b string) bool {
+	return ircLower(a) == ircLower(b)
+}
+
+func isNickByte(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+		strings.IndexByte("-_[]{}\\|`^", ch) >= 0
+}
+
+// stripIRCFormatting removes bold, color and other formatting codes.
+func stripIRCFormatting(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; ch {
+		case 0x02, 0x0f, 0x11, 0x16, 0x1d, 0x1e, 0x1f:
+		case 0x03:
+			// Color: up to two digits, optionally ",bg" with up to two more
+			j := i + 1
+			for n := 0; n < 2 && j < len(text) && text[j] >= '0' && text[j] <= '9'; n++ {
+				j++
+			}
+			if j > i+1 && j+1 < len(text) && text[j] == ',' && text[j+1] >= '0' && text[j+1] <= '9' {
+				j += 2
+				if j < len(text) && text[j] >= '0' && text[j] <= '9' {
+					j++
+				}
+			}
+			i = j - 1
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// splitIRCText breaks text into lines of at most limit bytes. Newlines
+// start a new line, blank lines are dropped, and long lines break at the
+// last space that fits, or mid-word at a UTF-8 boundary.
+func splitIRCText(text string, limit int) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(strings.ReplaceAll(line, "\r", ""), " \t")
+		for len(line) > limit {
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if space := strings.LastIndexByte(line[:cut], ' '); space > limit/2 {
+				cut = space
+			}
+			lines = append(lines, line[:cut])
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,226 @@
+package channels
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// fakeIRCd accepts connections one at a time and lets the test script
+// the conversation line by line.
+type fakeIRCd struct {
+	t     *testing.T
+	l     net.Listener
+	conns chan net.Conn
+	conn  net.Conn
+	lines chan string
+}
+
+func newFakeIRCd(t *testing.T) *fakeIRCd {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeIRCd{t: t, l: l, conns: make(chan net.Conn, 2)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			s.conns <- conn
+		}
+	}()
+	return s
+}
+
+// accept waits for the next client connection.
+func (s *fakeIRCd) accept() {
+	s.t.Helper()
+	select {
+	case s.conn = <-s.conns:
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("no connection")
+	}
+	lines := make(chan string, 100)
+	s.lines = lines
+	go func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimRight(line, "\r\n")
+		}
+	}(s.conn)
+}
+
+// expect skips client lines until one starts with prefix.
+func (s *fakeIRCd) expect(prefix string) string {
+	s.t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				s.t.Fatalf("connection closed waiting for %q", prefix)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-timeout:
+			s.t.Fatalf("timed out waiting for %q", prefix)
+		}
+	}
+}
+
+func (s *fakeIRCd) send(line string) {
+	s.conn.Write([]byte(line + "\r\n"))
+}
+
+func startIRCChannel(t *testing.T, cfg config.IRCConfig) (*IRCChannel, *bus.MessageBus) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	ch, err := NewIRCChannel(cfg, mb)
+	if err != nil {
+		t.Fatalf("NewIRCChannel error: %v", err)
+	}
+	ch.lineCost = time.Millisecond
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	t.Cleanup(func() { ch.Stop(context.Background()) })
+	return ch, mb
+}
+
+func TestIRCChannel_SASLJoinAndChat(t *testing.T) {
+	srv := newFakeIRCd(t)
+	ch, mb := startIRCChannel(t, config.IRCConfig{
+		Server:         srv.l.Addr().String(),
+		Nick:           "pico",
+		SASLUser:       "pico",
+		SASLPassword:   "hunter2",
+		Channels:       config.FlexibleStringSlice{"#hw", "#private key"},
+		RequireMention: true,
+	})
+	srv.accept()
+
+	srv.expect("CAP LS 302")
+	srv.expect("NICK pico")
+	srv.expect("USER pico 0 * :pico")
+	srv.send(":srv CAP * LS :multi-prefix sasl=PLAIN,EXTERNAL")
+	srv.expect("CAP REQ :sasl")
+	srv.send(":srv CAP * ACK :sasl")
+	srv.expect("AUTHENTICATE PLAIN")
+	srv.send("AUTHENTICATE +")
+	if got, want := srv.expect("AUTHENTICATE "), "AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte("pico\x00pico\x00hunter2")); got != want {
+		t.Errorf("SASL payload = %q, want %q", got, want)
+	}
+	srv.send(":srv 903 pico :SASL authentication successful")
+	srv.expect("CAP END")
+
+	// Someone else has the nick
+	srv.send(":srv 433 * pico :Nickname is already in use")
+	srv.expect("NICK pico_")
+	srv.send(":srv 001 pico_ :Welcome")
+	srv.expect("JOIN #hw")
+	srv.expect("JOIN #private key")
+
+	srv.send(":alice!al@example.org PRIVMSG #hw :just chatting among ourselves")
+	srv.send(":alice!al@example.org PRIVMSG #hw :Pico_: which pin is \x02GPIO4\x02?")
+	srv.send(":bob!b@host PRIVMSG pico_ :hi there")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "#hw" || msg.SenderID != "alice|al@example.org" || msg.Content != "which pin is GPIO4?" {
+		t.Fatalf("channel message = %+v", msg)
+	}
+	msg, ok = mb.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "bob" || msg.Content != "hi there" || msg.Metadata["is_dm"] != "true" {
+		t.Fatalf("private message = %+v", msg)
+	}
+
+	// A long reply is split under the line limit and addressed to alice
+	long := strings.Repeat("word ", 150) + "\n\n`end`"
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "#hw", Content: long}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	var got []string
+	for len(strings.Join(got, " ")) < len(strings.TrimSpace(strings.Repeat("word ", 150))) {
+		line := srv.expect("PRIVMSG #hw :")
+		if len(line)+2+len("pico_")+ircPrefixReserve > ircMaxLine {
+			t.Errorf("line of %d bytes is too long once relayed", len(line))
+		}
+		got = append(got, strings.TrimPrefix(line, "PRIVMSG #hw :"))
+	}
+	if !strings.HasPrefix(got[0], "alice: word word") || len(got) < 2 {
+		t.Errorf("lines = %q, want several addressed to alice", got)
+	}
+	if last := srv.expect("PRIVMSG #hw :"); last != "PRIVMSG #hw :end" {
+		t.Errorf("last line = %q", last)
+	}
+}
+
+func TestIRCChannel_ReconnectsAndAnswersPing(t *testing.T) {
+	srv := newFakeIRCd(t)
+	startIRCChannel(t, config.IRCConfig{Server: srv.l.Addr().String(), Nick: "pico"})
+
+	srv.accept()
+	srv.expect("NICK pico")
+	srv.send(":srv 001 pico :Welcome")
+	srv.send("PING :abc")
+	srv.expect("PONG :abc")
+	srv.send("ERROR :Closing link (ping timeout)")
+	srv.conn.Close()
+
+	srv.accept()
+	srv.expect("NICK pico")
+}
+
+func TestIRCAddressed(t *testing.T) {
+	tests := []struct {
+		text, want string
+		ok         bool
+	}{
+		{"pico: hello", "hello", true},
+		{"PICO, hello", "hello", true},
+		{"@pico hi", "hi", true},
+		{"thanks pico!", "thanks pico!", true},
+		{"picoclaw is neat", "picoclaw is neat", false},
+		{"unrelated", "unrelated", false},
+	}
+	for _, tt := range tests {
+		got, ok := ircAddressed(tt.text, "pico")
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ircAddressed(%q) = %q, %v; want %q, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseIRCLine(t *testing.T) {
+	msg, ok := parseIRCLine("@time=x :nick!u@h PRIVMSG #chan :hello : world\r\n")
+	if !ok || msg.Nick() != "nick" || msg.Command != "PRIVMSG" || msg.Param(0) != "#chan" || msg.Param(1) != "hello : world" {
+		t.Errorf("parsed = %+v", msg)
+	}
+}
+
+func TestSplitIRCText_KeepsRunesWhole(t *testing.T) {
+	for _, line := range splitIRCText(strings.Repeat("é", 40), 15) {
+		if len(line) > 15 || !strings.HasPrefix(line, "é") {
+			t.Errorf("line %q breaks a rune or the limit", line)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
@@ -236,9 +237,14 @@ type lineEvent struct {
 	ReplyToken string          `json:"replyToken"`
 	Source     lineSource      `json:"source"`
 	Message    json.RawMessage `json:"message"`
+	Postback   *linePostback   `json:"postback"`
 	Timestamp  int64           `json:"timestamp"`
 }
 
+type linePostback struct {
+	Data string `json:"data"`
+}
+
 type lineSource struct {
 	Type    string `json:"type"` // "user", "group", "room"
 	UserID  string `json:"userId"`
@@ -267,6 +273,10 @@ type lineMentionee struct {
 }
 
 func (c *LINEChannel) processEvent(event lineEvent) {
+	if event.Type == "postback" && event.Postback != nil {
+		c.processPostback(event)
+		return
+	}
 	if event.Type != "message" {
 		logger.DebugCF("line", "Ignoring non-message event", map[string]interface{}{
 			"type": event.Type,
@@ -385,6 +395,27 @@ func (c *LINEChannel) processEvent(event lineEvent) {
 	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
 }
 
+// processPostback turns a quick reply button tap into an inbound message.
+func (c *LINEChannel) processPostback(event lineEvent) {
+	senderID := event.Source.UserID
+	chatID := c.resolveChatID(event.Source)
+
+	if event.ReplyToken != "" {
+		c.replyTokens.Store(chatID, replyTokenEntry{
+			token:     event.ReplyToken,
+			timestamp: time.Now(),
+		})
+	}
+
+	metadata := map[string]string{
+		"platform":    "line",
+		"source_type": event.Source.Type,
+	}
+
+	c.sendLoading(senderID)
+	c.HandleButton(senderID, chatID, event.Postback.Data, metadata)
+}
+
 // isBotMentioned checks if the bot is mentioned in the message.
 // It first checks the mention metadata (userId match), then falls back
 // to text-based detection using the bot's display name, since LINE may
@@ -497,11 +528,13 @@ func (c *LINEChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 		quoteToken = qt.(string)
 	}
 
+	messages := buildLINEMessages(msg, quoteToken)
+
 	// Try reply token first (free, valid for ~25 seconds)
 	if entry, ok := c.replyTokens.LoadAndDelete(msg.ChatID); ok {
 		tokenEntry := entry.(replyTokenEntry)
 		if time.Since(tokenEntry.timestamp) < lineReplyTokenMaxAge {
-			if err := c.sendReply(ctx, tokenEntry.token, msg.Content, quoteToken); err == nil {
+			if err := c.sendReply(ctx, tokenEntry.token, messages); err == nil {
 				logger.DebugCF("line", "Message sent via Reply API", map[string]interface{}{
 					"chat_id": msg.ChatID,
 					"quoted":  quoteToken != "",
@@ -513,7 +546,108 @@ func (c *LINEChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 	}
 
 	// Fall back to Push API
-	return c.sendPush(ctx, msg.ChatID, msg.Content, quoteToken)
+	return c.sendPush(ctx, msg.ChatID, messages)
+}
+
+// LINE message limits.
+const (
+	lineMaxMessages     = 5    // Message objects per Reply/Push call
+	lineMaxLength       = 5000 // Characters in a text message
+	lineMaxQuickReplies = 13   // Quick reply buttons per message
+	lineMaxLabel        = 20   // Characters in a quick reply label
+	lineMaxPostbackData = 300  // Bytes of postback data
+)
+
+// buildLINEMessages converts an outbound message into LINE message objects.
+// LINE only accepts media by public HTTPS URL, so images with an https URL
+// are sent as image messages; everything else is listed as text.
+func buildLINEMessages(msg bus.OutboundMessage, quoteToken string) []interface{} {
+	var messages []interface{}
+	var fallback []bus.Attachment
+	var images []bus.Attachment
+	for _, a := range msg.Attachments {
+		if a.Kind() == bus.AttachmentImage && a.Path == "" && strings.HasPrefix(a.URL, "https://") {
+			images = append(images, a)
+		} else {
+			fallback = append(fallback, a)
+		}
+	}
+
+	text := withAttachmentText(msg.Content, fallback)
+	for _, a := range images {
+		if a.Caption != "" {
+			text = appendContent(text, a.Caption)
+		}
+	}
+	chunks := renderMessage(text, dialectPlain, lineMaxLength)
+	if len(chunks) == 0 && len(images) == 0 {
+		chunks = []string{""}
+	}
+	for i, chunk := range chunks {
+		if len(messages) == lineMaxMessages {
+			break
+		}
+		if i > 0 {
+			quoteToken = ""
+		}
+		messages = append(messages, buildTextMessage(chunk, quoteToken))
+	}
+
+	for _, a := range images {
+		if len(messages) == lineMaxMessages {
+			break
+		}
+		messages = append(messages, map[string]string{
+			"type":               "image",
+			"originalContentUrl": a.URL,
+			"previewImageUrl":    a.URL,
+		})
+	}
+
+	if len(msg.Buttons) > 0 {
+		last := len(messages) - 1
+		messages[last] = withQuickReply(messages[last].(map[string]string), msg.Buttons)
+	}
+	return messages
+}
+
+// withQuickReply attaches buttons to a message as quick replies: postback
+// actions that echo the label in the chat, or URI actions for links.
+func withQuickReply(message map[string]string, rows [][]bus.Button) map[string]interface{} {
+	var items []interface{}
+	for _, row := range rows {
+		for _, b := range row {
+			if len(items) == lineMaxQuickReplies {
+				break
+			}
+			label := b.Text
+			if runes := []rune(label); len(runes) > lineMaxLabel {
+				label = string(runes[:lineMaxLabel])
+			}
+			action := map[string]string{"label": label}
+			if b.URL != "" {
+				action["type"] = "uri"
+				action["uri"] = b.URL
+			} else {
+				action["type"] = "postback"
+				data := b.Payload()
+				for len(data) > lineMaxPostbackData {
+					_, size := utf8.DecodeLastRuneInString(data)
+					data = data[:len(data)-size]
+				}
+				action["data"] = data
+				action["displayText"] = b.Text
+			}
+			items = append(items, map[string]interface{}{"type": "action", "action": action})
+		}
+	}
+
+	out := make(map[string]interface{}, len(message)+1)
+	for k, v := range message {
+		out[k] = v
+	}
+	out["quickReply"] = map[string]interface{}{"items": items}
+	return out
 }
 
 // buildTextMessage creates a text message object, optionally with quoteToken.
@@ -528,21 +662,21 @@ func buildTextMessage(content, quoteToken string) map[string]string {
 	return msg
 }
 
-// sendReply sends a message using the LINE Reply API.
-func (c *LINEChannel) sendReply(ctx context.Context, replyToken, content, quoteToken string) error {
+// sendReply sends messages using the LINE Reply API.
+func (c *LINEChannel) sendReply(ctx context.Context, replyToken string, messages []interface{}) error {
 	payload := map[string]interface{}{
 		"replyToken": replyToken,
-		"messages":   []map[string]string{buildTextMessage(content, quoteToken)},
+		"messages":   messages,
 	}
 
 	return c.callAPI(ctx, lineReplyEndpoint, payload)
 }
 
-// sendPush sends a message using the LINE Push API.
-func (c *LINEChannel) sendPush(ctx context.Context, to, content, quoteToken string) error {
+// sendPush sends messages using the LINE Push API.
+func (c *LINEChannel) sendPush(ctx context.Context, to string, messages []interface{}) error {
 	payload := map[string]interface{}{
 		"to":       to,
-		"messages": []map[string]string{buildTextMessage(content, quoteToken)},
+		"messages": messages,
 	}
 
 	return c.callAPI(ctx, linePushEndpoint, payload)
@@ -585,7 +719,7 @@ func (c *LINEChannel) callAPI(ctx context.Context, endpoint string, payload inte
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("LINE API error (status %d): %s", resp.StatusCode, string(respBody))
+		return statusError(resp.StatusCode, fmt.Errorf("LINE API error (status %d): %s", resp.StatusCode, string(respBody)))
 	}
 
 	return nil
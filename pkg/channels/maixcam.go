@@ -173,6 +173,11 @@ func (c *MaixCamChannel) handlePersonDetection(msg MaixCamMessage) {
 		"w":         fmt.Sprintf("%.0f", w),
 		"h":         fmt.Sprintf("%.0f", h),
 	}
+	// The device has no message IDs; a detection resent with the same
+	// timestamp is the same event
+	if msg.Timestamp > 0 {
+		metadata["message_id"] = fmt.Sprintf("detection-%.0f", msg.Timestamp)
+	}
 
 	c.HandleMessage(senderID, chatID, content, []string{}, metadata)
 }
@@ -219,7 +224,7 @@ func (c *MaixCamChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 	response := map[string]interface{}{
 		"type":      "command",
 		"timestamp": float64(0),
-		"message":   msg.Content,
+		"message":   plainContent(msg),
 		"chat_id":   msg.ChatID,
 	}
 
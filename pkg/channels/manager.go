@@ -8,13 +8,22 @@ package channels
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"path/filepath"
 	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mymmrac/telego/telegoapi"
+	"github.com/slack-go/slack"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
 )
 
 type Manager struct {
@@ -22,6 +31,7 @@ type Manager struct {
 	bus          *bus.MessageBus
 	config       *config.Config
 	workspace    string
+	outbox       *outbox.Queue
 	dispatchTask *asyncTask
 	mu           sync.RWMutex
 }
@@ -42,6 +52,16 @@ func NewManager(cfg *config.Config, messageBus *bus.MessageBus, workspace string
 		return nil, err
 	}
 
+	store, err := outbox.NewStore(filepath.Join(workspace, "outbox"))
+	if err != nil {
+		// Without a store messages are still sent, just once
+		logger.WarnCF("channels", "Outbound queue unavailable", map[string]interface{}{
+			"error": err.Error(),
+		})
+	} else {
+		m.outbox = outbox.NewQueue(store, m.deliver, m.retryPolicy)
+	}
+
 	return m, nil
 }
 
@@ -178,6 +198,110 @@ func (m *Manager) initChannels() error {
 		}
 	}
 
+	if m.config.Channels.Matrix.Enabled && m.config.Channels.Matrix.AccessToken != "" {
+		logger.DebugC("channels", "Attempting to initialize Matrix channel")
+		matrix, err := NewMatrixChannel(m.config.Channels.Matrix, m.bus, m.workspace)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Matrix channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["matrix"] = matrix
+			logger.InfoC("channels", "Matrix channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Email.Enabled && m.config.Channels.Email.IMAPHost != "" {
+		logger.DebugC("channels", "Attempting to initialize Email channel")
+		email, err := NewEmailChannel(m.config.Channels.Email, m.bus, m.workspace)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Email channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["email"] = email
+			logger.InfoC("channels", "Email channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Webhook.Enabled {
+		logger.DebugC("channels", "Attempting to initialize Webhook channel")
+		webhook, err := NewWebhookChannel(m.config.Channels.Webhook, m.bus, m.workspace)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Webhook channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["webhook"] = webhook
+			logger.InfoC("channels", "Webhook channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Web.Enabled {
+		logger.DebugC("channels", "Attempting to initialize Web channel")
+		web, err := NewWebChannel(m.config.Channels.Web, m.config.Gateway, m.bus, m.workspace)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Web channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["web"] = web
+			logger.InfoC("channels", "Web channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.IRC.Enabled && m.config.Channels.IRC.Server != "" {
+		logger.DebugC("channels", "Attempting to initialize IRC channel")
+		irc, err := NewIRCChannel(m.config.Channels.IRC, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize IRC channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["irc"] = irc
+			logger.InfoC("channels", "IRC channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Signal.Enabled && m.config.Channels.Signal.Account != "" {
+		logger.DebugC("channels", "Attempting to initialize Signal channel")
+		signal, err := NewSignalChannel(m.config.Channels.Signal, m.bus, m.workspace)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Signal channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["signal"] = signal
+			logger.InfoC("channels", "Signal channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.WeCom.Enabled && (m.config.Channels.WeCom.CorpID != "" || m.config.Channels.WeCom.RobotToken != "") {
+		logger.DebugC("channels", "Attempting to initialize WeCom channel")
+		wecom, err := NewWeComChannel(m.config.Channels.WeCom, m.bus, m.workspace)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize WeCom channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["wecom"] = wecom
+			logger.InfoC("channels", "WeCom channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.MQTT.Enabled && m.config.Channels.MQTT.Broker != "" {
+		logger.DebugC("channels", "Attempting to initialize MQTT channel")
+		mqtt, err := NewMQTTChannel(m.config.Channels.MQTT, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize MQTT channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["mqtt"] = mqtt
+			logger.InfoC("channels", "MQTT channel enabled successfully")
+		}
+	}
+
 	logger.InfoCF("channels", "Channel initialization completed", map[string]interface{}{
 		"enabled_channels": len(m.channels),
 	})
@@ -199,6 +323,9 @@ func (m *Manager) StartAll(ctx context.Context) error {
 	dispatchCtx, cancel := context.WithCancel(ctx)
 	m.dispatchTask = &asyncTask{cancel: cancel}
 
+	if m.outbox != nil {
+		m.outbox.Start(dispatchCtx)
+	}
 	go m.dispatchOutbound(dispatchCtx)
 
 	for name, channel := range m.channels {
@@ -264,7 +391,7 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 			}
 
 			m.mu.RLock()
-			channel, exists := m.channels[msg.Channel]
+			_, exists := m.channels[msg.Channel]
 			m.mu.RUnlock()
 
 			if !exists {
@@ -274,7 +401,17 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 				continue
 			}
 
-			if err := channel.Send(ctx, msg); err != nil {
+			if m.outbox != nil {
+				if err := m.outbox.Enqueue(msg); err != nil {
+					logger.WarnCF("channels", "Failed to persist outbound message", map[string]interface{}{
+						"channel": msg.Channel,
+						"error":   err.Error(),
+					})
+				}
+				continue
+			}
+
+			if err := m.deliver(ctx, msg); err != nil {
 				logger.ErrorCF("channels", "Error sending message to channel", map[string]interface{}{
 					"channel": msg.Channel,
 					"error":   err.Error(),
@@ -284,6 +421,76 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 	}
 }
 
+// deliver sends msg through its channel. The outbound queue calls it for
+// each attempt; errors that another attempt can't fix are marked
+// permanent, so the message goes straight to the dead letters.
+func (m *Manager) deliver(ctx context.Context, msg bus.OutboundMessage) error {
+	m.mu.RLock()
+	channel, exists := m.channels[msg.Channel]
+	m.mu.RUnlock()
+
+	if !exists {
+		return outbox.Permanent(fmt.Errorf("channel %s not found", msg.Channel))
+	}
+	err := channel.Send(ctx, msg)
+	if permanentSendError(err) {
+		return outbox.Permanent(err)
+	}
+	return err
+}
+
+// httpStatusError is an API call that failed with an HTTP status.
+type httpStatusError struct {
+	status int
+	err    error
+}
+
+func (e *httpStatusError) Error() string { return e.err.Error() }
+func (e *httpStatusError) Unwrap() error { return e.err }
+
+// statusError tags err with the HTTP status of the failed call, so
+// delivery can tell rejected requests from transient failures.
+func statusError(status int, err error) error {
+	return &httpStatusError{status: status, err: err}
+}
+
+// permanentSendError reports whether the platform rejected the message
+// itself (unknown chat, no permission, bad request), as opposed to timing
+// out or rate limiting us.
+func permanentSendError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var (
+		status   int
+		httpErr  *httpStatusError
+		tgErr    *telegoapi.Error
+		dgErr    *discordgo.RESTError
+		slackErr slack.StatusCodeError
+	)
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.status
+	case errors.As(err, &tgErr):
+		status = tgErr.ErrorCode
+	case errors.As(err, &dgErr) && dgErr.Response != nil:
+		status = dgErr.Response.StatusCode
+	case errors.As(err, &slackErr):
+		status = slackErr.Code
+	}
+	return status >= 400 && status < 500 &&
+		status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+func (m *Manager) retryPolicy(channel string) outbox.Policy {
+	p := m.config.Channels.Delivery.RetryFor(channel)
+	return outbox.Policy{
+		MaxAttempts: max(p.MaxAttempts, 1),
+		Backoff:     time.Duration(p.BackoffSeconds) * time.Second,
+		MaxBackoff:  time.Duration(p.MaxBackoffSeconds) * time.Second,
+	}
+}
+
 func (m *Manager) GetChannel(name string) (Channel, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -295,11 +502,13 @@ func (m *Manager) GetStatus() map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	queued := m.bus.InboundStats().Channels
 	status := make(map[string]interface{})
 	for name, channel := range m.channels {
 		status[name] = map[string]interface{}{
 			"enabled": true,
 			"running": channel.IsRunning(),
+			"queued":  queued[name],
 		}
 	}
 	return status
@@ -0,0 +1,37 @@
+package channels
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mymmrac/telego/telegoapi"
+	"github.com/slack-go/slack"
+)
+
+func TestPermanentSendError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"network", errors.New("connection refused"), false},
+		{"not found", statusError(http.StatusNotFound, errors.New("no such room")), true},
+		{"wrapped forbidden", fmt.Errorf("send: %w", statusError(http.StatusForbidden, errors.New("forbidden"))), true},
+		{"rate limited", statusError(http.StatusTooManyRequests, errors.New("slow down")), false},
+		{"timeout", statusError(http.StatusRequestTimeout, errors.New("timeout")), false},
+		{"server error", statusError(http.StatusBadGateway, errors.New("bad gateway")), false},
+		{"telegram chat not found", fmt.Errorf("api: %w", &telegoapi.Error{ErrorCode: 400, Description: "chat not found"}), true},
+		{"telegram flood", fmt.Errorf("api: %w", &telegoapi.Error{ErrorCode: 429}), false},
+		{"discord missing access", &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusForbidden}}, true},
+		{"slack server error", slack.StatusCodeError{Code: http.StatusServiceUnavailable}, false},
+	}
+	for _, tt := range tests {
+		if got := permanentSendError(tt.err); got != tt.want {
+			t.Errorf("%s: permanentSendError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
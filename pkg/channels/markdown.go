@@ -0,0 +1,388 @@
+package channels
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// dialect is the markup a channel's messages are rendered in.
+type dialect int
+
+const (
+	dialectMarkdown     dialect = iota // Discord: markdown, lightly normalized
+	dialectTelegramHTML                // Telegram parse_mode HTML
+	dialectSlackMrkdwn                 // Slack mrkdwn
+	dialectPlain                       // LINE, QQ, OneBot: no markup
+)
+
+var (
+	reCodeBlock    = regexp.MustCompile("```([\\w+#.-]*)\\n?([\\s\\S]*?)```")
+	reInlineCode   = regexp.MustCompile("`([^`\\n]+)`")
+	reImage        = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+	reLink         = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	reBareURL      = regexp.MustCompile(`https?://[^\s<>()\x00]+`)
+	reHeading      = regexp.MustCompile(`(?m)^#{1,6}\s+(.+)$`)
+	reSmallHeading = regexp.MustCompile(`(?m)^#{4,6}\s+(.+)$`)
+	reQuote        = regexp.MustCompile(`(?m)^>\s?`)
+	reBullet       = regexp.MustCompile(`(?m)^(\s*)[-*+]\s+`)
+	reBold         = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	reBoldUnder    = regexp.MustCompile(`(^|\W)__(.+?)__(\W|$)`)
+	reItalic       = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*\n]*?)\*([^*\w]|$)`)
+	reItalicUnder  = regexp.MustCompile(`(^|\W)_([^_\s][^_\n]*?)_(\W|$)`)
+	reStrike       = regexp.MustCompile(`~~(.+?)~~`)
+)
+
+// renderMarkdown converts the agent's markdown into d. Code, links and URLs
+// are set aside first so emphasis rules never touch them.
+func renderMarkdown(text string, d dialect) string {
+	if text == "" {
+		return ""
+	}
+
+	var protected []string
+	protect := func(s string) string {
+		protected = append(protected, s)
+		return fmt.Sprintf("\x00%d\x00", len(protected)-1)
+	}
+
+	text = reCodeBlock.ReplaceAllStringFunc(text, func(m string) string {
+		match := reCodeBlock.FindStringSubmatch(m)
+		return protect(renderCodeBlock(match[1], match[2], d))
+	})
+	text = reInlineCode.ReplaceAllStringFunc(text, func(m string) string {
+		code := reInlineCode.FindStringSubmatch(m)[1]
+		switch d {
+		case dialectTelegramHTML:
+			return protect("<code>" + escapeHTML(code) + "</code>")
+		case dialectSlackMrkdwn:
+			return protect("`" + escapeSlack(code) + "`")
+		case dialectPlain:
+			return protect(code)
+		}
+		return protect(m)
+	})
+	text = reImage.ReplaceAllStringFunc(text, func(m string) string {
+		match := reImage.FindStringSubmatch(m)
+		return protect(renderLink(match[1], match[2], d))
+	})
+	text = reLink.ReplaceAllStringFunc(text, func(m string) string {
+		match := reLink.FindStringSubmatch(m)
+		return protect(renderLink(match[1], match[2], d))
+	})
+	text = reBareURL.ReplaceAllStringFunc(text, func(m string) string {
+		switch d {
+		case dialectTelegramHTML:
+			return protect(escapeHTML(m))
+		case dialectSlackMrkdwn:
+			return protect(escapeSlack(m))
+		}
+		return protect(m)
+	})
+
+	switch d {
+	case dialectTelegramHTML:
+		text = reQuote.ReplaceAllString(text, "")
+		text = escapeHTML(text)
+		text = reHeading.ReplaceAllString(text, "<b>$1</b>")
+		text = reBold.ReplaceAllString(text, "<b>$1</b>")
+		text = replaceEmphasis(reBoldUnder, text, "<b>", "</b>")
+		text = replaceEmphasis(reItalic, text, "<i>", "</i>")
+		text = replaceEmphasis(reItalicUnder, text, "<i>", "</i>")
+		text = reStrike.ReplaceAllString(text, "<s>$1</s>")
+		text = reBullet.ReplaceAllString(text, "$1• ")
+	case dialectSlackMrkdwn:
+		// Bold becomes single asterisks, so it is marked with \x01 until
+		// italics have been converted. Quote markers must stay unescaped.
+		text = reQuote.ReplaceAllString(text, "\x02")
+		text = escapeSlack(text)
+		text = strings.ReplaceAll(text, "\x02", "> ")
+		text = reHeading.ReplaceAllString(text, "\x01$1\x01")
+		text = reBold.ReplaceAllString(text, "\x01$1\x01")
+		text = replaceEmphasis(reBoldUnder, text, "\x01", "\x01")
+		text = reBullet.ReplaceAllString(text, "$1• ")
+		text = replaceEmphasis(reItalic, text, "_", "_")
+		text = reStrike.ReplaceAllString(text, "~$1~")
+		text = strings.ReplaceAll(text, "\x01", "*")
+	case dialectPlain:
+		text = reHeading.ReplaceAllString(text, "$1")
+		text = reQuote.ReplaceAllString(text, "")
+		text = reBold.ReplaceAllString(text, "$1")
+		text = replaceEmphasis(reBoldUnder, text, "", "")
+		text = reBullet.ReplaceAllString(text, "$1• ")
+		text = replaceEmphasis(reItalic, text, "", "")
+		text = replaceEmphasis(reItalicUnder, text, "", "")
+		text = reStrike.ReplaceAllString(text, "$1")
+	default:
+		// Discord has no headings below ###
+		text = reSmallHeading.ReplaceAllString(text, "**$1**")
+	}
+
+	for i := len(protected) - 1; i >= 0; i-- {
+		text = strings.ReplaceAll(text, fmt.Sprintf("\x00%d\x00", i), protected[i])
+	}
+	return text
+}
+
+// replaceEmphasis wraps the second capture group of re in open/close,
+// keeping the boundary characters in groups one and three. It runs twice
+// because adjacent matches share a boundary character.
+func replaceEmphasis(re *regexp.Regexp, text, open, close string) string {
+	for i := 0; i < 2; i++ {
+		text = re.ReplaceAllString(text, "${1}"+open+"${2}"+close+"${3}")
+	}
+	return text
+}
+
+func renderCodeBlock(lang, code string, d dialect) string {
+	switch d {
+	case dialectTelegramHTML:
+		if lang != "" {
+			return fmt.Sprintf(`<pre><code class="language-%s">%s</code></pre>`, escapeHTML(lang), escapeHTML(code))
+		}
+		return "<pre><code>" + escapeHTML(code) + "</code></pre>"
+	case dialectSlackMrkdwn:
+		// Slack ignores language hints
+		return "```\n" + escapeSlack(code) + "```"
+	case dialectPlain:
+		return strings.TrimSuffix(code, "\n")
+	}
+	return "```" + lang + "\n" + code + "```"
+}
+
+func renderLink(label, url string, d dialect) string {
+	switch d {
+	case dialectTelegramHTML:
+		if !safeLinkURL(url) {
+			// Shown, never followed: the text may come from a prompt injection
+			return escapeHTML(renderLink(label, url, dialectPlain))
+		}
+		if label == "" {
+			label = url
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, escapeHTML(url), escapeHTML(label))
+	case dialectSlackMrkdwn:
+		if label == "" {
+			return "<" + url + ">"
+		}
+		return "<" + url + "|" + escapeSlack(label) + ">"
+	case dialectPlain:
+		if label == "" || label == url {
+			return url
+		}
+		return label + " (" + url + ")"
+	}
+	if label == "" {
+		return url
+	}
+	return "[" + label + "](" + url + ")"
+}
+
+// safeLinkURL reports whether url may become an HTML link: only web and
+// mail links, so a javascript: or data: URL never turns into a live href.
+func safeLinkURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// escapeHTML escapes text for HTML content and quoted attribute values.
+func escapeHTML(text string) string {
+	text = strings.ReplaceAll(text, "&", "&amp;")
+	text = strings.ReplaceAll(text, "<", "&lt;")
+	text = strings.ReplaceAll(text, ">", "&gt;")
+	text = strings.ReplaceAll(text, `"`, "&quot;")
+	text = strings.ReplaceAll(text, "'", "&#39;")
+	return text
+}
+
+// escapeSlack escapes the three characters Slack mrkdwn reserves; other
+// entities would show up literally.
+func escapeSlack(text string) string {
+	text = strings.ReplaceAll(text, "&", "&amp;")
+	text = strings.ReplaceAll(text, "<", "&lt;")
+	text = strings.ReplaceAll(text, ">", "&gt;")
+	return text
+}
+
+// markdownHTML renders markdown as an HTML fragment for Matrix and the web
+// chat. It reuses the Telegram HTML dialect, a subset both render, and
+// turns newlines outside code blocks into <br>.
+func markdownHTML(text string) string {
+	html := renderMarkdown(text, dialectTelegramHTML)
+	var b strings.Builder
+	for {
+		start := strings.Index(html, "<pre>")
+		if start < 0 {
+			b.WriteString(strings.ReplaceAll(html, "\n", "<br>"))
+			return b.String()
+		}
+		end := strings.Index(html[start:], "</pre>")
+		if end < 0 {
+			end = len(html) - start
+		} else {
+			end += len("</pre>")
+		}
+		b.WriteString(strings.ReplaceAll(html[:start], "\n", "<br>"))
+		b.WriteString(html[start : start+end])
+		html = html[start+end:]
+	}
+}
+
+// minRenderLimit stops renderMessage from splitting ever finer when markup
+// alone keeps a piece over the limit.
+const minRenderLimit = 64
+
+// renderMessage renders markdown in d and splits it into messages of at
+// most limit characters. Splits fall on paragraph, line or word breaks
+// outside code blocks; a code block longer than the limit is split on
+// lines and its fence closed and reopened.
+func renderMessage(text string, d dialect, limit int) []string {
+	return renderPieces(text, d, limit, limit)
+}
+
+func renderPieces(text string, d dialect, sourceLimit, limit int) []string {
+	var out []string
+	for _, piece := range splitMarkdown(text, sourceLimit) {
+		rendered := renderMarkdown(piece, d)
+		if utf8.RuneCountInString(rendered) > limit && sourceLimit > minRenderLimit {
+			// Markup pushed it over; split the source finer
+			out = append(out, renderPieces(piece, d, sourceLimit*3/4, limit)...)
+			continue
+		}
+		out = append(out, rendered)
+	}
+	return out
+}
+
+const codeFence = "```"
+
+// splitMarkdown cuts text into pieces of at most limit runes without
+// breaking code blocks, unless a block alone is over the limit.
+func splitMarkdown(text string, limit int) []string {
+	var pieces []string
+	for utf8.RuneCountInString(text) > limit {
+		cut, fence := markdownBreak(text, limit)
+		head := strings.TrimRight(text[:cut], " \n")
+		rest := strings.TrimLeft(text[cut:], "\n")
+		if fence != "" {
+			head += "\n" + codeFence
+			rest = fence + "\n" + rest
+		}
+		if strings.TrimSpace(head) != "" {
+			pieces = append(pieces, head)
+		}
+		text = rest
+	}
+	if strings.TrimSpace(text) != "" {
+		pieces = append(pieces, text)
+	}
+	return pieces
+}
+
+// markdownBreak picks where to cut text so the head fits in limit runes.
+// It returns the byte offset and, when the cut falls inside a code block,
+// that block's opening fence line.
+func markdownBreak(text string, limit int) (int, string) {
+	// Leave room for a closing fence, and for the reopened fence in the
+	// next piece
+	reserve := len(codeFence) + 1
+	end := len(text)
+	if n := limit - reserve; n > 0 {
+		end = runeOffset(text, n)
+	}
+
+	var paragraph, line, space, codeLine int
+	var codeLineFence, fence string
+	prevBlank := false
+	for pos := 0; pos < end; {
+		if pos > 0 {
+			if fence == "" {
+				if prevBlank {
+					paragraph = pos
+				}
+				line = pos
+			} else {
+				codeLine = pos
+				codeLineFence = fence
+			}
+		}
+
+		lineEnd := len(text)
+		if nl := strings.IndexByte(text[pos:], '\n'); nl >= 0 {
+			lineEnd = pos + nl
+		}
+		current := text[pos:lineEnd]
+		trimmed := strings.TrimSpace(current)
+		if strings.HasPrefix(trimmed, codeFence) {
+			if fence == "" {
+				fence = trimmed
+			} else {
+				fence = ""
+			}
+		} else if fence == "" {
+			if idx := strings.LastIndexByte(text[pos:min(lineEnd, end)], ' '); idx > 0 {
+				space = pos + idx
+			}
+		}
+		prevBlank = trimmed == ""
+		pos = lineEnd + 1
+	}
+
+	half := end / 2
+	switch {
+	case paragraph >= half:
+		return paragraph, ""
+	case line >= half:
+		return line, ""
+	case space >= half:
+		return space, ""
+	case codeLine >= half:
+		return codeLine, codeLineFence
+	case paragraph > 0 || line > 0:
+		return max(paragraph, line), ""
+	case codeLine > 0:
+		return codeLine, codeLineFence
+	case space > 0:
+		return space, ""
+	}
+	// No break point at all: cut mid-line, closing any open block
+	return end, fenceOpenAt(text, end)
+}
+
+// fenceOpenAt returns the fence line of the code block open at offset, if
+// any.
+func fenceOpenAt(text string, offset int) string {
+	fence := ""
+	for _, line := range strings.Split(text[:offset], "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, codeFence) {
+			continue
+		}
+		if fence == "" {
+			fence = trimmed
+		} else {
+			fence = ""
+		}
+	}
+	return fence
+}
+
+// runeOffset returns the byte offset of the n-th rune in s.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
@@ -0,0 +1,147 @@
+package channels
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	src := "# Title\n**bold** and *italic* and ~~gone~~ in `a<b>`\n- see [docs](https://example.com/a_b_c)\n```go\nif a < b {}\n```"
+	tests := []struct {
+		name string
+		d    dialect
+		want string
+	}{
+		{
+			name: "telegram",
+			d:    dialectTelegramHTML,
+			want: "<b>Title</b>\n<b>bold</b> and <i>italic</i> and <s>gone</s> in <code>a&lt;b&gt;</code>\n• see <a href=\"https://example.com/a_b_c\">docs</a>\n<pre><code class=\"language-go\">if a &lt; b {}\n</code></pre>",
+		},
+		{
+			name: "slack",
+			d:    dialectSlackMrkdwn,
+			want: "*Title*\n*bold* and _italic_ and ~gone~ in `a&lt;b&gt;`\n• see <https://example.com/a_b_c|docs>\n```\nif a &lt; b {}\n```",
+		},
+		{
+			name: "plain",
+			d:    dialectPlain,
+			want: "Title\nbold and italic and gone in a<b>\n• see docs (https://example.com/a_b_c)\nif a < b {}",
+		},
+		{
+			name: "discord",
+			d:    dialectMarkdown,
+			want: src,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(src, tt.d); got != tt.want {
+				t.Errorf("renderMarkdown() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdown_LeavesURLsAndIdentifiersAlone(t *testing.T) {
+	src := "See https://example.com/some_path_here and snake_case_name"
+	if got := renderMarkdown(src, dialectPlain); got != src {
+		t.Errorf("plain = %q", got)
+	}
+	if got := renderMarkdown(src, dialectTelegramHTML); got != src {
+		t.Errorf("telegram = %q", got)
+	}
+}
+
+func TestSplitMarkdown_KeepsCodeBlocksWhole(t *testing.T) {
+	para := strings.Repeat("word ", 30)                      // 150 chars
+	code := "```\n" + strings.Repeat("x := 1\n", 20) + "```" // ~150 chars
+	text := para + "\n\n" + code + "\n\n" + para
+
+	pieces := splitMarkdown(text, 200)
+	if len(pieces) != 3 {
+		t.Fatalf("len(pieces) = %d, want 3: %q", len(pieces), pieces)
+	}
+	if pieces[1] != code {
+		t.Errorf("code block was split: %q", pieces[1])
+	}
+	for _, p := range pieces {
+		if n := utf8.RuneCountInString(p); n > 200 {
+			t.Errorf("piece has %d runes, limit 200", n)
+		}
+	}
+}
+
+func TestSplitMarkdown_ReopensOversizedCodeBlock(t *testing.T) {
+	text := "```python\n" + strings.Repeat("print('hello')\n", 30) + "```"
+
+	pieces := splitMarkdown(text, 150)
+	if len(pieces) < 2 {
+		t.Fatalf("len(pieces) = %d, want the block split", len(pieces))
+	}
+	for i, p := range pieces {
+		if !strings.HasPrefix(p, "```python\n") || !strings.HasSuffix(p, "```") {
+			t.Errorf("piece %d is not a closed python block: %q", i, p)
+		}
+		if n := utf8.RuneCountInString(p); n > 150 {
+			t.Errorf("piece %d has %d runes, limit 150", i, n)
+		}
+	}
+}
+
+func TestRenderMessage_FitsRenderedLimit(t *testing.T) {
+	// Escaping grows each "<" to "&lt;", so the source must be split finer
+	text := strings.Repeat("a < b ", 100)
+	for _, p := range renderMessage(text, dialectTelegramHTML, 200) {
+		if n := utf8.RuneCountInString(p); n > 200 {
+			t.Errorf("rendered piece has %d runes, limit 200", n)
+		}
+	}
+	if got := renderMessage("", dialectPlain, 100); len(got) != 0 {
+		t.Errorf("renderMessage(empty) = %q", got)
+	}
+}
+
+func TestMarkdownHTML_KeepsCodeBlockNewlines(t *testing.T) {
+	got := markdownHTML("run:\n```\na\nb\n```")
+	want := "run:<br><pre><code>a\nb\n</code></pre>"
+	if got != want {
+		t.Errorf("markdownHTML = %q, want %q", got, want)
+	}
+}
+
+func TestMarkdownHTML_OnlyLinksWebAndMail(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "javascript",
+			src:  "[click](javascript:alert(document.cookie))",
+			want: "click (javascript:alert(document.cookie))",
+		},
+		{
+			name: "mixed case scheme",
+			src:  "[click](JaVaScRiPt:alert(1))",
+			want: "click (JaVaScRiPt:alert(1))",
+		},
+		{
+			name: "quote breakout",
+			src:  `[x](https://example.com/"onmouseover="alert(1))`,
+			want: `<a href="https://example.com/&quot;onmouseover=&quot;alert(1">x</a>)`,
+		},
+		{
+			name: "mailto",
+			src:  "[mail](mailto:ana@example.com)",
+			want: `<a href="mailto:ana@example.com">mail</a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markdownHTML(tt.src); got != tt.want {
+				t.Errorf("markdownHTML(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,774 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	matrixSyncTimeout = 30 * time.Second
+	matrixRetryDelay  = 5 * time.Second
+	matrixMaxLength   = 16000
+
+	// matrixSyncFilter keeps sync responses to what the channel reads:
+	// room timelines and state, with member lists loaded lazily.
+	matrixSyncFilter = `{"presence":{"not_types":["*"]},"account_data":{"not_types":["*"]},` +
+		`"room":{"ephemeral":{"not_types":["*"]},"account_data":{"not_types":["*"]},` +
+		`"state":{"lazy_load_members":true},"timeline":{"limit":50,"lazy_load_members":true}}}`
+)
+
+// MatrixChannel implements the Channel interface for Matrix homeservers
+// (Synapse, Conduit, Dendrite) over the client-server API. It long-polls
+// /sync for messages and persists the sync token so a restart picks up
+// where it left off.
+//
+// End-to-end encryption is not supported: the bot works in unencrypted
+// rooms and ignores encrypted ones, warning once per room.
+type MatrixChannel struct {
+	*BaseChannel
+	config      config.MatrixConfig
+	homeserver  string
+	client      *http.Client
+	userID      string
+	displayName string
+	workspace   string
+	stateFile   string
+	sent        sentMessages
+	members     sync.Map // room ID -> joined member count
+	encrypted   sync.Map // room ID -> struct{}, encrypted rooms already warned about
+	txnPrefix   string
+	txn         atomic.Int64
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+type matrixEvent struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key,omitempty"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type matrixJoinedRoom struct {
+	Summary struct {
+		JoinedMemberCount *int `json:"m.joined_member_count"`
+	} `json:"summary"`
+	State struct {
+		Events []matrixEvent `json:"events"`
+	} `json:"state"`
+	Timeline struct {
+		Events []matrixEvent `json:"events"`
+	} `json:"timeline"`
+}
+
+type matrixInvitedRoom struct {
+	InviteState struct {
+		Events []matrixEvent `json:"events"`
+	} `json:"invite_state"`
+}
+
+type matrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join   map[string]matrixJoinedRoom  `json:"join"`
+		Invite map[string]matrixInvitedRoom `json:"invite"`
+	} `json:"rooms"`
+}
+
+type matrixMessageContent struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+	URL           string `json:"url,omitempty"`
+	FileName      string `json:"filename,omitempty"`
+	Info          struct {
+		MIMEType string `json:"mimetype"`
+	} `json:"info"`
+	Mentions *struct {
+		UserIDs []string `json:"user_ids"`
+		Room    bool     `json:"room"`
+	} `json:"m.mentions,omitempty"`
+	RelatesTo *struct {
+		RelType   string `json:"rel_type"`
+		EventID   string `json:"event_id"`
+		InReplyTo *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to,omitempty"`
+	} `json:"m.relates_to,omitempty"`
+}
+
+// matrixSyncState is what the channel keeps on disk between restarts.
+type matrixSyncState struct {
+	UserID    string `json:"user_id"`
+	NextBatch string `json:"next_batch"`
+}
+
+// NewMatrixChannel creates a new Matrix channel instance.
+func NewMatrixChannel(cfg config.MatrixConfig, messageBus *bus.MessageBus, workspace string) (*MatrixChannel, error) {
+	if cfg.Homeserver == "" || cfg.AccessToken == "" {
+		return nil, fmt.Errorf("matrix homeserver and access_token are required")
+	}
+
+	base := NewBaseChannel("matrix", cfg, messageBus, cfg.AllowFrom)
+
+	stateFile := ""
+	if workspace != "" {
+		stateFile = filepath.Join(workspace, "matrix", "sync.json")
+	}
+
+	return &MatrixChannel{
+		BaseChannel: base,
+		config:      cfg,
+		homeserver:  strings.TrimRight(cfg.Homeserver, "/"),
+		client:      &http.Client{Timeout: matrixSyncTimeout + 30*time.Second},
+		userID:      cfg.UserID,
+		workspace:   workspace,
+		stateFile:   stateFile,
+		txnPrefix:   strconv.FormatInt(time.Now().UnixNano(), 36),
+	}, nil
+}
+
+// Start checks the access token and launches the sync loop.
+func (c *MatrixChannel) Start(ctx context.Context) error {
+	logger.InfoC("matrix", "Starting Matrix channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.call(c.ctx, http.MethodGet, "/_matrix/client/v3/account/whoami", nil, nil, &whoami); err != nil {
+		return fmt.Errorf("failed to verify matrix access token: %w", err)
+	}
+	if c.userID != "" && c.userID != whoami.UserID {
+		logger.WarnCF("matrix", "Access token belongs to a different user than user_id", map[string]interface{}{
+			"user_id": c.userID,
+			"token":   whoami.UserID,
+		})
+	}
+	c.userID = whoami.UserID
+
+	// The display name is only used for mention detection in plain bodies
+	var profile struct {
+		DisplayName string `json:"displayname"`
+	}
+	if err := c.call(c.ctx, http.MethodGet, "/_matrix/client/v3/profile/"+url.PathEscape(c.userID), nil, nil, &profile); err == nil {
+		c.displayName = profile.DisplayName
+	}
+
+	since := c.loadSyncToken()
+	logger.InfoCF("matrix", "Matrix account verified", map[string]interface{}{
+		"user_id":      c.userID,
+		"display_name": c.displayName,
+		"resuming":     since != "",
+	})
+
+	go c.syncLoop(since)
+
+	c.setRunning(true)
+	logger.InfoC("matrix", "Matrix channel started")
+	return nil
+}
+
+// Stop ends the sync loop.
+func (c *MatrixChannel) Stop(ctx context.Context) error {
+	logger.InfoC("matrix", "Stopping Matrix channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.setRunning(false)
+	logger.InfoC("matrix", "Matrix channel stopped")
+	return nil
+}
+
+func (c *MatrixChannel) syncLoop(since string) {
+	for c.ctx.Err() == nil {
+		query := url.Values{
+			"timeout": {strconv.FormatInt(matrixSyncTimeout.Milliseconds(), 10)},
+			"filter":  {matrixSyncFilter},
+		}
+		if since != "" {
+			query.Set("since", since)
+		}
+
+		var resp matrixSyncResponse
+		if err := c.call(c.ctx, http.MethodGet, "/_matrix/client/v3/sync", query, nil, &resp); err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			logger.ErrorCF("matrix", "Sync failed, retrying", map[string]interface{}{
+				"error": err.Error(),
+			})
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(matrixRetryDelay):
+			}
+			continue
+		}
+
+		// Without a token the first sync returns recent history, which
+		// has already been answered (or never was meant for the bot)
+		c.processSync(&resp, since != "")
+		since = resp.NextBatch
+		c.saveSyncToken(since)
+	}
+}
+
+func (c *MatrixChannel) processSync(resp *matrixSyncResponse, deliver bool) {
+	for roomID, room := range resp.Rooms.Invite {
+		c.handleInvite(roomID, room)
+	}
+
+	for roomID, room := range resp.Rooms.Join {
+		if room.Summary.JoinedMemberCount != nil {
+			c.members.Store(roomID, *room.Summary.JoinedMemberCount)
+		}
+		for _, ev := range room.State.Events {
+			c.trackState(roomID, ev)
+		}
+		for _, ev := range room.Timeline.Events {
+			if ev.StateKey != nil {
+				c.trackState(roomID, ev)
+				continue
+			}
+			if deliver {
+				c.handleEvent(roomID, ev)
+			}
+		}
+	}
+}
+
+// trackState notes room encryption and invalidates the cached member
+// count when membership changes.
+func (c *MatrixChannel) trackState(roomID string, ev matrixEvent) {
+	switch ev.Type {
+	case "m.room.encryption":
+		c.warnEncrypted(roomID)
+	case "m.room.member":
+		c.members.Delete(roomID)
+	}
+}
+
+func (c *MatrixChannel) warnEncrypted(roomID string) {
+	if _, warned := c.encrypted.LoadOrStore(roomID, struct{}{}); !warned {
+		logger.WarnCF("matrix", "Room is encrypted, its messages can't be read", map[string]interface{}{
+			"room_id": roomID,
+		})
+	}
+}
+
+func (c *MatrixChannel) handleInvite(roomID string, room matrixInvitedRoom) {
+	inviter := ""
+	for _, ev := range room.InviteState.Events {
+		if ev.Type == "m.room.member" && ev.StateKey != nil && *ev.StateKey == c.userID {
+			inviter = ev.Sender
+		}
+	}
+
+	if !c.config.AutoJoin || !c.IsAllowed(inviter) {
+		logger.InfoCF("matrix", "Ignoring room invite", map[string]interface{}{
+			"room_id": roomID,
+			"inviter": inviter,
+		})
+		return
+	}
+
+	if err := c.call(c.ctx, http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(roomID), nil, struct{}{}, nil); err != nil {
+		logger.ErrorCF("matrix", "Failed to join room", map[string]interface{}{
+			"room_id": roomID,
+			"error":   err.Error(),
+		})
+		return
+	}
+	logger.InfoCF("matrix", "Joined room", map[string]interface{}{
+		"room_id": roomID,
+		"inviter": inviter,
+	})
+}
+
+func (c *MatrixChannel) handleEvent(roomID string, ev matrixEvent) {
+	if ev.Sender == c.userID {
+		return
+	}
+
+	if ev.Type == "m.room.encrypted" {
+		c.warnEncrypted(roomID)
+		return
+	}
+	if ev.Type != "m.room.message" {
+		return
+	}
+
+	var msg matrixMessageContent
+	if err := json.Unmarshal(ev.Content, &msg); err != nil {
+		logger.ErrorCF("matrix", "Failed to parse message", map[string]interface{}{
+			"event_id": ev.EventID,
+			"error":    err.Error(),
+		})
+		return
+	}
+	// Edits repeat the message; notices are other bots talking
+	if msg.MsgType == "m.notice" || (msg.RelatesTo != nil && msg.RelatesTo.RelType == "m.replace") {
+		return
+	}
+
+	isDM := c.isDirect(roomID)
+	if !isDM && c.config.RequireMention && !c.isMentioned(msg) {
+		logger.DebugCF("matrix", "Ignoring group message without mention", map[string]interface{}{
+			"room_id": roomID,
+		})
+		return
+	}
+
+	var content string
+	var mediaPaths []string
+
+	switch msg.MsgType {
+	case "m.text", "m.emote":
+		content = msg.Body
+		if msg.RelatesTo != nil && msg.RelatesTo.InReplyTo != nil {
+			content = stripMatrixReplyFallback(content)
+		}
+		if !isDM {
+			content = c.stripBotMention(content)
+		}
+	case "m.image", "m.audio", "m.video", "m.file":
+		name := msg.FileName
+		caption := ""
+		if name == "" {
+			name = msg.Body
+		} else if msg.Body != name {
+			caption = msg.Body
+		}
+		kind := strings.TrimPrefix(msg.MsgType, "m.")
+		if localPath := c.downloadMedia(msg.URL, name); localPath != "" {
+			mediaPaths = append(mediaPaths, localPath)
+		}
+		if kind == "image" {
+			content = "[image]"
+		} else {
+			content = fmt.Sprintf("[%s: %s]", kind, name)
+		}
+		if caption != "" {
+			content += "\n" + caption
+		}
+	default:
+		content = fmt.Sprintf("[%s]", strings.TrimPrefix(msg.MsgType, "m."))
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":   "matrix",
+		"message_id": ev.EventID,
+		"room_id":    roomID,
+		"is_dm":      strconv.FormatBool(isDM),
+	}
+
+	logger.DebugCF("matrix", "Received message", map[string]interface{}{
+		"sender_id": ev.Sender,
+		"room_id":   roomID,
+		"msgtype":   msg.MsgType,
+		"is_dm":     isDM,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(ev.Sender, roomID, content, mediaPaths, metadata)
+}
+
+// isDirect reports whether the room is a one-to-one chat with the bot.
+// The member count comes from sync summaries, or is fetched when a
+// membership change has invalidated it.
+func (c *MatrixChannel) isDirect(roomID string) bool {
+	if n, ok := c.members.Load(roomID); ok {
+		return n.(int) <= 2
+	}
+
+	var resp struct {
+		Joined map[string]json.RawMessage `json:"joined"`
+	}
+	if err := c.call(c.ctx, http.MethodGet, "/_matrix/client/v3/rooms/"+url.PathEscape(roomID)+"/joined_members", nil, nil, &resp); err != nil {
+		logger.WarnCF("matrix", "Failed to get room members, treating as group", map[string]interface{}{
+			"room_id": roomID,
+			"error":   err.Error(),
+		})
+		return false
+	}
+	c.members.Store(roomID, len(resp.Joined))
+	return len(resp.Joined) <= 2
+}
+
+// isMentioned checks the message's m.mentions, then pills in the HTML
+// body, then the bot's user ID or display name in the plain body.
+func (c *MatrixChannel) isMentioned(msg matrixMessageContent) bool {
+	if msg.Mentions != nil && (msg.Mentions.Room || slices.Contains(msg.Mentions.UserIDs, c.userID)) {
+		return true
+	}
+	if msg.FormattedBody != "" &&
+		(strings.Contains(msg.FormattedBody, "matrix.to/#/"+c.userID) ||
+			strings.Contains(msg.FormattedBody, "matrix.to/#/"+url.PathEscape(c.userID))) {
+		return true
+	}
+	if strings.Contains(msg.Body, c.userID) {
+		return true
+	}
+	if c.displayName == "" {
+		return false
+	}
+	body := strings.ToLower(msg.Body)
+	name := strings.ToLower(c.displayName)
+	return strings.Contains(body, "@"+name) || strings.HasPrefix(body, name+":") || strings.HasPrefix(body, name+",")
+}
+
+// stripBotMention removes the bot's user ID or display name from text.
+func (c *MatrixChannel) stripBotMention(text string) string {
+	text = strings.ReplaceAll(text, c.userID, "")
+	if c.displayName != "" {
+		if re, err := regexp.Compile(`(?i)^\s*@?` + regexp.QuoteMeta(c.displayName) + `[:,]?`); err == nil {
+			text = re.ReplaceAllString(text, "")
+		}
+		text = strings.ReplaceAll(text, "@"+c.displayName, "")
+	}
+	return strings.TrimSpace(text)
+}
+
+// stripMatrixReplyFallback drops the quoted "> " lines clients put at the
+// start of a reply's body.
+func stripMatrixReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+}
+
+// downloadMedia fetches an mxc:// URI, trying the authenticated media
+// endpoint first and the legacy one for older homeservers.
+func (c *MatrixChannel) downloadMedia(mxc, filename string) string {
+	serverAndID, ok := strings.CutPrefix(mxc, "mxc://")
+	if !ok || !strings.Contains(serverAndID, "/") {
+		return ""
+	}
+	if filename == "" {
+		filename = "file"
+	}
+
+	opts := utils.DownloadOptions{
+		LoggerPrefix: "matrix",
+		ExtraHeaders: map[string]string{
+			"Authorization": "Bearer " + c.config.AccessToken,
+		},
+		TempDir: c.workspace,
+	}
+	for _, endpoint := range []string{"/_matrix/client/v1/media/download/", "/_matrix/media/v3/download/"} {
+		if path := utils.DownloadFile(c.homeserver+endpoint+serverAndID, filename, opts); path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// Send sends a message to a Matrix room. Text goes out with an HTML
+// formatted body; buttons are listed as text since Matrix has none.
+func (c *MatrixChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("matrix channel not running")
+	}
+
+	roomID := msg.ChatID
+	if roomID == "" {
+		return fmt.Errorf("invalid matrix room ID: %s", msg.ChatID)
+	}
+
+	if len(msg.Reactions) > 0 && msg.ReplyTo != "" {
+		c.react(ctx, roomID, msg)
+	}
+
+	if msg.Content != "" || len(msg.Buttons) > 0 || (len(msg.Attachments) == 0 && len(msg.Reactions) == 0) {
+		if err := c.sendText(ctx, roomID, msg); err != nil {
+			return err
+		}
+	}
+
+	parts := outbox.PartsFrom(ctx)
+	for _, a := range msg.Attachments {
+		if parts.Skip() {
+			continue
+		}
+		if err := c.uploadAttachment(ctx, roomID, a); err != nil {
+			logger.ErrorCF("matrix", "Failed to upload attachment, sending as text", map[string]interface{}{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			text := attachmentText(a)
+			if _, err := c.sendEvent(ctx, roomID, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": text}); err != nil {
+				return err
+			}
+		}
+		parts.Done()
+	}
+
+	logger.DebugCF("matrix", "Message sent", map[string]interface{}{
+		"room_id": roomID,
+	})
+
+	return nil
+}
+
+// sendText sends (or edits) the message text in pieces under the size
+// limit. The first piece carries the reply relation.
+func (c *MatrixChannel) sendText(ctx context.Context, roomID string, msg bus.OutboundMessage) error {
+	chunks := splitMarkdown(withButtonText(msg.Content, msg.Buttons), matrixMaxLength)
+	if len(chunks) == 0 {
+		chunks = []string{msg.Content}
+	}
+
+	target := c.sent.editTarget(msg)
+	parts := outbox.PartsFrom(ctx)
+	for i, chunk := range chunks {
+		if parts.Skip() {
+			continue
+		}
+		content := matrixTextContent(chunk)
+
+		if i == 0 && target != "" {
+			edit := matrixTextContent("* " + chunk)
+			edit["m.new_content"] = content
+			edit["m.relates_to"] = map[string]string{"rel_type": "m.replace", "event_id": target}
+			if _, err := c.sendEvent(ctx, roomID, "m.room.message", edit); err == nil {
+				c.sent.remember(roomID, target)
+				parts.Done()
+				continue
+			}
+			// Fallback to new message if edit fails
+		}
+
+		if i == 0 && msg.ReplyTo != "" {
+			content["m.relates_to"] = map[string]interface{}{
+				"m.in_reply_to": map[string]string{"event_id": msg.ReplyTo},
+			}
+		}
+		eventID, err := c.sendEvent(ctx, roomID, "m.room.message", content)
+		if err != nil {
+			return err
+		}
+		c.sent.remember(roomID, eventID)
+		parts.Done()
+	}
+	return nil
+}
+
+// matrixTextContent builds an m.text event with the markdown source as
+// the plain body and its HTML rendering as the formatted body.
+func matrixTextContent(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"msgtype":        "m.text",
+		"body":           text,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": markdownHTML(text),
+	}
+}
+
+func (c *MatrixChannel) react(ctx context.Context, roomID string, msg bus.OutboundMessage) {
+	for _, emoji := range msg.Reactions {
+		content := map[string]interface{}{
+			"m.relates_to": map[string]string{
+				"rel_type": "m.annotation",
+				"event_id": msg.ReplyTo,
+				"key":      emoji,
+			},
+		}
+		if _, err := c.sendEvent(ctx, roomID, "m.reaction", content); err != nil {
+			logger.WarnCF("matrix", "Failed to add reaction", map[string]interface{}{
+				"room_id": roomID,
+				"emoji":   emoji,
+				"error":   err.Error(),
+			})
+		}
+	}
+}
+
+// uploadAttachment uploads the file to the media repository and posts it
+// as an image, audio, video or file message.
+func (c *MatrixChannel) uploadAttachment(ctx context.Context, roomID string, a bus.Attachment) error {
+	data, err := readAttachment(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"filename": {a.FileName()}}
+	endpoint := c.homeserver + "/_matrix/media/v3/upload?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", a.MIME())
+	var upload struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := c.do(req, &upload); err != nil {
+		return err
+	}
+
+	body := a.Caption
+	if body == "" {
+		body = a.FileName()
+	}
+	content := map[string]interface{}{
+		"msgtype":  "m." + a.Kind(),
+		"body":     body,
+		"filename": a.FileName(),
+		"url":      upload.ContentURI,
+		"info": map[string]interface{}{
+			"mimetype": a.MIME(),
+			"size":     len(data),
+		},
+	}
+	eventID, err := c.sendEvent(ctx, roomID, "m.room.message", content)
+	if err != nil {
+		return err
+	}
+	c.sent.remember(roomID, eventID)
+	return nil
+}
+
+// sendEvent sends a room event and returns its event ID.
+func (c *MatrixChannel) sendEvent(ctx context.Context, roomID, eventType string, content interface{}) (string, error) {
+	txnID := fmt.Sprintf("%s.%d", c.txnPrefix, c.txn.Add(1))
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/%s/%s", url.PathEscape(roomID), eventType, txnID)
+
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	if err := c.call(ctx, http.MethodPut, path, nil, content, &resp); err != nil {
+		return "", fmt.Errorf("failed to send matrix event: %w", err)
+	}
+	return resp.EventID, nil
+}
+
+// call makes an authenticated JSON request to the client-server API.
+func (c *MatrixChannel) call(ctx context.Context, method, path string, query url.Values, payload, out interface{}) error {
+	endpoint := c.homeserver + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+func (c *MatrixChannel) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+c.config.AccessToken)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.ErrCode != "" {
+			return statusError(resp.StatusCode, fmt.Errorf("matrix API error (status %d): %s: %s", resp.StatusCode, apiErr.ErrCode, apiErr.Error))
+		}
+		return statusError(resp.StatusCode, fmt.Errorf("matrix API error (status %d): %s", resp.StatusCode, string(respBody)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// loadSyncToken returns the saved sync token, or "" when there is none or
+// it belongs to another account.
+func (c *MatrixChannel) loadSyncToken() string {
+	if c.stateFile == "" {
+		return ""
+	}
+	data, err := os.ReadFile(c.stateFile)
+	if err != nil {
+		return ""
+	}
+	var state matrixSyncState
+	if err := json.Unmarshal(data, &state); err != nil || state.UserID != c.userID {
+		return ""
+	}
+	return state.NextBatch
+}
+
+// saveSyncToken writes the token atomically, so a crash never leaves a
+// truncated file behind.
+func (c *MatrixChannel) saveSyncToken(token string) {
+	if c.stateFile == "" || token == "" {
+		return
+	}
+	data, err := json.Marshal(matrixSyncState{UserID: c.userID, NextBatch: token})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.stateFile), 0700)
+	}
+	if err == nil {
+		tmp := c.stateFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, c.stateFile)
+		}
+	}
+	if err != nil {
+		logger.WarnCF("matrix", "Failed to save sync token", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
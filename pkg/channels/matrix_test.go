@@ -0,0 +1,188 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// matrixStub is a minimal homeserver: it serves canned sync batches in
+// order, then holds the long poll until the client goes away.
+type matrixStub struct {
+	mu      sync.Mutex
+	batches []string
+	sent    []map[string]interface{}
+	since   []string
+}
+
+func (s *matrixStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"bad token"}`))
+		return
+	}
+	switch {
+	case r.URL.Path == "/_matrix/client/v3/account/whoami":
+		w.Write([]byte(`{"user_id":"@pico:test"}`))
+	case strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/profile/"):
+		w.Write([]byte(`{"displayname":"Pico"}`))
+	case r.URL.Path == "/_matrix/client/v3/sync":
+		s.mu.Lock()
+		s.since = append(s.since, r.URL.Query().Get("since"))
+		if len(s.batches) == 0 {
+			s.mu.Unlock()
+			<-r.Context().Done()
+			return
+		}
+		batch := s.batches[0]
+		s.batches = s.batches[1:]
+		s.mu.Unlock()
+		w.Write([]byte(batch))
+	case strings.Contains(r.URL.Path, "/send/"):
+		var content map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&content)
+		s.mu.Lock()
+		s.sent = append(s.sent, content)
+		s.mu.Unlock()
+		w.Write([]byte(`{"event_id":"$sent"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errcode":"M_UNRECOGNIZED","error":"unknown"}`))
+	}
+}
+
+func startMatrixStub(t *testing.T, stub *matrixStub, workspace string) (*MatrixChannel, *bus.MessageBus) {
+	t.Helper()
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	mb := bus.NewMessageBus()
+	ch, err := NewMatrixChannel(config.MatrixConfig{
+		Homeserver:     srv.URL,
+		AccessToken:    "secret",
+		RequireMention: true,
+	}, mb, workspace)
+	if err != nil {
+		t.Fatalf("NewMatrixChannel error: %v", err)
+	}
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	t.Cleanup(func() { ch.Stop(context.Background()) })
+	return ch, mb
+}
+
+func TestMatrixChannel_SyncDeliversAndGates(t *testing.T) {
+	stub := &matrixStub{batches: []string{
+		// Initial sync: history is not answered
+		`{"next_batch":"s1","rooms":{"join":{"!dm:test":{"summary":{"m.joined_member_count":2},
+			"timeline":{"events":[{"type":"m.room.message","event_id":"$old","sender":"@alice:test","content":{"msgtype":"m.text","body":"old"}}]}}}}}`,
+		`{"next_batch":"s2","rooms":{"join":{
+			"!dm:test":{"timeline":{"events":[
+				{"type":"m.room.encrypted","event_id":"$enc","sender":"@alice:test","content":{}},
+				{"type":"m.room.message","event_id":"$1","sender":"@alice:test","content":{"msgtype":"m.text","body":"hello"}}]}},
+			"!grp:test":{"summary":{"m.joined_member_count":5},"timeline":{"events":[
+				{"type":"m.room.message","event_id":"$2","sender":"@bob:test","content":{"msgtype":"m.text","body":"chatting among ourselves"}},
+				{"type":"m.room.message","event_id":"$3","sender":"@bob:test","content":{"msgtype":"m.text","body":"> <@pico:test> earlier\n\nPico: what's up?",
+					"m.mentions":{"user_ids":["@pico:test"]},"m.relates_to":{"m.in_reply_to":{"event_id":"$0"}}}}]}}}}}`,
+	}}
+	workspace := t.TempDir()
+	_, mb := startMatrixStub(t, stub, workspace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Rooms in a sync batch come in no particular order
+	got := make(map[string]bus.InboundMessage)
+	for i := 0; i < 2; i++ {
+		msg, ok := mb.ConsumeInbound(ctx)
+		if !ok {
+			t.Fatalf("got %d of 2 messages", i)
+		}
+		got[msg.ChatID] = msg
+	}
+	if dm := got["!dm:test"]; dm.Content != "hello" || dm.Metadata["is_dm"] != "true" {
+		t.Errorf("DM message = %+v, want hello", dm)
+	}
+	if group := got["!grp:test"]; group.Content != "what's up?" || group.Metadata["message_id"] != "$3" {
+		t.Errorf("group message = %+v, want the mention with reply fallback stripped", group)
+	}
+
+	// The token from the last sync is saved for the next start
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(filepath.Join(workspace, "matrix", "sync.json"))
+		if strings.Contains(string(data), `"next_batch":"s2"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sync state = %s, want next_batch s2", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if depth := mb.InboundStats().Depth; depth != 0 {
+		t.Errorf("depth = %d, want only the two messages delivered", depth)
+	}
+}
+
+func TestMatrixChannel_ResumesFromSavedToken(t *testing.T) {
+	workspace := t.TempDir()
+	os.MkdirAll(filepath.Join(workspace, "matrix"), 0700)
+	os.WriteFile(filepath.Join(workspace, "matrix", "sync.json"), []byte(`{"user_id":"@pico:test","next_batch":"s9"}`), 0600)
+
+	stub := &matrixStub{batches: []string{
+		`{"next_batch":"s10","rooms":{"join":{"!dm:test":{"summary":{"m.joined_member_count":2},"timeline":{"events":[
+			{"type":"m.room.message","event_id":"$1","sender":"@alice:test","content":{"msgtype":"m.text","body":"while you were away"}}]}}}}}`,
+	}}
+	_, mb := startMatrixStub(t, stub, workspace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if msg, ok := mb.ConsumeInbound(ctx); !ok || msg.Content != "while you were away" {
+		t.Fatalf("message = %+v, want the one sent while offline", msg)
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.since[0] != "s9" {
+		t.Errorf("first sync since = %q, want s9", stub.since[0])
+	}
+}
+
+func TestMatrixChannel_SendFormatsAndReplies(t *testing.T) {
+	stub := &matrixStub{}
+	ch, _ := startMatrixStub(t, stub, "")
+
+	err := ch.Send(context.Background(), bus.OutboundMessage{
+		ChatID:  "!grp:test",
+		Content: "**done**\nsee `x`",
+		ReplyTo: "$3",
+	})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.sent) != 1 {
+		t.Fatalf("sent %d events, want 1", len(stub.sent))
+	}
+	content := stub.sent[0]
+	if content["formatted_body"] != "<b>done</b><br>see <code>x</code>" {
+		t.Errorf("formatted_body = %v", content["formatted_body"])
+	}
+	relates, _ := content["m.relates_to"].(map[string]interface{})
+	reply, _ := relates["m.in_reply_to"].(map[string]interface{})
+	if reply["event_id"] != "$3" {
+		t.Errorf("m.relates_to = %v, want reply to $3", content["m.relates_to"])
+	}
+}
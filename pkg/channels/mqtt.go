@@ -0,0 +1,297 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	mqttPublishTimeout = 10 * time.Second
+	mqttMaxReconnect   = 2 * time.Minute
+)
+
+// MQTTChannel implements the Channel interface for an MQTT broker. It
+// subscribes to the configured topics and publishes replies to the
+// response topic, so sensors, dashboards and voice satellites can talk to
+// the agent.
+type MQTTChannel struct {
+	*BaseChannel
+	config config.MQTTConfig
+	client paho.Client
+	qos    byte
+	routes sync.Map // chat ID -> mqttRoute of its latest message
+	// replyTopics holds topics replies went to, so a broad subscription
+	// doesn't feed the agent its own replies.
+	replyTopics sync.Map
+}
+
+// mqttRoute is where a chat's messages came from and where its replies go.
+type mqttRoute struct {
+	topic         string
+	responseTopic string // set by the message and allowed by response_topics
+}
+
+// mqttInbound is a JSON payload. Payloads without content are passed to
+// the agent as text. Any client may publish any payload, so SenderID is
+// only a display name and ResponseTopic is checked against the config.
+type mqttInbound struct {
+	Content       string `json:"content"`
+	SenderID      string `json:"sender_id"`
+	ChatID        string `json:"chat_id"`
+	MessageID     string `json:"message_id"`
+	ResponseTopic string `json:"response_topic"`
+}
+
+// mqttOutbound is a reply in the "json" format.
+type mqttOutbound struct {
+	ChatID      string           `json:"chat_id"`
+	Content     string           `json:"content"`
+	ReplyTo     string           `json:"reply_to,omitempty"`
+	Attachments []bus.Attachment `json:"attachments,omitempty"`
+	Buttons     [][]bus.Button   `json:"buttons,omitempty"`
+}
+
+// NewMQTTChannel creates a new MQTT channel instance.
+func NewMQTTChannel(cfg config.MQTTConfig, messageBus *bus.MessageBus) (*MQTTChannel, error) {
+	if cfg.Broker == "" {
+		return nil, fmt.Errorf("mqtt broker is required")
+	}
+	if len(cfg.Topics) == 0 {
+		return nil, fmt.Errorf("mqtt topics are required")
+	}
+	if cfg.QoS < 0 || cfg.QoS > 2 {
+		return nil, fmt.Errorf("mqtt qos must be 0, 1 or 2")
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = "text"
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("mqtt format must be text or json")
+	}
+	if cfg.ResponseTopic == "" {
+		cfg.ResponseTopic = "picoclaw/out/{chat_id}"
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "picoclaw"
+	}
+
+	base := NewBaseChannel("mqtt", cfg, messageBus, cfg.AllowFrom)
+
+	return &MQTTChannel{
+		BaseChannel: base,
+		config:      cfg,
+		qos:         byte(cfg.QoS),
+	}, nil
+}
+
+// Start connects to the broker. The client keeps retrying in the
+// background, so a broker that is down at startup is picked up later.
+func (c *MQTTChannel) Start(ctx context.Context) error {
+	logger.InfoCF("mqtt", "Starting MQTT channel", map[string]interface{}{
+		"broker": c.config.Broker,
+	})
+
+	opts := paho.NewClientOptions().
+		AddBroker(c.config.Broker).
+		SetClientID(c.config.ClientID).
+		SetUsername(c.config.Username).
+		SetPassword(c.config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(mqttMaxReconnect).
+		SetOnConnectHandler(c.subscribe).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logger.WarnCF("mqtt", "Lost connection to broker, reconnecting", map[string]interface{}{
+				"error": err.Error(),
+			})
+		})
+	c.client = paho.NewClient(opts)
+	c.client.Connect()
+
+	c.setRunning(true)
+	logger.InfoC("mqtt", "MQTT channel started")
+	return nil
+}
+
+// Stop disconnects from the broker.
+func (c *MQTTChannel) Stop(ctx context.Context) error {
+	logger.InfoC("mqtt", "Stopping MQTT channel")
+
+	if c.client != nil {
+		c.client.Disconnect(250)
+	}
+
+	c.setRunning(false)
+	logger.InfoC("mqtt", "MQTT channel stopped")
+	return nil
+}
+
+// subscribe runs on every connect, since a clean session forgets
+// subscriptions.
+func (c *MQTTChannel) subscribe(client paho.Client) {
+	filters := make(map[string]byte, len(c.config.Topics))
+	for _, topic := range c.config.Topics {
+		filters[topic] = c.qos
+	}
+	token := client.SubscribeMultiple(filters, c.handleMessage)
+	if token.WaitTimeout(mqttPublishTimeout) && token.Error() != nil {
+		logger.ErrorCF("mqtt", "Failed to subscribe", map[string]interface{}{
+			"topics": strings.Join(c.config.Topics, ","),
+			"error":  token.Error().Error(),
+		})
+		return
+	}
+	logger.InfoCF("mqtt", "Connected and subscribed", map[string]interface{}{
+		"topics": strings.Join(c.config.Topics, ","),
+	})
+}
+
+func (c *MQTTChannel) handleMessage(_ paho.Client, msg paho.Message) {
+	topic := msg.Topic()
+	// Retained payloads are old state, not requests; acting on them again
+	// after every reconnect would repeat old commands
+	if msg.Retained() {
+		logger.DebugCF("mqtt", "Ignoring retained message", map[string]interface{}{
+			"topic": topic,
+		})
+		return
+	}
+	if _, ok := c.replyTopics.Load(topic); ok {
+		return
+	}
+
+	var in mqttInbound
+	payload := strings.TrimSpace(string(msg.Payload()))
+	if strings.HasPrefix(payload, "{") && json.Unmarshal(msg.Payload(), &in) == nil && in.Content != "" {
+		payload = in.Content
+	}
+	if payload == "" {
+		return
+	}
+
+	// The topic is the sender, since broker ACLs can control who publishes
+	// where but nothing checks the payload
+	senderID := topic
+	chatID := mqttChatID(in.ChatID)
+	if chatID == "" {
+		chatID = mqttChatID(topic)
+	}
+	route := mqttRoute{topic: topic}
+	if in.ResponseTopic != "" {
+		if c.responseTopicAllowed(in.ResponseTopic) {
+			route.responseTopic = in.ResponseTopic
+		} else {
+			logger.WarnCF("mqtt", "Ignoring response topic not in response_topics", map[string]interface{}{
+				"topic":          topic,
+				"response_topic": in.ResponseTopic,
+			})
+		}
+	}
+	c.routes.Store(chatID, route)
+
+	metadata := map[string]string{
+		"platform": "mqtt",
+		"topic":    topic,
+	}
+	if in.SenderID != "" {
+		metadata["sender_name"] = in.SenderID
+	}
+	// Sensors repeat identical payloads, so only messages that carry an
+	// ID are deduplicated
+	if in.MessageID != "" {
+		metadata["message_id"] = in.MessageID
+	}
+
+	logger.DebugCF("mqtt", "Received message", map[string]interface{}{
+		"topic":   topic,
+		"chat_id": chatID,
+		"preview": utils.Truncate(payload, 50),
+	})
+
+	c.HandleMessage(senderID, chatID, payload, nil, metadata)
+}
+
+// Send publishes a reply to the chat's response topic.
+func (c *MQTTChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("mqtt channel not running")
+	}
+
+	topic := c.responseTopic(msg.ChatID)
+	var payload []byte
+	if c.config.Format == "json" {
+		data, err := json.Marshal(mqttOutbound{
+			ChatID:      msg.ChatID,
+			Content:     renderMarkdown(msg.Content, dialectPlain),
+			ReplyTo:     msg.ReplyTo,
+			Attachments: msg.Attachments,
+			Buttons:     msg.Buttons,
+		})
+		if err != nil {
+			return err
+		}
+		payload = data
+	} else {
+		content := withAttachmentText(withButtonText(msg.Content, msg.Buttons), msg.Attachments)
+		payload = []byte(renderMarkdown(content, dialectPlain))
+	}
+
+	c.replyTopics.Store(topic, struct{}{})
+	token := c.client.Publish(topic, c.qos, false, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("mqtt publish to %s timed out", topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("mqtt publish to %s: %w", topic, err)
+	}
+
+	logger.DebugCF("mqtt", "Reply published", map[string]interface{}{
+		"topic": topic,
+	})
+	return nil
+}
+
+// responseTopic fills in the response topic template for a chat. Chats
+// the channel has not heard from get their topic back from the chat ID.
+func (c *MQTTChannel) responseTopic(chatID string) string {
+	route := mqttRoute{topic: strings.ReplaceAll(chatID, ".", "/")}
+	if r, ok := c.routes.Load(chatID); ok {
+		route = r.(mqttRoute)
+	}
+	if route.responseTopic != "" {
+		return route.responseTopic
+	}
+	return strings.NewReplacer("{chat_id}", chatID, "{topic}", route.topic).Replace(c.config.ResponseTopic)
+}
+
+// responseTopicAllowed reports whether a message may have its reply
+// published to topic.
+func (c *MQTTChannel) responseTopicAllowed(topic string) bool {
+	if strings.ContainsAny(topic, "+#") {
+		return false
+	}
+	for _, filter := range c.config.ResponseTopics {
+		if utils.MQTTTopicMatch(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// mqttChatID makes a topic or caller-chosen ID usable as a chat ID, which
+// names a session file and so can't hold path separators.
+func mqttChatID(id string) string {
+	return strings.NewReplacer("/", ".", "\\", ".").Replace(id)
+}
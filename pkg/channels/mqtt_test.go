@@ -0,0 +1,188 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// startTestBroker runs an in-process MQTT broker and returns its URL.
+func startTestBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := mochi.New(&mochi.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	server.AddHook(new(auth.AllowHook), nil)
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr})); err != nil {
+		t.Fatalf("add listener: %v", err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, "tcp://" + addr
+}
+
+func startMQTTChannel(t *testing.T, cfg config.MQTTConfig) (*MQTTChannel, *bus.MessageBus) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	ch, err := NewMQTTChannel(cfg, mb)
+	if err != nil {
+		t.Fatalf("NewMQTTChannel error: %v", err)
+	}
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	t.Cleanup(func() { ch.Stop(context.Background()) })
+
+	// Wait for the subscription to be in place
+	deadline := time.Now().Add(3 * time.Second)
+	for !ch.client.IsConnectionOpen() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	return ch, mb
+}
+
+func TestMQTTChannel_TextRoundTrip(t *testing.T) {
+	broker, url := startTestBroker(t)
+	replies := make(chan string, 4)
+	broker.Subscribe("picoclaw/out/#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		replies <- pk.TopicName + " " + string(pk.Payload)
+	})
+
+	// Retained state is not a request
+	broker.Publish("picoclaw/in/kitchen", []byte("old command"), true, 0)
+	ch, mb := startMQTTChannel(t, config.MQTTConfig{
+		Broker:        url,
+		ClientID:      "picoclaw-test",
+		Topics:        config.FlexibleStringSlice{"picoclaw/in/#"},
+		ResponseTopic: "picoclaw/out/{chat_id}",
+		QoS:           1,
+	})
+
+	broker.Publish("picoclaw/in/kitchen", []byte("is the oven on?"), false, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.Content != "is the oven on?" || msg.ChatID != "picoclaw.in.kitchen" || msg.SenderID != "picoclaw/in/kitchen" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: msg.ChatID, Content: "**No**, it is off"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	select {
+	case got := <-replies:
+		if got != "picoclaw/out/picoclaw.in.kitchen No, it is off" {
+			t.Errorf("reply = %q", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply published")
+	}
+}
+
+func TestMQTTChannel_JSONPayloads(t *testing.T) {
+	broker, url := startTestBroker(t)
+	replies := make(chan []byte, 4)
+	broker.Subscribe("satellite/+/tts", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		replies <- pk.Payload
+	})
+
+	ch, mb := startMQTTChannel(t, config.MQTTConfig{
+		Broker:         url,
+		Topics:         config.FlexibleStringSlice{"satellite/#"},
+		ResponseTopic:  "{topic}/reply",
+		ResponseTopics: config.FlexibleStringSlice{"satellite/+/tts"},
+		Format:         "json",
+	})
+
+	broker.Publish("satellite/den/stt", []byte(`{"content":"set a timer","sender_id":"den-mic","chat_id":"den","response_topic":"satellite/den/tts","message_id":"u1"}`), false, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.Content != "set a timer" || msg.ChatID != "den" || msg.SenderID != "satellite/den/stt" ||
+		msg.Metadata["sender_name"] != "den-mic" || msg.Metadata["message_id"] != "u1" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	ch.Send(ctx, bus.OutboundMessage{ChatID: "den", Content: "Timer set"})
+	select {
+	case payload := <-replies:
+		var out mqttOutbound
+		if err := json.Unmarshal(payload, &out); err != nil || out.ChatID != "den" || out.Content != "Timer set" {
+			t.Errorf("reply = %s", payload)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply published")
+	}
+
+	// The reply topic is under the subscription, but is not fed back
+	shortCtx, cancelShort := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancelShort()
+	if msg, ok := mb.ConsumeInbound(shortCtx); ok {
+		t.Errorf("own reply came back as %+v", msg)
+	}
+
+	// A sensor payload that is JSON but not a message reaches the agent whole
+	broker.Publish("satellite/den/temp", []byte(`{"celsius":21.5}`), false, 0)
+	msg, ok = mb.ConsumeInbound(ctx)
+	if !ok || msg.Content != `{"celsius":21.5}` || msg.ChatID != "satellite.den.temp" {
+		t.Errorf("sensor message = %+v", msg)
+	}
+}
+
+func TestMQTTChannel_PayloadCannotRedirect(t *testing.T) {
+	broker, url := startTestBroker(t)
+	replies := make(chan string, 4)
+	broker.Subscribe("#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		if !strings.HasPrefix(pk.TopicName, "picoclaw/in/") {
+			replies <- pk.TopicName
+		}
+	})
+
+	ch, mb := startMQTTChannel(t, config.MQTTConfig{
+		Broker:    url,
+		Topics:    config.FlexibleStringSlice{"picoclaw/in/#"},
+		AllowFrom: config.FlexibleStringSlice{"picoclaw/in/hall"},
+	})
+
+	// A payload naming an allowed sender from another topic is refused
+	broker.Publish("picoclaw/in/garage", []byte(`{"content":"open","sender_id":"picoclaw/in/hall"}`), false, 0)
+	broker.Publish("picoclaw/in/hall", []byte(`{"content":"lights off","response_topic":"home/lock/set"}`), false, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.Content != "lights off" || msg.SenderID != "picoclaw/in/hall" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	// The reply goes to the configured topic, not the one in the payload
+	ch.Send(ctx, bus.OutboundMessage{ChatID: msg.ChatID, Content: "done"})
+	select {
+	case got := <-replies:
+		if got != "picoclaw/out/picoclaw.in.hall" {
+			t.Errorf("reply topic = %q", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reply published")
+	}
+}
@@ -2,6 +2,7 @@ package channels
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -14,17 +15,19 @@ import (
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
 )
 
+// oneBotMaxLength keeps each message under what QQ clients accept in one
+// message.
+const oneBotMaxLength = 3000
+
 type OneBotChannel struct {
 	*BaseChannel
 	config      config.OneBotConfig
 	conn        *websocket.Conn
 	ctx         context.Context
 	cancel      context.CancelFunc
-	dedup       map[string]struct{}
-	dedupRing   []string
-	dedupIdx    int
 	mu          sync.Mutex
 	writeMu     sync.Mutex
 	echoCounter int64
@@ -82,25 +85,26 @@ type oneBotAPIRequest struct {
 }
 
 type oneBotSendPrivateMsgParams struct {
-	UserID  int64  `json:"user_id"`
-	Message string `json:"message"`
+	UserID  int64       `json:"user_id"`
+	Message interface{} `json:"message"` // string (CQ code) or []oneBotSegment
 }
 
 type oneBotSendGroupMsgParams struct {
-	GroupID int64  `json:"group_id"`
-	Message string `json:"message"`
+	GroupID int64       `json:"group_id"`
+	Message interface{} `json:"message"` // string (CQ code) or []oneBotSegment
+}
+
+type oneBotSegment struct {
+	Type string            `json:"type"`
+	Data map[string]string `json:"data"`
 }
 
 func NewOneBotChannel(cfg config.OneBotConfig, messageBus *bus.MessageBus) (*OneBotChannel, error) {
 	base := NewBaseChannel("onebot", cfg, messageBus, cfg.AllowFrom)
 
-	const dedupSize = 1024
 	return &OneBotChannel{
 		BaseChannel: base,
 		config:      cfg,
-		dedup:       make(map[string]struct{}, dedupSize),
-		dedupRing:   make([]string, dedupSize),
-		dedupIdx:    0,
 	}, nil
 }
 
@@ -220,11 +224,25 @@ func (c *OneBotChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("OneBot WebSocket not connected")
 	}
 
-	action, params, err := c.buildSendRequest(msg)
-	if err != nil {
-		return err
+	parts := outbox.PartsFrom(ctx)
+	for _, message := range buildOneBotMessages(ctx, msg) {
+		if parts.Skip() {
+			continue
+		}
+		action, params, err := c.buildSendRequest(msg.ChatID, message)
+		if err != nil {
+			return err
+		}
+		if err := c.sendAction(conn, action, params); err != nil {
+			return err
+		}
+		parts.Done()
 	}
 
+	return nil
+}
+
+func (c *OneBotChannel) sendAction(conn *websocket.Conn, action string, params interface{}) error {
 	c.writeMu.Lock()
 	c.echoCounter++
 	echo := fmt.Sprintf("send_%d", c.echoCounter)
@@ -255,9 +273,7 @@ func (c *OneBotChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	return nil
 }
 
-func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, interface{}, error) {
-	chatID := msg.ChatID
-
+func (c *OneBotChannel) buildSendRequest(chatID string, message interface{}) (string, interface{}, error) {
 	if len(chatID) > 6 && chatID[:6] == "group:" {
 		groupID, err := strconv.ParseInt(chatID[6:], 10, 64)
 		if err != nil {
@@ -265,7 +281,7 @@ func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, inter
 		}
 		return "send_group_msg", oneBotSendGroupMsgParams{
 			GroupID: groupID,
-			Message: msg.Content,
+			Message: message,
 		}, nil
 	}
 
@@ -276,7 +292,7 @@ func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, inter
 		}
 		return "send_private_msg", oneBotSendPrivateMsgParams{
 			UserID:  userID,
-			Message: msg.Content,
+			Message: message,
 		}, nil
 	}
 
@@ -287,10 +303,83 @@ func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, inter
 
 	return "send_private_msg", oneBotSendPrivateMsgParams{
 		UserID:  userID,
-		Message: msg.Content,
+		Message: message,
 	}, nil
 }
 
+// buildOneBotMessages returns the messages to send: the plain text alone,
+// or segment arrays when there are attachments. Images go inline with the
+// text; voice and video must be messages of their own. Local files are sent
+// as base64. Other files have no send segment in OneBot v11 and are listed
+// as text.
+func buildOneBotMessages(ctx context.Context, msg bus.OutboundMessage) []interface{} {
+	text := withButtonText(msg.Content, msg.Buttons)
+	if len(msg.Attachments) == 0 {
+		var messages []interface{}
+		for _, chunk := range renderMessage(text, dialectPlain, oneBotMaxLength) {
+			messages = append(messages, chunk)
+		}
+		return messages
+	}
+
+	var inline []oneBotSegment
+	var separate []interface{}
+	for _, a := range msg.Attachments {
+		segType := ""
+		switch a.Kind() {
+		case bus.AttachmentImage:
+			segType = "image"
+		case bus.AttachmentAudio:
+			segType = "record"
+		case bus.AttachmentVideo:
+			segType = "video"
+		}
+
+		file := a.URL
+		if segType != "" && a.Path != "" {
+			data, err := readAttachment(ctx, a)
+			if err != nil {
+				logger.ErrorCF("onebot", "Failed to read attachment, sending as text", map[string]interface{}{
+					"file":  a.Path,
+					"error": err.Error(),
+				})
+				segType = ""
+			} else {
+				file = "base64://" + base64.StdEncoding.EncodeToString(data)
+			}
+		}
+
+		if segType == "" {
+			text = appendContent(text, attachmentText(a))
+			continue
+		}
+		if a.Caption != "" {
+			text = appendContent(text, a.Caption)
+		}
+		seg := oneBotSegment{Type: segType, Data: map[string]string{"file": file}}
+		if segType == "image" {
+			inline = append(inline, seg)
+		} else {
+			separate = append(separate, []oneBotSegment{seg})
+		}
+	}
+
+	// Long text goes out first; its last piece carries the images
+	var messages []interface{}
+	chunks := renderMessage(text, dialectPlain, oneBotMaxLength)
+	for i, chunk := range chunks {
+		if i < len(chunks)-1 {
+			messages = append(messages, chunk)
+			continue
+		}
+		inline = append([]oneBotSegment{{Type: "text", Data: map[string]string{"text": chunk}}}, inline...)
+	}
+	if len(inline) > 0 {
+		messages = append(messages, inline)
+	}
+	return append(messages, separate...)
+}
+
 func (c *OneBotChannel) listen() {
 	for {
 		select {
@@ -547,13 +636,6 @@ func (c *OneBotChannel) handleMetaEvent(raw *oneBotRawEvent) {
 }
 
 func (c *OneBotChannel) handleMessage(evt *oneBotEvent) {
-	if c.isDuplicate(evt.MessageID) {
-		logger.DebugCF("onebot", "Duplicate message, skipping", map[string]interface{}{
-			"message_id": evt.MessageID,
-		})
-		return
-	}
-
 	content := evt.Content
 	if content == "" {
 		logger.DebugCF("onebot", "Received empty message, ignoring", map[string]interface{}{
@@ -565,8 +647,10 @@ func (c *OneBotChannel) handleMessage(evt *oneBotEvent) {
 	senderID := strconv.FormatInt(evt.UserID, 10)
 	var chatID string
 
-	metadata := map[string]string{
-		"message_id": evt.MessageID,
+	// Some implementations report 0 when there is no ID
+	metadata := map[string]string{}
+	if evt.MessageID != "0" {
+		metadata["message_id"] = evt.MessageID
 	}
 
 	switch evt.MessageType {
@@ -638,28 +722,6 @@ func (c *OneBotChannel) handleMessage(evt *oneBotEvent) {
 	c.HandleMessage(senderID, chatID, content, []string{}, metadata)
 }
 
-func (c *OneBotChannel) isDuplicate(messageID string) bool {
-	if messageID == "" || messageID == "0" {
-		return false
-	}
-
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if _, exists := c.dedup[messageID]; exists {
-		return true
-	}
-
-	if old := c.dedupRing[c.dedupIdx]; old != "" {
-		delete(c.dedup, old)
-	}
-	c.dedupRing[c.dedupIdx] = messageID
-	c.dedup[messageID] = struct{}{}
-	c.dedupIdx = (c.dedupIdx + 1) % len(c.dedupRing)
-
-	return false
-}
-
 func truncate(s string, n int) string {
 	runes := []rune(s)
 	if len(runes) <= n {
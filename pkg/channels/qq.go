@@ -3,7 +3,6 @@ package channels
 import (
 	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/tencent-connect/botgo"
@@ -16,8 +15,12 @@ import (
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
 )
 
+// qqMaxLength keeps each message under QQ's text length limit.
+const qqMaxLength = 2000
+
 type QQChannel struct {
 	*BaseChannel
 	config         config.QQConfig
@@ -26,17 +29,14 @@ type QQChannel struct {
 	ctx            context.Context
 	cancel         context.CancelFunc
 	sessionManager botgo.SessionManager
-	processedIDs   map[string]bool
-	mu             sync.RWMutex
 }
 
 func NewQQChannel(cfg config.QQConfig, messageBus *bus.MessageBus) (*QQChannel, error) {
 	base := NewBaseChannel("qq", cfg, messageBus, cfg.AllowFrom)
 
 	return &QQChannel{
-		BaseChannel:  base,
-		config:       cfg,
-		processedIDs: make(map[string]bool),
+		BaseChannel: base,
+		config:      cfg,
 	}, nil
 }
 
@@ -116,18 +116,24 @@ func (c *QQChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 		return fmt.Errorf("QQ bot not running")
 	}
 
-	// 构造消息
-	msgToCreate := &dto.MessageToCreate{
-		Content: msg.Content,
-	}
+	parts := outbox.PartsFrom(ctx)
+	for _, chunk := range renderMessage(plainContent(msg), dialectPlain, qqMaxLength) {
+		if parts.Skip() {
+			continue
+		}
+		msgToCreate := &dto.MessageToCreate{
+			Content: chunk,
+		}
 
-	// C2C 消息发送
-	_, err := c.api.PostC2CMessage(ctx, msg.ChatID, msgToCreate)
-	if err != nil {
-		logger.ErrorCF("qq", "Failed to send C2C message", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return err
+		// C2C 消息发送
+		_, err := c.api.PostC2CMessage(ctx, msg.ChatID, msgToCreate)
+		if err != nil {
+			logger.ErrorCF("qq", "Failed to send C2C message", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return err
+		}
+		parts.Done()
 	}
 
 	return nil
@@ -136,11 +142,6 @@ func (c *QQChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 // handleC2CMessage 处理 QQ 私聊消息
 func (c *QQChannel) handleC2CMessage() event.C2CMessageEventHandler {
 	return func(event *dto.WSPayload, data *dto.WSC2CMessageData) error {
-		// 去重检查
-		if c.isDuplicate(data.ID) {
-			return nil
-		}
-
 		// 提取用户信息
 		var senderID string
 		if data.Author != nil && data.Author.ID != "" {
@@ -176,11 +177,6 @@ func (c *QQChannel) handleC2CMessage() event.C2CMessageEventHandler {
 // handleGroupATMessage 处理群@消息
 func (c *QQChannel) handleGroupATMessage() event.GroupATMessageEventHandler {
 	return func(event *dto.WSPayload, data *dto.WSGroupATMessageData) error {
-		// 去重检查
-		if c.isDuplicate(data.ID) {
-			return nil
-		}
-
 		// 提取用户信息
 		var senderID string
 		if data.Author != nil && data.Author.ID != "" {
@@ -214,30 +210,3 @@ func (c *QQChannel) handleGroupATMessage() event.GroupATMessageEventHandler {
 		return nil
 	}
 }
-
-// isDuplicate 检查消息是否重复
-func (c *QQChannel) isDuplicate(messageID string) bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.processedIDs[messageID] {
-		return true
-	}
-
-	c.processedIDs[messageID] = true
-
-	// 简单清理：限制 map 大小
-	if len(c.processedIDs) > 10000 {
-		// 清空一半
-		count := 0
-		for id := range c.processedIDs {
-			if count >= 5000 {
-				break
-			}
-			delete(c.processedIDs, id)
-			count++
-		}
-	}
-
-	return false
-}
@@ -0,0 +1,123 @@
+package channels
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+// sentMessages remembers the last message the bot sent to each chat, so
+// bus.EditLast can be resolved to a platform message ID.
+type sentMessages struct {
+	m sync.Map // chatID -> message ID
+}
+
+func (s *sentMessages) remember(chatID, messageID string) {
+	if messageID != "" {
+		s.m.Store(chatID, messageID)
+	}
+}
+
+// editTarget returns the platform ID of the message msg edits, or "" when
+// it isn't an edit (or there is nothing to edit yet).
+func (s *sentMessages) editTarget(msg bus.OutboundMessage) string {
+	if msg.EditMessageID != bus.EditLast {
+		return msg.EditMessageID
+	}
+	if id, ok := s.m.Load(msg.ChatID); ok {
+		return id.(string)
+	}
+	return ""
+}
+
+const (
+	buttonPayloadTTL  = 24 * time.Hour
+	buttonPayloadSize = 4096
+)
+
+// buttonPayloads swaps button payloads longer than a platform's callback
+// field (64 bytes on Telegram, 100 on Discord) for short tokens. Tokens
+// expire after buttonPayloadTTL, and at most buttonPayloadSize are kept;
+// a press on an older button arrives as the bare token.
+type buttonPayloads struct {
+	mu     sync.Mutex
+	tokens map[string]buttonPayload
+	ring   []string // insertion order, oldest overwritten first
+	next   int
+}
+
+type buttonPayload struct {
+	payload string
+	at      time.Time
+}
+
+const buttonTokenPrefix = "~"
+
+func (p *buttonPayloads) encode(payload string, limit int) string {
+	if len(payload) <= limit && !strings.HasPrefix(payload, buttonTokenPrefix) {
+		return payload
+	}
+	sum := sha256.Sum256([]byte(payload))
+	token := buttonTokenPrefix + hex.EncodeToString(sum[:12])
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tokens == nil {
+		p.tokens = make(map[string]buttonPayload, buttonPayloadSize)
+		p.ring = make([]string, buttonPayloadSize)
+	}
+	if _, ok := p.tokens[token]; ok {
+		// Same payload sent again; keep its slot and refresh the TTL
+		p.tokens[token] = buttonPayload{payload: payload, at: now}
+		return token
+	}
+	if old := p.ring[p.next]; old != "" {
+		delete(p.tokens, old)
+	}
+	p.ring[p.next] = token
+	p.tokens[token] = buttonPayload{payload: payload, at: now}
+	p.next = (p.next + 1) % len(p.ring)
+	return token
+}
+
+func (p *buttonPayloads) decode(id string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.tokens[id]; ok && time.Since(e.at) < buttonPayloadTTL {
+		return e.payload
+	}
+	return id
+}
+
+// withButtonText appends the buttons to content as text lines, for
+// channels without interactive buttons.
+func withButtonText(content string, rows [][]bus.Button) string {
+	if len(rows) == 0 {
+		return content
+	}
+	var lines []string
+	for _, row := range rows {
+		for _, b := range row {
+			if b.URL != "" {
+				lines = append(lines, fmt.Sprintf("• %s: %s", b.Text, b.URL))
+			} else {
+				lines = append(lines, "• "+b.Text)
+			}
+		}
+	}
+	return appendContent(content, strings.Join(lines, "\n"))
+}
+
+// plainContent renders a message's text with its buttons and attachments
+// spelled out, for channels that support neither.
+func plainContent(msg bus.OutboundMessage) string {
+	return withAttachmentText(withButtonText(msg.Content, msg.Buttons), msg.Attachments)
+}
@@ -0,0 +1,146 @@
+package channels
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/slack-go/slack"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+var testButtons = [][]bus.Button{
+	{{Text: "Yes", Data: "confirm:yes"}, {Text: "No"}},
+	{{Text: "Docs", URL: "https://example.com/docs"}},
+}
+
+func TestSentMessages_EditTarget(t *testing.T) {
+	var sent sentMessages
+	msg := bus.OutboundMessage{ChatID: "42", EditMessageID: bus.EditLast}
+	if got := sent.editTarget(msg); got != "" {
+		t.Errorf("editTarget before any send = %q, want empty", got)
+	}
+	sent.remember("42", "7")
+	if got := sent.editTarget(msg); got != "7" {
+		t.Errorf("editTarget(last) = %q, want 7", got)
+	}
+	msg.EditMessageID = "3"
+	if got := sent.editTarget(msg); got != "3" {
+		t.Errorf("editTarget(3) = %q", got)
+	}
+}
+
+func TestButtonPayloads_LongDataRoundTrip(t *testing.T) {
+	var payloads buttonPayloads
+	if got := payloads.encode("short", 64); got != "short" {
+		t.Errorf("short payload encoded as %q", got)
+	}
+	long := strings.Repeat("x", 100)
+	token := payloads.encode(long, 64)
+	if len(token) > 64 {
+		t.Fatalf("token %q exceeds limit", token)
+	}
+	if got := payloads.decode(token); got != long {
+		t.Errorf("decode(token) = %q", got)
+	}
+}
+
+func TestButtonPayloads_Bounded(t *testing.T) {
+	var payloads buttonPayloads
+	first := payloads.encode(strings.Repeat("a", 100), 64)
+	for i := 0; i < buttonPayloadSize; i++ {
+		payloads.encode(fmt.Sprintf("%s-%d", strings.Repeat("b", 100), i), 64)
+	}
+	if got := payloads.decode(first); got != first {
+		t.Errorf("oldest token still decodes to %q", got)
+	}
+	if len(payloads.tokens) != buttonPayloadSize {
+		t.Errorf("kept %d tokens, want %d", len(payloads.tokens), buttonPayloadSize)
+	}
+
+	long := strings.Repeat("c", 100)
+	token := payloads.encode(long, 64)
+	payloads.tokens[token] = buttonPayload{payload: long, at: time.Now().Add(-buttonPayloadTTL)}
+	if got := payloads.decode(token); got != token {
+		t.Errorf("expired token decodes to %q", got)
+	}
+}
+
+func TestWithButtonText(t *testing.T) {
+	got := withButtonText("Pick one", testButtons)
+	want := "Pick one\n• Yes\n• No\n• Docs: https://example.com/docs"
+	if got != want {
+		t.Errorf("withButtonText() = %q, want %q", got, want)
+	}
+}
+
+func TestTelegramInlineKeyboard(t *testing.T) {
+	c := &TelegramChannel{}
+	keyboard := c.inlineKeyboard(testButtons)
+	if len(keyboard.InlineKeyboard) != 2 || len(keyboard.InlineKeyboard[0]) != 2 {
+		t.Fatalf("keyboard = %+v", keyboard.InlineKeyboard)
+	}
+	if got := keyboard.InlineKeyboard[0][1].CallbackData; got != "No" {
+		t.Errorf("callback data = %q, want label", got)
+	}
+	if got := keyboard.InlineKeyboard[1][0].URL; got != "https://example.com/docs" {
+		t.Errorf("url button = %q", got)
+	}
+	if c.inlineKeyboard(nil) != nil {
+		t.Error("expected no keyboard without buttons")
+	}
+}
+
+func TestSlackBlocks(t *testing.T) {
+	blocks := slackBlocks("Pick one", testButtons)
+	if len(blocks) != 3 {
+		t.Fatalf("len(blocks) = %d, want section + 2 action rows", len(blocks))
+	}
+	actions := blocks[1].(*slack.ActionBlock)
+	button := actions.Elements.ElementSet[0].(*slack.ButtonBlockElement)
+	if button.Value != "confirm:yes" || button.Text.Text != "Yes" {
+		t.Errorf("button = %+v", button)
+	}
+	link := blocks[2].(*slack.ActionBlock).Elements.ElementSet[0].(*slack.ButtonBlockElement)
+	if link.URL == "" || link.Value != "" {
+		t.Errorf("link button = %+v, want url and no value", link)
+	}
+}
+
+func TestDiscordComponents(t *testing.T) {
+	c := &DiscordChannel{}
+	rows := c.components(testButtons)
+	if len(rows) != 2 {
+		t.Fatalf("len(rows) = %d", len(rows))
+	}
+	button := rows[0].(discordgo.ActionsRow).Components[0].(discordgo.Button)
+	if button.CustomID != "confirm:yes" || button.Label != "Yes" {
+		t.Errorf("button = %+v", button)
+	}
+	link := rows[1].(discordgo.ActionsRow).Components[0].(discordgo.Button)
+	if link.Style != discordgo.LinkButton || link.CustomID != "" {
+		t.Errorf("link button = %+v", link)
+	}
+}
+
+func TestBuildLINEMessages_QuickReply(t *testing.T) {
+	messages := buildLINEMessages(bus.OutboundMessage{Content: "Pick one", Buttons: testButtons}, "")
+	message := messages[0].(map[string]interface{})
+	if message["text"] != "Pick one" {
+		t.Errorf("text = %v", message["text"])
+	}
+	items := message["quickReply"].(map[string]interface{})["items"].([]interface{})
+	if len(items) != 3 {
+		t.Fatalf("len(items) = %d, want 3", len(items))
+	}
+	action := items[0].(map[string]interface{})["action"].(map[string]string)
+	if action["type"] != "postback" || action["data"] != "confirm:yes" || action["displayText"] != "Yes" {
+		t.Errorf("postback action = %v", action)
+	}
+	if uri := items[2].(map[string]interface{})["action"].(map[string]string); uri["type"] != "uri" {
+		t.Errorf("link action = %v", uri)
+	}
+}
@@ -0,0 +1,591 @@
+package channels
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	signalCallTimeout = 60 * time.Second
+	signalMinBackoff  = 2 * time.Second
+	signalMaxBackoff  = 2 * time.Minute
+	// signalGroupPrefix marks group chat IDs. Group IDs are base64 and
+	// may contain '/', so chat IDs carry them URL-safe encoded.
+	signalGroupPrefix = "group."
+)
+
+// SignalChannel implements the Channel interface for Signal through a
+// signal-cli daemon's JSON-RPC interface ("signal-cli daemon --socket"
+// or "--tcp"). The daemon owns the account; picoclaw only talks to it.
+type SignalChannel struct {
+	*BaseChannel
+	config    config.SignalConfig
+	workspace string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	sent      sentMessages
+	nextID    atomic.Int64
+
+	mu      sync.Mutex
+	conn    net.Conn
+	pending map[int64]chan signalResponse // request ID -> waiting call
+	writeMu sync.Mutex
+}
+
+type signalRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// signalResponse is a response to a call, or a notification when Method
+// is set.
+type signalResponse struct {
+	ID     *int64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type signalEnvelope struct {
+	Source       string             `json:"source"`
+	SourceNumber string             `json:"sourceNumber"`
+	SourceUUID   string             `json:"sourceUuid"`
+	SourceName   string             `json:"sourceName"`
+	Timestamp    int64              `json:"timestamp"`
+	DataMessage  *signalDataMessage `json:"dataMessage"`
+}
+
+type signalDataMessage struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+	GroupInfo *struct {
+		GroupID string `json:"groupId"`
+	} `json:"groupInfo"`
+	Attachments []struct {
+		ID          string `json:"id"`
+		ContentType string `json:"contentType"`
+		Filename    string `json:"filename"`
+		Size        int64  `json:"size"`
+	} `json:"attachments"`
+	Mentions []struct {
+		Name   string `json:"name"`
+		Number string `json:"number"`
+		UUID   string `json:"uuid"`
+		Start  int    `json:"start"`
+		Length int    `json:"length"`
+	} `json:"mentions"`
+	Quote *struct {
+		Author       string `json:"author"`
+		AuthorNumber string `json:"authorNumber"`
+	} `json:"quote"`
+}
+
+// NewSignalChannel creates a new Signal channel instance.
+func NewSignalChannel(cfg config.SignalConfig, messageBus *bus.MessageBus, workspace string) (*SignalChannel, error) {
+	if cfg.Account == "" {
+		return nil, fmt.Errorf("signal account is required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "127.0.0.1:7583"
+	}
+
+	base := NewBaseChannel("signal", cfg, messageBus, cfg.AllowFrom)
+
+	return &SignalChannel{
+		BaseChannel: base,
+		config:      cfg,
+		workspace:   workspace,
+		pending:     make(map[int64]chan signalResponse),
+	}, nil
+}
+
+// Start launches the connection loop. The daemon may come up after the
+// gateway, so connecting happens in the background.
+func (c *SignalChannel) Start(ctx context.Context) error {
+	logger.InfoCF("signal", "Starting Signal channel", map[string]interface{}{
+		"endpoint": c.config.Endpoint,
+	})
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	go c.run()
+
+	c.setRunning(true)
+	logger.InfoC("signal", "Signal channel started")
+	return nil
+}
+
+// Stop closes the daemon connection.
+func (c *SignalChannel) Stop(ctx context.Context) error {
+	logger.InfoC("signal", "Stopping Signal channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+
+	c.setRunning(false)
+	logger.InfoC("signal", "Signal channel stopped")
+	return nil
+}
+
+// run keeps a connection to the daemon, reconnecting with backoff.
+func (c *SignalChannel) run() {
+	backoff := signalMinBackoff
+	for c.ctx.Err() == nil {
+		started := time.Now()
+		err := c.session()
+		if c.ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = signalMinBackoff
+		}
+		logger.WarnCF("signal", "Lost connection to signal-cli, reconnecting", map[string]interface{}{
+			"error": fmt.Sprint(err),
+			"retry": backoff.String(),
+		})
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > signalMaxBackoff {
+			backoff = signalMaxBackoff
+		}
+	}
+}
+
+// session reads from one daemon connection until it fails. The daemon
+// pushes received messages as "receive" notifications.
+func (c *SignalChannel) session() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	logger.InfoC("signal", "Connected to signal-cli")
+
+	defer func() {
+		conn.Close()
+		c.mu.Lock()
+		c.conn = nil
+		// Fail calls still waiting on this connection
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var resp signalResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			logger.WarnCF("signal", "Ignoring malformed line from signal-cli", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+		if resp.Method != "" {
+			if resp.Method == "receive" {
+				go c.handleReceive(resp.Params)
+			}
+			continue
+		}
+		if resp.ID != nil {
+			c.mu.Lock()
+			ch, ok := c.pending[*resp.ID]
+			delete(c.pending, *resp.ID)
+			c.mu.Unlock()
+			if ok {
+				ch <- resp
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("connection closed")
+}
+
+func (c *SignalChannel) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	endpoint := c.config.Endpoint
+	if path, ok := strings.CutPrefix(endpoint, "unix://"); ok {
+		return dialer.DialContext(c.ctx, "unix", path)
+	}
+	if strings.HasPrefix(endpoint, "/") {
+		return dialer.DialContext(c.ctx, "unix", endpoint)
+	}
+	return dialer.DialContext(c.ctx, "tcp", endpoint)
+}
+
+// call sends a JSON-RPC request and decodes its result into result.
+func (c *SignalChannel) call(ctx context.Context, method string, params map[string]interface{}, result interface{}) error {
+	c.mu.Lock()
+	conn := c.conn
+	if conn == nil {
+		c.mu.Unlock()
+		return fmt.Errorf("signal-cli not connected")
+	}
+	id := c.nextID.Add(1)
+	ch := make(chan signalResponse, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	// A daemon serving several accounts needs to know which one
+	params["account"] = c.config.Account
+	data, err := json.Marshal(signalRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		c.forget(id)
+		return err
+	}
+
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err = conn.Write(append(data, '\n'))
+	c.writeMu.Unlock()
+	if err != nil {
+		c.forget(id)
+		return fmt.Errorf("signal-cli %s: %w", method, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, signalCallTimeout)
+	defer cancel()
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("signal-cli %s: connection lost", method)
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("signal-cli %s: %s (code %d)", method, resp.Error.Message, resp.Error.Code)
+		}
+		if result != nil && len(resp.Result) > 0 {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		c.forget(id)
+		return fmt.Errorf("signal-cli %s: %w", method, ctx.Err())
+	}
+}
+
+func (c *SignalChannel) forget(id int64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *SignalChannel) handleReceive(params json.RawMessage) {
+	var notification struct {
+		Envelope signalEnvelope `json:"envelope"`
+	}
+	if err := json.Unmarshal(params, &notification); err != nil {
+		logger.WarnCF("signal", "Failed to parse received message", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	env := notification.Envelope
+	msg := env.DataMessage
+	// Receipts, typing notifications and messages synced from the
+	// account's other devices carry no data message
+	if msg == nil {
+		return
+	}
+	if env.SourceNumber == "" {
+		env.SourceNumber = env.Source
+	}
+	if env.SourceNumber == c.config.Account {
+		return
+	}
+
+	senderID := signalSenderID(env.SourceNumber, env.SourceUUID)
+	if !c.IsAllowed(senderID) {
+		logger.DebugCF("signal", "Message from sender not in allow_from", map[string]interface{}{
+			"sender_id": senderID,
+		})
+		return
+	}
+
+	author := env.SourceUUID
+	if env.SourceNumber != "" {
+		author = env.SourceNumber
+	}
+	isGroup := msg.GroupInfo != nil && msg.GroupInfo.GroupID != ""
+	chatID := author
+	if isGroup {
+		chatID = signalGroupChatID(msg.GroupInfo.GroupID)
+	}
+
+	content, mentioned := c.resolveMentions(msg)
+	if isGroup && c.config.RequireMention && !mentioned && !c.quotesBot(msg) {
+		return
+	}
+
+	var mediaPaths []string
+	for _, a := range msg.Attachments {
+		name := a.Filename
+		if name == "" {
+			name = a.ID
+		}
+		if path := c.downloadAttachment(a.ID, name, author, msg); path != "" {
+			mediaPaths = append(mediaPaths, path)
+		}
+		kind := (bus.Attachment{Path: name, MIMEType: a.ContentType}).Kind()
+		if kind == bus.AttachmentImage {
+			content = appendContent(content, "[image]")
+		} else {
+			content = appendContent(content, fmt.Sprintf("[%s: %s]", kind, name))
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	timestamp := msg.Timestamp
+	if timestamp == 0 {
+		timestamp = env.Timestamp
+	}
+	metadata := map[string]string{
+		"platform":    "signal",
+		"message_id":  signalMessageID(timestamp, author),
+		"sender_name": env.SourceName,
+		"is_dm":       strconv.FormatBool(!isGroup),
+	}
+
+	logger.DebugCF("signal", "Received message", map[string]interface{}{
+		"sender_id": senderID,
+		"chat_id":   chatID,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+}
+
+// resolveMentions puts names back where Signal left mention placeholders
+// and drops mentions of the bot. It reports whether the bot was mentioned.
+func (c *SignalChannel) resolveMentions(msg *signalDataMessage) (string, bool) {
+	if len(msg.Mentions) == 0 {
+		return msg.Message, false
+	}
+
+	// Mention offsets count UTF-16 code units; replace from the end so
+	// earlier offsets stay valid
+	text := utf16.Encode([]rune(msg.Message))
+	mentions := msg.Mentions
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].Start > mentions[j].Start })
+	mentioned := false
+	for _, m := range mentions {
+		if m.Start < 0 || m.Length < 0 || m.Start+m.Length > len(text) {
+			continue
+		}
+		replacement := "@" + m.Name
+		if m.Number == c.config.Account || (m.Name == c.config.Account && m.Number == "") {
+			mentioned = true
+			replacement = ""
+		}
+		text = append(text[:m.Start], append(utf16.Encode([]rune(replacement)), text[m.Start+m.Length:]...)...)
+	}
+	content := string(utf16.Decode(text))
+	return strings.TrimSpace(strings.ReplaceAll(content, "  ", " ")), mentioned
+}
+
+// quotesBot reports whether the message replies to one of the bot's.
+func (c *SignalChannel) quotesBot(msg *signalDataMessage) bool {
+	return msg.Quote != nil && (msg.Quote.AuthorNumber == c.config.Account || msg.Quote.Author == c.config.Account)
+}
+
+// downloadAttachment fetches a received attachment from the daemon and
+// saves it to the media directory.
+func (c *SignalChannel) downloadAttachment(id, name, author string, msg *signalDataMessage) string {
+	params := map[string]interface{}{"id": id}
+	if msg.GroupInfo != nil && msg.GroupInfo.GroupID != "" {
+		params["groupId"] = msg.GroupInfo.GroupID
+	} else {
+		params["recipient"] = author
+	}
+
+	var result struct {
+		Data string `json:"data"`
+	}
+	if err := c.call(c.ctx, "getAttachment", params, &result); err != nil {
+		logger.ErrorCF("signal", "Failed to get attachment", map[string]interface{}{
+			"id":    id,
+			"error": err.Error(),
+		})
+		return ""
+	}
+	data, err := base64.StdEncoding.DecodeString(result.Data)
+	if err != nil {
+		logger.ErrorCF("signal", "Failed to decode attachment", map[string]interface{}{
+			"id":    id,
+			"error": err.Error(),
+		})
+		return ""
+	}
+	path, err := saveMedia(c.workspace, name, data)
+	if err != nil {
+		logger.ErrorCF("signal", "Failed to save attachment", map[string]interface{}{
+			"id":    id,
+			"error": err.Error(),
+		})
+		return ""
+	}
+	return path
+}
+
+// Send delivers a message, with its attachments, to a contact or group.
+func (c *SignalChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("signal channel not running")
+	}
+	params, err := signalRecipient(msg.ChatID)
+	if err != nil {
+		return err
+	}
+
+	if len(msg.Reactions) > 0 && msg.ReplyTo != "" {
+		c.react(ctx, msg)
+		if msg.Content == "" && len(msg.Attachments) == 0 {
+			return nil
+		}
+	}
+
+	params["message"] = renderMarkdown(withButtonText(msg.Content, msg.Buttons), dialectPlain)
+	var attachments []string
+	for _, a := range msg.Attachments {
+		data, err := readAttachment(ctx, a)
+		if err != nil {
+			logger.ErrorCF("signal", "Failed to read attachment, sending as text", map[string]interface{}{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			params["message"] = appendContent(params["message"].(string), attachmentText(a))
+			continue
+		}
+		// Data URIs work when the daemon runs on another machine
+		attachments = append(attachments, fmt.Sprintf("data:%s;filename=%s;base64,%s",
+			a.MIME(), a.FileName(), base64.StdEncoding.EncodeToString(data)))
+	}
+	if len(attachments) > 0 {
+		params["attachments"] = attachments
+	}
+
+	if target, err := strconv.ParseInt(c.sent.editTarget(msg), 10, 64); err == nil {
+		params["editTimestamp"] = target
+	} else if timestamp, author, ok := parseSignalMessageID(msg.ReplyTo); ok {
+		params["quoteTimestamp"] = timestamp
+		params["quoteAuthor"] = author
+	}
+
+	var result struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+	if err := c.call(ctx, "send", params, &result); err != nil {
+		return err
+	}
+	c.sent.remember(msg.ChatID, strconv.FormatInt(result.Timestamp, 10))
+
+	logger.DebugCF("signal", "Message sent", map[string]interface{}{
+		"chat_id": msg.ChatID,
+	})
+	return nil
+}
+
+func (c *SignalChannel) react(ctx context.Context, msg bus.OutboundMessage) {
+	timestamp, author, ok := parseSignalMessageID(msg.ReplyTo)
+	if !ok {
+		return
+	}
+	for _, emoji := range msg.Reactions {
+		params, _ := signalRecipient(msg.ChatID)
+		params["emoji"] = emoji
+		params["targetAuthor"] = author
+		params["targetTimestamp"] = timestamp
+		if err := c.call(ctx, "sendReaction", params, nil); err != nil {
+			logger.WarnCF("signal", "Failed to send reaction", map[string]interface{}{
+				"emoji": emoji,
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// signalSenderID combines number and UUID so allow_from can list either.
+// Senders who hide their number only have a UUID.
+func signalSenderID(number, uuid string) string {
+	switch {
+	case number == "":
+		return uuid
+	case uuid == "":
+		return number
+	}
+	return number + "|" + uuid
+}
+
+func signalGroupChatID(groupID string) string {
+	return signalGroupPrefix + strings.NewReplacer("+", "-", "/", "_").Replace(groupID)
+}
+
+// signalRecipient returns the send parameters addressing a chat ID.
+func signalRecipient(chatID string) (map[string]interface{}, error) {
+	if chatID == "" {
+		return nil, fmt.Errorf("invalid signal chat ID: %s", chatID)
+	}
+	if encoded, ok := strings.CutPrefix(chatID, signalGroupPrefix); ok {
+		return map[string]interface{}{"groupId": strings.NewReplacer("-", "+", "_", "/").Replace(encoded)}, nil
+	}
+	return map[string]interface{}{"recipient": []string{chatID}}, nil
+}
+
+// signalMessageID identifies a message by its timestamp and author, which
+// is what quotes and reactions need.
+func signalMessageID(timestamp int64, author string) string {
+	return strconv.FormatInt(timestamp, 10) + ":" + author
+}
+
+func parseSignalMessageID(id string) (int64, string, bool) {
+	ts, author, ok := strings.Cut(id, ":")
+	if !ok || author == "" {
+		return 0, "", false
+	}
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return timestamp, author, true
+}
@@ -0,0 +1,221 @@
+package channels
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// fakeSignalDaemon speaks signal-cli's JSON-RPC over TCP. Calls are
+// answered by result, keyed by method, and recorded for the test.
+type fakeSignalDaemon struct {
+	t       *testing.T
+	l       net.Listener
+	conn    chan net.Conn
+	calls   chan signalCall
+	results map[string]string
+}
+
+type signalCall struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+func newFakeSignalDaemon(t *testing.T, results map[string]string) *fakeSignalDaemon {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	d := &fakeSignalDaemon{t: t, l: l, conn: make(chan net.Conn, 1), calls: make(chan signalCall, 20), results: results}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		d.conn <- conn
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 64<<10), 16<<20)
+		for scanner.Scan() {
+			var req struct {
+				ID int64 `json:"id"`
+				signalCall
+			}
+			json.Unmarshal(scanner.Bytes(), &req)
+			d.calls <- req.signalCall
+			result := d.results[req.Method]
+			if result == "" {
+				result = "{}"
+			}
+			conn.Write([]byte(`{"jsonrpc":"2.0","id":` + jsonNumber(req.ID) + `,"result":` + result + "}\n"))
+		}
+	}()
+	return d
+}
+
+func jsonNumber(n int64) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+// receive pushes a received envelope to the client.
+func (d *fakeSignalDaemon) receive(conn net.Conn, envelope string) {
+	var line bytes.Buffer
+	if err := json.Compact(&line, []byte(`{"jsonrpc":"2.0","method":"receive","params":{"envelope":`+envelope+"}}")); err != nil {
+		d.t.Fatalf("bad envelope: %v", err)
+	}
+	conn.Write(append(line.Bytes(), '\n'))
+}
+
+func (d *fakeSignalDaemon) expect(method string) signalCall {
+	d.t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case call := <-d.calls:
+			if call.Method == method {
+				return call
+			}
+		case <-timeout:
+			d.t.Fatalf("timed out waiting for %s", method)
+		}
+	}
+}
+
+func startSignalChannel(t *testing.T, d *fakeSignalDaemon) (*SignalChannel, *bus.MessageBus, net.Conn) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	ch, err := NewSignalChannel(config.SignalConfig{
+		Account:        "+15550000000",
+		Endpoint:       d.l.Addr().String(),
+		RequireMention: true,
+	}, mb, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSignalChannel error: %v", err)
+	}
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	t.Cleanup(func() { ch.Stop(context.Background()) })
+
+	var conn net.Conn
+	select {
+	case conn = <-d.conn:
+	case <-time.After(3 * time.Second):
+		t.Fatal("channel did not connect")
+	}
+	// Wait until the channel has registered the connection
+	for i := 0; i < 100; i++ {
+		ch.mu.Lock()
+		connected := ch.conn != nil
+		ch.mu.Unlock()
+		if connected {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ch, mb, conn
+}
+
+func TestSignalChannel_DirectMessageWithAttachment(t *testing.T) {
+	d := newFakeSignalDaemon(t, map[string]string{
+		"getAttachment": `{"data":"` + base64.StdEncoding.EncodeToString([]byte("PNGDATA")) + `"}`,
+		"send":          `{"timestamp":1700000000999}`,
+	})
+	ch, mb, conn := startSignalChannel(t, d)
+
+	// Receipts and our own messages are ignored
+	d.receive(conn, `{"sourceNumber":"+15551111111","sourceUuid":"u-1","timestamp":1,"receiptMessage":{}}`)
+	d.receive(conn, `{"sourceNumber":"+15550000000","timestamp":2,"dataMessage":{"timestamp":2,"message":"echo"}}`)
+	d.receive(conn, `{"sourceNumber":"+15551111111","sourceUuid":"u-1","sourceName":"Ana","timestamp":3,
+		"dataMessage":{"timestamp":3,"message":"what is this?","attachments":[{"id":"att1","contentType":"image/png","filename":"board.png"}]}}`)
+
+	if call := d.expect("getAttachment"); call.Params["id"] != "att1" || call.Params["recipient"] != "+15551111111" {
+		t.Errorf("getAttachment params = %v", call.Params)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "+15551111111" || msg.SenderID != "+15551111111|u-1" || msg.Content != "what is this?\n[image]" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+	if len(msg.Media) != 1 {
+		t.Fatalf("media = %v, want the saved image", msg.Media)
+	}
+	if data, _ := os.ReadFile(msg.Media[0]); string(data) != "PNGDATA" {
+		t.Errorf("saved attachment = %q", data)
+	}
+
+	// Replies quote the message, then edits target the reply
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: msg.ChatID, Content: "A **board**", ReplyTo: msg.Metadata["message_id"]}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	call := d.expect("send")
+	if call.Params["message"] != "A board" || call.Params["quoteAuthor"] != "+15551111111" || call.Params["quoteTimestamp"] != float64(3) {
+		t.Errorf("send params = %v", call.Params)
+	}
+	if call.Params["account"] != "+15550000000" {
+		t.Errorf("account = %v", call.Params["account"])
+	}
+	ch.Send(ctx, bus.OutboundMessage{ChatID: msg.ChatID, Content: "A dev board", EditMessageID: bus.EditLast})
+	if call := d.expect("send"); call.Params["editTimestamp"] != float64(1700000000999) {
+		t.Errorf("edit params = %v", call.Params)
+	}
+}
+
+func TestSignalChannel_GroupMentions(t *testing.T) {
+	d := newFakeSignalDaemon(t, nil)
+	ch, mb, conn := startSignalChannel(t, d)
+
+	group := `"groupInfo":{"groupId":"ab+c/d=="}`
+	d.receive(conn, `{"sourceNumber":"+15551111111","timestamp":1,"dataMessage":{"timestamp":1,"message":"lunch?",`+group+`}}`)
+	// "￼" placeholders mark mentions; offsets count UTF-16 units
+	d.receive(conn, `{"sourceNumber":"+15551111111","timestamp":2,"dataMessage":{"timestamp":2,
+		"message":"😀 ￼ ask ￼ about it",`+group+`,
+		"mentions":[{"name":"+15550000000","number":"+15550000000","start":3,"length":1},{"name":"Bo","number":"+15552222222","start":9,"length":1}]}}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.Content != "😀 ask @Bo about it" {
+		t.Fatalf("inbound = %+v, want only the mention", msg)
+	}
+	if msg.ChatID != "group.ab-c_d==" || msg.Metadata["is_dm"] != "false" {
+		t.Errorf("chat = %q, metadata = %v", msg.ChatID, msg.Metadata)
+	}
+
+	ch.Send(ctx, bus.OutboundMessage{ChatID: msg.ChatID, Content: "sure", Reactions: []string{"👍"}, ReplyTo: msg.Metadata["message_id"]})
+	if call := d.expect("sendReaction"); call.Params["groupId"] != "ab+c/d==" || call.Params["emoji"] != "👍" || call.Params["targetTimestamp"] != float64(2) {
+		t.Errorf("sendReaction params = %v", call.Params)
+	}
+	if call := d.expect("send"); call.Params["groupId"] != "ab+c/d==" || call.Params["recipient"] != nil {
+		t.Errorf("send params = %v", call.Params)
+	}
+}
+
+func TestSignalSenderID(t *testing.T) {
+	if got := signalSenderID("+1555", "u-1"); got != "+1555|u-1" {
+		t.Errorf("signalSenderID = %q", got)
+	}
+	if got := signalSenderID("", "u-1"); got != "u-1" {
+		t.Errorf("signalSenderID without number = %q", got)
+	}
+	if _, _, ok := parseSignalMessageID("notanid"); ok {
+		t.Error("parseSignalMessageID accepted an invalid ID")
+	}
+	if !strings.HasPrefix(signalGroupChatID("a/b"), signalGroupPrefix) {
+		t.Error("group chat ID lacks the prefix")
+	}
+}
@@ -1,9 +1,12 @@
 package channels
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +18,7 @@ import (
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
 	"github.com/sipeed/picoclaw/pkg/utils"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
@@ -29,9 +33,38 @@ type SlackChannel struct {
 	ctx          context.Context
 	cancel       context.CancelFunc
 	pendingAcks  sync.Map
+	sent         sentMessages
 	workspace    string
 }
 
+const (
+	// slackMaxLength is the maximum text length of a Block Kit section,
+	// which also keeps plain messages well under Slack's truncation point.
+	slackMaxLength = 3000
+	// slackContextLimit is the maximum number of elements in a context block
+	slackContextLimit = 10
+)
+
+// reSlackChannelID matches channel, DM and private channel IDs.
+var reSlackChannelID = regexp.MustCompile(`^[CDG][A-Z0-9]+$`)
+
+// slackReactionNames maps common emoji to Slack reaction names; anything
+// else is used as a name with surrounding colons removed.
+var slackReactionNames = map[string]string{
+	"👍":  "thumbsup",
+	"👎":  "thumbsdown",
+	"✅":  "white_check_mark",
+	"❌":  "x",
+	"👀":  "eyes",
+	"❤️": "heart",
+	"❤":  "heart",
+	"🎉":  "tada",
+	"🔥":  "fire",
+	"😂":  "joy",
+	"🙏":  "pray",
+	"🤔":  "thinking_face",
+}
+
 type slackMessageRef struct {
 	ChannelID string
 	Timestamp string
@@ -119,17 +152,30 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("invalid slack chat ID: %s", msg.ChatID)
 	}
 
-	opts := []slack.MsgOption{
-		slack.MsgOptionText(msg.Content, false),
+	if len(msg.Reactions) > 0 && msg.ReplyTo != "" {
+		c.react(ctx, channelID, msg)
 	}
 
-	if threadTS != "" {
-		opts = append(opts, slack.MsgOptionTS(threadTS))
+	if msg.Content != "" || (len(msg.Attachments) == 0 && len(msg.Reactions) == 0) {
+		if err := c.sendText(ctx, channelID, threadTS, msg); err != nil {
+			return err
+		}
 	}
 
-	_, _, err := c.api.PostMessageContext(ctx, channelID, opts...)
-	if err != nil {
-		return fmt.Errorf("failed to send slack message: %w", err)
+	for _, a := range msg.Attachments {
+		if err := c.uploadAttachment(ctx, channelID, threadTS, a); err != nil {
+			logger.ErrorCF("slack", "Failed to upload attachment, sending as text", map[string]interface{}{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			opts := []slack.MsgOption{slack.MsgOptionText(attachmentText(a), false)}
+			if threadTS != "" {
+				opts = append(opts, slack.MsgOptionTS(threadTS))
+			}
+			if _, _, err := c.api.PostMessageContext(ctx, channelID, opts...); err != nil {
+				return fmt.Errorf("failed to send slack message: %w", err)
+			}
+		}
 	}
 
 	if ref, ok := c.pendingAcks.LoadAndDelete(msg.ChatID); ok {
@@ -148,6 +194,173 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 	return nil
 }
 
+// sendText posts (or edits) the message text, with any buttons as a Block
+// Kit actions block. Replies to a message go into its thread.
+func (c *SlackChannel) sendText(ctx context.Context, channelID, threadTS string, msg bus.OutboundMessage) error {
+	if threadTS == "" {
+		threadTS = msg.ReplyTo
+	}
+
+	chunks := renderMessage(msg.Content, dialectSlackMrkdwn, slackMaxLength)
+	if len(chunks) == 0 {
+		chunks = []string{""}
+	}
+	target := c.sent.editTarget(msg)
+	parts := outbox.PartsFrom(ctx)
+	for i, chunk := range chunks {
+		if parts.Skip() {
+			continue
+		}
+		// The text is the notification fallback for the blocks. Buttons go
+		// under the last piece; the first may be an edit
+		opts := []slack.MsgOption{
+			slack.MsgOptionText(chunk, false),
+		}
+		var rows [][]bus.Button
+		if i == len(chunks)-1 {
+			rows = msg.Buttons
+		}
+		if blocks := slackBlocks(chunk, rows); len(blocks) > 0 {
+			opts = append(opts, slack.MsgOptionBlocks(blocks...))
+		}
+
+		if i == 0 && target != "" {
+			if _, _, _, err := c.api.UpdateMessageContext(ctx, channelID, target, opts...); err == nil {
+				c.sent.remember(msg.ChatID, target)
+				parts.Done()
+				continue
+			}
+			// Fallback to new message if edit fails
+		}
+
+		if threadTS != "" {
+			opts = append(opts, slack.MsgOptionTS(threadTS))
+		}
+		_, ts, err := c.api.PostMessageContext(ctx, channelID, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to send slack message: %w", err)
+		}
+		c.sent.remember(msg.ChatID, ts)
+		parts.Done()
+	}
+	return nil
+}
+
+// react adds the message's reactions to its ReplyTo message.
+func (c *SlackChannel) react(ctx context.Context, channelID string, msg bus.OutboundMessage) {
+	for _, emoji := range msg.Reactions {
+		name, ok := slackReactionNames[emoji]
+		if !ok {
+			name = strings.Trim(emoji, ":")
+		}
+		err := c.api.AddReactionContext(ctx, name, slack.ItemRef{
+			Channel:   channelID,
+			Timestamp: msg.ReplyTo,
+		})
+		if err != nil {
+			logger.WarnCF("slack", "Failed to add reaction", map[string]interface{}{
+				"reaction": name,
+				"error":    err.Error(),
+			})
+		}
+	}
+}
+
+// slackBlocks lays out rendered mrkdwn as Block Kit: text in sections,
+// each code block in a section of its own, "-# " lines as context, then
+// one actions block per button row.
+func slackBlocks(text string, rows [][]bus.Button) []slack.Block {
+	blocks := slackTextBlocks(text)
+	for i, row := range rows {
+		elements := make([]slack.BlockElement, 0, len(row))
+		for j, b := range row {
+			label := slack.NewTextBlockObject(slack.PlainTextType, b.Text, false, false)
+			actionID := fmt.Sprintf("button_%d_%d", i, j)
+			var button *slack.ButtonBlockElement
+			if b.URL != "" {
+				button = slack.NewButtonBlockElement(actionID, "", label).WithURL(b.URL)
+			} else {
+				button = slack.NewButtonBlockElement(actionID, b.Payload(), label)
+			}
+			elements = append(elements, button)
+		}
+		blocks = append(blocks, slack.NewActionBlock(fmt.Sprintf("buttons_%d", i), elements...))
+	}
+	return blocks
+}
+
+// slackTextBlocks splits mrkdwn into section and context blocks.
+func slackTextBlocks(text string) []slack.Block {
+	var blocks []slack.Block
+	var section []string
+	var context []slack.MixedElement
+	flushSection := func() {
+		if body := strings.Trim(strings.Join(section, "\n"), "\n"); strings.TrimSpace(body) != "" {
+			blocks = append(blocks, slackSection(body))
+		}
+		section = nil
+	}
+	flushContext := func() {
+		if len(context) > 0 {
+			blocks = append(blocks, slack.NewContextBlock("", context...))
+		}
+		context = nil
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, codeFence):
+			flushContext()
+			flushSection()
+			code := []string{line}
+			closed := len(line) > len(codeFence) && strings.HasSuffix(line, codeFence)
+			for !closed && i+1 < len(lines) {
+				i++
+				code = append(code, lines[i])
+				closed = strings.HasSuffix(lines[i], codeFence)
+			}
+			blocks = append(blocks, slackSection(strings.Join(code, "\n")))
+		case strings.HasPrefix(line, "-# "):
+			flushSection()
+			if len(context) == slackContextLimit {
+				flushContext()
+			}
+			context = append(context, slack.NewTextBlockObject(slack.MarkdownType, strings.TrimPrefix(line, "-# "), false, false))
+		default:
+			flushContext()
+			section = append(section, line)
+		}
+	}
+	flushContext()
+	flushSection()
+	return blocks
+}
+
+func slackSection(mrkdwn string) *slack.SectionBlock {
+	return slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, mrkdwn, false, false), nil, nil)
+}
+
+// uploadAttachment shares a file into the channel (and thread), with the
+// caption as its comment.
+func (c *SlackChannel) uploadAttachment(ctx context.Context, channelID, threadTS string, a bus.Attachment) error {
+	data, err := readAttachment(ctx, a)
+	if err != nil {
+		return err
+	}
+	_, err = c.api.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+		Reader:          bytes.NewReader(data),
+		FileSize:        len(data),
+		Filename:        a.FileName(),
+		Title:           a.FileName(),
+		InitialComment:  a.Caption,
+		Channel:         channelID,
+		ThreadTimestamp: threadTS,
+	})
+	return err
+}
+
 func (c *SlackChannel) eventLoop() {
 	for {
 		select {
@@ -163,9 +376,7 @@ func (c *SlackChannel) eventLoop() {
 			case socketmode.EventTypeSlashCommand:
 				c.handleSlashCommand(event)
 			case socketmode.EventTypeInteractive:
-				if event.Request != nil {
-					c.socketClient.Ack(*event.Request)
-				}
+				c.handleInteractive(event)
 			}
 		}
 	}
@@ -213,10 +424,7 @@ func (c *SlackChannel) handleMessageEvent(ev *slackevents.MessageEvent) {
 	threadTS := ev.ThreadTimeStamp
 	messageTS := ev.TimeStamp
 
-	chatID := channelID
-	if threadTS != "" {
-		chatID = channelID + "/" + threadTS
-	}
+	chatID := slackChatID(channelID, threadTS, messageTS)
 
 	c.api.AddReaction("eyes", slack.ItemRef{
 		Channel:   channelID,
@@ -280,6 +488,7 @@ func (c *SlackChannel) handleMessageEvent(ev *slackevents.MessageEvent) {
 	}
 
 	metadata := map[string]string{
+		"message_id": messageTS,
 		"message_ts": messageTS,
 		"channel_id": channelID,
 		"thread_ts":  threadTS,
@@ -306,12 +515,7 @@ func (c *SlackChannel) handleAppMention(ev *slackevents.AppMentionEvent) {
 	threadTS := ev.ThreadTimeStamp
 	messageTS := ev.TimeStamp
 
-	var chatID string
-	if threadTS != "" {
-		chatID = channelID + "/" + threadTS
-	} else {
-		chatID = channelID + "/" + messageTS
-	}
+	chatID := slackChatID(channelID, threadTS, messageTS)
 
 	c.api.AddReaction("eyes", slack.ItemRef{
 		Channel:   channelID,
@@ -330,6 +534,7 @@ func (c *SlackChannel) handleAppMention(ev *slackevents.AppMentionEvent) {
 	}
 
 	metadata := map[string]string{
+		"message_id": messageTS,
 		"message_ts": messageTS,
 		"channel_id": channelID,
 		"thread_ts":  threadTS,
@@ -352,13 +557,17 @@ func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
 
 	senderID := cmd.UserID
 	channelID := cmd.ChannelID
-	chatID := channelID
 	content := cmd.Text
 
 	if strings.TrimSpace(content) == "" {
 		content = "help"
 	}
 
+	chatID := channelID
+	if !isSlackDM(channelID) {
+		chatID = c.startCommandThread(cmd)
+	}
+
 	metadata := map[string]string{
 		"channel_id": channelID,
 		"platform":   "slack",
@@ -375,6 +584,176 @@ func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
 	c.HandleMessage(senderID, chatID, content, nil, metadata)
 }
 
+// startCommandThread posts who ran a slash command, giving the command a
+// thread, and so a session, of its own. It returns the thread's chat ID,
+// or the channel's when the post fails (e.g. the bot isn't in it).
+func (c *SlackChannel) startCommandThread(cmd slack.SlashCommand) string {
+	usage := fmt.Sprintf("<@%s> used `%s`", cmd.UserID, escapeSlack(cmd.Command))
+	blocks := []slack.Block{
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, usage, false, false)),
+	}
+	if text := strings.TrimSpace(cmd.Text); text != "" {
+		blocks = append([]slack.Block{slackSection(escapeSlack(text))}, blocks...)
+	}
+	_, ts, err := c.api.PostMessage(cmd.ChannelID,
+		slack.MsgOptionText(strings.TrimSpace(usage+" "+escapeSlack(cmd.Text)), false),
+		slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		logger.WarnCF("slack", "Failed to start a thread for slash command, answering in the channel", map[string]interface{}{
+			"channel_id": cmd.ChannelID,
+			"error":      err.Error(),
+		})
+		return cmd.ChannelID
+	}
+	return slackChatID(cmd.ChannelID, "", ts)
+}
+
+// handleInteractive turns Block Kit actions and modal submissions into
+// inbound messages.
+func (c *SlackChannel) handleInteractive(event socketmode.Event) {
+	if event.Request != nil {
+		c.socketClient.Ack(*event.Request)
+	}
+
+	callback, ok := event.Data.(slack.InteractionCallback)
+	if !ok {
+		return
+	}
+	switch callback.Type {
+	case slack.InteractionTypeBlockActions:
+		c.handleBlockActions(callback)
+	case slack.InteractionTypeViewSubmission:
+		c.handleViewSubmission(callback)
+	}
+}
+
+// handleBlockActions passes button clicks and menu, date and other picks
+// to the agent as the chosen value.
+func (c *SlackChannel) handleBlockActions(callback slack.InteractionCallback) {
+	channelID := callback.Channel.ID
+	if channelID == "" {
+		channelID = callback.Container.ChannelID
+	}
+	threadTS := callback.Container.ThreadTs
+	chatID := slackChatID(channelID, threadTS, callback.Container.MessageTs)
+	if callback.View.ID != "" {
+		chatID = c.viewChatID(callback)
+	}
+	if chatID == "" {
+		return
+	}
+
+	for _, action := range callback.ActionCallback.BlockActions {
+		// Link buttons report clicks too, without a value; there is
+		// nothing to send back
+		value := slackActionValue(*action)
+		if value == "" {
+			continue
+		}
+		metadata := map[string]string{
+			"message_ts": callback.Container.MessageTs,
+			"channel_id": channelID,
+			"thread_ts":  threadTS,
+			"platform":   "slack",
+			"action_id":  action.ActionID,
+			"trigger_id": callback.TriggerID,
+		}
+		if action.Type == slack.ActionType(slack.METButton) {
+			metadata["button_text"] = action.Text.Text
+		}
+		c.HandleButton(callback.User.ID, chatID, value, metadata)
+	}
+}
+
+// handleViewSubmission passes a submitted modal to the agent as its title
+// and one "label: value" line per field.
+func (c *SlackChannel) handleViewSubmission(callback slack.InteractionCallback) {
+	view := callback.View
+	if view.State == nil {
+		return
+	}
+	title := view.CallbackID
+	if view.Title != nil && view.Title.Text != "" {
+		title = view.Title.Text
+	}
+	lines := []string{fmt.Sprintf("[form: %s]", title)}
+
+	// Fields in the order the form shows them, then any it doesn't label
+	labels := make(map[string]string)
+	var order []string
+	for _, block := range view.Blocks.BlockSet {
+		if input, ok := block.(*slack.InputBlock); ok && input.Label != nil {
+			labels[input.BlockID] = input.Label.Text
+			order = append(order, input.BlockID)
+		}
+	}
+	var rest []string
+	for blockID := range view.State.Values {
+		if _, ok := labels[blockID]; !ok {
+			rest = append(rest, blockID)
+		}
+	}
+	sort.Strings(rest)
+	for _, blockID := range append(order, rest...) {
+		label := labels[blockID]
+		if label == "" {
+			label = blockID
+		}
+		actionIDs := make([]string, 0, len(view.State.Values[blockID]))
+		for actionID := range view.State.Values[blockID] {
+			actionIDs = append(actionIDs, actionID)
+		}
+		sort.Strings(actionIDs)
+		for _, actionID := range actionIDs {
+			lines = append(lines, label+": "+slackActionValue(view.State.Values[blockID][actionID]))
+		}
+	}
+
+	metadata := map[string]string{
+		"interaction": "form",
+		"callback_id": view.CallbackID,
+		"view_id":     view.ID,
+		"platform":    "slack",
+	}
+	c.HandleMessage(callback.User.ID, c.viewChatID(callback), strings.Join(lines, "\n"), nil, metadata)
+}
+
+// viewChatID is the chat a modal belongs to: the chat ID in its
+// private_metadata, else the user's DM with the app.
+func (c *SlackChannel) viewChatID(callback slack.InteractionCallback) string {
+	chatID := callback.View.PrivateMetadata
+	if channelID, _ := parseSlackChatID(chatID); reSlackChannelID.MatchString(channelID) {
+		return chatID
+	}
+	return callback.User.ID
+}
+
+// slackActionValue returns what an interactive element was set to, with
+// several choices separated by commas.
+func slackActionValue(action slack.BlockAction) string {
+	var values []string
+	for _, o := range action.SelectedOptions {
+		values = append(values, o.Value)
+	}
+	values = append(values, action.SelectedUsers...)
+	values = append(values, action.SelectedChannels...)
+	values = append(values, action.SelectedConversations...)
+	for _, v := range []string{
+		action.Value,
+		action.SelectedOption.Value,
+		action.SelectedUser,
+		action.SelectedChannel,
+		action.SelectedConversation,
+		action.SelectedDate,
+		action.SelectedTime,
+	} {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
 func (c *SlackChannel) downloadSlackFile(file slack.File) string {
 	downloadURL := file.URLPrivateDownload
 	if downloadURL == "" {
@@ -400,11 +779,28 @@ func (c *SlackChannel) stripBotMention(text string) string {
 	return strings.TrimSpace(text)
 }
 
+// slackChatID names the chat, and so the session, a message belongs to:
+// its thread, or the thread it starts. Outside threads a DM is a single
+// conversation.
+func slackChatID(channelID, threadTS, messageTS string) string {
+	if threadTS == "" && !isSlackDM(channelID) {
+		threadTS = messageTS
+	}
+	if threadTS == "" {
+		return channelID
+	}
+	return channelID + ":" + threadTS
+}
+
+func isSlackDM(channelID string) bool {
+	return strings.HasPrefix(channelID, "D")
+}
+
+// parseSlackChatID splits a chat ID into channel and thread. It also
+// takes the older "channel/thread" form, which session files can't hold.
 func parseSlackChatID(chatID string) (channelID, threadTS string) {
-	parts := strings.SplitN(chatID, "/", 2)
-	channelID = parts[0]
-	if len(parts) > 1 {
-		threadTS = parts[1]
+	if i := strings.IndexAny(chatID, ":/"); i >= 0 {
+		return chatID[:i], chatID[i+1:]
 	}
-	return
+	return chatID, ""
 }
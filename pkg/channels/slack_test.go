@@ -1,7 +1,20 @@
 package channels
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
@@ -22,6 +35,12 @@ func TestParseSlackChatID(t *testing.T) {
 		},
 		{
 			name:       "channel with thread",
+			chatID:     "C123456:1234567890.123456",
+			wantChanID: "C123456",
+			wantThread: "1234567890.123456",
+		},
+		{
+			name:       "channel with thread, older form",
 			chatID:     "C123456/1234567890.123456",
 			wantChanID: "C123456",
 			wantThread: "1234567890.123456",
@@ -172,3 +191,190 @@ func TestSlackChannelIsAllowed(t *testing.T) {
 		}
 	})
 }
+
+// fakeSlackAPI stubs the Web API methods the channel calls and records the
+// form each chat.postMessage was sent with.
+type fakeSlackAPI struct {
+	*httptest.Server
+	mu    sync.Mutex
+	posts []url.Values
+}
+
+func newFakeSlackAPI(t *testing.T) *fakeSlackAPI {
+	t.Helper()
+	f := &fakeSlackAPI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if path.Base(r.URL.Path) != "chat.postMessage" {
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		f.mu.Lock()
+		f.posts = append(f.posts, r.PostForm)
+		ts := fmt.Sprintf("1700000000.%06d", len(f.posts))
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "channel": r.PostForm.Get("channel"), "ts": ts})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSlackAPI) lastPost() url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.posts) == 0 {
+		return nil
+	}
+	return f.posts[len(f.posts)-1]
+}
+
+func newTestSlackChannel(t *testing.T, api *fakeSlackAPI) (*SlackChannel, *bus.MessageBus) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	ch, err := NewSlackChannel(config.SlackConfig{BotToken: "xoxb-test", AppToken: "xapp-test"}, mb, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSlackChannel error: %v", err)
+	}
+	ch.api = slack.New("xoxb-test", slack.OptionAPIURL(api.URL+"/"))
+	ch.botUserID = "UBOT"
+	ch.ctx = context.Background()
+	ch.setRunning(true)
+	return ch, mb
+}
+
+func consumeSlack(t *testing.T, mb *bus.MessageBus) bus.InboundMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	return msg
+}
+
+func TestSlackChannel_ThreadSessions(t *testing.T) {
+	api := newFakeSlackAPI(t)
+	ch, mb := newTestSlackChannel(t, api)
+
+	// A top-level channel message starts a thread; its app_mention twin
+	// is the same message
+	ch.handleMessageEvent(&slackevents.MessageEvent{User: "U1", Channel: "C1", TimeStamp: "100.1", Text: "<@UBOT> deploy?"})
+	ch.handleAppMention(&slackevents.AppMentionEvent{User: "U1", Channel: "C1", TimeStamp: "100.1", Text: "<@UBOT> deploy?"})
+	ch.handleMessageEvent(&slackevents.MessageEvent{User: "U1", Channel: "C1", TimeStamp: "100.2", ThreadTimeStamp: "100.1", Text: "to staging"})
+	ch.handleMessageEvent(&slackevents.MessageEvent{User: "U1", Channel: "D1", TimeStamp: "200.1", Text: "hi"})
+
+	for _, want := range []struct{ chatID, content string }{
+		{"C1:100.1", "deploy?"},
+		{"C1:100.1", "to staging"},
+		{"D1", "hi"},
+	} {
+		msg := consumeSlack(t, mb)
+		if msg.ChatID != want.chatID || msg.Content != want.content || msg.SessionKey != "slack:"+want.chatID {
+			t.Fatalf("inbound = %+v, want %q in %s", msg, want.content, want.chatID)
+		}
+	}
+
+	if err := ch.Send(context.Background(), bus.OutboundMessage{ChatID: "C1:100.1", Content: "Deploying now"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	post := api.lastPost()
+	if post.Get("channel") != "C1" || post.Get("thread_ts") != "100.1" || post.Get("blocks") == "" {
+		t.Errorf("reply = %v, want blocks in thread 100.1", post)
+	}
+}
+
+func TestSlackChannel_SlashCommandThread(t *testing.T) {
+	api := newFakeSlackAPI(t)
+	ch, mb := newTestSlackChannel(t, api)
+
+	ch.handleSlashCommand(socketmode.Event{Data: slack.SlashCommand{Command: "/ask", Text: "status?", UserID: "U1", ChannelID: "C1"}})
+	starter := api.lastPost()
+	if starter.Get("channel") != "C1" || starter.Get("thread_ts") != "" {
+		t.Fatalf("thread starter = %v", starter)
+	}
+	msg := consumeSlack(t, mb)
+	if msg.ChatID != "C1:1700000000.000001" || msg.Content != "status?" {
+		t.Errorf("inbound = %+v, want it in the starter's thread", msg)
+	}
+
+	// In a DM the command is part of the conversation
+	ch.handleSlashCommand(socketmode.Event{Data: slack.SlashCommand{Command: "/ask", Text: "and now?", UserID: "U1", ChannelID: "D1"}})
+	if msg := consumeSlack(t, mb); msg.ChatID != "D1" {
+		t.Errorf("DM command chat = %q, want D1", msg.ChatID)
+	}
+}
+
+func TestSlackChannel_Interactive(t *testing.T) {
+	api := newFakeSlackAPI(t)
+	ch, mb := newTestSlackChannel(t, api)
+
+	var actions slack.InteractionCallback
+	json.Unmarshal([]byte(`{
+		"type": "block_actions",
+		"user": {"id": "U1"},
+		"trigger_id": "trig",
+		"container": {"type": "message", "channel_id": "C1", "message_ts": "100.5", "thread_ts": "100.1"},
+		"actions": [
+			{"type": "button", "block_id": "buttons_0", "action_id": "button_0_0", "text": {"type": "plain_text", "text": "Open"}, "url": "https://example.com"},
+			{"type": "static_select", "block_id": "pick", "action_id": "env", "selected_option": {"value": "staging"}}
+		]
+	}`), &actions)
+	ch.handleInteractive(socketmode.Event{Data: actions})
+	msg := consumeSlack(t, mb)
+	if msg.ChatID != "C1:100.1" || msg.Content != "staging" || msg.Metadata["action_id"] != "env" || msg.Metadata["trigger_id"] != "trig" {
+		t.Errorf("select = %+v", msg)
+	}
+
+	var submission slack.InteractionCallback
+	json.Unmarshal([]byte(`{
+		"type": "view_submission",
+		"user": {"id": "U1"},
+		"view": {
+			"id": "V1",
+			"callback_id": "approve",
+			"title": {"type": "plain_text", "text": "Approve deploy"},
+			"private_metadata": "C1:100.1",
+			"blocks": [
+				{"type": "input", "block_id": "reason", "label": {"type": "plain_text", "text": "Reason"}, "element": {"type": "plain_text_input", "action_id": "in"}},
+				{"type": "input", "block_id": "when", "label": {"type": "plain_text", "text": "Date"}, "element": {"type": "datepicker", "action_id": "d"}}
+			],
+			"state": {"values": {
+				"when": {"d": {"type": "datepicker", "selected_date": "2026-10-20"}},
+				"reason": {"in": {"type": "plain_text_input", "value": "hotfix"}}
+			}}
+		}
+	}`), &submission)
+	ch.handleInteractive(socketmode.Event{Data: submission})
+	msg = consumeSlack(t, mb)
+	if msg.ChatID != "C1:100.1" || msg.Content != "[form: Approve deploy]\nReason: hotfix\nDate: 2026-10-20" || msg.Metadata["interaction"] != "form" {
+		t.Errorf("form = %+v", msg)
+	}
+
+	// Without a chat in private_metadata, the answer goes to the user's DM
+	submission.View.PrivateMetadata = `{"step":2}`
+	ch.handleInteractive(socketmode.Event{Data: submission})
+	if msg := consumeSlack(t, mb); msg.ChatID != "U1" {
+		t.Errorf("form chat = %q, want U1", msg.ChatID)
+	}
+}
+
+func TestSlackBlocks_Layout(t *testing.T) {
+	text := renderMarkdown("**Plan**\n\n```\nmake deploy\n```\nThen check.\n-# took 3s\n-# model: glm", dialectSlackMrkdwn)
+	blocks := slackBlocks(text, [][]bus.Button{{{Text: "Approve", Data: "approve"}}})
+
+	var types []string
+	for _, b := range blocks {
+		types = append(types, string(b.BlockType()))
+	}
+	if got := fmt.Sprint(types); got != "[section section section context actions]" {
+		t.Fatalf("block types = %s", got)
+	}
+	if code := blocks[1].(*slack.SectionBlock).Text.Text; code != "```\nmake deploy\n```" {
+		t.Errorf("code section = %q", code)
+	}
+	if n := len(blocks[3].(*slack.ContextBlock).ContextElements.Elements); n != 2 {
+		t.Errorf("context elements = %d, want 2", n)
+	}
+}
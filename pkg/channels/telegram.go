@@ -7,10 +7,11 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf16"
 
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
@@ -18,6 +19,7 @@ import (
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
 	"github.com/sipeed/picoclaw/pkg/utils"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
@@ -30,9 +32,19 @@ type TelegramChannel struct {
 	transcriber  *voice.GroqTranscriber
 	placeholders sync.Map // chatID -> messageID
 	stopThinking sync.Map // chatID -> thinkingCancel
-	workspace    string   // Workspace directory for file downloads
+	sent         sentMessages
+	payloads     buttonPayloads
+	workspace    string // Workspace directory for file downloads
 }
 
+const (
+	// telegramMaxLength is Telegram's 4096 character message limit, less
+	// headroom for characters counted twice (Telegram counts UTF-16 units)
+	telegramMaxLength = 4000
+	// telegramCallbackLimit is the maximum size of inline button callback data
+	telegramCallbackLimit = 64
+)
+
 type thinkingCancel struct {
 	fn context.CancelFunc
 }
@@ -95,6 +107,7 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 	logger.InfoCF("telegram", "Telegram bot connected", map[string]interface{}{
 		"username": c.bot.Username(),
 	})
+	c.registerCommands(ctx)
 
 	go func() {
 		for {
@@ -108,6 +121,8 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 				}
 				if update.Message != nil {
 					c.handleMessage(ctx, update)
+				} else if update.CallbackQuery != nil {
+					c.handleCallbackQuery(ctx, update.CallbackQuery)
 				}
 			}
 		}
@@ -116,6 +131,31 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 	return nil
 }
 
+// registerCommands publishes the configured commands as the bot's "/"
+// menu. An empty list leaves the menu set in BotFather alone.
+func (c *TelegramChannel) registerCommands(ctx context.Context) {
+	if len(c.config.Commands) == 0 {
+		return
+	}
+	commands := make([]telego.BotCommand, 0, len(c.config.Commands))
+	for _, cmd := range c.config.Commands {
+		name := strings.ToLower(strings.TrimPrefix(cmd.Command, "/"))
+		if name == "" {
+			continue
+		}
+		description := cmd.Description
+		if description == "" {
+			description = name
+		}
+		commands = append(commands, telego.BotCommand{Command: name, Description: description})
+	}
+	if err := c.bot.SetMyCommands(ctx, &telego.SetMyCommandsParams{Commands: commands}); err != nil {
+		logger.WarnCF("telegram", "Failed to register command menu", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
 func (c *TelegramChannel) Stop(ctx context.Context) error {
 	logger.InfoC("telegram", "Stopping Telegram bot...")
 	c.setRunning(false)
@@ -127,7 +167,7 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		return fmt.Errorf("telegram bot not running")
 	}
 
-	chatID, err := parseChatID(msg.ChatID)
+	chatID, threadID, err := parseChatID(msg.ChatID)
 	if err != nil {
 		return fmt.Errorf("invalid chat ID: %w", err)
 	}
@@ -140,33 +180,235 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		c.stopThinking.Delete(msg.ChatID)
 	}
 
-	htmlContent := markdownToTelegramHTML(msg.Content)
+	if len(msg.Reactions) > 0 {
+		c.react(ctx, chatID, msg)
+	}
 
-	// Try to edit placeholder
-	if pID, ok := c.placeholders.Load(msg.ChatID); ok {
+	if msg.Content != "" || (len(msg.Attachments) == 0 && len(msg.Reactions) == 0) {
+		if err := c.sendText(ctx, chatID, threadID, msg); err != nil {
+			return err
+		}
+	} else if pID, ok := c.placeholders.Load(msg.ChatID); ok {
+		// Attachment-only reply: the "Thinking..." placeholder has nothing to become
 		c.placeholders.Delete(msg.ChatID)
-		editMsg := tu.EditMessageText(tu.ID(chatID), pID.(int), htmlContent)
-		editMsg.ParseMode = telego.ModeHTML
+		c.bot.DeleteMessage(ctx, tu.Delete(tu.ID(chatID), pID.(int)))
+	}
 
-		if _, err = c.bot.EditMessageText(ctx, editMsg); err == nil {
-			return nil
+	parts := outbox.PartsFrom(ctx)
+	for _, a := range msg.Attachments {
+		if parts.Skip() {
+			continue
 		}
-		// Fallback to new message if edit fails
+		if err := c.sendAttachment(ctx, chatID, threadID, a); err != nil {
+			logger.ErrorCF("telegram", "Failed to upload attachment, sending as text", map[string]interface{}{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			if _, err := c.bot.SendMessage(ctx, tu.Message(tu.ID(chatID), attachmentText(a)).WithMessageThreadID(threadID)); err != nil {
+				return err
+			}
+		}
+		parts.Done()
 	}
 
-	tgMsg := tu.Message(tu.ID(chatID), htmlContent)
-	tgMsg.ParseMode = telego.ModeHTML
+	return nil
+}
 
-	if _, err = c.bot.SendMessage(ctx, tgMsg); err != nil {
-		logger.ErrorCF("telegram", "HTML parse failed, falling back to plain text", map[string]interface{}{
+func (c *TelegramChannel) sendText(ctx context.Context, chatID int64, threadID int, msg bus.OutboundMessage) error {
+	chunks := renderMessage(msg.Content, dialectTelegramHTML, telegramMaxLength)
+	keyboard := c.inlineKeyboard(msg.Buttons)
+
+	// Edit the requested message, else turn the placeholder into the reply.
+	// A reply to a specific message needs a new message, so the placeholder
+	// goes away instead. A retry edits what the first attempt picked, as
+	// the placeholder is gone by then.
+	target := c.sent.editTarget(msg)
+	if pID, ok := c.placeholders.LoadAndDelete(msg.ChatID); ok {
+		if target == "" && msg.ReplyTo == "" {
+			target = fmt.Sprintf("%d", pID.(int))
+		} else {
+			c.bot.DeleteMessage(ctx, tu.Delete(tu.ID(chatID), pID.(int)))
+		}
+	}
+	parts := outbox.PartsFrom(ctx)
+	target = parts.EditTarget(target)
+
+	for i, htmlContent := range chunks {
+		if parts.Skip() {
+			continue
+		}
+		// Buttons go under the last piece; the first is the edit or reply
+		var markup *telego.InlineKeyboardMarkup
+		if i == len(chunks)-1 {
+			markup = keyboard
+		}
+		if i == 0 && c.editText(ctx, chatID, target, htmlContent, markup) {
+			c.sent.remember(msg.ChatID, target)
+			parts.Done()
+			continue
+		}
+
+		tgMsg := tu.Message(tu.ID(chatID), htmlContent).WithMessageThreadID(threadID)
+		tgMsg.ParseMode = telego.ModeHTML
+		if markup != nil {
+			tgMsg.ReplyMarkup = markup
+		}
+		if replyTo, err := strconv.Atoi(msg.ReplyTo); err == nil && i == 0 {
+			tgMsg.ReplyParameters = &telego.ReplyParameters{MessageID: replyTo, AllowSendingWithoutReply: true}
+		}
+
+		sent, err := c.bot.SendMessage(ctx, tgMsg)
+		if err != nil {
+			logger.ErrorCF("telegram", "HTML parse failed, falling back to plain text", map[string]interface{}{
+				"error": err.Error(),
+			})
+			tgMsg.ParseMode = ""
+			if sent, err = c.bot.SendMessage(ctx, tgMsg); err != nil {
+				return err
+			}
+		}
+		c.sent.remember(msg.ChatID, fmt.Sprintf("%d", sent.MessageID))
+		parts.Done()
+	}
+
+	return nil
+}
+
+// editText replaces the text of message target, reporting whether it
+// worked. An empty or invalid target is never edited.
+func (c *TelegramChannel) editText(ctx context.Context, chatID int64, target, htmlContent string, markup *telego.InlineKeyboardMarkup) bool {
+	messageID, err := strconv.Atoi(target)
+	if err != nil {
+		return false
+	}
+	editMsg := tu.EditMessageText(tu.ID(chatID), messageID, htmlContent)
+	editMsg.ParseMode = telego.ModeHTML
+	editMsg.ReplyMarkup = markup
+	_, err = c.bot.EditMessageText(ctx, editMsg)
+	return err == nil
+}
+
+// react sets the message's reactions on its ReplyTo message. Telegram only
+// accepts a fixed set of emoji; failures are logged, not returned.
+func (c *TelegramChannel) react(ctx context.Context, chatID int64, msg bus.OutboundMessage) {
+	messageID, err := strconv.Atoi(msg.ReplyTo)
+	if err != nil {
+		return
+	}
+	reactions := make([]telego.ReactionType, 0, len(msg.Reactions))
+	for _, emoji := range msg.Reactions {
+		reactions = append(reactions, &telego.ReactionTypeEmoji{Type: telego.ReactionEmoji, Emoji: emoji})
+	}
+	err = c.bot.SetMessageReaction(ctx, &telego.SetMessageReactionParams{
+		ChatID:    tu.ID(chatID),
+		MessageID: messageID,
+		Reaction:  reactions,
+	})
+	if err != nil {
+		logger.WarnCF("telegram", "Failed to set reaction", map[string]interface{}{
+			"message_id": messageID,
+			"error":      err.Error(),
+		})
+	}
+}
+
+// inlineKeyboard converts button rows to an inline keyboard, or nil when
+// there are none.
+func (c *TelegramChannel) inlineKeyboard(rows [][]bus.Button) *telego.InlineKeyboardMarkup {
+	if len(rows) == 0 {
+		return nil
+	}
+	keyboard := make([][]telego.InlineKeyboardButton, 0, len(rows))
+	for _, row := range rows {
+		buttons := make([]telego.InlineKeyboardButton, 0, len(row))
+		for _, b := range row {
+			button := tu.InlineKeyboardButton(b.Text)
+			if b.URL != "" {
+				button = button.WithURL(b.URL)
+			} else {
+				button = button.WithCallbackData(c.payloads.encode(b.Payload(), telegramCallbackLimit))
+			}
+			buttons = append(buttons, button)
+		}
+		keyboard = append(keyboard, buttons)
+	}
+	return tu.InlineKeyboard(keyboard...)
+}
+
+// handleCallbackQuery turns an inline button click into an inbound message.
+func (c *TelegramChannel) handleCallbackQuery(ctx context.Context, query *telego.CallbackQuery) {
+	// Stops the button's loading spinner in the client
+	if err := c.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID)); err != nil {
+		logger.DebugCF("telegram", "Failed to answer callback query", map[string]interface{}{
 			"error": err.Error(),
 		})
-		tgMsg.ParseMode = ""
-		_, err = c.bot.SendMessage(ctx, tgMsg)
-		return err
+	}
+	if query.Message == nil || query.Data == "" {
+		return
+	}
+
+	user := query.From
+	userID := fmt.Sprintf("%d", user.ID)
+	senderID := userID
+	if user.Username != "" {
+		senderID = fmt.Sprintf("%s|%s", userID, user.Username)
+	}
+	if !c.IsAllowed(userID) && !c.IsAllowed(senderID) {
+		logger.DebugCF("telegram", "Button click rejected by allowlist", map[string]interface{}{
+			"user_id":  userID,
+			"username": user.Username,
+		})
+		return
 	}
 
-	return nil
+	chat := query.Message.GetChat()
+	threadID := 0
+	if m, ok := query.Message.(*telego.Message); ok {
+		threadID = topicThreadID(m)
+	}
+	metadata := map[string]string{
+		"message_id": fmt.Sprintf("%d", query.Message.GetMessageID()),
+		"user_id":    userID,
+		"username":   user.Username,
+		"first_name": user.FirstName,
+		"is_group":   fmt.Sprintf("%t", chat.Type != "private"),
+	}
+
+	c.HandleButton(senderID, telegramChatID(chat.ID, threadID), c.payloads.decode(query.Data), metadata)
+}
+
+// sendAttachment uploads a file with the method matching its kind: photos,
+// voice notes (OGG/Opus), audio, video, and documents for everything else.
+func (c *TelegramChannel) sendAttachment(ctx context.Context, chatID int64, threadID int, a bus.Attachment) error {
+	var file telego.InputFile
+	if a.Path != "" {
+		f, err := os.Open(a.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		file = tu.File(f)
+	} else {
+		file = tu.FileFromURL(a.URL)
+	}
+
+	id := tu.ID(chatID)
+	var err error
+	switch a.Kind() {
+	case bus.AttachmentImage:
+		_, err = c.bot.SendPhoto(ctx, tu.Photo(id, file).WithCaption(a.Caption).WithMessageThreadID(threadID))
+	case bus.AttachmentAudio:
+		if a.MIME() == "audio/ogg" {
+			_, err = c.bot.SendVoice(ctx, tu.Voice(id, file).WithCaption(a.Caption).WithMessageThreadID(threadID))
+		} else {
+			_, err = c.bot.SendAudio(ctx, tu.Audio(id, file).WithCaption(a.Caption).WithMessageThreadID(threadID))
+		}
+	case bus.AttachmentVideo:
+		_, err = c.bot.SendVideo(ctx, tu.Video(id, file).WithCaption(a.Caption).WithMessageThreadID(threadID))
+	default:
+		_, err = c.bot.SendDocument(ctx, tu.Document(id, file).WithCaption(a.Caption).WithMessageThreadID(threadID))
+	}
+	return err
 }
 
 func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Update) {
@@ -196,9 +438,34 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Updat
 	}
 
 	chatID := message.Chat.ID
+	threadID := topicThreadID(message)
+	chatIDStr := telegramChatID(chatID, threadID)
+	isGroup := message.Chat.Type != "private"
+
+	text, mentioned := c.resolveMentions(message.Text, message.Entities)
+	caption, captionMentioned := c.resolveMentions(message.Caption, message.CaptionEntities)
+	content := text
+	if caption != "" {
+		if content != "" {
+			content += "\n"
+		}
+		content += caption
+	}
+
+	// In groups, skip messages meant for others before downloading anything
+	if isGroup && c.config.RequireMention && !mentioned && !captionMentioned && !c.repliesToBot(message) {
+		triggered, stripped := c.checkGroupTrigger(content)
+		if !triggered {
+			logger.DebugCF("telegram", "Ignoring group message not addressed to the bot", map[string]interface{}{
+				"chat_id": chatIDStr,
+			})
+			return
+		}
+		content = stripped
+	}
+
 	c.chatIDs[senderID] = chatID
 
-	content := ""
 	mediaPaths := []string{}
 	localFiles := []string{} // 跟踪需要清理的本地文件
 
@@ -218,17 +485,6 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Updat
 		}
 	}()
 
-	if message.Text != "" {
-		content += message.Text
-	}
-
-	if message.Caption != "" {
-		if content != "" {
-			content += "\n"
-		}
-		content += message.Caption
-	}
-
 	if message.Photo != nil && len(message.Photo) > 0 {
 		photo := message.Photo[len(message.Photo)-1]
 		photoPath := c.downloadPhoto(ctx, photo.FileID)
@@ -307,12 +563,12 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Updat
 
 	logger.DebugCF("telegram", "Received message", map[string]interface{}{
 		"sender_id": senderID,
-		"chat_id":   fmt.Sprintf("%d", chatID),
+		"chat_id":   chatIDStr,
 		"preview":   utils.Truncate(content, 50),
 	})
 
 	// Thinking indicator
-	err := c.bot.SendChatAction(ctx, tu.ChatAction(tu.ID(chatID), telego.ChatActionTyping))
+	err := c.bot.SendChatAction(ctx, tu.ChatAction(tu.ID(chatID), telego.ChatActionTyping).WithMessageThreadID(threadID))
 	if err != nil {
 		logger.ErrorCF("telegram", "Failed to send chat action", map[string]interface{}{
 			"error": err.Error(),
@@ -320,7 +576,6 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Updat
 	}
 
 	// Stop any previous thinking animation
-	chatIDStr := fmt.Sprintf("%d", chatID)
 	if prevStop, ok := c.stopThinking.Load(chatIDStr); ok {
 		if cf, ok := prevStop.(*thinkingCancel); ok && cf != nil {
 			cf.Cancel()
@@ -331,7 +586,7 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Updat
 	_, thinkCancel := context.WithTimeout(ctx, 5*time.Minute)
 	c.stopThinking.Store(chatIDStr, &thinkingCancel{fn: thinkCancel})
 
-	pMsg, err := c.bot.SendMessage(ctx, tu.Message(tu.ID(chatID), "Thinking... 💭"))
+	pMsg, err := c.bot.SendMessage(ctx, tu.Message(tu.ID(chatID), "Thinking... 💭").WithMessageThreadID(threadID))
 	if err == nil {
 		pID := pMsg.MessageID
 		c.placeholders.Store(chatIDStr, pID)
@@ -342,10 +597,87 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Updat
 		"user_id":    fmt.Sprintf("%d", user.ID),
 		"username":   user.Username,
 		"first_name": user.FirstName,
-		"is_group":   fmt.Sprintf("%t", message.Chat.Type != "private"),
+		"is_group":   fmt.Sprintf("%t", isGroup),
 	}
 
-	c.HandleMessage(senderID, fmt.Sprintf("%d", chatID), content, mediaPaths, metadata)
+	c.HandleMessage(senderID, chatIDStr, content, mediaPaths, metadata)
+}
+
+// resolveMentions removes the bot's @mentions from text and the @bot
+// suffix from its commands ("/help@picobot" becomes "/help"), reporting
+// whether the message was addressed to the bot. A bare command counts
+// when it is one of the configured ones.
+func (c *TelegramChannel) resolveMentions(text string, entities []telego.MessageEntity) (string, bool) {
+	if text == "" {
+		return text, false
+	}
+	username := c.bot.Username()
+	units := utf16.Encode([]rune(text))
+	addressed := false
+	// Back to front, so earlier offsets stay valid as text is cut out
+	for i := len(entities) - 1; i >= 0; i-- {
+		e := entities[i]
+		if e.Offset < 0 || e.Length <= 0 || e.Offset+e.Length > len(units) {
+			continue
+		}
+		part := string(utf16.Decode(units[e.Offset : e.Offset+e.Length]))
+		replacement, ok := "", false
+		switch e.Type {
+		case "mention":
+			ok = username != "" && strings.EqualFold(part, "@"+username)
+		case "text_mention":
+			ok = e.User != nil && e.User.ID == c.bot.ID()
+		case "bot_command":
+			command, target, hasTarget := strings.Cut(part, "@")
+			if hasTarget {
+				ok = username != "" && strings.EqualFold(target, username)
+				replacement = command
+			} else if e.Offset == 0 && c.isCommand(command) {
+				addressed = true
+			}
+		}
+		if !ok {
+			continue
+		}
+		addressed = true
+		units = append(units[:e.Offset], append(utf16.Encode([]rune(replacement)), units[e.Offset+e.Length:]...)...)
+	}
+	return strings.TrimSpace(string(utf16.Decode(units))), addressed
+}
+
+// isCommand reports whether command, with its slash, is in the menu.
+func (c *TelegramChannel) isCommand(command string) bool {
+	for _, cmd := range c.config.Commands {
+		if strings.EqualFold(strings.TrimPrefix(command, "/"), strings.TrimPrefix(cmd.Command, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// repliesToBot reports whether the message replies to one of the bot's
+// messages. In forum topics every message replies to the topic's first
+// message, which doesn't count.
+func (c *TelegramChannel) repliesToBot(message *telego.Message) bool {
+	reply := message.ReplyToMessage
+	if reply == nil || reply.From == nil || reply.ForumTopicCreated != nil {
+		return false
+	}
+	return reply.From.ID == c.bot.ID()
+}
+
+// checkGroupTrigger reports whether content starts with one of the
+// configured trigger prefixes, and returns it without the prefix.
+func (c *TelegramChannel) checkGroupTrigger(content string) (bool, string) {
+	for _, prefix := range c.config.GroupTriggerPrefix {
+		if prefix == "" {
+			continue
+		}
+		if strings.HasPrefix(content, prefix) {
+			return true, strings.TrimSpace(strings.TrimPrefix(content, prefix))
+		}
+	}
+	return false, content
 }
 
 func (c *TelegramChannel) downloadPhoto(ctx context.Context, fileID string) string {
@@ -394,112 +726,32 @@ func (c *TelegramChannel) downloadFile(ctx context.Context, fileID, ext string)
 	return c.downloadFileWithInfo(file, ext)
 }
 
-func parseChatID(chatIDStr string) (int64, error) {
-	var id int64
-	_, err := fmt.Sscanf(chatIDStr, "%d", &id)
-	return id, err
-}
-
-func markdownToTelegramHTML(text string) string {
-	if text == "" {
-		return ""
-	}
-
-	codeBlocks := extractCodeBlocks(text)
-	text = codeBlocks.text
-
-	inlineCodes := extractInlineCodes(text)
-	text = inlineCodes.text
-
-	text = regexp.MustCompile(`^#{1,6}\s+(.+)$`).ReplaceAllString(text, "$1")
-
-	text = regexp.MustCompile(`^>\s*(.*)$`).ReplaceAllString(text, "$1")
-
-	text = escapeHTML(text)
-
-	text = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`).ReplaceAllString(text, `<a href="$2">$1</a>`)
-
-	text = regexp.MustCompile(`\*\*(.+?)\*\*`).ReplaceAllString(text, "<b>$1</b>")
-
-	text = regexp.MustCompile(`__(.+?)__`).ReplaceAllString(text, "<b>$1</b>")
-
-	reItalic := regexp.MustCompile(`_([^_]+)_`)
-	text = reItalic.ReplaceAllStringFunc(text, func(s string) string {
-		match := reItalic.FindStringSubmatch(s)
-		if len(match) < 2 {
-			return s
-		}
-		return "<i>" + match[1] + "</i>"
-	})
-
-	text = regexp.MustCompile(`~~(.+?)~~`).ReplaceAllString(text, "<s>$1</s>")
-
-	text = regexp.MustCompile(`^[-*]\s+`).ReplaceAllString(text, "• ")
-
-	for i, code := range inlineCodes.codes {
-		escaped := escapeHTML(code)
-		text = strings.ReplaceAll(text, fmt.Sprintf("\x00IC%d\x00", i), fmt.Sprintf("<code>%s</code>", escaped))
+// topicThreadID returns the forum topic a message belongs to, or 0. Only
+// topic messages count: replies elsewhere carry a thread ID too.
+func topicThreadID(message *telego.Message) int {
+	if !message.IsTopicMessage {
+		return 0
 	}
-
-	for i, code := range codeBlocks.codes {
-		escaped := escapeHTML(code)
-		text = strings.ReplaceAll(text, fmt.Sprintf("\x00CB%d\x00", i), fmt.Sprintf("<pre><code>%s</code></pre>", escaped))
-	}
-
-	return text
+	return message.MessageThreadID
 }
 
-type codeBlockMatch struct {
-	text  string
-	codes []string
-}
-
-func extractCodeBlocks(text string) codeBlockMatch {
-	re := regexp.MustCompile("```[\\w]*\\n?([\\s\\S]*?)```")
-	matches := re.FindAllStringSubmatch(text, -1)
-
-	codes := make([]string, 0, len(matches))
-	for _, match := range matches {
-		codes = append(codes, match[1])
+// telegramChatID builds the chat ID the agent sees. Each forum topic is a
+// chat of its own, "<chat>:<topic>", so it gets its own session.
+func telegramChatID(chatID int64, threadID int) string {
+	if threadID == 0 {
+		return fmt.Sprintf("%d", chatID)
 	}
-
-	i := 0
-	text = re.ReplaceAllStringFunc(text, func(m string) string {
-		placeholder := fmt.Sprintf("\x00CB%d\x00", i)
-		i++
-		return placeholder
-	})
-
-	return codeBlockMatch{text: text, codes: codes}
-}
-
-type inlineCodeMatch struct {
-	text  string
-	codes []string
+	return fmt.Sprintf("%d:%d", chatID, threadID)
 }
 
-func extractInlineCodes(text string) inlineCodeMatch {
-	re := regexp.MustCompile("`([^`]+)`")
-	matches := re.FindAllStringSubmatch(text, -1)
-
-	codes := make([]string, 0, len(matches))
-	for _, match := range matches {
-		codes = append(codes, match[1])
+// parseChatID splits a chat ID into the chat and its forum topic, which
+// is 0 outside forums.
+func parseChatID(chatIDStr string) (int64, int, error) {
+	chat, topic, hasTopic := strings.Cut(chatIDStr, ":")
+	id, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil || !hasTopic {
+		return id, 0, err
 	}
-
-	i := 0
-	text = re.ReplaceAllStringFunc(text, func(m string) string {
-		placeholder := fmt.Sprintf("\x00IC%d\x00", i)
-		i++
-		return placeholder
-	})
-
-	return inlineCodeMatch{text: text, codes: codes}
-}
-
-func escapeHTML(text string) string {
-	text = strings.ReplaceAll(text, "&", "&amp;")
-	text = strings.ReplaceAll(text, "<", "&lt;")
-	text = strings.ReplaceAll(text, ">", "&gt;")
-	return text
+	threadID, err := strconv.Atoi(topic)
+	return id, threadID, err
 }
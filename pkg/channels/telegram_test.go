@@ -0,0 +1,213 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mymmrac/telego"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+const testTelegramToken = "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+// fakeTelegramAPI stubs the Bot API methods the channel calls and records
+// each call's parameters.
+type fakeTelegramAPI struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []telegramCall
+}
+
+type telegramCall struct {
+	method string
+	params map[string]interface{}
+}
+
+func newFakeTelegramAPI(t *testing.T) *fakeTelegramAPI {
+	t.Helper()
+	f := &fakeTelegramAPI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := path.Base(r.URL.Path)
+		params := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&params)
+		f.mu.Lock()
+		f.calls = append(f.calls, telegramCall{method: method, params: params})
+		n := len(f.calls)
+		f.mu.Unlock()
+
+		var result interface{} = true
+		switch method {
+		case "getMe":
+			result = map[string]interface{}{"id": 42, "is_bot": true, "first_name": "Pico", "username": "picobot"}
+		case "sendMessage":
+			result = map[string]interface{}{"message_id": 1000 + n, "date": 0, "chat": map[string]interface{}{"id": params["chat_id"], "type": "supergroup"}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// called returns the parameters of each call to method.
+func (f *fakeTelegramAPI) called(method string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []map[string]interface{}
+	for _, c := range f.calls {
+		if c.method == method {
+			out = append(out, c.params)
+		}
+	}
+	return out
+}
+
+func newTestTelegramChannel(t *testing.T, api *fakeTelegramAPI, cfg config.TelegramConfig) (*TelegramChannel, *bus.MessageBus) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	cfg.Token = testTelegramToken
+	ch, err := NewTelegramChannel(cfg, mb, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewTelegramChannel error: %v", err)
+	}
+	ch.bot, err = telego.NewBot(testTelegramToken, telego.WithAPIServer(api.URL), telego.WithHTTPClient(api.Client()), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatalf("NewBot error: %v", err)
+	}
+	ch.setRunning(true)
+	return ch, mb
+}
+
+func telegramUpdate(id int, chat telego.Chat, text string, entities ...telego.MessageEntity) telego.Update {
+	return telego.Update{Message: &telego.Message{
+		MessageID: id,
+		From:      &telego.User{ID: 7, FirstName: "Ann", Username: "ann"},
+		Chat:      chat,
+		Text:      text,
+		Entities:  entities,
+	}}
+}
+
+func TestTelegramChannel_GroupGating(t *testing.T) {
+	api := newFakeTelegramAPI(t)
+	ch, mb := newTestTelegramChannel(t, api, config.TelegramConfig{
+		RequireMention:     true,
+		GroupTriggerPrefix: []string{"!ai"},
+		Commands:           []config.TelegramCommand{{Command: "help", Description: "Help"}},
+	})
+	group := telego.Chat{ID: -100, Type: "supergroup"}
+
+	botMessage := &telego.Message{MessageID: 5, From: &telego.User{ID: 42, IsBot: true}, Chat: group}
+	reply := telegramUpdate(4, group, "and tomorrow?")
+	reply.Message.ReplyToMessage = botMessage
+
+	updates := []telego.Update{
+		telegramUpdate(1, group, "hello all"),
+		telegramUpdate(2, group, "/ban@otherbot", telego.MessageEntity{Type: "bot_command", Offset: 0, Length: 13}),
+		telegramUpdate(3, group, "@PicoBot what time is it?", telego.MessageEntity{Type: "mention", Offset: 0, Length: 8}),
+		reply,
+		telegramUpdate(6, group, "!ai weather"),
+		telegramUpdate(7, group, "/help@picobot", telego.MessageEntity{Type: "bot_command", Offset: 0, Length: 13}),
+		telegramUpdate(8, group, "/help", telego.MessageEntity{Type: "bot_command", Offset: 0, Length: 5}),
+		telegramUpdate(9, telego.Chat{ID: 7, Type: "private"}, "hi"),
+	}
+	for _, u := range updates {
+		ch.handleMessage(context.Background(), u)
+	}
+
+	want := []struct{ chatID, content string }{
+		{"-100", "what time is it?"},
+		{"-100", "and tomorrow?"},
+		{"-100", "weather"},
+		{"-100", "/help"},
+		{"-100", "/help"},
+		{"7", "hi"},
+	}
+	for _, w := range want {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		msg, ok := mb.ConsumeInbound(ctx)
+		cancel()
+		if !ok || msg.ChatID != w.chatID || msg.Content != w.content {
+			t.Fatalf("inbound = %+v, want %q in %s", msg, w.content, w.chatID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if msg, ok := mb.ConsumeInbound(ctx); ok {
+		t.Errorf("unexpected inbound %+v", msg)
+	}
+}
+
+func TestTelegramChannel_ForumTopics(t *testing.T) {
+	api := newFakeTelegramAPI(t)
+	ch, mb := newTestTelegramChannel(t, api, config.TelegramConfig{})
+
+	update := telegramUpdate(1, telego.Chat{ID: -100, Type: "supergroup", IsForum: true}, "status?")
+	update.Message.IsTopicMessage = true
+	update.Message.MessageThreadID = 77
+	ch.handleMessage(context.Background(), update)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "-100:77" {
+		t.Fatalf("inbound = %+v, want chat -100:77", msg)
+	}
+	if calls := api.called("sendChatAction"); len(calls) != 1 || calls[0]["message_thread_id"] != float64(77) {
+		t.Errorf("typing = %v, want it in topic 77", calls)
+	}
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "-100:78", Content: "all good"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	sent := api.called("sendMessage")
+	last := sent[len(sent)-1]
+	if last["chat_id"] != float64(-100) || last["message_thread_id"] != float64(78) || last["text"] != "all good" {
+		t.Errorf("reply = %v, want it in topic 78", last)
+	}
+}
+
+func TestTelegramChannel_RegisterCommands(t *testing.T) {
+	api := newFakeTelegramAPI(t)
+	ch, _ := newTestTelegramChannel(t, api, config.TelegramConfig{
+		Commands: []config.TelegramCommand{{Command: "/Summary", Description: "Summarize the chat"}, {Command: "help"}},
+	})
+	ch.registerCommands(context.Background())
+
+	calls := api.called("setMyCommands")
+	if len(calls) != 1 {
+		t.Fatalf("setMyCommands calls = %v", calls)
+	}
+	got, _ := json.Marshal(calls[0]["commands"])
+	want := `[{"command":"summary","description":"Summarize the chat"},{"command":"help","description":"help"}]`
+	if string(got) != want {
+		t.Errorf("commands = %s, want %s", got, want)
+	}
+}
+
+func TestParseTelegramChatID(t *testing.T) {
+	tests := []struct {
+		in     string
+		chat   int64
+		thread int
+		err    bool
+	}{
+		{"123", 123, 0, false},
+		{"-1001234:56", -1001234, 56, false},
+		{"-100:x", 0, 0, true},
+		{"abc", 0, 0, true},
+	}
+	for _, tt := range tests {
+		chat, thread, err := parseChatID(tt.in)
+		if (err != nil) != tt.err || (!tt.err && (chat != tt.chat || thread != tt.thread)) {
+			t.Errorf("parseChatID(%q) = %d, %d, %v", tt.in, chat, thread, err)
+		}
+	}
+}
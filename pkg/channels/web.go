@@ -0,0 +1,771 @@
+package channels
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/session"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+//go:embed web/index.html
+var webAssets embed.FS
+
+const (
+	webCookieName = "picoclaw_web"
+	webCookieTTL  = 30 * 24 * time.Hour
+	webMaxUpload  = 20 << 20
+	webMaxFrame   = 64 << 10
+	// webMaxPending caps the messages kept for a user with no page open.
+	webMaxPending = 50
+	webPingPeriod = 30 * time.Second
+)
+
+var webSessionPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// WebChannel implements the Channel interface for a browser chat page
+// served on the gateway port. Pages talk to it over a WebSocket; each
+// user can keep several conversations ("sessions"), and sees the agent's
+// tool calls while it works.
+type WebChannel struct {
+	*BaseChannel
+	config     config.WebConfig
+	users      map[string]string // user name -> login token, when set per user
+	cookieKey  []byte
+	addr       string
+	workspace  string
+	httpServer *http.Server
+	upgrader   websocket.Upgrader
+	sent       sentMessages
+	nextID     atomic.Uint64
+	uploads    sync.Map // upload ID -> webFile waiting to be sent
+	files      sync.Map // file ID -> webFile offered for download
+
+	mu      sync.Mutex
+	clients map[string]map[*webClient]struct{} // user -> open pages
+	pending map[string][]webEvent              // user -> messages sent while no page was open
+}
+
+// webClient is one open page.
+type webClient struct {
+	conn *websocket.Conn
+	user string
+	out  chan webEvent
+}
+
+type webFile struct {
+	user string
+	path string
+}
+
+// webEvent is a frame sent to the page. Type is "message", "edit",
+// "reaction", "echo" (the user's own message from another page) or one of
+// the bus.Event* types.
+type webEvent struct {
+	Type        string          `json:"type"`
+	Session     string          `json:"session,omitempty"`
+	ID          string          `json:"id,omitempty"`
+	Content     string          `json:"content,omitempty"`
+	HTML        string          `json:"html,omitempty"`
+	Tool        string          `json:"tool,omitempty"`
+	Args        string          `json:"args,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Buttons     [][]bus.Button  `json:"buttons,omitempty"`
+	Attachments []webAttachment `json:"attachments,omitempty"`
+}
+
+type webAttachment struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Kind string `json:"kind"`
+}
+
+// webFrame is a frame received from the page.
+type webFrame struct {
+	Type    string   `json:"type"` // "message" or "button"
+	Session string   `json:"session"`
+	ID      string   `json:"id"`
+	Content string   `json:"content"`
+	Media   []string `json:"media"` // upload IDs
+	Data    string   `json:"data"`  // button payload
+}
+
+// webHistoryItem is one entry of a session's history as shown on the page.
+type webHistoryItem struct {
+	Role    string `json:"role"` // "user", "assistant" or "tool_call"
+	Content string `json:"content,omitempty"`
+	HTML    string `json:"html,omitempty"`
+	Tool    string `json:"tool,omitempty"`
+	Args    string `json:"args,omitempty"`
+}
+
+type webSessionInfo struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Updated time.Time `json:"updated"`
+}
+
+// NewWebChannel creates a new web chat channel served on the gateway's
+// address.
+func NewWebChannel(cfg config.WebConfig, gateway config.GatewayConfig, messageBus *bus.MessageBus, workspace string) (*WebChannel, error) {
+	if cfg.Token == "" && len(cfg.Users) == 0 {
+		return nil, fmt.Errorf("web token or users are required")
+	}
+
+	// Cookies are signed with a key derived from every token, so changing
+	// any of them logs everyone out
+	users := make(map[string]string, len(cfg.Users))
+	names := make([]string, 0, len(cfg.Users))
+	for name, token := range cfg.Users {
+		user := webUserName(name)
+		if user == "" || token == "" {
+			return nil, fmt.Errorf("web user %q needs a name and a token", name)
+		}
+		users[user] = token
+		names = append(names, user)
+	}
+	sort.Strings(names)
+	key := sha256.New()
+	key.Write([]byte(cfg.Token))
+	for _, name := range names {
+		fmt.Fprintf(key, "\x00%s\x00%s", name, users[name])
+	}
+
+	allowFrom := make([]string, 0, len(cfg.AllowFrom))
+	for _, name := range cfg.AllowFrom {
+		allowFrom = append(allowFrom, webUserName(name))
+	}
+	base := NewBaseChannel("web", cfg, messageBus, allowFrom)
+
+	c := &WebChannel{
+		BaseChannel: base,
+		config:      cfg,
+		users:       users,
+		cookieKey:   key.Sum(nil),
+		addr:        fmt.Sprintf("%s:%d", gateway.Host, gateway.Port),
+		workspace:   workspace,
+		clients:     make(map[string]map[*webClient]struct{}),
+		pending:     make(map[string][]webEvent),
+	}
+	messageBus.OnEvent("web", c.onAgentEvent)
+	return c, nil
+}
+
+// Start launches the HTTP server.
+func (c *WebChannel) Start(ctx context.Context) error {
+	logger.InfoC("web", "Starting Web channel")
+
+	c.httpServer = &http.Server{
+		Addr:    c.addr,
+		Handler: c.routes(),
+	}
+
+	go func() {
+		logger.InfoCF("web", "Web chat listening", map[string]interface{}{
+			"addr": c.addr,
+		})
+		if err := c.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.ErrorCF("web", "Web server error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	c.setRunning(true)
+	logger.InfoC("web", "Web channel started")
+	return nil
+}
+
+// Stop closes open pages and shuts down the HTTP server.
+func (c *WebChannel) Stop(ctx context.Context) error {
+	logger.InfoC("web", "Stopping Web channel")
+
+	c.mu.Lock()
+	for _, pages := range c.clients {
+		for client := range pages {
+			client.conn.Close()
+		}
+	}
+	c.mu.Unlock()
+
+	if c.httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := c.httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.ErrorCF("web", "Web server shutdown error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	c.setRunning(false)
+	logger.InfoC("web", "Web channel stopped")
+	return nil
+}
+
+func (c *WebChannel) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", c.handleIndex)
+	mux.HandleFunc("POST /api/login", c.handleLogin)
+	mux.HandleFunc("POST /api/logout", c.handleLogout)
+	mux.HandleFunc("GET /api/sessions", c.authenticated(c.handleSessions))
+	mux.HandleFunc("GET /api/sessions/{id}", c.authenticated(c.handleHistory))
+	mux.HandleFunc("POST /api/upload", c.authenticated(c.handleUpload))
+	mux.HandleFunc("GET /api/files/{id}", c.authenticated(c.handleFile))
+	mux.HandleFunc("GET /ws", c.authenticated(c.handleWebSocket))
+	return mux
+}
+
+func (c *WebChannel) handleIndex(w http.ResponseWriter, r *http.Request) {
+	page, err := webAssets.ReadFile("web/index.html")
+	if err != nil {
+		http.Error(w, "page not found", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(page)
+}
+
+// handleLogin checks the name's own token, or the shared one when no
+// users are configured, and sets the login cookie for the name.
+func (c *WebChannel) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		writeWebJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	user := webUserName(req.Name)
+	want := c.config.Token
+	if len(c.users) > 0 {
+		want = c.users[user]
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(want)) != 1 {
+		logger.WarnCF("web", "Rejected login", map[string]interface{}{
+			"remote": r.RemoteAddr,
+		})
+		// Slow down token guessing
+		time.Sleep(time.Second)
+		writeWebJSON(w, http.StatusUnauthorized, map[string]string{"error": "wrong token"})
+		return
+	}
+	if user == "" {
+		writeWebJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return
+	}
+	if !c.IsAllowed(user) {
+		writeWebJSON(w, http.StatusForbidden, map[string]string{"error": "name not allowed"})
+		return
+	}
+
+	expires := time.Now().Add(webCookieTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     webCookieName,
+		Value:    c.cookieValue(user, expires),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   r.TLS != nil,
+	})
+	writeWebJSON(w, http.StatusOK, map[string]string{"user": user})
+}
+
+func (c *WebChannel) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: webCookieName, Path: "/", MaxAge: -1})
+	writeWebJSON(w, http.StatusOK, map[string]string{})
+}
+
+// webUserKey is the request context key of the logged-in user.
+type webUserKey struct{}
+
+func webUser(r *http.Request) string {
+	user, _ := r.Context().Value(webUserKey{}).(string)
+	return user
+}
+
+// authenticated wraps handlers that need a logged-in user, available to
+// them through webUser.
+func (c *WebChannel) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := ""
+		if cookie, err := r.Cookie(webCookieName); err == nil {
+			user = c.checkCookie(cookie.Value)
+		}
+		if user == "" || !c.IsAllowed(user) {
+			writeWebJSON(w, http.StatusUnauthorized, map[string]string{"error": "login required"})
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), webUserKey{}, user)))
+	}
+}
+
+// cookieValue signs user and expiry with the token, so changing the
+// token logs everyone out.
+func (c *WebChannel) cookieValue(user string, expires time.Time) string {
+	payload := user + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "|" + c.sign(payload)
+}
+
+func (c *WebChannel) checkCookie(value string) string {
+	parts := strings.Split(value, "|")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload := parts[0] + "|" + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(c.sign(payload))) {
+		return ""
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ""
+	}
+	return parts[0]
+}
+
+func (c *WebChannel) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.cookieKey)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleSessions lists the user's saved sessions, most recent first.
+func (c *WebChannel) handleSessions(w http.ResponseWriter, r *http.Request) {
+	user := webUser(r)
+	prefix := "web_" + user + "."
+
+	entries, _ := os.ReadDir(filepath.Join(c.workspace, "sessions"))
+	sessions := []webSessionInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".json")
+		s, err := c.loadSession(user, id)
+		if err != nil {
+			continue
+		}
+		info := webSessionInfo{ID: id, Title: "New chat", Updated: s.Updated}
+		for _, m := range s.Messages {
+			if text := m.GetTextContent(); m.Role == "user" && text != "" {
+				info.Title = utils.Truncate(text, 40)
+				break
+			}
+		}
+		sessions = append(sessions, info)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Updated.After(sessions[j].Updated) })
+	writeWebJSON(w, http.StatusOK, sessions)
+}
+
+// handleHistory returns a session's conversation: user messages, replies
+// and the tool calls made on the way.
+func (c *WebChannel) handleHistory(w http.ResponseWriter, r *http.Request) {
+	user := webUser(r)
+	id := r.PathValue("id")
+	if !webSessionPattern.MatchString(id) {
+		writeWebJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid session"})
+		return
+	}
+
+	items := []webHistoryItem{}
+	s, err := c.loadSession(user, id)
+	if err != nil {
+		// Not saved yet: the agent hasn't answered in it
+		writeWebJSON(w, http.StatusOK, items)
+		return
+	}
+	for _, m := range s.Messages {
+		text := m.GetTextContent()
+		switch m.Role {
+		case "user":
+			items = append(items, webHistoryItem{Role: "user", Content: text})
+		case "assistant":
+			for _, tc := range m.ToolCalls {
+				item := webHistoryItem{Role: "tool_call", Tool: tc.Name}
+				if tc.Function != nil {
+					item.Tool = tc.Function.Name
+					item.Args = utils.Truncate(tc.Function.Arguments, 200)
+				}
+				items = append(items, item)
+			}
+			if text != "" {
+				items = append(items, webHistoryItem{Role: "assistant", Content: text, HTML: markdownHTML(text)})
+			}
+		}
+	}
+	writeWebJSON(w, http.StatusOK, items)
+}
+
+// loadSession reads a session from the agent's session store.
+func (c *WebChannel) loadSession(user, id string) (*session.Session, error) {
+	data, err := os.ReadFile(filepath.Join(c.workspace, "sessions", "web_"+webChatID(user, id)+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var s session.Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// handleUpload saves a file for the user's next message and returns its ID.
+func (c *WebChannel) handleUpload(w http.ResponseWriter, r *http.Request) {
+	user := webUser(r)
+	r.Body = http.MaxBytesReader(w, r.Body, webMaxUpload)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeWebJSON(w, http.StatusBadRequest, map[string]string{"error": "file is required (max 20 MB)"})
+		return
+	}
+	defer file.Close()
+
+	dir := mediaDir(c.workspace)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		writeWebJSON(w, http.StatusInternalServerError, map[string]string{"error": "cannot save file"})
+		return
+	}
+	id := uuid.New().String()[:8] + "_" + utils.SanitizeFilename(header.Filename)
+	out, err := os.OpenFile(filepath.Join(dir, id), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		writeWebJSON(w, http.StatusInternalServerError, map[string]string{"error": "cannot save file"})
+		return
+	}
+	_, err = io.Copy(out, file)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		writeWebJSON(w, http.StatusBadRequest, map[string]string{"error": "upload failed"})
+		return
+	}
+
+	c.uploads.Store(id, webFile{user: user, path: out.Name()})
+	writeWebJSON(w, http.StatusOK, map[string]string{"id": id, "name": header.Filename})
+}
+
+// handleFile serves an attachment the agent sent to the user.
+func (c *WebChannel) handleFile(w http.ResponseWriter, r *http.Request) {
+	v, ok := c.files.Load(r.PathValue("id"))
+	if !ok || v.(webFile).user != webUser(r) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(v.(webFile).path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(v.(webFile).path), info.ModTime(), f)
+}
+
+// handleWebSocket runs one page's connection: messages it sends go to the
+// agent, and replies and agent events go back to it.
+func (c *WebChannel) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	user := webUser(r)
+	// The upgrader's default origin check keeps other sites from using
+	// the login cookie
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(webMaxFrame)
+
+	client := &webClient{conn: conn, user: user, out: make(chan webEvent, 64)}
+	c.mu.Lock()
+	if c.clients[user] == nil {
+		c.clients[user] = make(map[*webClient]struct{})
+	}
+	c.clients[user][client] = struct{}{}
+	pending := c.pending[user]
+	delete(c.pending, user)
+	c.mu.Unlock()
+
+	logger.DebugCF("web", "Page connected", map[string]interface{}{
+		"user": user,
+	})
+
+	done := make(chan struct{})
+	go c.writeLoop(client, pending, done)
+	defer func() {
+		c.mu.Lock()
+		delete(c.clients[user], client)
+		if len(c.clients[user]) == 0 {
+			delete(c.clients, user)
+		}
+		c.mu.Unlock()
+		close(done)
+		conn.Close()
+	}()
+
+	for {
+		var frame webFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		c.handleFrame(client, frame)
+	}
+}
+
+func (c *WebChannel) writeLoop(client *webClient, pending []webEvent, done <-chan struct{}) {
+	ping := time.NewTicker(webPingPeriod)
+	defer ping.Stop()
+
+	for _, ev := range pending {
+		if err := client.conn.WriteJSON(ev); err != nil {
+			client.conn.Close()
+			return
+		}
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case ev := <-client.out:
+			client.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := client.conn.WriteJSON(ev); err != nil {
+				client.conn.Close()
+				return
+			}
+		case <-ping.C:
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				client.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *WebChannel) handleFrame(client *webClient, frame webFrame) {
+	if !webSessionPattern.MatchString(frame.Session) {
+		c.sendTo(client, webEvent{Type: "error", Error: "invalid session"})
+		return
+	}
+	chatID := webChatID(client.user, frame.Session)
+	metadata := map[string]string{
+		"platform": "web",
+		"session":  frame.Session,
+	}
+	if frame.ID != "" {
+		metadata["message_id"] = frame.ID
+	}
+
+	switch frame.Type {
+	case "button":
+		c.HandleButton(client.user, chatID, frame.Data, metadata)
+	case "message":
+		content := frame.Content
+		var media []string
+		for _, id := range frame.Media {
+			v, ok := c.uploads.LoadAndDelete(id)
+			if !ok || v.(webFile).user != client.user {
+				continue
+			}
+			path := v.(webFile).path
+			media = append(media, path)
+			if (bus.Attachment{Path: path}).Kind() == bus.AttachmentImage {
+				content = appendContent(content, "[image]")
+			} else {
+				content = appendContent(content, fmt.Sprintf("[file: %s]", strings.SplitN(id, "_", 2)[1]))
+			}
+		}
+		if strings.TrimSpace(content) == "" {
+			return
+		}
+
+		logger.DebugCF("web", "Received message", map[string]interface{}{
+			"user":    client.user,
+			"session": frame.Session,
+			"preview": utils.Truncate(content, 50),
+		})
+
+		// Other pages of the same user show the message too
+		c.deliver(client.user, webEvent{Type: "echo", Session: frame.Session, ID: frame.ID, Content: frame.Content}, client)
+		c.HandleMessage(client.user, chatID, content, media, metadata)
+	}
+}
+
+// Send delivers a reply to the user's open pages, or keeps it until a
+// page connects.
+func (c *WebChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("web channel not running")
+	}
+	user, sessionID, ok := strings.Cut(msg.ChatID, ".")
+	if !ok || user == "" {
+		return fmt.Errorf("invalid web chat ID: %s", msg.ChatID)
+	}
+
+	if len(msg.Reactions) > 0 && msg.ReplyTo != "" {
+		c.deliver(user, webEvent{Type: "reaction", Session: sessionID, ID: msg.ReplyTo, Content: strings.Join(msg.Reactions, "")}, nil)
+		if msg.Content == "" && len(msg.Attachments) == 0 {
+			return nil
+		}
+	}
+
+	ev := webEvent{
+		Type:    "message",
+		Session: sessionID,
+		Content: msg.Content,
+		HTML:    markdownHTML(msg.Content),
+		Buttons: msg.Buttons,
+	}
+	if target := c.sent.editTarget(msg); target != "" {
+		ev.Type = "edit"
+		ev.ID = target
+	} else {
+		ev.ID = "m" + strconv.FormatUint(c.nextID.Add(1), 10)
+		c.sent.remember(msg.ChatID, ev.ID)
+	}
+	for _, a := range msg.Attachments {
+		ev.Attachments = append(ev.Attachments, c.offer(user, a))
+	}
+
+	c.deliver(user, ev, nil)
+	return nil
+}
+
+// offer makes an attachment downloadable by user.
+func (c *WebChannel) offer(user string, a bus.Attachment) webAttachment {
+	wa := webAttachment{Name: a.FileName(), URL: a.URL, Kind: a.Kind()}
+	if a.Path != "" {
+		id := uuid.New().String()
+		c.files.Store(id, webFile{user: user, path: a.Path})
+		wa.URL = "/api/files/" + id
+	}
+	return wa
+}
+
+// onAgentEvent forwards turn progress to the pages of the chat's user.
+// Events for users with no page open are dropped.
+func (c *WebChannel) onAgentEvent(ev bus.AgentEvent) {
+	user, sessionID, ok := strings.Cut(ev.ChatID, ".")
+	if !ok {
+		return
+	}
+	out := webEvent{
+		Type:    ev.Type,
+		Session: sessionID,
+		Tool:    ev.Tool,
+		Args:    ev.Args,
+		Content: ev.Content,
+		Error:   ev.Error,
+	}
+	// Deltas are shown as plain text until the full text arrives
+	if ev.Content != "" && ev.Type != bus.EventTextDelta {
+		out.HTML = markdownHTML(ev.Content)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for client := range c.clients[user] {
+		c.sendTo(client, out)
+	}
+}
+
+// deliver sends ev to every open page of user except skip. Messages for a
+// user with no page open are kept for the next connection.
+func (c *WebChannel) deliver(user string, ev webEvent, skip *webClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.clients[user]) == 0 {
+		if ev.Type == "echo" {
+			return
+		}
+		pending := append(c.pending[user], ev)
+		if len(pending) > webMaxPending {
+			pending = pending[len(pending)-webMaxPending:]
+		}
+		c.pending[user] = pending
+		return
+	}
+	for client := range c.clients[user] {
+		if client != skip {
+			c.sendTo(client, ev)
+		}
+	}
+}
+
+// sendTo queues ev for a page without blocking; a page that stops reading
+// misses events rather than stalling the agent.
+func (c *WebChannel) sendTo(client *webClient, ev webEvent) {
+	select {
+	case client.out <- ev:
+	default:
+		logger.WarnCF("web", "Page not keeping up, dropping event", map[string]interface{}{
+			"user": client.user,
+			"type": ev.Type,
+		})
+	}
+}
+
+// webUserName turns a login name into a sender ID: lowercase letters,
+// digits, '-' and '_', at most 32 of them. The result never contains the
+// '.' that separates user and session in chat IDs.
+func webUserName(name string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if n == 32 {
+			break
+		}
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('_')
+		default:
+			continue
+		}
+		n++
+	}
+	return b.String()
+}
+
+// webChatID is the chat ID of one of a user's sessions.
+func webChatID(user, sessionID string) string {
+	return user + "." + sessionID
+}
+
+func writeWebJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
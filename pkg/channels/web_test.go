@@ -0,0 +1,251 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func startWebChannel(t *testing.T, workspace string) (*WebChannel, *bus.MessageBus, *httptest.Server) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	ch, err := NewWebChannel(config.WebConfig{Token: "family"}, config.GatewayConfig{}, mb, workspace)
+	if err != nil {
+		t.Fatalf("NewWebChannel error: %v", err)
+	}
+	ch.setRunning(true)
+	srv := httptest.NewServer(ch.routes())
+	t.Cleanup(srv.Close)
+	return ch, mb, srv
+}
+
+// webLogin logs in as name and returns a client carrying the cookie.
+func webLogin(t *testing.T, srv *httptest.Server, name, token string) (*http.Client, int) {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Post(srv.URL+"/api/login", "application/json",
+		strings.NewReader(`{"name":"`+name+`","token":"`+token+`"}`))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	resp.Body.Close()
+	return client, resp.StatusCode
+}
+
+func dialWeb(t *testing.T, srv *httptest.Server, client *http.Client) *websocket.Conn {
+	t.Helper()
+	header := http.Header{}
+	for _, c := range client.Jar.Cookies(mustParseURL(t, srv.URL)) {
+		header.Add("Cookie", c.String())
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readWebEvent(t *testing.T, conn *websocket.Conn) webEvent {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ev webEvent
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return ev
+}
+
+func TestWebChannel_LoginRequired(t *testing.T) {
+	_, _, srv := startWebChannel(t, t.TempDir())
+
+	resp, err := http.Get(srv.URL + "/api/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("sessions without login = %d, want 401", resp.StatusCode)
+	}
+	if _, status := webLogin(t, srv, "Ana", "guess"); status != http.StatusUnauthorized {
+		t.Errorf("wrong token login = %d, want 401", status)
+	}
+
+	client, status := webLogin(t, srv, "Ana", "family")
+	if status != http.StatusOK {
+		t.Fatalf("login = %d", status)
+	}
+	resp, err = client.Get(srv.URL + "/api/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("sessions after login = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestWebChannel_PerUserTokens(t *testing.T) {
+	mb := bus.NewMessageBus()
+	ch, err := NewWebChannel(config.WebConfig{
+		Token: "family",
+		Users: map[string]string{"Ana": "ana-secret", "Ben": "ben-secret"},
+	}, config.GatewayConfig{}, mb, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewWebChannel error: %v", err)
+	}
+	ch.setRunning(true)
+	srv := httptest.NewServer(ch.routes())
+	t.Cleanup(srv.Close)
+
+	// Neither the shared token nor someone else's logs in as Ana
+	for _, token := range []string{"family", "ben-secret"} {
+		if _, status := webLogin(t, srv, "Ana", token); status != http.StatusUnauthorized {
+			t.Errorf("login as Ana with %q = %d, want 401", token, status)
+		}
+	}
+	if _, status := webLogin(t, srv, "Cleo", "family"); status != http.StatusUnauthorized {
+		t.Errorf("login as unlisted user = %d, want 401", status)
+	}
+	if _, status := webLogin(t, srv, "ana", "ana-secret"); status != http.StatusOK {
+		t.Errorf("login with own token = %d, want 200", status)
+	}
+}
+
+func TestWebChannel_ChatOverWebSocket(t *testing.T) {
+	ch, mb, srv := startWebChannel(t, t.TempDir())
+	client, _ := webLogin(t, srv, "Ana", "family")
+	conn := dialWeb(t, srv, client)
+
+	if err := conn.WriteJSON(webFrame{Type: "message", Session: "s1", ID: "c1", Content: "what's for dinner?"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.SenderID != "ana" || msg.ChatID != "ana.s1" || msg.Content != "what's for dinner?" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	// Tool calls show up while the agent works, then the reply
+	mb.PublishEvent(bus.AgentEvent{Type: bus.EventToolCall, Channel: "web", ChatID: "ana.s1", Tool: "read_file", Args: `{"path":"menu.md"}`})
+	if ev := readWebEvent(t, conn); ev.Type != "tool_call" || ev.Session != "s1" || ev.Tool != "read_file" {
+		t.Errorf("event = %+v, want the tool call", ev)
+	}
+	// Streamed text arrives as plain text pieces
+	mb.PublishEvent(bus.AgentEvent{Type: bus.EventTextDelta, Channel: "web", ChatID: "ana.s1", Content: "**Pa"})
+	if ev := readWebEvent(t, conn); ev.Type != "text_delta" || ev.Content != "**Pa" || ev.HTML != "" {
+		t.Errorf("event = %+v, want the raw delta", ev)
+	}
+	if err := ch.Send(context.Background(), bus.OutboundMessage{ChatID: "ana.s1", Content: "**Pasta**"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if ev := readWebEvent(t, conn); ev.Type != "message" || ev.HTML != "<b>Pasta</b>" || ev.ID == "" {
+		t.Errorf("event = %+v, want the rendered reply", ev)
+	}
+
+	// Edits target the last reply
+	ch.Send(context.Background(), bus.OutboundMessage{ChatID: "ana.s1", Content: "Soup", EditMessageID: bus.EditLast})
+	if ev := readWebEvent(t, conn); ev.Type != "edit" || ev.ID != "m1" {
+		t.Errorf("event = %+v, want an edit of m1", ev)
+	}
+}
+
+func TestWebChannel_KeepsRepliesUntilPageConnects(t *testing.T) {
+	ch, _, srv := startWebChannel(t, t.TempDir())
+	client, _ := webLogin(t, srv, "ana", "family")
+
+	ch.Send(context.Background(), bus.OutboundMessage{ChatID: "ana.s1", Content: "reminder: dentist"})
+	conn := dialWeb(t, srv, client)
+	if ev := readWebEvent(t, conn); ev.Content != "reminder: dentist" {
+		t.Errorf("event = %+v, want the reply sent while away", ev)
+	}
+}
+
+func TestWebChannel_SessionHistory(t *testing.T) {
+	workspace := t.TempDir()
+	os.MkdirAll(filepath.Join(workspace, "sessions"), 0755)
+	os.WriteFile(filepath.Join(workspace, "sessions", "web_ana.s1.json"), []byte(`{"key":"web:ana.s1","messages":[
+		{"role":"user","content":"weather?"},
+		{"role":"assistant","content":"","tool_calls":[{"id":"1","type":"function","function":{"name":"web_fetch","arguments":"{}"}}]},
+		{"role":"tool","content":"sunny","tool_call_id":"1"},
+		{"role":"assistant","content":"Sunny"}],"updated":"2026-01-02T00:00:00Z"}`), 0644)
+	_, _, srv := startWebChannel(t, workspace)
+
+	client, _ := webLogin(t, srv, "ana", "family")
+	var sessions []webSessionInfo
+	getWebJSON(t, client, srv.URL+"/api/sessions", &sessions)
+	if len(sessions) != 1 || sessions[0].ID != "s1" || sessions[0].Title != "weather?" {
+		t.Errorf("sessions = %+v", sessions)
+	}
+	var history []webHistoryItem
+	getWebJSON(t, client, srv.URL+"/api/sessions/s1", &history)
+	var roles []string
+	for _, item := range history {
+		roles = append(roles, item.Role)
+	}
+	if strings.Join(roles, ",") != "user,tool_call,assistant" || history[1].Tool != "web_fetch" {
+		t.Errorf("history = %+v", history)
+	}
+
+	// Another user can't see it
+	other, _ := webLogin(t, srv, "bo", "family")
+	getWebJSON(t, other, srv.URL+"/api/sessions", &sessions)
+	if len(sessions) != 0 {
+		t.Errorf("bo's sessions = %+v, want none", sessions)
+	}
+}
+
+func TestWebUserName(t *testing.T) {
+	tests := map[string]string{
+		"Ana":          "ana",
+		" Mary Jane ":  "mary_jane",
+		"../../etc":    "etc",
+		"zoë.smith|x":  "zoësmithx",
+		"":             "",
+		"a.b/c:d\\e\n": "abcde",
+	}
+	for in, want := range tests {
+		if got := webUserName(in); got != want {
+			t.Errorf("webUserName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func getWebJSON(t *testing.T, client *http.Client, url string, v interface{}) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s: %v", url, err)
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
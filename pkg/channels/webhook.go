@@ -0,0 +1,469 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	webhookSignatureHeader = "X-Picoclaw-Signature"
+	webhookTimestampHeader = "X-Picoclaw-Timestamp"
+	webhookMaxBody         = 1 << 20
+	webhookMaxMedia        = 10
+	// webhookMaxSkew bounds how old a signed request may be, so a captured
+	// one can't be replayed later
+	webhookMaxSkew = 5 * time.Minute
+	// webhookMetadataPrefix namespaces caller metadata so it can't pose as
+	// keys the channels and agent set themselves (interaction, reply_to…)
+	webhookMetadataPrefix = "webhook_"
+)
+
+// WebhookChannel implements the Channel interface for plain HTTP callers:
+// home automation, CI pipelines and custom apps. Messages are POSTed as
+// JSON; replies are returned in the response when the caller waits for
+// them, and POSTed to the callback URL otherwise.
+type WebhookChannel struct {
+	*BaseChannel
+	config      config.WebhookConfig
+	httpServer  *http.Server
+	client      *http.Client
+	syncTimeout time.Duration
+	workspace   string
+	mu          sync.Mutex
+	waiters     map[string]*webhookWaiter // chat ID -> request waiting for its replies
+}
+
+// webhookWaiter collects the replies of a synchronous request's turn. The
+// turn is over once the agent reports EventTurnEnd and every reply it
+// published has reached Send. Guarded by WebhookChannel.mu.
+type webhookWaiter struct {
+	started   bool // The request's turn has begun
+	ended     bool
+	published int // Replies published for the chat since the turn began
+	replies   []bus.OutboundMessage
+	notify    chan struct{}
+}
+
+// wake tells the waiting request to look at its state again.
+func (w *webhookWaiter) wake() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// webhookRequest is the body of an inbound POST.
+type webhookRequest struct {
+	MessageID string            `json:"message_id"`
+	SenderID  string            `json:"sender_id"`
+	ChatID    string            `json:"chat_id"`
+	Content   string            `json:"content"`
+	Media     []string          `json:"media"` // URLs, downloaded for the agent
+	Metadata  map[string]string `json:"metadata"`
+	Sync      *bool             `json:"sync"`
+}
+
+type webhookResponse struct {
+	Status  string                `json:"status"`
+	ChatID  string                `json:"chat_id,omitempty"`
+	Replies []bus.OutboundMessage `json:"replies,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// NewWebhookChannel creates a new webhook channel instance.
+func NewWebhookChannel(cfg config.WebhookConfig, messageBus *bus.MessageBus, workspace string) (*WebhookChannel, error) {
+	if cfg.Secret == "" && cfg.Token == "" {
+		return nil, fmt.Errorf("webhook secret or token is required")
+	}
+
+	syncTimeout := time.Duration(cfg.SyncTimeout) * time.Second
+	if syncTimeout <= 0 {
+		syncTimeout = 120 * time.Second
+	}
+
+	base := NewBaseChannel("webhook", cfg, messageBus, cfg.AllowFrom)
+
+	c := &WebhookChannel{
+		BaseChannel: base,
+		config:      cfg,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		syncTimeout: syncTimeout,
+		workspace:   workspace,
+		waiters:     make(map[string]*webhookWaiter),
+	}
+	// Registered after any configured hooks, so it counts the replies that
+	// actually reach the dispatcher
+	messageBus.UseOutbound("webhook-replies", c.countReply)
+	messageBus.OnEvent("webhook", c.onAgentEvent)
+	return c, nil
+}
+
+// Start launches the HTTP server.
+func (c *WebhookChannel) Start(ctx context.Context) error {
+	logger.InfoC("webhook", "Starting Webhook channel")
+
+	mux := http.NewServeMux()
+	webhookPath := c.config.Path
+	if webhookPath == "" {
+		webhookPath = "/webhook/message"
+	}
+	mux.HandleFunc(webhookPath, c.webhookHandler)
+
+	addr := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
+	c.httpServer = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		logger.InfoCF("webhook", "Webhook server listening", map[string]interface{}{
+			"addr": addr,
+			"path": webhookPath,
+		})
+		if err := c.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.ErrorCF("webhook", "Webhook server error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	c.setRunning(true)
+	logger.InfoC("webhook", "Webhook channel started")
+	return nil
+}
+
+// Stop gracefully shuts down the HTTP server.
+func (c *WebhookChannel) Stop(ctx context.Context) error {
+	logger.InfoC("webhook", "Stopping Webhook channel")
+
+	if c.httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := c.httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.ErrorCF("webhook", "Webhook server shutdown error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	c.setRunning(false)
+	logger.InfoC("webhook", "Webhook channel stopped")
+	return nil
+}
+
+// webhookHandler authenticates a POST, publishes its message and, for
+// synchronous requests, waits for the agent's replies.
+func (c *WebhookChannel) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+	if err != nil {
+		writeWebhookResponse(w, http.StatusRequestEntityTooLarge, webhookResponse{Status: "error", Error: "body too large"})
+		return
+	}
+
+	if !c.authenticate(r, body) {
+		logger.WarnC("webhook", "Rejected unauthenticated request")
+		writeWebhookResponse(w, http.StatusUnauthorized, webhookResponse{Status: "error", Error: "unauthorized"})
+		return
+	}
+
+	var req webhookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeWebhookResponse(w, http.StatusBadRequest, webhookResponse{Status: "error", Error: "invalid JSON: " + err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" && len(req.Media) == 0 {
+		writeWebhookResponse(w, http.StatusBadRequest, webhookResponse{Status: "error", Error: "content is required"})
+		return
+	}
+	if req.SenderID == "" {
+		req.SenderID = "webhook"
+	}
+	if req.ChatID == "" {
+		req.ChatID = req.SenderID
+	}
+	if !c.IsAllowed(req.SenderID) {
+		writeWebhookResponse(w, http.StatusForbidden, webhookResponse{Status: "error", Error: "sender not allowed"})
+		return
+	}
+
+	// Without a callback there is nowhere else for replies to go
+	wait := c.config.CallbackURL == ""
+	if req.Sync != nil {
+		wait = *req.Sync
+	}
+
+	var waiter *webhookWaiter
+	if wait {
+		waiter = &webhookWaiter{notify: make(chan struct{}, 1)}
+		c.mu.Lock()
+		if _, busy := c.waiters[req.ChatID]; busy {
+			c.mu.Unlock()
+			writeWebhookResponse(w, http.StatusConflict, webhookResponse{Status: "error", ChatID: req.ChatID, Error: "a request for this chat is already waiting"})
+			return
+		}
+		c.waiters[req.ChatID] = waiter
+		c.mu.Unlock()
+		defer c.stopWaiting(req.ChatID)
+	}
+
+	switch err := c.publish(req); {
+	case errors.Is(err, ErrDuplicateMessage):
+		// A retry of a request that was already handled
+		writeWebhookResponse(w, http.StatusOK, webhookResponse{Status: "duplicate", ChatID: req.ChatID})
+		return
+	case errors.Is(err, bus.ErrInboundDropped):
+		writeWebhookResponse(w, http.StatusAccepted, webhookResponse{Status: "dropped", ChatID: req.ChatID})
+		return
+	case err != nil:
+		writeWebhookResponse(w, http.StatusServiceUnavailable, webhookResponse{Status: "error", ChatID: req.ChatID, Error: err.Error()})
+		return
+	}
+
+	if !wait {
+		writeWebhookResponse(w, http.StatusAccepted, webhookResponse{Status: "accepted", ChatID: req.ChatID})
+		return
+	}
+
+	collected, ok := c.collect(r.Context(), waiter)
+	if !ok {
+		writeWebhookResponse(w, http.StatusGatewayTimeout, webhookResponse{Status: "timeout", ChatID: req.ChatID})
+		return
+	}
+	writeWebhookResponse(w, http.StatusOK, webhookResponse{Status: "ok", ChatID: req.ChatID, Replies: collected})
+}
+
+// publish hands the request's message to the agent. It returns nil once
+// the message is queued, and HandleMessage's error otherwise.
+func (c *WebhookChannel) publish(req webhookRequest) error {
+	metadata := map[string]string{}
+	for k, v := range req.Metadata {
+		metadata[webhookMetadataPrefix+k] = v
+	}
+	metadata["platform"] = "webhook"
+	if req.MessageID != "" {
+		metadata["message_id"] = req.MessageID
+	}
+
+	content := req.Content
+	var mediaPaths []string
+	for i, url := range req.Media {
+		if i == webhookMaxMedia {
+			break
+		}
+		name := path.Base(strings.SplitN(url, "?", 2)[0])
+		localPath := utils.DownloadFile(url, name, utils.DownloadOptions{
+			LoggerPrefix: "webhook",
+			TempDir:      c.workspace,
+		})
+		if localPath != "" {
+			mediaPaths = append(mediaPaths, localPath)
+			content = appendContent(content, fmt.Sprintf("[file: %s]", name))
+		}
+	}
+
+	logger.DebugCF("webhook", "Received message", map[string]interface{}{
+		"sender_id": req.SenderID,
+		"chat_id":   req.ChatID,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	return c.HandleMessage(req.SenderID, req.ChatID, content, mediaPaths, metadata)
+}
+
+// collect waits until the request's turn has ended and all of its replies
+// have arrived. It reports false when that doesn't happen before the
+// timeout.
+func (c *WebhookChannel) collect(ctx context.Context, w *webhookWaiter) ([]bus.OutboundMessage, bool) {
+	timeout := time.NewTimer(c.syncTimeout)
+	defer timeout.Stop()
+
+	for {
+		c.mu.Lock()
+		done := w.ended && len(w.replies) >= w.published
+		replies := w.replies
+		c.mu.Unlock()
+		if done {
+			return replies, true
+		}
+
+		select {
+		case <-w.notify:
+		case <-timeout.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// countReply is an outbound hook counting the replies published for a
+// waiting request's turn, so collect knows how many to wait for.
+func (c *WebhookChannel) countReply(ctx context.Context, msg bus.OutboundMessage) ([]bus.OutboundMessage, error) {
+	if msg.Channel == c.Name() {
+		c.mu.Lock()
+		if w, ok := c.waiters[msg.ChatID]; ok && w.started && !w.ended {
+			w.published++
+		}
+		c.mu.Unlock()
+	}
+	return []bus.OutboundMessage{msg}, nil
+}
+
+// onAgentEvent tracks the turn of a waiting request. A turn that was
+// already running when the request came in belongs to an earlier message,
+// so its end is ignored.
+func (c *WebhookChannel) onAgentEvent(ev bus.AgentEvent) {
+	if ev.Type != bus.EventTurnStart && ev.Type != bus.EventTurnEnd {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.waiters[ev.ChatID]
+	if !ok {
+		return
+	}
+	switch {
+	case ev.Type == bus.EventTurnStart:
+		w.started = true
+	case w.started:
+		w.ended = true
+		w.wake()
+	}
+}
+
+// stopWaiting unregisters a waiting request. Replies that arrive later go
+// to the callback URL.
+func (c *WebhookChannel) stopWaiting(chatID string) {
+	c.mu.Lock()
+	delete(c.waiters, chatID)
+	c.mu.Unlock()
+}
+
+// authenticate accepts a valid bearer token or signature, whichever is
+// configured. A signature covers the timestamp header as well as the body,
+// and is only accepted within webhookMaxSkew of it.
+func (c *WebhookChannel) authenticate(r *http.Request, body []byte) bool {
+	if c.config.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(c.config.Token)) == 1 {
+			return true
+		}
+	}
+	if c.config.Secret != "" {
+		signature := strings.TrimPrefix(r.Header.Get(webhookSignatureHeader), "sha256=")
+		timestamp := r.Header.Get(webhookTimestampHeader)
+		return signature != "" && freshTimestamp(timestamp, time.Now()) &&
+			hmac.Equal([]byte(signature), []byte(c.sign(timestamp, body)))
+	}
+	return false
+}
+
+// freshTimestamp reports whether timestamp, in Unix seconds, is within
+// webhookMaxSkew of now.
+func freshTimestamp(timestamp string, now time.Time) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(sec, 0))
+	return skew <= webhookMaxSkew && skew >= -webhookMaxSkew
+}
+
+// sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" under the
+// configured secret.
+func (c *WebhookChannel) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(c.config.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func writeWebhookResponse(w http.ResponseWriter, status int, resp webhookResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Send hands the reply to a request waiting for it, or POSTs it to the
+// callback URL.
+func (c *WebhookChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("webhook channel not running")
+	}
+
+	c.mu.Lock()
+	w, waiting := c.waiters[msg.ChatID]
+	if waiting {
+		w.replies = append(w.replies, msg)
+		w.wake()
+	}
+	c.mu.Unlock()
+	if waiting {
+		return nil
+	}
+
+	if c.config.CallbackURL == "" {
+		return fmt.Errorf("no request waiting for chat %s and no callback_url configured", msg.ChatID)
+	}
+	return c.postCallback(ctx, msg)
+}
+
+// postCallback POSTs the reply as JSON, signed like inbound requests when
+// a secret is configured.
+func (c *WebhookChannel) postCallback(ctx context.Context, msg bus.OutboundMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(webhookSignatureHeader, "sha256="+c.sign(timestamp, body))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("callback request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return statusError(resp.StatusCode, fmt.Errorf("callback error (status %d): %s", resp.StatusCode, string(respBody)))
+	}
+
+	logger.DebugCF("webhook", "Reply delivered to callback", map[string]interface{}{
+		"chat_id": msg.ChatID,
+	})
+	return nil
+}
@@ -0,0 +1,225 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newTestWebhook(t *testing.T, cfg config.WebhookConfig) (*WebhookChannel, *bus.MessageBus) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	ch, err := NewWebhookChannel(cfg, mb, "")
+	if err != nil {
+		t.Fatalf("NewWebhookChannel error: %v", err)
+	}
+	ch.setRunning(true)
+	return ch, mb
+}
+
+// signedHeader signs body the way a caller holding the secret would.
+func signedHeader(ch *WebhookChannel, body string, at time.Time) http.Header {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return http.Header{
+		webhookTimestampHeader: {timestamp},
+		webhookSignatureHeader: {"sha256=" + ch.sign(timestamp, []byte(body))},
+	}
+}
+
+func postWebhook(ch *WebhookChannel, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhook/message", strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	ch.webhookHandler(rec, req)
+	return rec
+}
+
+// playTurn plays the agent and the dispatcher for the next inbound
+// message: it publishes the replies inside a turn, and only delivers them
+// once the turn has ended.
+func playTurn(ch *WebhookChannel, mb *bus.MessageBus, reply func(bus.InboundMessage) []string) {
+	ctx := context.Background()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok {
+		return
+	}
+	mb.PublishEvent(bus.AgentEvent{Type: bus.EventTurnStart, Channel: "webhook", ChatID: msg.ChatID})
+	contents := reply(msg)
+	for _, content := range contents {
+		mb.PublishOutbound(bus.OutboundMessage{Channel: "webhook", ChatID: msg.ChatID, Content: content})
+	}
+	mb.PublishEvent(bus.AgentEvent{Type: bus.EventTurnEnd, Channel: "webhook", ChatID: msg.ChatID})
+	for range contents {
+		out, _ := mb.SubscribeOutbound(ctx)
+		ch.Send(ctx, out)
+	}
+}
+
+func TestWebhookChannel_SyncReplyWithSignature(t *testing.T) {
+	ch, mb := newTestWebhook(t, config.WebhookConfig{Secret: "s3cret", SyncTimeout: 5})
+
+	// Play the agent: answer whatever comes in
+	go playTurn(ch, mb, func(msg bus.InboundMessage) []string {
+		return []string{"turned off " + msg.Content}
+	})
+
+	body := `{"sender_id":"homeassistant","chat_id":"living-room","content":"the lights"}`
+	rec := postWebhook(ch, body, signedHeader(ch, body, time.Now()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp webhookResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.ChatID != "living-room" || len(resp.Replies) != 1 || resp.Replies[0].Content != "turned off the lights" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// A bad signature is turned away
+	rec = postWebhook(ch, body, http.Header{webhookSignatureHeader: {"sha256=00"}})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature status = %d, want 401", rec.Code)
+	}
+
+	// So is a correctly signed request replayed after the window
+	rec = postWebhook(ch, body, signedHeader(ch, body, time.Now().Add(-webhookMaxSkew-time.Minute)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("stale signature status = %d, want 401", rec.Code)
+	}
+
+	// And a fresh timestamp swapped onto an old signature
+	header := signedHeader(ch, body, time.Now().Add(-webhookMaxSkew-time.Minute))
+	header.Set(webhookTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	rec = postWebhook(ch, body, header)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("swapped timestamp status = %d, want 401", rec.Code)
+	}
+}
+
+func TestWebhookChannel_SyncWaitsForTurnEnd(t *testing.T) {
+	ch, mb := newTestWebhook(t, config.WebhookConfig{Token: "tok", SyncTimeout: 5})
+
+	var metadata map[string]string
+	go playTurn(ch, mb, func(msg bus.InboundMessage) []string {
+		metadata = msg.Metadata
+		return []string{"Checking the logs…", "The disk is full."}
+	})
+
+	body := `{"chat_id":"ops","content":"why did it fail?","metadata":{"interaction":"button","build":"42"}}`
+	rec := postWebhook(ch, body, http.Header{"Authorization": {"Bearer tok"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp webhookResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Replies) != 2 || resp.Replies[1].Content != "The disk is full." {
+		t.Errorf("replies = %+v", resp.Replies)
+	}
+
+	// Caller metadata can't pose as keys the channels set themselves
+	if metadata["interaction"] != "" || metadata["webhook_interaction"] != "button" || metadata["webhook_build"] != "42" {
+		t.Errorf("metadata = %v", metadata)
+	}
+}
+
+func TestWebhookChannel_AsyncDeliversToCallback(t *testing.T) {
+	callbacks := make(chan string, 1)
+	var signature, timestamp string
+	cb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(webhookSignatureHeader)
+		timestamp = r.Header.Get(webhookTimestampHeader)
+		callbacks <- string(body)
+	}))
+	defer cb.Close()
+
+	ch, mb := newTestWebhook(t, config.WebhookConfig{Token: "tok", Secret: "s3cret", CallbackURL: cb.URL})
+
+	rec := postWebhook(ch, `{"chat_id":"build-42","content":"pipeline failed"}`, http.Header{"Authorization": {"Bearer tok"}})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", rec.Code)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.SenderID != "webhook" || msg.ChatID != "build-42" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	if err := ch.Send(ctx, bus.OutboundMessage{Channel: "webhook", ChatID: "build-42", Content: "flaky test, rerun"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	body := <-callbacks
+	if !strings.Contains(body, `"content":"flaky test, rerun"`) {
+		t.Errorf("callback body = %s", body)
+	}
+	if !freshTimestamp(timestamp, time.Now()) || signature != "sha256="+ch.sign(timestamp, []byte(body)) {
+		t.Errorf("callback signature = %q", signature)
+	}
+
+	rec = postWebhook(ch, `{"content":"hi"}`, http.Header{"Authorization": {"Bearer wrong"}})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token status = %d, want 401", rec.Code)
+	}
+}
+
+func TestWebhookChannel_AnswersAtOnceWhenNotQueued(t *testing.T) {
+	mb, err := bus.NewMessageBusWithOptions(bus.InboundOptions{Capacity: 2, Overflow: bus.OverflowReject})
+	if err != nil {
+		t.Fatalf("NewMessageBusWithOptions error: %v", err)
+	}
+	mb.UseInbound("drop-spam", func(ctx context.Context, msg bus.InboundMessage) ([]bus.InboundMessage, error) {
+		if msg.Content == "spam" {
+			return nil, nil
+		}
+		return []bus.InboundMessage{msg}, nil
+	})
+	// Nobody plays the agent, so a request that waited would time out
+	ch, err := NewWebhookChannel(config.WebhookConfig{Token: "tok", SyncTimeout: 60}, mb, "")
+	if err != nil {
+		t.Fatalf("NewWebhookChannel error: %v", err)
+	}
+	ch.setRunning(true)
+	auth := http.Header{"Authorization": {"Bearer tok"}}
+
+	tests := []struct {
+		name   string
+		body   string
+		code   int
+		status string
+	}{
+		{"queued", `{"chat_id":"a","message_id":"m1","content":"hi","sync":false}`, http.StatusAccepted, "accepted"},
+		{"duplicate", `{"chat_id":"a","message_id":"m1","content":"hi"}`, http.StatusOK, "duplicate"},
+		{"dropped by hook", `{"chat_id":"b","content":"spam"}`, http.StatusAccepted, "dropped"},
+		{"fills queue", `{"chat_id":"c","content":"hi","sync":false}`, http.StatusAccepted, "accepted"},
+		{"queue full", `{"chat_id":"d","content":"hi"}`, http.StatusServiceUnavailable, "error"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		rec := postWebhook(ch, tt.body, auth)
+		var resp webhookResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != tt.code || resp.Status != tt.status {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, rec.Code, resp.Status, tt.code, tt.status)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: answered after %v", tt.name, elapsed)
+		}
+	}
+}
+
+func TestNewWebhookChannel_RequiresAuth(t *testing.T) {
+	if _, err := NewWebhookChannel(config.WebhookConfig{}, bus.NewMessageBus(), ""); err == nil {
+		t.Error("expected an error without secret or token")
+	}
+}
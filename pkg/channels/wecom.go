@@ -0,0 +1,848 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/outbox"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	wecomAPIBase  = "https://qyapi.weixin.qq.com/cgi-bin"
+	wecomMaxBody  = 1 << 20
+	wecomMaxMedia = 20 << 20
+	// Message limits are in bytes; CJK text takes three bytes a character,
+	// so these rune limits keep any text under them.
+	wecomMaxText     = 680  // text: 2048 bytes
+	wecomMaxMarkdown = 1360 // markdown: 4096 bytes
+	// Robot webhooks take images up to 2 MB, sent inline.
+	wecomMaxRobotImage = 2 << 20
+	// wecomRobotPrefix marks chat IDs of group robot conversations.
+	wecomRobotPrefix = "robot."
+	// wecomTokenMargin renews the access token this long before WeCom
+	// says it expires.
+	wecomTokenMargin = 5 * time.Minute
+)
+
+// reRobotMention matches the "@Robot" WeCom puts in front of messages
+// that address a group robot.
+var reRobotMention = regexp.MustCompile(`^@\S+\s*`)
+
+// WeComChannel implements the Channel interface for WeCom (WeChat Work).
+// A self-built app receives encrypted callbacks over HTTP and replies
+// through the app message API; a group robot receives callbacks on its
+// own path and replies through the webhook URL each callback carries.
+type WeComChannel struct {
+	*BaseChannel
+	config     config.WeComConfig
+	httpServer *http.Server
+	client     *http.Client
+	apiBase    string
+	workspace  string
+	appCrypt   *wecomCrypt
+	robotCrypt *wecomCrypt
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	robotURLs sync.Map // robot chat ID -> webhook URL from its latest callback
+}
+
+// wecomResult is the error part every WeCom API response carries.
+type wecomResult struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+func (r wecomResult) err() error {
+	if r.ErrCode == 0 {
+		return nil
+	}
+	return &wecomError{Code: r.ErrCode, Message: r.ErrMsg}
+}
+
+type wecomError struct {
+	Code    int
+	Message string
+}
+
+func (e *wecomError) Error() string {
+	return fmt.Sprintf("wecom API error %d: %s", e.Code, e.Message)
+}
+
+// tokenInvalid reports whether the access token was rejected: invalid
+// (40014), missing (41001) or expired (42001).
+func (e *wecomError) tokenInvalid() bool {
+	return e.Code == 40014 || e.Code == 41001 || e.Code == 42001
+}
+
+// wecomAppMessage is a decrypted app callback.
+type wecomAppMessage struct {
+	FromUserName string `xml:"FromUserName"`
+	CreateTime   int64  `xml:"CreateTime"`
+	MsgType      string `xml:"MsgType"`
+	Content      string `xml:"Content"`
+	MsgID        string `xml:"MsgId"`
+	MediaID      string `xml:"MediaId"`
+	Format       string `xml:"Format"`
+	Label        string `xml:"Label"`
+	Title        string `xml:"Title"`
+	URL          string `xml:"Url"`
+	Event        string `xml:"Event"`
+}
+
+// wecomRobotMessage is a decrypted group robot callback.
+type wecomRobotMessage struct {
+	WebhookURL string `xml:"WebhookUrl"`
+	ChatID     string `xml:"ChatId"`
+	ChatType   string `xml:"ChatType"` // "group" or "single"
+	From       struct {
+		UserID string `xml:"UserId"`
+		Name   string `xml:"Name"`
+	} `xml:"From"`
+	MsgType string `xml:"MsgType"`
+	MsgID   string `xml:"MsgId"`
+	Text    struct {
+		Content string `xml:"Content"`
+	} `xml:"Text"`
+	Image struct {
+		ImageURL string `xml:"ImageUrl"`
+	} `xml:"Image"`
+	Mixed struct {
+		Items []struct {
+			MsgType string `xml:"MsgType"`
+			Text    struct {
+				Content string `xml:"Content"`
+			} `xml:"Text"`
+			Image struct {
+				ImageURL string `xml:"ImageUrl"`
+			} `xml:"Image"`
+		} `xml:"MsgItem"`
+	} `xml:"MixedMessage"`
+}
+
+// NewWeComChannel creates a new WeCom channel instance. The app, the
+// robot, or both may be configured.
+func NewWeComChannel(cfg config.WeComConfig, messageBus *bus.MessageBus, workspace string) (*WeComChannel, error) {
+	c := &WeComChannel{
+		config:    cfg,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		apiBase:   wecomAPIBase,
+		workspace: workspace,
+	}
+
+	if cfg.CorpID != "" {
+		if cfg.Secret == "" || cfg.AgentID == 0 || cfg.Token == "" || cfg.EncodingAESKey == "" {
+			return nil, fmt.Errorf("wecom secret, agent_id, token and encoding_aes_key are required with corp_id")
+		}
+		crypt, err := newWeComCrypt(cfg.Token, cfg.EncodingAESKey, cfg.CorpID)
+		if err != nil {
+			return nil, err
+		}
+		c.appCrypt = crypt
+	}
+	if cfg.RobotToken != "" {
+		// Robot callbacks are encrypted without a receiver ID
+		crypt, err := newWeComCrypt(cfg.RobotToken, cfg.RobotEncodingAESKey, "")
+		if err != nil {
+			return nil, fmt.Errorf("wecom robot: %w", err)
+		}
+		c.robotCrypt = crypt
+	}
+	if c.appCrypt == nil && c.robotCrypt == nil {
+		return nil, fmt.Errorf("wecom corp_id or robot_token is required")
+	}
+
+	c.BaseChannel = NewBaseChannel("wecom", cfg, messageBus, cfg.AllowFrom)
+	return c, nil
+}
+
+// Start launches the callback server.
+func (c *WeComChannel) Start(ctx context.Context) error {
+	logger.InfoC("wecom", "Starting WeCom channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	addr := fmt.Sprintf("%s:%d", c.config.WebhookHost, c.config.WebhookPort)
+	c.httpServer = &http.Server{
+		Addr:    addr,
+		Handler: c.routes(),
+	}
+
+	go func() {
+		logger.InfoCF("wecom", "WeCom callback server listening", map[string]interface{}{
+			"addr": addr,
+		})
+		if err := c.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.ErrorCF("wecom", "Callback server error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	c.setRunning(true)
+	logger.InfoC("wecom", "WeCom channel started")
+	return nil
+}
+
+// Stop gracefully shuts down the callback server.
+func (c *WeComChannel) Stop(ctx context.Context) error {
+	logger.InfoC("wecom", "Stopping WeCom channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	if c.httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := c.httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.ErrorCF("wecom", "Callback server shutdown error", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	c.setRunning(false)
+	logger.InfoC("wecom", "WeCom channel stopped")
+	return nil
+}
+
+func (c *WeComChannel) routes() http.Handler {
+	mux := http.NewServeMux()
+	if c.appCrypt != nil {
+		path := c.config.WebhookPath
+		if path == "" {
+			path = "/webhook/wecom"
+		}
+		mux.HandleFunc(path, c.callbackHandler(c.appCrypt, c.handleAppMessage))
+	}
+	if c.robotCrypt != nil {
+		path := c.config.RobotPath
+		if path == "" {
+			path = "/webhook/wecom/robot"
+		}
+		mux.HandleFunc(path, c.callbackHandler(c.robotCrypt, c.handleRobotMessage))
+	}
+	return mux
+}
+
+// callbackHandler serves one callback URL. WeCom checks the URL with a
+// signed GET whose decrypted echostr must be sent back, then POSTs
+// encrypted messages.
+func (c *WeComChannel) callbackHandler(crypt *wecomCrypt, handle func([]byte)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		signature, timestamp, nonce := q.Get("msg_signature"), q.Get("timestamp"), q.Get("nonce")
+
+		switch r.Method {
+		case http.MethodGet:
+			echo := q.Get("echostr")
+			if !crypt.verify(signature, timestamp, nonce, echo) {
+				logger.WarnC("wecom", "Invalid URL verification signature")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			plain, err := crypt.decrypt(echo)
+			if err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write(plain)
+
+		case http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, wecomMaxBody))
+			if err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			var envelope struct {
+				Encrypt string `xml:"Encrypt"`
+			}
+			if err := xml.Unmarshal(body, &envelope); err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			if !crypt.verify(signature, timestamp, nonce, envelope.Encrypt) {
+				logger.WarnC("wecom", "Invalid callback signature")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			plain, err := crypt.decrypt(envelope.Encrypt)
+			if err != nil {
+				logger.ErrorCF("wecom", "Failed to decrypt callback", map[string]interface{}{
+					"error": err.Error(),
+				})
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+
+			// Answer at once; WeCom retries callbacks that take over 5s
+			w.WriteHeader(http.StatusOK)
+			go handle(plain)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (c *WeComChannel) handleAppMessage(plain []byte) {
+	var msg wecomAppMessage
+	if err := xml.Unmarshal(plain, &msg); err != nil {
+		logger.ErrorCF("wecom", "Failed to parse app message", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if msg.MsgType == "event" {
+		logger.DebugCF("wecom", "Ignoring app event", map[string]interface{}{
+			"event": msg.Event,
+		})
+		return
+	}
+
+	senderID := msg.FromUserName
+	if !c.IsAllowed(senderID) {
+		logger.DebugCF("wecom", "Message from sender not in allow_from", map[string]interface{}{
+			"sender_id": senderID,
+		})
+		return
+	}
+
+	var content string
+	var mediaPaths []string
+	addMedia := func(name, placeholder string) {
+		if path := c.downloadMedia(msg.MediaID, name); path != "" {
+			mediaPaths = append(mediaPaths, path)
+		}
+		content = placeholder
+	}
+	switch msg.MsgType {
+	case "text":
+		content = msg.Content
+	case "image":
+		addMedia("image.jpg", "[image]")
+	case "voice":
+		name := "voice." + strings.ToLower(msg.Format)
+		if msg.Format == "" {
+			name = "voice.amr"
+		}
+		addMedia(name, "[audio: "+name+"]")
+	case "video":
+		addMedia("video.mp4", "[video: video.mp4]")
+	case "location":
+		content = "[location: " + msg.Label + "]"
+	case "link":
+		content = appendContent(msg.Title, msg.URL)
+	default:
+		content = fmt.Sprintf("[%s]", msg.MsgType)
+	}
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":   "wecom",
+		"message_id": msg.MsgID,
+		"is_dm":      "true",
+	}
+
+	logger.DebugCF("wecom", "Received app message", map[string]interface{}{
+		"sender_id": senderID,
+		"type":      msg.MsgType,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(senderID, senderID, content, mediaPaths, metadata)
+}
+
+func (c *WeComChannel) handleRobotMessage(plain []byte) {
+	var msg wecomRobotMessage
+	if err := xml.Unmarshal(plain, &msg); err != nil {
+		logger.ErrorCF("wecom", "Failed to parse robot message", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if msg.ChatID == "" || msg.From.UserID == "" {
+		logger.DebugCF("wecom", "Ignoring robot callback", map[string]interface{}{
+			"type": msg.MsgType,
+		})
+		return
+	}
+
+	senderID := msg.From.UserID
+	if !c.IsAllowed(senderID) {
+		logger.DebugCF("wecom", "Message from sender not in allow_from", map[string]interface{}{
+			"sender_id": senderID,
+		})
+		return
+	}
+
+	chatID := wecomRobotPrefix + msg.ChatID
+	if msg.WebhookURL != "" {
+		c.robotURLs.Store(chatID, msg.WebhookURL)
+	}
+
+	var content string
+	var mediaPaths []string
+	addImage := func(imageURL string) {
+		path := utils.DownloadFile(imageURL, "image.jpg", utils.DownloadOptions{
+			LoggerPrefix: "wecom",
+			TempDir:      c.workspace,
+		})
+		if path != "" {
+			mediaPaths = append(mediaPaths, path)
+		}
+		content = appendContent(content, "[image]")
+	}
+	switch msg.MsgType {
+	case "text":
+		content = msg.Text.Content
+	case "image":
+		addImage(msg.Image.ImageURL)
+	case "mixed":
+		for _, item := range msg.Mixed.Items {
+			switch item.MsgType {
+			case "text":
+				content = appendContent(content, item.Text.Content)
+			case "image":
+				addImage(item.Image.ImageURL)
+			}
+		}
+	default:
+		content = fmt.Sprintf("[%s]", msg.MsgType)
+	}
+	// Group messages reach the robot only when they @ it
+	if msg.ChatType != "single" {
+		content = reRobotMention.ReplaceAllString(content, "")
+	}
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	metadata := map[string]string{
+		"platform":    "wecom",
+		"message_id":  msg.MsgID,
+		"sender_name": msg.From.Name,
+		"is_dm":       fmt.Sprint(msg.ChatType == "single"),
+	}
+
+	logger.DebugCF("wecom", "Received robot message", map[string]interface{}{
+		"sender_id": senderID,
+		"chat_id":   chatID,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+}
+
+// Send delivers a message through the app, or through the robot for robot
+// chats. Markdown replies are sent as markdown messages.
+func (c *WeComChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("wecom channel not running")
+	}
+	if chatID, ok := strings.CutPrefix(msg.ChatID, wecomRobotPrefix); ok {
+		return c.sendRobot(ctx, chatID, msg)
+	}
+	if c.appCrypt == nil {
+		return fmt.Errorf("wecom app not configured for chat %s", msg.ChatID)
+	}
+	return c.sendApp(ctx, msg)
+}
+
+func (c *WeComChannel) sendApp(ctx context.Context, msg bus.OutboundMessage) error {
+	content := withButtonText(msg.Content, msg.Buttons)
+	var media []map[string]interface{}
+	for _, a := range msg.Attachments {
+		msgType := "file"
+		if a.Kind() == bus.AttachmentImage {
+			msgType = "image"
+		}
+		mediaID, err := c.uploadMedia(ctx, a, msgType)
+		if err != nil {
+			logger.ErrorCF("wecom", "Failed to upload attachment, sending as text", map[string]interface{}{
+				"file":  a.FileName(),
+				"error": err.Error(),
+			})
+			content = appendContent(content, attachmentText(a))
+			continue
+		}
+		media = append(media, map[string]interface{}{
+			"msgtype": msgType,
+			msgType:   map[string]string{"media_id": mediaID},
+		})
+		if a.Caption != "" {
+			content = appendContent(content, a.Caption)
+		}
+	}
+
+	parts := outbox.PartsFrom(ctx)
+	for _, m := range append(wecomChunks(content), media...) {
+		if parts.Skip() {
+			continue
+		}
+		m["touser"] = msg.ChatID
+		m["agentid"] = c.config.AgentID
+		err := c.api(ctx, func(token string) (*http.Request, error) {
+			body, err := json.Marshal(m)
+			if err != nil {
+				return nil, err
+			}
+			return http.NewRequestWithContext(ctx, http.MethodPost,
+				c.apiBase+"/message/send?access_token="+url.QueryEscape(token), bytes.NewReader(body))
+		}, nil)
+		if err != nil {
+			return err
+		}
+		parts.Done()
+	}
+
+	logger.DebugCF("wecom", "App message sent", map[string]interface{}{
+		"chat_id": msg.ChatID,
+	})
+	return nil
+}
+
+// sendRobot posts to the robot's webhook. Robots send images inline and
+// list other attachments as text.
+func (c *WeComChannel) sendRobot(ctx context.Context, chatID string, msg bus.OutboundMessage) error {
+	webhookURL := c.config.RobotWebhookURL
+	if u, ok := c.robotURLs.Load(wecomRobotPrefix + chatID); ok {
+		webhookURL = u.(string)
+	}
+	if webhookURL == "" {
+		return fmt.Errorf("no wecom robot webhook known for chat %s", chatID)
+	}
+
+	content := withButtonText(msg.Content, msg.Buttons)
+	var images []map[string]interface{}
+	for _, a := range msg.Attachments {
+		if a.Kind() == bus.AttachmentImage {
+			data, err := readAttachment(ctx, a)
+			if err == nil && len(data) <= wecomMaxRobotImage {
+				sum := md5.Sum(data)
+				images = append(images, map[string]interface{}{
+					"msgtype": "image",
+					"image": map[string]string{
+						"base64": base64.StdEncoding.EncodeToString(data),
+						"md5":    hex.EncodeToString(sum[:]),
+					},
+				})
+				if a.Caption != "" {
+					content = appendContent(content, a.Caption)
+				}
+				continue
+			}
+		}
+		content = appendContent(content, attachmentText(a))
+	}
+
+	parts := outbox.PartsFrom(ctx)
+	for _, m := range append(wecomChunks(content), images...) {
+		if parts.Skip() {
+			continue
+		}
+		m["chatid"] = chatID
+		body, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if err := c.do(req, nil); err != nil {
+			return err
+		}
+		parts.Done()
+	}
+
+	logger.DebugCF("wecom", "Robot message sent", map[string]interface{}{
+		"chat_id": chatID,
+	})
+	return nil
+}
+
+// wecomChunks turns content into text messages, or markdown messages when
+// it has markdown formatting. WeCom's markdown covers what the agent
+// writes; only the WeCom client renders it, not WeChat.
+func wecomChunks(content string) []map[string]interface{} {
+	var messages []map[string]interface{}
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	if renderMarkdown(content, dialectPlain) != content {
+		for _, chunk := range renderMessage(content, dialectMarkdown, wecomMaxMarkdown) {
+			messages = append(messages, map[string]interface{}{
+				"msgtype":  "markdown",
+				"markdown": map[string]string{"content": chunk},
+			})
+		}
+		return messages
+	}
+	for _, chunk := range renderMessage(content, dialectPlain, wecomMaxText) {
+		messages = append(messages, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": chunk},
+		})
+	}
+	return messages
+}
+
+// uploadMedia uploads an attachment as temporary media and returns its ID.
+func (c *WeComChannel) uploadMedia(ctx context.Context, a bus.Attachment, mediaType string) (string, error) {
+	data, err := readAttachment(ctx, a)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		MediaID string `json:"media_id"`
+	}
+	err = c.api(ctx, func(token string) (*http.Request, error) {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, err := w.CreateFormFile("media", a.FileName())
+		if err != nil {
+			return nil, err
+		}
+		part.Write(data)
+		w.Close()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			c.apiBase+"/media/upload?type="+mediaType+"&access_token="+url.QueryEscape(token), &body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req, nil
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.MediaID, nil
+}
+
+// downloadMedia fetches received media and saves it for the agent.
+func (c *WeComChannel) downloadMedia(mediaID, name string) string {
+	if mediaID == "" {
+		return ""
+	}
+	var data []byte
+	err := c.api(c.ctx, func(token string) (*http.Request, error) {
+		return http.NewRequestWithContext(c.ctx, http.MethodGet,
+			c.apiBase+"/media/get?media_id="+url.QueryEscape(mediaID)+"&access_token="+url.QueryEscape(token), nil)
+	}, &data)
+	if err == nil {
+		var path string
+		if path, err = saveMedia(c.workspace, name, data); err == nil {
+			return path
+		}
+	}
+	logger.ErrorCF("wecom", "Failed to download media", map[string]interface{}{
+		"media_id": mediaID,
+		"error":    err.Error(),
+	})
+	return ""
+}
+
+// api calls a WeCom API with the app's access token. When WeCom rejects
+// the token it is fetched anew and the call made once more.
+func (c *WeComChannel) api(ctx context.Context, build func(token string) (*http.Request, error), out interface{}) error {
+	stale := ""
+	for {
+		token, err := c.accessToken(ctx, stale)
+		if err != nil {
+			return err
+		}
+		req, err := build(token)
+		if err != nil {
+			return err
+		}
+		err = c.do(req, out)
+		var apiErr *wecomError
+		if stale == "" && errors.As(err, &apiErr) && apiErr.tokenInvalid() {
+			stale = token
+			continue
+		}
+		return err
+	}
+}
+
+// do sends a request and checks WeCom's error code. out receives the JSON
+// response, or the body itself when out is a *[]byte and WeCom answers
+// with a file.
+func (c *WeComChannel) do(req *http.Request, out interface{}) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, wecomMaxMedia))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp.StatusCode, fmt.Errorf("wecom API returned status %d: %s", resp.StatusCode, utils.Truncate(string(body), 200)))
+	}
+
+	if raw, ok := out.(*[]byte); ok {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if mediaType != "application/json" && mediaType != "text/plain" {
+			*raw = body
+			return nil
+		}
+		out = nil
+	}
+
+	var result wecomResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("wecom API: %w", err)
+	}
+	if err := result.err(); err != nil {
+		return err
+	}
+	if out != nil {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
+
+// accessToken returns the cached access token, fetching a new one when it
+// is about to expire or equals stale, a token WeCom just rejected.
+func (c *WeComChannel) accessToken(ctx context.Context, stale string) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token != "" && c.token != stale && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.apiBase+"/gettoken?corpid="+url.QueryEscape(c.config.CorpID)+"&corpsecret="+url.QueryEscape(c.config.Secret), nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"` // seconds
+	}
+	if err := c.do(req, &result); err != nil {
+		return "", fmt.Errorf("wecom access token: %w", err)
+	}
+
+	lifetime := time.Duration(result.ExpiresIn)*time.Second - wecomTokenMargin
+	if lifetime < time.Minute {
+		lifetime = time.Minute
+	}
+	c.token = result.AccessToken
+	c.tokenExpiry = time.Now().Add(lifetime)
+	logger.DebugCF("wecom", "Fetched access token", map[string]interface{}{
+		"expires_in": result.ExpiresIn,
+	})
+	return c.token, nil
+}
+
+// wecomCrypt verifies and decrypts callbacks: SHA-1 signatures over the
+// sorted token, timestamp, nonce and ciphertext, and AES-256-CBC with the
+// key from EncodingAESKey.
+type wecomCrypt struct {
+	token     string
+	key       []byte
+	receiveID string // checked against the decrypted message when set
+}
+
+func newWeComCrypt(token, encodingAESKey, receiveID string) (*wecomCrypt, error) {
+	if token == "" {
+		return nil, fmt.Errorf("wecom token is required")
+	}
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("wecom encoding_aes_key must be 43 base64 characters")
+	}
+	return &wecomCrypt{token: token, key: key, receiveID: receiveID}, nil
+}
+
+func (w *wecomCrypt) signature(timestamp, nonce, encrypted string) string {
+	parts := []string{w.token, timestamp, nonce, encrypted}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (w *wecomCrypt) verify(signature, timestamp, nonce, encrypted string) bool {
+	if signature == "" || encrypted == "" {
+		return false
+	}
+	expected := w.signature(timestamp, nonce, encrypted)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) == 1
+}
+
+// decrypt returns the message inside a ciphertext laid out as 16 random
+// bytes, a 4-byte big-endian length, the message and the receiver ID.
+func (w *wecomCrypt) decrypt(encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext is not a whole number of blocks")
+	}
+	block, err := aes.NewCipher(w.key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, w.key[:aes.BlockSize]).CryptBlocks(plain, data)
+
+	// PKCS#7 padded to 32 bytes
+	pad := int(plain[len(plain)-1])
+	if pad < 1 || pad > 32 || pad > len(plain) {
+		return nil, fmt.Errorf("bad padding")
+	}
+	plain = plain[:len(plain)-pad]
+	if len(plain) < 20 {
+		return nil, fmt.Errorf("message too short")
+	}
+	size := int(binary.BigEndian.Uint32(plain[16:20]))
+	if size > len(plain)-20 {
+		return nil, fmt.Errorf("bad message length")
+	}
+	msg, receiveID := plain[20:20+size], string(plain[20+size:])
+	if w.receiveID != "" && receiveID != w.receiveID {
+		return nil, fmt.Errorf("message is for %q, not %q", receiveID, w.receiveID)
+	}
+	return msg, nil
+}
@@ -0,0 +1,265 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+var testWeComKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))[:43]
+
+// fakeWeComAPI stubs the token, message and media endpoints and records
+// what was sent.
+type fakeWeComAPI struct {
+	*httptest.Server
+	mu         sync.Mutex
+	tokenCalls int
+	expireNext bool // answer the next message/send with "token expired"
+	sent       []map[string]interface{}
+	robotSent  []map[string]interface{}
+}
+
+func newFakeWeComAPI(t *testing.T) *fakeWeComAPI {
+	t.Helper()
+	f := &fakeWeComAPI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gettoken", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.tokenCalls++
+		n := f.tokenCalls
+		f.mu.Unlock()
+		if r.URL.Query().Get("corpsecret") != "s3cret" {
+			w.Write([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"errcode": 0, "access_token": "tok" + string(rune('0'+n)), "expires_in": 7200})
+	})
+	mux.HandleFunc("/message/send", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.expireNext {
+			f.expireNext = false
+			w.Write([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`))
+			return
+		}
+		var m map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&m)
+		m["access_token"] = r.URL.Query().Get("access_token")
+		f.sent = append(f.sent, m)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	})
+	mux.HandleFunc("/media/upload", func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("media")
+		if err != nil {
+			w.Write([]byte(`{"errcode":40004,"errmsg":"invalid media"}`))
+			return
+		}
+		file.Close()
+		w.Write([]byte(`{"errcode":0,"type":"` + r.URL.Query().Get("type") + `","media_id":"m-` + header.Filename + `"}`))
+	})
+	mux.HandleFunc("/media/get", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("JPEG:" + r.URL.Query().Get("media_id")))
+	})
+	mux.HandleFunc("/robot/send", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&m)
+		f.mu.Lock()
+		f.robotSent = append(f.robotSent, m)
+		f.mu.Unlock()
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func startWeComChannel(t *testing.T, api *fakeWeComAPI, cfg config.WeComConfig) (*WeComChannel, *bus.MessageBus, *httptest.Server) {
+	t.Helper()
+	mb := bus.NewMessageBus()
+	cfg.WebhookHost = "127.0.0.1"
+	ch, err := NewWeComChannel(cfg, mb, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewWeComChannel error: %v", err)
+	}
+	ch.apiBase = api.URL
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	t.Cleanup(func() { ch.Stop(context.Background()) })
+	srv := httptest.NewServer(ch.routes())
+	t.Cleanup(srv.Close)
+	return ch, mb, srv
+}
+
+// wecomEncrypt encrypts msg the way WeCom does for a callback.
+func wecomEncrypt(t *testing.T, crypt *wecomCrypt, receiveID, msg string) string {
+	t.Helper()
+	var plain bytes.Buffer
+	plain.WriteString("0123456789abcdef")
+	binary.Write(&plain, binary.BigEndian, uint32(len(msg)))
+	plain.WriteString(msg)
+	plain.WriteString(receiveID)
+	pad := 32 - plain.Len()%32
+	plain.Write(bytes.Repeat([]byte{byte(pad)}, pad))
+
+	block, err := aes.NewCipher(crypt.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, plain.Len())
+	cipher.NewCBCEncrypter(block, crypt.key[:aes.BlockSize]).CryptBlocks(out, plain.Bytes())
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+// postWeCom posts a signed, encrypted callback.
+func postWeCom(t *testing.T, srv *httptest.Server, path string, crypt *wecomCrypt, receiveID, msg string) int {
+	t.Helper()
+	encrypted := wecomEncrypt(t, crypt, receiveID, msg)
+	q := url.Values{
+		"msg_signature": {crypt.signature("1700000000", "n0nce", encrypted)},
+		"timestamp":     {"1700000000"},
+		"nonce":         {"n0nce"},
+	}
+	resp, err := http.Post(srv.URL+path+"?"+q.Encode(), "text/xml",
+		strings.NewReader("<xml><Encrypt><![CDATA["+encrypted+"]]></Encrypt></xml>"))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWeComChannel_URLVerification(t *testing.T) {
+	api := newFakeWeComAPI(t)
+	ch, _, srv := startWeComChannel(t, api, config.WeComConfig{
+		CorpID: "ww1", Secret: "s3cret", AgentID: 1000002, Token: "tkn", EncodingAESKey: testWeComKey,
+	})
+
+	echo := wecomEncrypt(t, ch.appCrypt, "ww1", "echo-123")
+	q := url.Values{
+		"msg_signature": {ch.appCrypt.signature("1", "2", echo)},
+		"timestamp":     {"1"},
+		"nonce":         {"2"},
+		"echostr":       {echo},
+	}
+	resp, err := http.Get(srv.URL + "/webhook/wecom?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "echo-123" {
+		t.Errorf("verification = %d %q, want the decrypted echostr", resp.StatusCode, body)
+	}
+
+	q.Set("msg_signature", "bad")
+	resp, _ = http.Get(srv.URL + "/webhook/wecom?" + q.Encode())
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("bad signature = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestWeComChannel_AppMessagesAndReplies(t *testing.T) {
+	api := newFakeWeComAPI(t)
+	ch, mb, srv := startWeComChannel(t, api, config.WeComConfig{
+		CorpID: "ww1", Secret: "s3cret", AgentID: 1000002, Token: "tkn", EncodingAESKey: testWeComKey,
+	})
+
+	// A message for another corp is rejected
+	if status := postWeCom(t, srv, "/webhook/wecom", ch.appCrypt, "ww-other", "<xml><MsgType>text</MsgType></xml>"); status != http.StatusBadRequest {
+		t.Errorf("wrong receiver = %d, want 400", status)
+	}
+
+	postWeCom(t, srv, "/webhook/wecom", ch.appCrypt, "ww1", `<xml><ToUserName>ww1</ToUserName><FromUserName>zhangsan</FromUserName>
+		<MsgType>image</MsgType><MediaId>media-1</MediaId><MsgId>101</MsgId><AgentID>1000002</AgentID></xml>`)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.SenderID != "zhangsan" || msg.ChatID != "zhangsan" || msg.Content != "[image]" || len(msg.Media) != 1 {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	// Plain text goes as text, markdown as markdown, images are uploaded
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "zhangsan", Content: "收到"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	api.mu.Lock()
+	api.expireNext = true
+	api.mu.Unlock()
+	chart := filepath.Join(t.TempDir(), "chart.png")
+	os.WriteFile(chart, []byte("PNG"), 0644)
+	err := ch.Send(ctx, bus.OutboundMessage{ChatID: "zhangsan", Content: "**Done**", Attachments: []bus.Attachment{{Path: chart}}})
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.sent) != 3 {
+		t.Fatalf("sent = %v, want three messages", api.sent)
+	}
+	if api.sent[0]["msgtype"] != "text" || api.sent[0]["touser"] != "zhangsan" || api.sent[0]["agentid"] != float64(1000002) {
+		t.Errorf("text message = %v", api.sent[0])
+	}
+	if api.sent[1]["msgtype"] != "markdown" || api.sent[2]["msgtype"] != "image" {
+		t.Errorf("messages = %v, want markdown then image", api.sent[1:])
+	}
+	if image := api.sent[2]["image"].(map[string]interface{}); image["media_id"] != "m-chart.png" {
+		t.Errorf("image = %v", image)
+	}
+	// The token is cached, and fetched anew once WeCom says it expired
+	if api.tokenCalls != 2 || api.sent[0]["access_token"] != "tok1" || api.sent[1]["access_token"] != "tok2" {
+		t.Errorf("token calls = %d, tokens %v %v", api.tokenCalls, api.sent[0]["access_token"], api.sent[1]["access_token"])
+	}
+}
+
+func TestWeComChannel_GroupRobot(t *testing.T) {
+	api := newFakeWeComAPI(t)
+	ch, mb, srv := startWeComChannel(t, api, config.WeComConfig{RobotToken: "rtkn", RobotEncodingAESKey: testWeComKey})
+
+	postWeCom(t, srv, "/webhook/wecom/robot", ch.robotCrypt, "", `<xml>
+		<WebhookUrl><![CDATA[`+api.URL+`/robot/send?key=k1]]></WebhookUrl>
+		<ChatId><![CDATA[wrkSFfCgAA]]></ChatId><ChatType>group</ChatType>
+		<From><UserId>lisi</UserId><Name>李四</Name></From>
+		<MsgType>text</MsgType><Text><Content><![CDATA[@PicoBot 今天的日程?]]></Content></Text><MsgId>m1</MsgId></xml>`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.SenderID != "lisi" || msg.ChatID != "robot.wrkSFfCgAA" || msg.Content != "今天的日程?" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: msg.ChatID, Content: "- 10:00 standup"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.robotSent) != 1 || api.robotSent[0]["chatid"] != "wrkSFfCgAA" || api.robotSent[0]["msgtype"] != "markdown" {
+		t.Errorf("robot sent = %v", api.robotSent)
+	}
+
+	// Without an app, only robot chats can be answered
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "lisi", Content: "hi"}); err == nil {
+		t.Error("Send to an app chat without an app succeeded")
+	}
+}
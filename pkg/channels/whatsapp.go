@@ -89,7 +89,7 @@ func (c *WhatsAppChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	payload := map[string]interface{}{
 		"type":    "message",
 		"to":      msg.ChatID,
-		"content": msg.Content,
+		"content": plainContent(msg),
 	}
 
 	data, err := json.Marshal(payload)
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 )
@@ -52,11 +53,36 @@ type Config struct {
 	Tools     ToolsConfig     `json:"tools"`
 	Heartbeat HeartbeatConfig `json:"heartbeat"`
 	Devices   DevicesConfig   `json:"devices"`
+	Usage     UsageConfig     `json:"usage"`
+	Bus       BusConfig       `json:"bus"`
 	mu        sync.RWMutex
 }
 
 type AgentsConfig struct {
 	Defaults AgentDefaults `json:"defaults"`
+	Routing  RoutingConfig `json:"routing"`
+}
+
+// ModelRoute selects a provider and model. An empty Provider means the
+// default provider; an empty Model means the default model.
+type ModelRoute struct {
+	Provider string `json:"provider,omitempty" env:"PROVIDER"`
+	Model    string `json:"model,omitempty" env:"MODEL"`
+}
+
+// IsZero reports whether the route leaves both provider and model unset.
+func (r ModelRoute) IsZero() bool {
+	return r.Provider == "" && r.Model == ""
+}
+
+// RoutingConfig sends particular tasks, channels or chats to models other
+// than agents.defaults. Chat keys are "<channel>:<chat_id>".
+type RoutingConfig struct {
+	Summarize ModelRoute            `json:"summarize" envPrefix:"PICOCLAW_AGENTS_ROUTING_SUMMARIZE_"`
+	Heartbeat ModelRoute            `json:"heartbeat" envPrefix:"PICOCLAW_AGENTS_ROUTING_HEARTBEAT_"`
+	Subagent  ModelRoute            `json:"subagent" envPrefix:"PICOCLAW_AGENTS_ROUTING_SUBAGENT_"`
+	Channels  map[string]ModelRoute `json:"channels,omitempty"`
+	Chats     map[string]ModelRoute `json:"chats,omitempty"`
 }
 
 type AgentDefaults struct {
@@ -68,6 +94,9 @@ type AgentDefaults struct {
 	ContextWindow       int     `json:"context_window" env:"PICOCLAW_AGENTS_DEFAULTS_CONTEXT_WINDOW"`
 	Temperature         float64 `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
 	MaxToolIterations   int     `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	ReasoningEffort     string  `json:"reasoning_effort,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_REASONING_EFFORT"` // low, medium or high
+	ThinkingBudget      int     `json:"thinking_budget,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_THINKING_BUDGET"`   // Claude extended thinking tokens
+	ShowReasoning       bool    `json:"show_reasoning,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_SHOW_REASONING"`     // Prefix replies with a collapsed reasoning summary
 }
 
 type ChannelsConfig struct {
@@ -81,6 +110,48 @@ type ChannelsConfig struct {
 	Slack    SlackConfig    `json:"slack"`
 	LINE     LINEConfig     `json:"line"`
 	OneBot   OneBotConfig   `json:"onebot"`
+	Matrix   MatrixConfig   `json:"matrix"`
+	Email    EmailConfig    `json:"email"`
+	Webhook  WebhookConfig  `json:"webhook"`
+	Web      WebConfig      `json:"web"`
+	IRC      IRCConfig      `json:"irc"`
+	Signal   SignalConfig   `json:"signal"`
+	WeCom    WeComConfig    `json:"wecom"`
+	MQTT     MQTTConfig     `json:"mqtt"`
+	Delivery DeliveryConfig `json:"delivery"`
+}
+
+// RetryPolicy controls redelivery of failed outbound messages. The wait
+// starts at BackoffSeconds and doubles up to MaxBackoffSeconds.
+type RetryPolicy struct {
+	MaxAttempts       int `json:"max_attempts,omitempty"`
+	BackoffSeconds    int `json:"backoff_seconds,omitempty"`
+	MaxBackoffSeconds int `json:"max_backoff_seconds,omitempty"`
+}
+
+// DeliveryConfig holds the default retry policy for outbound messages,
+// plus overrides keyed by channel name. Unset override fields fall back
+// to the default.
+type DeliveryConfig struct {
+	Retry    RetryPolicy            `json:"retry"`
+	Channels map[string]RetryPolicy `json:"channels,omitempty"`
+}
+
+// RetryFor returns the retry policy for channel.
+func (d DeliveryConfig) RetryFor(channel string) RetryPolicy {
+	p := d.Retry
+	if o, ok := d.Channels[channel]; ok {
+		if o.MaxAttempts > 0 {
+			p.MaxAttempts = o.MaxAttempts
+		}
+		if o.BackoffSeconds > 0 {
+			p.BackoffSeconds = o.BackoffSeconds
+		}
+		if o.MaxBackoffSeconds > 0 {
+			p.MaxBackoffSeconds = o.MaxBackoffSeconds
+		}
+	}
+	return p
 }
 
 type WhatsAppConfig struct {
@@ -89,11 +160,25 @@ type WhatsAppConfig struct {
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WHATSAPP_ALLOW_FROM"`
 }
 
+// TelegramConfig runs a bot over long polling. With RequireMention the
+// bot only answers in groups when mentioned, replied to or sent one of its
+// commands, or when a message starts with a GroupTriggerPrefix. Commands,
+// when set, replace the bot's "/" menu.
 type TelegramConfig struct {
-	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_TELEGRAM_ENABLED"`
-	Token     string              `json:"token" env:"PICOCLAW_CHANNELS_TELEGRAM_TOKEN"`
-	Proxy     string              `json:"proxy" env:"PICOCLAW_CHANNELS_TELEGRAM_PROXY"`
-	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_TELEGRAM_ALLOW_FROM"`
+	Enabled            bool                `json:"enabled" env:"PICOCLAW_CHANNELS_TELEGRAM_ENABLED"`
+	Token              string              `json:"token" env:"PICOCLAW_CHANNELS_TELEGRAM_TOKEN"`
+	Proxy              string              `json:"proxy" env:"PICOCLAW_CHANNELS_TELEGRAM_PROXY"`
+	RequireMention     bool                `json:"require_mention" env:"PICOCLAW_CHANNELS_TELEGRAM_REQUIRE_MENTION"`
+	GroupTriggerPrefix []string            `json:"group_trigger_prefix" env:"PICOCLAW_CHANNELS_TELEGRAM_GROUP_TRIGGER_PREFIX"`
+	Commands           []TelegramCommand   `json:"commands"`
+	AllowFrom          FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_TELEGRAM_ALLOW_FROM"`
+}
+
+// TelegramCommand is an entry in the "/" menu. Choosing it sends the
+// agent a message such as "/help".
+type TelegramCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
 }
 
 type FeishuConfig struct {
@@ -105,10 +190,25 @@ type FeishuConfig struct {
 	AllowFrom         FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_FEISHU_ALLOW_FROM"`
 }
 
+// DiscordConfig connects a bot. With RequireMention the bot only answers
+// in servers when mentioned, replied to or in a thread it opened; DMs are
+// always answered. AutoThread opens a thread for each new conversation in
+// a server channel. Commands, when set, replace the bot's global slash
+// commands.
 type DiscordConfig struct {
-	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_DISCORD_ENABLED"`
-	Token     string              `json:"token" env:"PICOCLAW_CHANNELS_DISCORD_TOKEN"`
-	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_DISCORD_ALLOW_FROM"`
+	Enabled        bool                `json:"enabled" env:"PICOCLAW_CHANNELS_DISCORD_ENABLED"`
+	Token          string              `json:"token" env:"PICOCLAW_CHANNELS_DISCORD_TOKEN"`
+	RequireMention bool                `json:"require_mention" env:"PICOCLAW_CHANNELS_DISCORD_REQUIRE_MENTION"`
+	AutoThread     bool                `json:"auto_thread" env:"PICOCLAW_CHANNELS_DISCORD_AUTO_THREAD"`
+	Commands       []DiscordCommand    `json:"commands"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_DISCORD_ALLOW_FROM"`
+}
+
+// DiscordCommand is a slash command. Using it sends the agent a message
+// such as "/ask what's the weather", with the command's text option.
+type DiscordCommand struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
 }
 
 type MaixCamConfig struct {
@@ -158,6 +258,147 @@ type OneBotConfig struct {
 	AllowFrom          FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_ONEBOT_ALLOW_FROM"`
 }
 
+// MatrixConfig logs in to a homeserver with an existing account's access
+// token. In rooms with more than two members the bot only answers when
+// mentioned, unless RequireMention is off. With AutoJoin it accepts
+// invites from senders in AllowFrom (MXIDs like "@alice:example.org").
+type MatrixConfig struct {
+	Enabled        bool                `json:"enabled" env:"PICOCLAW_CHANNELS_MATRIX_ENABLED"`
+	Homeserver     string              `json:"homeserver" env:"PICOCLAW_CHANNELS_MATRIX_HOMESERVER"`
+	UserID         string              `json:"user_id" env:"PICOCLAW_CHANNELS_MATRIX_USER_ID"`
+	AccessToken    string              `json:"access_token" env:"PICOCLAW_CHANNELS_MATRIX_ACCESS_TOKEN"`
+	RequireMention bool                `json:"require_mention" env:"PICOCLAW_CHANNELS_MATRIX_REQUIRE_MENTION"`
+	AutoJoin       bool                `json:"auto_join" env:"PICOCLAW_CHANNELS_MATRIX_AUTO_JOIN"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_MATRIX_ALLOW_FROM"`
+}
+
+// EmailConfig reads an IMAP mailbox and replies over SMTP. Port 993
+// (IMAP) and 465 (SMTP) use implicit TLS; other ports are upgraded with
+// STARTTLS, and logging in without TLS needs AllowInsecure. AllowFrom
+// holds sender addresses, which only count once the receiving server's
+// Authentication-Results show DKIM or DMARC passing for the From domain,
+// unless AllowUnauthenticated is set.
+type EmailConfig struct {
+	Enabled       bool                `json:"enabled" env:"PICOCLAW_CHANNELS_EMAIL_ENABLED"`
+	IMAPHost      string              `json:"imap_host" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_HOST"`
+	IMAPPort      int                 `json:"imap_port" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_PORT"`
+	SMTPHost      string              `json:"smtp_host" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_HOST"`
+	SMTPPort      int                 `json:"smtp_port" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_PORT"`
+	Username      string              `json:"username" env:"PICOCLAW_CHANNELS_EMAIL_USERNAME"`
+	Password      string              `json:"password" env:"PICOCLAW_CHANNELS_EMAIL_PASSWORD"`
+	FromAddress   string              `json:"from_address" env:"PICOCLAW_CHANNELS_EMAIL_FROM_ADDRESS"` // Defaults to Username
+	Mailbox       string              `json:"mailbox" env:"PICOCLAW_CHANNELS_EMAIL_MAILBOX"`
+	PollInterval  int                 `json:"poll_interval" env:"PICOCLAW_CHANNELS_EMAIL_POLL_INTERVAL"` // seconds
+	UseIDLE       bool                `json:"use_idle" env:"PICOCLAW_CHANNELS_EMAIL_USE_IDLE"`
+	AllowInsecure bool                `json:"allow_insecure" env:"PICOCLAW_CHANNELS_EMAIL_ALLOW_INSECURE"`
+	AllowFrom     FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_EMAIL_ALLOW_FROM"`
+	// AuthServID is the receiving server's name in Authentication-Results;
+	// when empty, only the topmost header is trusted.
+	AuthServID           string `json:"auth_serv_id" env:"PICOCLAW_CHANNELS_EMAIL_AUTH_SERV_ID"`
+	AllowUnauthenticated bool   `json:"allow_unauthenticated" env:"PICOCLAW_CHANNELS_EMAIL_ALLOW_UNAUTHENTICATED"`
+}
+
+// WebhookConfig accepts messages as authenticated HTTP POSTs. Requests
+// are signed with Secret (HMAC-SHA256 of the body) or carry Token as a
+// bearer token. Replies go back in the response when the caller waits for
+// them, and to CallbackURL otherwise.
+type WebhookConfig struct {
+	Enabled     bool                `json:"enabled" env:"PICOCLAW_CHANNELS_WEBHOOK_ENABLED"`
+	Host        string              `json:"host" env:"PICOCLAW_CHANNELS_WEBHOOK_HOST"`
+	Port        int                 `json:"port" env:"PICOCLAW_CHANNELS_WEBHOOK_PORT"`
+	Path        string              `json:"path" env:"PICOCLAW_CHANNELS_WEBHOOK_PATH"`
+	Secret      string              `json:"secret" env:"PICOCLAW_CHANNELS_WEBHOOK_SECRET"`
+	Token       string              `json:"token" env:"PICOCLAW_CHANNELS_WEBHOOK_TOKEN"`
+	CallbackURL string              `json:"callback_url" env:"PICOCLAW_CHANNELS_WEBHOOK_CALLBACK_URL"`
+	SyncTimeout int                 `json:"sync_timeout" env:"PICOCLAW_CHANNELS_WEBHOOK_SYNC_TIMEOUT"` // seconds
+	AllowFrom   FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WEBHOOK_ALLOW_FROM"`
+}
+
+// WebConfig serves a browser chat page on the gateway's host and port.
+// With Users, each person logs in with their own token (name -> token).
+// Otherwise users pick any name and log in with the shared Token, so
+// anyone who has it can use any name and read that name's sessions. The
+// name is the sender ID checked against AllowFrom.
+type WebConfig struct {
+	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_WEB_ENABLED"`
+	Token     string              `json:"token" env:"PICOCLAW_CHANNELS_WEB_TOKEN"`
+	Users     map[string]string   `json:"users"`
+	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WEB_ALLOW_FROM"`
+}
+
+// IRCConfig connects to one IRC network. In channels the bot only answers
+// messages addressed to its nick when RequireMention is set; private
+// queries are always answered. Channels entries may carry a key after a
+// space ("#private secret").
+type IRCConfig struct {
+	Enabled        bool                `json:"enabled" env:"PICOCLAW_CHANNELS_IRC_ENABLED"`
+	Server         string              `json:"server" env:"PICOCLAW_CHANNELS_IRC_SERVER"` // host:port
+	TLS            bool                `json:"tls" env:"PICOCLAW_CHANNELS_IRC_TLS"`
+	Nick           string              `json:"nick" env:"PICOCLAW_CHANNELS_IRC_NICK"`
+	Username       string              `json:"username" env:"PICOCLAW_CHANNELS_IRC_USERNAME"`
+	RealName       string              `json:"real_name" env:"PICOCLAW_CHANNELS_IRC_REAL_NAME"`
+	Password       string              `json:"password" env:"PICOCLAW_CHANNELS_IRC_PASSWORD"` // server password
+	SASLUser       string              `json:"sasl_user" env:"PICOCLAW_CHANNELS_IRC_SASL_USER"`
+	SASLPassword   string              `json:"sasl_password" env:"PICOCLAW_CHANNELS_IRC_SASL_PASSWORD"`
+	Channels       FlexibleStringSlice `json:"channels" env:"PICOCLAW_CHANNELS_IRC_CHANNELS"`
+	RequireMention bool                `json:"require_mention" env:"PICOCLAW_CHANNELS_IRC_REQUIRE_MENTION"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_IRC_ALLOW_FROM"`
+}
+
+// SignalConfig talks to a signal-cli daemon that owns the bot's account.
+// Endpoint is host:port for "--tcp" or a socket path for "--socket".
+// In groups the bot only answers when mentioned or quoted, unless
+// RequireMention is off.
+type SignalConfig struct {
+	Enabled        bool                `json:"enabled" env:"PICOCLAW_CHANNELS_SIGNAL_ENABLED"`
+	Account        string              `json:"account" env:"PICOCLAW_CHANNELS_SIGNAL_ACCOUNT"` // e.g. +15551234567
+	Endpoint       string              `json:"endpoint" env:"PICOCLAW_CHANNELS_SIGNAL_ENDPOINT"`
+	RequireMention bool                `json:"require_mention" env:"PICOCLAW_CHANNELS_SIGNAL_REQUIRE_MENTION"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_SIGNAL_ALLOW_FROM"`
+}
+
+// WeComConfig connects a WeCom (WeChat Work) self-built app and, optionally,
+// a group robot. WeCom posts encrypted callbacks that are checked with the
+// Token and EncodingAESKey set on the receiving side; app replies go
+// through the message API as AgentID. A robot has its own callback key
+// pair and is answered through the webhook URL each callback carries.
+type WeComConfig struct {
+	Enabled             bool                `json:"enabled" env:"PICOCLAW_CHANNELS_WECOM_ENABLED"`
+	CorpID              string              `json:"corp_id" env:"PICOCLAW_CHANNELS_WECOM_CORP_ID"`
+	Secret              string              `json:"secret" env:"PICOCLAW_CHANNELS_WECOM_SECRET"`
+	AgentID             int                 `json:"agent_id" env:"PICOCLAW_CHANNELS_WECOM_AGENT_ID"`
+	Token               string              `json:"token" env:"PICOCLAW_CHANNELS_WECOM_TOKEN"`
+	EncodingAESKey      string              `json:"encoding_aes_key" env:"PICOCLAW_CHANNELS_WECOM_ENCODING_AES_KEY"`
+	WebhookHost         string              `json:"webhook_host" env:"PICOCLAW_CHANNELS_WECOM_WEBHOOK_HOST"`
+	WebhookPort         int                 `json:"webhook_port" env:"PICOCLAW_CHANNELS_WECOM_WEBHOOK_PORT"`
+	WebhookPath         string              `json:"webhook_path" env:"PICOCLAW_CHANNELS_WECOM_WEBHOOK_PATH"`
+	RobotToken          string              `json:"robot_token" env:"PICOCLAW_CHANNELS_WECOM_ROBOT_TOKEN"`
+	RobotEncodingAESKey string              `json:"robot_encoding_aes_key" env:"PICOCLAW_CHANNELS_WECOM_ROBOT_ENCODING_AES_KEY"`
+	RobotPath           string              `json:"robot_path" env:"PICOCLAW_CHANNELS_WECOM_ROBOT_PATH"`
+	RobotWebhookURL     string              `json:"robot_webhook_url" env:"PICOCLAW_CHANNELS_WECOM_ROBOT_WEBHOOK_URL"` // for messages to a robot chat it has not heard from yet
+	AllowFrom           FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WECOM_ALLOW_FROM"`
+}
+
+// MQTTConfig connects to an MQTT broker. Payloads published on Topics
+// become messages, as plain text or JSON with a "content" field, and
+// replies are published to ResponseTopic, where {topic} is the topic the
+// message came in on and {chat_id} its chat. A message may name its own
+// response topic only if it matches one of ResponseTopics. Format is
+// "text" or "json".
+type MQTTConfig struct {
+	Enabled        bool                `json:"enabled" env:"PICOCLAW_CHANNELS_MQTT_ENABLED"`
+	Broker         string              `json:"broker" env:"PICOCLAW_CHANNELS_MQTT_BROKER"` // tcp://, ssl:// or ws:// URL
+	Username       string              `json:"username" env:"PICOCLAW_CHANNELS_MQTT_USERNAME"`
+	Password       string              `json:"password" env:"PICOCLAW_CHANNELS_MQTT_PASSWORD"`
+	ClientID       string              `json:"client_id" env:"PICOCLAW_CHANNELS_MQTT_CLIENT_ID"`
+	Topics         FlexibleStringSlice `json:"topics" env:"PICOCLAW_CHANNELS_MQTT_TOPICS"`
+	ResponseTopic  string              `json:"response_topic" env:"PICOCLAW_CHANNELS_MQTT_RESPONSE_TOPIC"`
+	ResponseTopics FlexibleStringSlice `json:"response_topics" env:"PICOCLAW_CHANNELS_MQTT_RESPONSE_TOPICS"` // MQTT filters
+	Format         string              `json:"format" env:"PICOCLAW_CHANNELS_MQTT_FORMAT"`
+	QoS            int                 `json:"qos" env:"PICOCLAW_CHANNELS_MQTT_QOS"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_MQTT_ALLOW_FROM"`
+}
+
 type HeartbeatConfig struct {
 	Enabled  bool `json:"enabled" env:"PICOCLAW_HEARTBEAT_ENABLED"`
 	Interval int  `json:"interval" env:"PICOCLAW_HEARTBEAT_INTERVAL"` // minutes, min 5
@@ -168,6 +409,89 @@ type DevicesConfig struct {
 	MonitorUSB bool `json:"monitor_usb" env:"PICOCLAW_DEVICES_MONITOR_USB"`
 }
 
+// BusConfig bounds the queue of messages waiting for the agent. Overflow
+// is "block" (wait for the agent), "drop_oldest" (discard the oldest
+// message of the busiest channel) or "reject" (refuse and tell the sender
+// RejectMessage). With Spool, queued messages are kept on disk and survive
+// a restart.
+type BusConfig struct {
+	QueueSize     int            `json:"queue_size" env:"PICOCLAW_BUS_QUEUE_SIZE"`
+	Overflow      string         `json:"overflow" env:"PICOCLAW_BUS_OVERFLOW"`
+	Spool         bool           `json:"spool" env:"PICOCLAW_BUS_SPOOL"`
+	RejectMessage string         `json:"reject_message,omitempty" env:"PICOCLAW_BUS_REJECT_MESSAGE"`
+	Hooks         []HookConfig   `json:"hooks,omitempty"`
+	Debounce      DebounceConfig `json:"debounce"`
+}
+
+// DebounceConfig merges messages a sender sends in quick succession into
+// one agent turn. WindowMS is the default wait for a follow-up; Channels
+// overrides it per channel, where 0 turns merging off. Slash commands are
+// never held.
+type DebounceConfig struct {
+	WindowMS int            `json:"window_ms" env:"PICOCLAW_BUS_DEBOUNCE_WINDOW_MS"`
+	Channels map[string]int `json:"channels,omitempty"`
+}
+
+// WindowFor returns the merge window for channel.
+func (d DebounceConfig) WindowFor(channel string) time.Duration {
+	ms, ok := d.Channels[channel]
+	if !ok {
+		ms = d.WindowMS
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// HookConfig runs an external program on each message in one direction
+// ("inbound" or "outbound"). Channels limits it to those channels; empty
+// means all. With FailClosed a message is dropped if the program fails
+// instead of passing unchanged.
+type HookConfig struct {
+	Name           string   `json:"name"`
+	Direction      string   `json:"direction"`
+	Command        string   `json:"command"`
+	Args           []string `json:"args,omitempty"`
+	Channels       []string `json:"channels,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+	FailClosed     bool     `json:"fail_closed,omitempty"`
+}
+
+type UsageConfig struct {
+	Enabled       bool                  `json:"enabled" env:"PICOCLAW_USAGE_ENABLED"`
+	Pricing       map[string]ModelPrice `json:"pricing,omitempty"` // keyed by model or "<provider>/<model>"
+	Budgets       BudgetsConfig         `json:"budgets"`
+	BudgetMessage string                `json:"budget_message,omitempty" env:"PICOCLAW_USAGE_BUDGET_MESSAGE"`
+}
+
+// ModelPrice is the price in USD per million tokens.
+type ModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cache_read,omitempty"`  // Defaults to Input
+	CacheWrite float64 `json:"cache_write,omitempty"` // Defaults to Input
+}
+
+// BudgetLimit caps usage per calendar day and month. Zero means unlimited.
+type BudgetLimit struct {
+	DailyTokens   int     `json:"daily_tokens,omitempty"`
+	MonthlyTokens int     `json:"monthly_tokens,omitempty"`
+	DailyCost     float64 `json:"daily_cost,omitempty"`
+	MonthlyCost   float64 `json:"monthly_cost,omitempty"`
+}
+
+// IsZero reports whether no limit is set.
+func (b BudgetLimit) IsZero() bool {
+	return b == BudgetLimit{}
+}
+
+// BudgetsConfig holds default limits for every sender and channel, plus
+// overrides keyed by "<channel>:<sender_id>" and channel name.
+type BudgetsConfig struct {
+	PerSender  BudgetLimit            `json:"per_sender"`
+	PerChannel BudgetLimit            `json:"per_channel"`
+	Senders    map[string]BudgetLimit `json:"senders,omitempty"`
+	Channels   map[string]BudgetLimit `json:"channels,omitempty"`
+}
+
 type ProvidersConfig struct {
 	Anthropic     ProviderConfig `json:"anthropic"`
 	OpenAI        ProviderConfig `json:"openai"`
@@ -181,14 +505,102 @@ type ProvidersConfig struct {
 	ShengSuanYun  ProviderConfig `json:"shengsuanyun"`
 	DeepSeek      ProviderConfig `json:"deepseek"`
 	GitHubCopilot ProviderConfig `json:"github_copilot"`
+
+	// Named holds user-defined provider entries. They live next to the
+	// built-in vendors in the "providers" object, keyed by any name that
+	// is not one of the fields above.
+	Named map[string]ProviderConfig `json:"-"`
 }
 
 type ProviderConfig struct {
-	APIKey      string `json:"api_key" env:"PICOCLAW_PROVIDERS_{{.Name}}_API_KEY"`
-	APIBase     string `json:"api_base" env:"PICOCLAW_PROVIDERS_{{.Name}}_API_BASE"`
-	Proxy       string `json:"proxy,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_PROXY"`
-	AuthMethod  string `json:"auth_method,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_AUTH_METHOD"`
-	ConnectMode string `json:"connect_mode,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
+	Type        string            `json:"type,omitempty"` // openai-compatible, anthropic, codex, gemini, cli
+	APIKey      string            `json:"api_key" env:"PICOCLAW_PROVIDERS_{{.Name}}_API_KEY"`
+	APIBase     string            `json:"api_base" env:"PICOCLAW_PROVIDERS_{{.Name}}_API_BASE"`
+	Proxy       string            `json:"proxy,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_PROXY"`
+	AuthMethod  string            `json:"auth_method,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_AUTH_METHOD"`
+	ConnectMode string            `json:"connect_mode,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
+	Headers     map[string]string `json:"headers,omitempty"`                                                      // extra HTTP headers sent with every request
+	Models      map[string]string `json:"models,omitempty"`                                                       // alias -> upstream model ID
+}
+
+// builtinProviderKeys lists the JSON keys of the fixed ProvidersConfig fields.
+var builtinProviderKeys = map[string]bool{
+	"anthropic":      true,
+	"openai":         true,
+	"openrouter":     true,
+	"groq":           true,
+	"zhipu":          true,
+	"vllm":           true,
+	"gemini":         true,
+	"nvidia":         true,
+	"moonshot":       true,
+	"shengsuanyun":   true,
+	"deepseek":       true,
+	"github_copilot": true,
+}
+
+func (p *ProvidersConfig) UnmarshalJSON(data []byte) error {
+	type plain ProvidersConfig
+	builtin := plain(*p)
+	if err := json.Unmarshal(data, &builtin); err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	// Provider names are case-insensitive: encoding/json already matches the
+	// built-in fields regardless of case, so named entries are lowercased too
+	// and two keys that only differ in case are rejected.
+	named := make(map[string]ProviderConfig)
+	seen := make(map[string]string, len(raw))
+	for name, entry := range raw {
+		key := strings.ToLower(name)
+		if prev, ok := seen[key]; ok {
+			return fmt.Errorf("providers %q and %q differ only in case", prev, name)
+		}
+		seen[key] = name
+		if builtinProviderKeys[key] {
+			continue
+		}
+		var pc ProviderConfig
+		if err := json.Unmarshal(entry, &pc); err != nil {
+			return fmt.Errorf("provider %q: %w", name, err)
+		}
+		named[key] = pc
+	}
+
+	*p = ProvidersConfig(builtin)
+	if len(named) > 0 {
+		p.Named = named
+	}
+	return nil
+}
+
+func (p ProvidersConfig) MarshalJSON() ([]byte, error) {
+	type plain ProvidersConfig
+	data, err := json.Marshal(plain(p))
+	if err != nil || len(p.Named) == 0 {
+		return data, err
+	}
+
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for name, pc := range p.Named {
+		if builtinProviderKeys[name] {
+			continue
+		}
+		entry, err := json.Marshal(pc)
+		if err != nil {
+			return nil, err
+		}
+		merged[name] = entry
+	}
+	return json.Marshal(merged)
 }
 
 type GatewayConfig struct {
@@ -212,8 +624,20 @@ type WebToolsConfig struct {
 	DuckDuckGo DuckDuckGoConfig `json:"duckduckgo"`
 }
 
+// MQTTToolConfig lets the agent publish to and read from an MQTT broker.
+// Without a broker of its own it uses the MQTT channel's. AllowTopics
+// limits the topics it may touch, as MQTT filters; empty allows all.
+type MQTTToolConfig struct {
+	Enabled     bool                `json:"enabled" env:"PICOCLAW_TOOLS_MQTT_ENABLED"`
+	Broker      string              `json:"broker" env:"PICOCLAW_TOOLS_MQTT_BROKER"`
+	Username    string              `json:"username" env:"PICOCLAW_TOOLS_MQTT_USERNAME"`
+	Password    string              `json:"password" env:"PICOCLAW_TOOLS_MQTT_PASSWORD"`
+	AllowTopics FlexibleStringSlice `json:"allow_topics" env:"PICOCLAW_TOOLS_MQTT_ALLOW_TOPICS"`
+}
+
 type ToolsConfig struct {
-	Web WebToolsConfig `json:"web"`
+	Web  WebToolsConfig `json:"web"`
+	MQTT MQTTToolConfig `json:"mqtt"`
 }
 
 func DefaultConfig() *Config {
@@ -237,9 +661,11 @@ func DefaultConfig() *Config {
 				AllowFrom: FlexibleStringSlice{},
 			},
 			Telegram: TelegramConfig{
-				Enabled:   false,
-				Token:     "",
-				AllowFrom: FlexibleStringSlice{},
+				Enabled:            false,
+				Token:              "",
+				RequireMention:     false,
+				GroupTriggerPrefix: []string{},
+				AllowFrom:          FlexibleStringSlice{},
 			},
 			Feishu: FeishuConfig{
 				Enabled:           false,
@@ -250,9 +676,11 @@ func DefaultConfig() *Config {
 				AllowFrom:         FlexibleStringSlice{},
 			},
 			Discord: DiscordConfig{
-				Enabled:   false,
-				Token:     "",
-				AllowFrom: FlexibleStringSlice{},
+				Enabled:        false,
+				Token:          "",
+				RequireMention: false,
+				AutoThread:     false,
+				AllowFrom:      FlexibleStringSlice{},
 			},
 			MaixCam: MaixCamConfig{
 				Enabled:   false,
@@ -295,6 +723,87 @@ func DefaultConfig() *Config {
 				GroupTriggerPrefix: []string{},
 				AllowFrom:          FlexibleStringSlice{},
 			},
+			Matrix: MatrixConfig{
+				Enabled:        false,
+				Homeserver:     "",
+				UserID:         "",
+				AccessToken:    "",
+				RequireMention: true,
+				AutoJoin:       true,
+				AllowFrom:      FlexibleStringSlice{},
+			},
+			Email: EmailConfig{
+				Enabled:      false,
+				IMAPHost:     "",
+				IMAPPort:     993,
+				SMTPHost:     "",
+				SMTPPort:     587,
+				Username:     "",
+				Password:     "",
+				Mailbox:      "INBOX",
+				PollInterval: 60,
+				UseIDLE:      true,
+				AllowFrom:    FlexibleStringSlice{},
+			},
+			Webhook: WebhookConfig{
+				Enabled:     false,
+				Host:        "0.0.0.0",
+				Port:        18792,
+				Path:        "/webhook/message",
+				Secret:      "",
+				Token:       "",
+				CallbackURL: "",
+				SyncTimeout: 120,
+				AllowFrom:   FlexibleStringSlice{},
+			},
+			Web: WebConfig{
+				Enabled:   false,
+				Token:     "",
+				AllowFrom: FlexibleStringSlice{},
+			},
+			IRC: IRCConfig{
+				Enabled:        false,
+				Server:         "irc.libera.chat:6697",
+				TLS:            true,
+				Nick:           "picoclaw",
+				Username:       "picoclaw",
+				RealName:       "picoclaw",
+				Channels:       FlexibleStringSlice{},
+				RequireMention: true,
+				AllowFrom:      FlexibleStringSlice{},
+			},
+			Signal: SignalConfig{
+				Enabled:        false,
+				Endpoint:       "127.0.0.1:7583",
+				RequireMention: true,
+				AllowFrom:      FlexibleStringSlice{},
+			},
+			WeCom: WeComConfig{
+				Enabled:     false,
+				WebhookHost: "0.0.0.0",
+				WebhookPort: 18793,
+				WebhookPath: "/webhook/wecom",
+				RobotPath:   "/webhook/wecom/robot",
+				AllowFrom:   FlexibleStringSlice{},
+			},
+			MQTT: MQTTConfig{
+				Enabled:        false,
+				Broker:         "tcp://localhost:1883",
+				ClientID:       "picoclaw",
+				Topics:         FlexibleStringSlice{"picoclaw/in/#"},
+				ResponseTopic:  "picoclaw/out/{chat_id}",
+				ResponseTopics: FlexibleStringSlice{},
+				Format:         "text",
+				QoS:            1,
+				AllowFrom:      FlexibleStringSlice{},
+			},
+			Delivery: DeliveryConfig{
+				Retry: RetryPolicy{
+					MaxAttempts:       5,
+					BackoffSeconds:    2,
+					MaxBackoffSeconds: 300,
+				},
+			},
 		},
 		Providers: ProvidersConfig{
 			Anthropic:    ProviderConfig{},
@@ -324,6 +833,10 @@ func DefaultConfig() *Config {
 					MaxResults: 5,
 				},
 			},
+			MQTT: MQTTToolConfig{
+				Enabled:     false,
+				AllowTopics: FlexibleStringSlice{},
+			},
 		},
 		Heartbeat: HeartbeatConfig{
 			Enabled:  true,
@@ -333,6 +846,14 @@ func DefaultConfig() *Config {
 			Enabled:    false,
 			MonitorUSB: true,
 		},
+		Usage: UsageConfig{
+			Enabled: true,
+		},
+		Bus: BusConfig{
+			QueueSize: 100,
+			Overflow:  "block",
+			Spool:     true,
+		},
 	}
 }
 
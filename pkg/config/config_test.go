@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -177,3 +178,74 @@ func TestConfig_Complete(t *testing.T) {
 		t.Error("Heartbeat should be enabled by default")
 	}
 }
+
+// TestProvidersConfig_NamedEntries verifies user-defined providers sit next to built-in ones
+func TestProvidersConfig_NamedEntries(t *testing.T) {
+	data := []byte(`{
+		"openai": {"api_key": "sk-openai"},
+		"lab-vllm": {
+			"type": "openai-compatible",
+			"api_base": "http://10.0.0.5:8000/v1",
+			"headers": {"X-Team": "assistant"},
+			"models": {"fast": "qwen2.5-7b"}
+		}
+	}`)
+
+	var pc ProvidersConfig
+	if err := json.Unmarshal(data, &pc); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if pc.OpenAI.APIKey != "sk-openai" {
+		t.Errorf("OpenAI.APIKey = %q, want %q", pc.OpenAI.APIKey, "sk-openai")
+	}
+	if _, ok := pc.Named["openai"]; ok {
+		t.Error("built-in provider should not appear in Named")
+	}
+	lab, ok := pc.Named["lab-vllm"]
+	if !ok {
+		t.Fatal("lab-vllm missing from Named")
+	}
+	if lab.APIBase != "http://10.0.0.5:8000/v1" || lab.Headers["X-Team"] != "assistant" || lab.Models["fast"] != "qwen2.5-7b" {
+		t.Errorf("lab-vllm = %+v", lab)
+	}
+
+	out, err := json.Marshal(pc)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	var roundTrip ProvidersConfig
+	if err := json.Unmarshal(out, &roundTrip); err != nil {
+		t.Fatalf("Unmarshal round trip error: %v", err)
+	}
+	if roundTrip.Named["lab-vllm"].Models["fast"] != "qwen2.5-7b" {
+		t.Errorf("round trip lost named provider: %s", out)
+	}
+}
+
+// TestProvidersConfig_NamesAreCaseInsensitive verifies named keys are lowercased
+// and a mixed-case built-in key is not also registered as a named entry
+func TestProvidersConfig_NamesAreCaseInsensitive(t *testing.T) {
+	data := []byte(`{
+		"OpenAI": {"api_key": "sk-openai"},
+		"Lab-VLLM": {"api_base": "http://localhost:8000/v1"}
+	}`)
+
+	var pc ProvidersConfig
+	if err := json.Unmarshal(data, &pc); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if pc.OpenAI.APIKey != "sk-openai" {
+		t.Errorf("OpenAI.APIKey = %q, want %q", pc.OpenAI.APIKey, "sk-openai")
+	}
+	if len(pc.Named) != 1 {
+		t.Fatalf("Named = %v, want only lab-vllm", pc.Named)
+	}
+	if pc.Named["lab-vllm"].APIBase != "http://localhost:8000/v1" {
+		t.Errorf("Named = %v, want lowercased lab-vllm", pc.Named)
+	}
+
+	dup := []byte(`{"lab": {}, "LAB": {}}`)
+	if err := json.Unmarshal(dup, &pc); err == nil {
+		t.Error("expected an error for keys that differ only in case")
+	}
+}
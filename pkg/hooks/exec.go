@@ -0,0 +1,159 @@
+// Package hooks runs external programs as message bus hooks.
+//
+// A hook program gets one JSON object on stdin:
+//
+//	{"direction": "inbound", "message": {...}}
+//
+// and answers on stdout with one of:
+//
+//	{"message": {...}}          pass on a (possibly modified) message
+//	{"messages": [{...}, ...]}  fan out; an empty list drops the message
+//	{"drop": true}              drop the message
+//
+// Empty output passes the message on unchanged, so a program that only
+// logs can print nothing. Echoing the input back also works.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const defaultTimeout = 10 * time.Second
+
+type request[T any] struct {
+	Direction string `json:"direction"`
+	Message   T      `json:"message"`
+}
+
+type response[T any] struct {
+	Message  *T   `json:"message"`
+	Messages []T  `json:"messages"`
+	Drop     bool `json:"drop"`
+}
+
+// Exec is a hook backed by an external program, run once per message.
+type Exec struct {
+	cfg     config.HookConfig
+	timeout time.Duration
+}
+
+func NewExec(cfg config.HookConfig) (*Exec, error) {
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("hook %q has no command", cfg.Name)
+	}
+	if cfg.Direction != "inbound" && cfg.Direction != "outbound" {
+		return nil, fmt.Errorf("hook %q: direction must be inbound or outbound, got %q", cfg.Name, cfg.Direction)
+	}
+	timeout := defaultTimeout
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	return &Exec{cfg: cfg, timeout: timeout}, nil
+}
+
+// Register adds the configured hooks to mb in order.
+func Register(mb *bus.MessageBus, hooks []config.HookConfig) error {
+	for _, cfg := range hooks {
+		h, err := NewExec(cfg)
+		if err != nil {
+			return err
+		}
+		if cfg.Direction == "inbound" {
+			mb.UseInbound(cfg.Name, h.Inbound)
+		} else {
+			mb.UseOutbound(cfg.Name, h.Outbound)
+		}
+		logger.InfoCF("hooks", "Registered hook", map[string]interface{}{
+			"name":      cfg.Name,
+			"direction": cfg.Direction,
+			"command":   cfg.Command,
+		})
+	}
+	return nil
+}
+
+// Inbound is a bus.InboundHook.
+func (e *Exec) Inbound(ctx context.Context, msg bus.InboundMessage) ([]bus.InboundMessage, error) {
+	if !e.applies(msg.Channel) {
+		return []bus.InboundMessage{msg}, nil
+	}
+	out, err := run(ctx, e, msg)
+	return handleFailure(e, out, err)
+}
+
+// Outbound is a bus.OutboundHook.
+func (e *Exec) Outbound(ctx context.Context, msg bus.OutboundMessage) ([]bus.OutboundMessage, error) {
+	if !e.applies(msg.Channel) {
+		return []bus.OutboundMessage{msg}, nil
+	}
+	out, err := run(ctx, e, msg)
+	return handleFailure(e, out, err)
+}
+
+func (e *Exec) applies(channel string) bool {
+	return len(e.cfg.Channels) == 0 || slices.Contains(e.cfg.Channels, channel)
+}
+
+// handleFailure turns an error into a drop when the hook fails closed; the
+// bus passes the message unchanged otherwise.
+func handleFailure[T any](e *Exec, out []T, err error) ([]T, error) {
+	if err != nil && e.cfg.FailClosed {
+		logger.WarnCF("hooks", "Hook failed, dropping message", map[string]interface{}{
+			"name":  e.cfg.Name,
+			"error": err.Error(),
+		})
+		return nil, nil
+	}
+	return out, err
+}
+
+func run[T any](ctx context.Context, e *Exec, msg T) ([]T, error) {
+	input, err := json.Marshal(request[T]{Direction: e.cfg.Direction, Message: msg})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.cfg.Command, e.cfg.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("hook %s: %s", e.cfg.Name, msg)
+		}
+		return nil, fmt.Errorf("hook %s: %w", e.cfg.Name, err)
+	}
+
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return []T{msg}, nil
+	}
+	var resp response[T]
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("hook %s: invalid output: %w", e.cfg.Name, err)
+	}
+	switch {
+	case resp.Drop:
+		return nil, nil
+	case resp.Messages != nil:
+		return resp.Messages, nil
+	case resp.Message != nil:
+		return []T{*resp.Message}, nil
+	}
+	return []T{msg}, nil
+}
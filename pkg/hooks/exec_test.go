@@ -0,0 +1,79 @@
+package hooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func shellHook(t *testing.T, direction, script string, failClosed bool) *Exec {
+	t.Helper()
+	h, err := NewExec(config.HookConfig{
+		Name:       "test",
+		Direction:  direction,
+		Command:    "sh",
+		Args:       []string{"-c", script},
+		FailClosed: failClosed,
+	})
+	if err != nil {
+		t.Fatalf("NewExec error: %v", err)
+	}
+	return h
+}
+
+func TestExec_Inbound(t *testing.T) {
+	msg := bus.InboundMessage{Channel: "telegram", ChatID: "1", Content: "my password is hunter2"}
+
+	tests := []struct {
+		name       string
+		script     string
+		failClosed bool
+		want       []string
+		wantErr    bool
+	}{
+		{"echo edited input", `sed 's/hunter2/[redacted]/'`, false, []string{"my password is [redacted]"}, false},
+		{"no output passes", `cat > /dev/null`, false, []string{msg.Content}, false},
+		{"drop", `echo '{"drop": true}'`, false, nil, false},
+		{"fan out", `echo '{"messages": [{"channel": "telegram", "content": "a"}, {"channel": "telegram", "content": "b"}]}'`, false, []string{"a", "b"}, false},
+		{"failure", `echo nope >&2; exit 1`, false, nil, true},
+		{"failure closed", `exit 1`, true, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := shellHook(t, "inbound", tt.script, tt.failClosed).Inbound(context.Background(), msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(out) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d: %+v", len(out), len(tt.want), out)
+			}
+			for i, want := range tt.want {
+				if out[i].Content != want {
+					t.Errorf("message %d = %q, want %q", i, out[i].Content, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExec_SkipsOtherChannels(t *testing.T) {
+	h, err := NewExec(config.HookConfig{Name: "slack-only", Direction: "outbound", Command: "false", Channels: []string{"slack"}})
+	if err != nil {
+		t.Fatalf("NewExec error: %v", err)
+	}
+	out, err := h.Outbound(context.Background(), bus.OutboundMessage{Channel: "telegram", Content: "hi"})
+	if err != nil || len(out) != 1 || out[0].Content != "hi" {
+		t.Errorf("got %+v, %v; want message passed through", out, err)
+	}
+}
+
+func TestNewExec_Validates(t *testing.T) {
+	if _, err := NewExec(config.HookConfig{Name: "x", Direction: "sideways", Command: "cat"}); err == nil {
+		t.Error("expected error for bad direction")
+	}
+	if _, err := NewExec(config.HookConfig{Name: "x", Direction: "inbound"}); err == nil {
+		t.Error("expected error for missing command")
+	}
+}
@@ -0,0 +1,313 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// rescanInterval is how often the queue looks for entries replayed from the
+// dead-letter store by another process.
+const rescanInterval = 30 * time.Second
+
+// Policy controls retries for one channel. The wait before each retry
+// starts at Backoff and doubles up to MaxBackoff.
+type Policy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// delay returns the wait after the given number of failed attempts.
+func (p Policy) delay(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// SendFunc delivers one message. The context carries the entry's Parts.
+type SendFunc func(ctx context.Context, msg bus.OutboundMessage) error
+
+// permanentError marks a delivery failure that retrying can't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the queue moves the entry to the dead letters
+// right away instead of retrying it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Parts tracks the delivery of a message that goes out in several parts
+// (chunks of a long text, attachments), so that a retry skips the parts an
+// earlier attempt already sent instead of sending them twice. Senders call
+// Skip before each part, in the same order on every attempt, and Done once
+// it went out. A nil *Parts, as returned outside the queue, skips nothing.
+type Parts struct {
+	entry *Entry
+	save  func(*Entry)
+	next  int // Parts looked at so far in this attempt
+}
+
+type partsKey struct{}
+
+// PartsFrom returns the tracker for the message being delivered with ctx,
+// or nil.
+func PartsFrom(ctx context.Context) *Parts {
+	p, _ := ctx.Value(partsKey{}).(*Parts)
+	return p
+}
+
+// Skip moves on to the next part and reports whether an earlier attempt
+// already sent it.
+func (p *Parts) Skip() bool {
+	if p == nil {
+		return false
+	}
+	p.next++
+	return p.next <= p.entry.PartsSent
+}
+
+// Done records that the current part went out.
+func (p *Parts) Done() {
+	if p == nil || p.next <= p.entry.PartsSent {
+		return
+	}
+	p.entry.PartsSent = p.next
+	p.save(p.entry)
+}
+
+// EditTarget returns the message the first part should edit: the one an
+// earlier attempt picked, else target, which is kept for later attempts.
+// This matters when the choice used up state, like a placeholder.
+func (p *Parts) EditTarget(target string) string {
+	if p == nil {
+		return target
+	}
+	if p.entry.EditTarget != "" {
+		return p.entry.EditTarget
+	}
+	if target != "" {
+		p.entry.EditTarget = target
+		p.save(p.entry)
+	}
+	return target
+}
+
+// PolicyFunc returns the retry policy for a channel.
+type PolicyFunc func(channel string) Policy
+
+// Queue delivers persisted entries with retries. Messages to the same chat
+// go out one at a time, in order; different chats don't wait on each other.
+type Queue struct {
+	store  *Store
+	send   SendFunc
+	policy PolicyFunc
+
+	mu     sync.Mutex
+	ctx    context.Context
+	chats  map[string][]*Entry // chat key -> entries in order
+	active map[string]bool     // chats with a delivery goroutine
+	known  map[string]bool     // IDs of entries held in chats
+}
+
+func NewQueue(store *Store, send SendFunc, policy PolicyFunc) *Queue {
+	return &Queue{
+		store:  store,
+		send:   send,
+		policy: policy,
+		ctx:    context.Background(),
+		chats:  make(map[string][]*Entry),
+		active: make(map[string]bool),
+		known:  make(map[string]bool),
+	}
+}
+
+// Start resumes delivery of entries left from a previous run and keeps
+// picking up replayed ones until ctx is done.
+func (q *Queue) Start(ctx context.Context) {
+	q.mu.Lock()
+	q.ctx = ctx
+	q.mu.Unlock()
+
+	q.loadPending()
+	go func() {
+		ticker := time.NewTicker(rescanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q.loadPending()
+			}
+		}
+	}()
+}
+
+// Enqueue persists msg and schedules its delivery. If persisting fails the
+// message is still delivered, just not durably.
+func (q *Queue) Enqueue(msg bus.OutboundMessage) error {
+	e, err := q.store.Add(msg)
+
+	q.mu.Lock()
+	q.schedule(e)
+	q.mu.Unlock()
+	return err
+}
+
+// loadPending schedules stored entries not yet in memory. It holds the lock
+// while listing so an entry finishing meanwhile isn't scheduled again.
+func (q *Queue) loadPending() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries, err := q.store.Pending()
+	if err != nil {
+		logger.ErrorCF("outbox", "Failed to read pending deliveries", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	for _, e := range entries {
+		if !q.known[e.ID] {
+			q.schedule(e)
+		}
+	}
+}
+
+// schedule appends e to its chat and starts a delivery goroutine for the
+// chat if none is running. Must be called with q.mu held.
+func (q *Queue) schedule(e *Entry) {
+	key := e.Message.Channel + "\x00" + e.Message.ChatID
+	q.known[e.ID] = true
+	q.chats[key] = append(q.chats[key], e)
+	if !q.active[key] {
+		q.active[key] = true
+		go q.deliverChat(q.ctx, key)
+	}
+}
+
+func (q *Queue) deliverChat(ctx context.Context, key string) {
+	for {
+		q.mu.Lock()
+		entries := q.chats[key]
+		if len(entries) == 0 || ctx.Err() != nil {
+			delete(q.active, key)
+			if len(entries) == 0 {
+				delete(q.chats, key)
+			}
+			q.mu.Unlock()
+			return
+		}
+		e := entries[0]
+		q.mu.Unlock()
+
+		if !q.deliver(ctx, e) {
+			// Shutting down; the entry stays pending for the next run
+			q.mu.Lock()
+			delete(q.active, key)
+			q.mu.Unlock()
+			return
+		}
+
+		q.mu.Lock()
+		q.chats[key] = q.chats[key][1:]
+		delete(q.known, e.ID)
+		q.mu.Unlock()
+	}
+}
+
+// deliver sends e until it succeeds, runs out of attempts or fails
+// permanently, returning false only if ctx ended first.
+func (q *Queue) deliver(ctx context.Context, e *Entry) bool {
+	policy := q.policy(e.Message.Channel)
+	for {
+		parts := &Parts{entry: e, save: q.update}
+		err := q.send(context.WithValue(ctx, partsKey{}, parts), e.Message)
+		if err == nil {
+			q.mu.Lock()
+			if err := q.store.Done(e.ID); err != nil {
+				logger.WarnCF("outbox", "Failed to remove delivered entry", map[string]interface{}{
+					"id":    e.ID,
+					"error": err.Error(),
+				})
+			}
+			q.mu.Unlock()
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		e.Attempts++
+		e.LastError = err.Error()
+		if e.Attempts >= policy.MaxAttempts || IsPermanent(err) {
+			logger.ErrorCF("outbox", "Delivery failed, moved to dead letters", map[string]interface{}{
+				"id":       e.ID,
+				"channel":  e.Message.Channel,
+				"chat_id":  e.Message.ChatID,
+				"attempts": e.Attempts,
+				"error":    e.LastError,
+			})
+			q.mu.Lock()
+			if err := q.store.Fail(e); err != nil {
+				logger.ErrorCF("outbox", "Failed to store dead letter", map[string]interface{}{
+					"id":    e.ID,
+					"error": err.Error(),
+				})
+			}
+			q.mu.Unlock()
+			return true
+		}
+
+		delay := policy.delay(e.Attempts)
+		logger.WarnCF("outbox", "Delivery failed, retrying", map[string]interface{}{
+			"id":       e.ID,
+			"channel":  e.Message.Channel,
+			"attempts": e.Attempts,
+			"retry_in": delay.String(),
+			"error":    e.LastError,
+		})
+		q.update(e)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+// update persists e's progress, logging rather than failing the delivery
+// when it can't.
+func (q *Queue) update(e *Entry) {
+	if err := q.store.Update(e); err != nil {
+		logger.WarnCF("outbox", "Failed to update pending entry", map[string]interface{}{
+			"id":    e.ID,
+			"error": err.Error(),
+		})
+	}
+}
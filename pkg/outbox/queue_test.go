@@ -0,0 +1,241 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+// fakeSender fails the first failures[content] attempts for each message
+// and records the order of successful sends.
+type fakeSender struct {
+	mu       sync.Mutex
+	failures map[string]int
+	sent     []string
+	attempts map[string]int
+}
+
+func newFakeSender(failures map[string]int) *fakeSender {
+	return &fakeSender{failures: failures, attempts: make(map[string]int)}
+}
+
+func (f *fakeSender) send(ctx context.Context, msg bus.OutboundMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts[msg.Content]++
+	if f.attempts[msg.Content] <= f.failures[msg.Content] {
+		return errors.New("channel unavailable")
+	}
+	f.sent = append(f.sent, msg.Content)
+	return nil
+}
+
+func (f *fakeSender) sentMessages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+func testPolicy(string) Policy {
+	return Policy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for deliveries")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestQueue(t *testing.T, sender *fakeSender) (*Queue, *Store) {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	q := NewQueue(store, sender.send, testPolicy)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	q.Start(ctx)
+	return q, store
+}
+
+func TestQueue_RetriesKeepChatOrder(t *testing.T) {
+	sender := newFakeSender(map[string]int{"first": 2})
+	q, store := newTestQueue(t, sender)
+
+	for _, content := range []string{"first", "second", "third"} {
+		if err := q.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "1", Content: content}); err != nil {
+			t.Fatalf("Enqueue error: %v", err)
+		}
+	}
+
+	waitFor(t, func() bool { return len(sender.sentMessages()) == 3 })
+	got := sender.sentMessages()
+	want := []string{"first", "second", "third"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent = %v, want %v", got, want)
+		}
+	}
+
+	waitFor(t, func() bool {
+		pending, _ := store.Pending()
+		return len(pending) == 0
+	})
+}
+
+func TestQueue_DeadLettersAndReplays(t *testing.T) {
+	sender := newFakeSender(map[string]int{"doomed": 3})
+	q, store := newTestQueue(t, sender)
+
+	if err := q.Enqueue(bus.OutboundMessage{Channel: "slack", ChatID: "C1", Content: "doomed"}); err != nil {
+		t.Fatalf("Enqueue error: %v", err)
+	}
+
+	var dead []*Entry
+	waitFor(t, func() bool {
+		dead, _ = store.Dead()
+		return len(dead) == 1
+	})
+	if dead[0].Attempts != 3 || dead[0].LastError != "channel unavailable" {
+		t.Errorf("dead entry = %+v, want 3 attempts with last error", dead[0])
+	}
+	if len(sender.sentMessages()) != 0 {
+		t.Fatalf("sent = %v, want nothing", sender.sentMessages())
+	}
+
+	// Replay puts it back in pending; the next scan delivers it
+	if err := store.Replay(dead[0].ID); err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+	if dead, _ := store.Dead(); len(dead) != 0 {
+		t.Errorf("dead after replay = %d, want 0", len(dead))
+	}
+	q.loadPending()
+	waitFor(t, func() bool { return len(sender.sentMessages()) == 1 })
+}
+
+func TestQueue_ResumesPendingAfterRestart(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	for _, content := range []string{"a", "b"} {
+		if _, err := store.Add(bus.OutboundMessage{Channel: "discord", ChatID: "9", Content: content}); err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+	}
+
+	sender := newFakeSender(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	NewQueue(store, sender.send, testPolicy).Start(ctx)
+
+	waitFor(t, func() bool { return len(sender.sentMessages()) == 2 })
+	if got := sender.sentMessages(); got[0] != "a" || got[1] != "b" {
+		t.Errorf("sent = %v, want [a b]", got)
+	}
+}
+
+func TestQueue_RetryResumesAfterSentParts(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		sent     []string
+		targets  []string
+		attempts int
+	)
+	send := func(ctx context.Context, msg bus.OutboundMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		parts := PartsFrom(ctx)
+		// The placeholder is used up by the first attempt
+		placeholder := ""
+		if attempts == 1 {
+			placeholder = "placeholder-1"
+		}
+		targets = append(targets, parts.EditTarget(placeholder))
+		for i := 0; i < 3; i++ {
+			if parts.Skip() {
+				continue
+			}
+			if i == 2 && attempts == 1 {
+				return errors.New("connection reset")
+			}
+			sent = append(sent, fmt.Sprintf("part %d", i))
+			parts.Done()
+		}
+		return nil
+	}
+
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewQueue(store, send, testPolicy)
+	q.Start(ctx)
+	if err := q.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "1", Content: "long"}); err != nil {
+		t.Fatalf("Enqueue error: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		pending, _ := store.Pending()
+		return len(pending) == 0
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(sent, ",") != "part 0,part 1,part 2" {
+		t.Errorf("sent = %v, want each part once", sent)
+	}
+	if len(targets) != 2 || targets[1] != "placeholder-1" {
+		t.Errorf("edit targets = %v, want the retry to reuse placeholder-1", targets)
+	}
+}
+
+func TestQueue_PermanentErrorSkipsRetries(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore error: %v", err)
+	}
+	attempts := 0
+	send := func(ctx context.Context, msg bus.OutboundMessage) error {
+		attempts++
+		return Permanent(errors.New("channel not found"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewQueue(store, send, testPolicy)
+	q.Start(ctx)
+	q.Enqueue(bus.OutboundMessage{Channel: "gone", ChatID: "1", Content: "hi"})
+
+	var dead []*Entry
+	waitFor(t, func() bool {
+		dead, _ = store.Dead()
+		return len(dead) == 1
+	})
+	if dead[0].Attempts != 1 || dead[0].LastError != "channel not found" {
+		t.Errorf("dead entry = %+v, want 1 attempt", dead[0])
+	}
+}
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{Backoff: 2 * time.Second, MaxBackoff: 10 * time.Second}
+	for attempts, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 3: 8 * time.Second, 4: 10 * time.Second, 20: 10 * time.Second} {
+		if got := p.delay(attempts); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
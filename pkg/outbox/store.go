@@ -0,0 +1,195 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	pendingDir = "pending"
+	deadDir    = "dead"
+)
+
+// Entry is an outbound message waiting for delivery, or given up on.
+// PartsSent and EditTarget record how far earlier attempts got with a
+// message sent in several parts, so a retry picks up where they stopped.
+type Entry struct {
+	ID         string              `json:"id"`
+	Message    bus.OutboundMessage `json:"message"`
+	Attempts   int                 `json:"attempts"`
+	LastError  string              `json:"last_error,omitempty"`
+	PartsSent  int                 `json:"parts_sent,omitempty"`
+	EditTarget string              `json:"edit_target,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	FailedAt   time.Time           `json:"failed_at,omitempty"`
+}
+
+// Store persists entries as one JSON file each: pending/ for messages not
+// yet delivered and dead/ for ones that ran out of attempts. IDs sort in
+// creation order.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+	seq int
+}
+
+// NewStore opens (creating if needed) the store in dir.
+func NewStore(dir string) (*Store, error) {
+	for _, sub := range []string{pendingDir, deadDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("creating outbox directory: %w", err)
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Add persists msg as a new pending entry.
+func (s *Store) Add(msg bus.OutboundMessage) (*Entry, error) {
+	e := &Entry{
+		ID:        s.newID(),
+		Message:   msg,
+		CreatedAt: time.Now(),
+	}
+	return e, s.write(pendingDir, e)
+}
+
+// Update rewrites a pending entry, e.g. after a failed attempt.
+func (s *Store) Update(e *Entry) error {
+	return s.write(pendingDir, e)
+}
+
+// Done removes a delivered entry.
+func (s *Store) Done(id string) error {
+	err := os.Remove(s.path(pendingDir, id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Fail moves an entry to the dead-letter store.
+func (s *Store) Fail(e *Entry) error {
+	e.FailedAt = time.Now()
+	if err := s.write(deadDir, e); err != nil {
+		return err
+	}
+	return s.Done(e.ID)
+}
+
+// Pending returns the undelivered entries, oldest first.
+func (s *Store) Pending() ([]*Entry, error) {
+	return s.list(pendingDir)
+}
+
+// Dead returns the dead-lettered entries, oldest first.
+func (s *Store) Dead() ([]*Entry, error) {
+	return s.list(deadDir)
+}
+
+// Replay moves a dead-lettered entry back to pending with its attempts
+// reset. A running gateway picks it up on its next scan.
+func (s *Store) Replay(id string) error {
+	e, err := s.read(deadDir, id)
+	if err != nil {
+		return err
+	}
+	e.Attempts = 0
+	e.LastError = ""
+	e.FailedAt = time.Time{}
+	if err := s.write(pendingDir, e); err != nil {
+		return err
+	}
+	return os.Remove(s.path(deadDir, id))
+}
+
+// Purge deletes a dead-lettered entry.
+func (s *Store) Purge(id string) error {
+	err := os.Remove(s.path(deadDir, id))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no failed delivery %s", id)
+	}
+	return err
+}
+
+func (s *Store) newID() string {
+	s.mu.Lock()
+	s.seq = (s.seq + 1) % 10000
+	seq := s.seq
+	s.mu.Unlock()
+	return fmt.Sprintf("%d-%04d", time.Now().UnixNano(), seq)
+}
+
+func (s *Store) path(sub, id string) string {
+	return filepath.Join(s.dir, sub, id+".json")
+}
+
+// write saves e atomically (temp file + rename), so a crash never leaves
+// a half-written entry.
+func (s *Store) write(sub string, e *Entry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling outbox entry: %w", err)
+	}
+	path := s.path(sub, e.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing outbox entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing outbox entry: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) read(sub, id string) (*Entry, error) {
+	data, err := os.ReadFile(s.path(sub, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no failed delivery %s", id)
+		}
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("reading outbox entry %s: %w", id, err)
+	}
+	return &e, nil
+}
+
+func (s *Store) list(sub string) ([]*Entry, error) {
+	files, err := os.ReadDir(filepath.Join(s.dir, sub))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+
+	entries := make([]*Entry, 0, len(ids))
+	for _, id := range ids {
+		e, err := s.read(sub, id)
+		if err != nil {
+			logger.WarnCF("outbox", "Skipping unreadable entry", map[string]interface{}{
+				"id":    id,
+				"error": err.Error(),
+			})
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,266 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// ErrNoCassetteMatch is returned by a ReplayProvider when no recorded
+// interaction matches the request.
+var ErrNoCassetteMatch = errors.New("no recorded interaction matches request")
+
+// Interaction is one recorded Chat call. A cassette is a JSONL file of
+// interactions in call order.
+type Interaction struct {
+	Hash       string       `json:"hash"`
+	Model      string       `json:"model"`
+	Messages   []Message    `json:"messages"`
+	Tools      []string     `json:"tools,omitempty"`
+	Response   *LLMResponse `json:"response,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	RecordedAt time.Time    `json:"recorded_at"`
+}
+
+// HashRequest returns the key used to match a request against a cassette.
+// System messages are left out because the turn context embeds the current
+// time; everything else (model, offered tool names, roles, text, tool calls,
+// tool results) counts.
+func HashRequest(messages []Message, tools []ToolDefinition, model string) string {
+	type hashedCall struct {
+		ID        string                 `json:"id"`
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	type hashedMessage struct {
+		Role       string       `json:"role"`
+		Content    string       `json:"content"`
+		ToolCalls  []hashedCall `json:"tool_calls,omitempty"`
+		ToolCallID string       `json:"tool_call_id,omitempty"`
+	}
+
+	hashed := make([]hashedMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			continue
+		}
+		hm := hashedMessage{
+			Role:       msg.Role,
+			Content:    msg.GetTextContent(),
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, tc := range msg.ToolCalls {
+			name, args := tc.Name, tc.Arguments
+			if tc.Function != nil {
+				if name == "" {
+					name = tc.Function.Name
+				}
+				if args == nil && tc.Function.Arguments != "" {
+					json.Unmarshal([]byte(tc.Function.Arguments), &args)
+				}
+			}
+			hm.ToolCalls = append(hm.ToolCalls, hashedCall{ID: tc.ID, Name: name, Arguments: args})
+		}
+		hashed = append(hashed, hm)
+	}
+
+	names := toolNames(tools)
+	sort.Strings(names)
+
+	// encoding/json sorts map keys, so the encoding is canonical
+	data, _ := json.Marshal(struct {
+		Model    string          `json:"model"`
+		Tools    []string        `json:"tools"`
+		Messages []hashedMessage `json:"messages"`
+	}{model, names, hashed})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func toolNames(tools []ToolDefinition) []string {
+	names := make([]string, 0, len(tools))
+	for _, t := range tools {
+		names = append(names, t.Function.Name)
+	}
+	return names
+}
+
+// RecordingProvider wraps a provider and appends every call to a cassette.
+type RecordingProvider struct {
+	inner LLMProvider
+	path  string
+	mu    sync.Mutex
+}
+
+func NewRecordingProvider(inner LLMProvider, path string) (*RecordingProvider, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+		}
+	}
+	return &RecordingProvider{inner: inner, path: path}, nil
+}
+
+func (p *RecordingProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	resp, err := p.inner.Chat(ctx, messages, tools, model, options)
+
+	interaction := Interaction{
+		Hash:       HashRequest(messages, tools, model),
+		Model:      model,
+		Messages:   messages,
+		Tools:      toolNames(tools),
+		Response:   resp,
+		RecordedAt: time.Now(),
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+	}
+	if werr := p.append(interaction); werr != nil {
+		logger.WarnCF("providers", "Failed to record interaction", map[string]interface{}{
+			"path":  p.path,
+			"error": werr.Error(),
+		})
+	}
+
+	return resp, err
+}
+
+func (p *RecordingProvider) GetDefaultModel() string {
+	return p.inner.GetDefaultModel()
+}
+
+func (p *RecordingProvider) append(interaction Interaction) error {
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// ReplayProvider serves responses from a cassette without network access.
+// Requests are matched by HashRequest; repeated identical requests get the
+// recorded responses in order. When nothing matches, the provider fails
+// with ErrNoCassetteMatch; with SetStrict(false) the next unplayed
+// interaction is served instead (useful when tool output such as timestamps
+// differs between runs).
+type ReplayProvider struct {
+	interactions []Interaction
+	strict       bool
+
+	mu     sync.Mutex
+	played []bool
+}
+
+// LoadCassette reads all interactions from a cassette file.
+func LoadCassette(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var interactions []Interaction
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var it Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &it); err != nil {
+			return nil, fmt.Errorf("invalid cassette entry %d: %w", len(interactions)+1, err)
+		}
+		interactions = append(interactions, it)
+	}
+	return interactions, scanner.Err()
+}
+
+func NewReplayProvider(path string) (*ReplayProvider, error) {
+	interactions, err := LoadCassette(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading cassette: %w", err)
+	}
+	return &ReplayProvider{
+		interactions: interactions,
+		strict:       true,
+		played:       make([]bool, len(interactions)),
+	}, nil
+}
+
+// SetStrict controls whether unmatched requests fail (the default) or fall
+// back to the next unplayed interaction.
+func (p *ReplayProvider) SetStrict(strict bool) {
+	p.strict = strict
+}
+
+func (p *ReplayProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	hash := HashRequest(messages, tools, model)
+
+	p.mu.Lock()
+	idx := p.next(func(it Interaction) bool { return it.Hash == hash })
+	if idx < 0 && !p.strict {
+		idx = p.next(func(Interaction) bool { return true })
+		if idx >= 0 {
+			logger.WarnCF("providers", "No cassette match, replaying next interaction in order",
+				map[string]interface{}{
+					"hash":  hash,
+					"index": idx,
+				})
+		}
+	}
+	p.mu.Unlock()
+
+	if idx < 0 {
+		return nil, fmt.Errorf("%w (hash %s)", ErrNoCassetteMatch, hash)
+	}
+
+	it := p.interactions[idx]
+	if it.Error != "" {
+		return nil, errors.New(it.Error)
+	}
+	if it.Response == nil {
+		return &LLMResponse{FinishReason: "stop"}, nil
+	}
+	resp := *it.Response
+	return &resp, nil
+}
+
+// next marks and returns the first unplayed interaction accepted by match,
+// or -1. Caller holds p.mu.
+func (p *ReplayProvider) next(match func(Interaction) bool) int {
+	for i, it := range p.interactions {
+		if !p.played[i] && match(it) {
+			p.played[i] = true
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *ReplayProvider) GetDefaultModel() string {
+	if len(p.interactions) > 0 {
+		return p.interactions[0].Model
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// scriptedProvider returns canned responses in order
+type scriptedProvider struct {
+	responses []*LLMResponse
+	calls     int
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	if p.calls >= len(p.responses) {
+		return nil, errors.New("script exhausted")
+	}
+	resp := p.responses[p.calls]
+	p.calls++
+	return resp, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string {
+	return "scripted"
+}
+
+func TestCassette_RecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "weather.jsonl")
+	inner := &scriptedProvider{responses: []*LLMResponse{
+		{ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: map[string]interface{}{"city": "SF"}}}, FinishReason: "tool_calls"},
+		{Content: "It's 72F in SF.", FinishReason: "stop"},
+	}}
+	recorder, err := NewRecordingProvider(inner, path)
+	if err != nil {
+		t.Fatalf("NewRecordingProvider error: %v", err)
+	}
+
+	ctx := context.Background()
+	turn1 := []Message{
+		{Role: "system", Content: "Current time: 10:00"},
+		{Role: "user", Content: "Weather in SF?"},
+	}
+	first, _ := recorder.Chat(ctx, turn1, nil, "model-a", nil)
+	turn2 := append(turn1,
+		Message{Role: "assistant", ToolCalls: first.ToolCalls},
+		Message{Role: "tool", Content: "72F", ToolCallID: "call_1"},
+	)
+	if _, err := recorder.Chat(ctx, turn2, nil, "model-a", nil); err != nil {
+		t.Fatalf("recorded Chat error: %v", err)
+	}
+
+	replay, err := NewReplayProvider(path)
+	if err != nil {
+		t.Fatalf("NewReplayProvider error: %v", err)
+	}
+	if got := replay.GetDefaultModel(); got != "model-a" {
+		t.Errorf("GetDefaultModel() = %q, want model-a", got)
+	}
+
+	// Requests are matched by content, not order; the system prompt is ignored
+	turn2[0] = Message{Role: "system", Content: "Current time: 11:30"}
+	resp, err := replay.Chat(ctx, turn2, nil, "model-a", nil)
+	if err != nil {
+		t.Fatalf("replay Chat error: %v", err)
+	}
+	if resp.Content != "It's 72F in SF." {
+		t.Errorf("Content = %q", resp.Content)
+	}
+	resp, err = replay.Chat(ctx, turn1, nil, "model-a", nil)
+	if err != nil || len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "get_weather" {
+		t.Fatalf("replay turn1 = %+v, %v", resp, err)
+	}
+
+	if _, err := replay.Chat(ctx, []Message{{Role: "user", Content: "unrecorded"}}, nil, "model-a", nil); !errors.Is(err, ErrNoCassetteMatch) {
+		t.Errorf("unmatched request error = %v, want ErrNoCassetteMatch", err)
+	}
+}
+
+func TestCassette_ReplayFallsBackInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.jsonl")
+	recorder, _ := NewRecordingProvider(&scriptedProvider{responses: []*LLMResponse{{Content: "one"}}}, path)
+	recorder.Chat(context.Background(), []Message{{Role: "user", Content: "a"}}, nil, "m", nil)
+
+	replay, err := NewReplayProvider(path)
+	if err != nil {
+		t.Fatalf("NewReplayProvider error: %v", err)
+	}
+	replay.SetStrict(false)
+	resp, err := replay.Chat(context.Background(), []Message{{Role: "user", Content: "b"}}, nil, "m", nil)
+	if err != nil || resp.Content != "one" {
+		t.Errorf("fallback replay = %+v, %v", resp, err)
+	}
+}
+
+func TestCassette_StrictReplayMatchesToolsAndModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.jsonl")
+	recorder, _ := NewRecordingProvider(&scriptedProvider{responses: []*LLMResponse{{Content: "one"}}}, path)
+	tools := []ToolDefinition{
+		{Type: "function", Function: ToolFunctionDefinition{Name: "read_file"}},
+		{Type: "function", Function: ToolFunctionDefinition{Name: "exec"}},
+	}
+	msgs := []Message{{Role: "user", Content: "a"}}
+	recorder.Chat(context.Background(), msgs, tools, "m", nil)
+
+	tests := []struct {
+		name  string
+		tools []ToolDefinition
+		model string
+	}{
+		{"tool removed", tools[:1], "m"},
+		{"no tools", nil, "m"},
+		{"model changed", tools, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, err := NewReplayProvider(path)
+			if err != nil {
+				t.Fatalf("NewReplayProvider error: %v", err)
+			}
+			if _, err := replay.Chat(context.Background(), msgs, tt.tools, tt.model, nil); !errors.Is(err, ErrNoCassetteMatch) {
+				t.Errorf("error = %v, want ErrNoCassetteMatch", err)
+			}
+		})
+	}
+
+	// Tool order does not matter
+	replay, _ := NewReplayProvider(path)
+	reordered := []ToolDefinition{tools[1], tools[0]}
+	if resp, err := replay.Chat(context.Background(), msgs, reordered, "m", nil); err != nil || resp.Content != "one" {
+		t.Errorf("reordered tools replay = %+v, %v", resp, err)
+	}
+}
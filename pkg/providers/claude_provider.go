@@ -16,10 +16,14 @@ type ClaudeProvider struct {
 }
 
 func NewClaudeProvider(token string) *ClaudeProvider {
-	client := anthropic.NewClient(
+	return newClaudeProvider(
 		option.WithAPIKey(token),
 		option.WithBaseURL("https://api.anthropic.com"),
 	)
+}
+
+func newClaudeProvider(opts ...option.RequestOption) *ClaudeProvider {
+	client := anthropic.NewClient(opts...)
 	return &ClaudeProvider{client: &client}
 }
 
@@ -104,19 +108,9 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		case "system":
 			// System messages are always text
 			textContent := msg.GetTextContent()
-			block := anthropic.TextBlockParam{
+			system = append(system, anthropic.TextBlockParam{
 				Text: textContent,
-			}
-
-			// Enable caching for system blocks if requested
-			// Cache the main system prompt but not the last block (which may be dynamic memory)
-			if enableCaching && len(system) > 0 {
-				// Mark previous block as cacheable
-				cacheControl := anthropic.NewCacheControlEphemeralParam()
-				system[len(system)-1].CacheControl = cacheControl
-			}
-
-			system = append(system, block)
+			})
 		case "user":
 			if msg.ToolCallID != "" {
 				// Tool result - always text
@@ -133,7 +127,8 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 			}
 		case "assistant":
 			if len(msg.ToolCalls) > 0 {
-				var blocks []anthropic.ContentBlockParamUnion
+				// Thinking blocks must precede tool_use blocks unchanged
+				blocks := claudeThinkingBlocks(msg.ReasoningBlocks)
 				textContent := msg.GetTextContent()
 				if textContent != "" {
 					blocks = append(blocks, anthropic.NewTextBlock(textContent))
@@ -171,7 +166,14 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		params.System = system
 	}
 
-	if temp, ok := options["temperature"].(float64); ok {
+	if budget := claudeThinkingBudget(options); budget > 0 {
+		// max_tokens includes the thinking budget, and extended thinking
+		// only runs at the default temperature.
+		if params.MaxTokens <= budget {
+			params.MaxTokens += budget
+		}
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(budget)
+	} else if temp, ok := options["temperature"].(float64); ok {
 		params.Temperature = anthropic.Float(temp)
 	}
 
@@ -179,9 +181,87 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		params.Tools = translateToolsForClaude(tools)
 	}
 
+	if enableCaching {
+		addClaudeCacheBreakpoints(&params)
+	}
+
 	return params, nil
 }
 
+// addClaudeCacheBreakpoints marks the cacheable prefix of a request. The
+// cache covers tools, then system, then messages, so breakpoints go on the
+// last tool, the first system block (the stable prompt; later blocks carry
+// the time and the chat), the previous user turn (stable history) and the
+// last message (so the next tool iteration reads everything before it).
+// Anthropic allows at most four breakpoints.
+func addClaudeCacheBreakpoints(params *anthropic.MessageNewParams) {
+	if n := len(params.Tools); n > 0 {
+		if cc := params.Tools[n-1].GetCacheControl(); cc != nil {
+			*cc = anthropic.NewCacheControlEphemeralParam()
+		}
+	}
+
+	if len(params.System) > 0 {
+		params.System[0].CacheControl = anthropic.NewCacheControlEphemeralParam()
+	}
+
+	marked := 0
+	for i := len(params.Messages) - 1; i >= 0 && marked < 2; i-- {
+		msg := params.Messages[i]
+		if marked == 1 && msg.Role != anthropic.MessageParamRoleUser {
+			continue
+		}
+		if markLastCacheableBlock(msg.Content) {
+			marked++
+		}
+	}
+}
+
+// markLastCacheableBlock puts a breakpoint on the last block that accepts
+// one (thinking blocks don't).
+func markLastCacheableBlock(blocks []anthropic.ContentBlockParamUnion) bool {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if cc := blocks[i].GetCacheControl(); cc != nil {
+			*cc = anthropic.NewCacheControlEphemeralParam()
+			return true
+		}
+	}
+	return false
+}
+
+// claudeThinkingBudget returns the extended thinking budget in tokens from
+// "thinking_budget", or derived from "reasoning_effort". Zero disables thinking.
+func claudeThinkingBudget(options map[string]interface{}) int64 {
+	if budget, ok := options["thinking_budget"].(int); ok && budget > 0 {
+		// The API rejects budgets below 1024
+		return max(int64(budget), 1024)
+	}
+	switch options["reasoning_effort"] {
+	case "low", "minimal":
+		return 1024
+	case "medium":
+		return 4096
+	case "high":
+		return 16384
+	}
+	return 0
+}
+
+// claudeThinkingBlocks converts saved thinking blocks back into request
+// blocks. Blocks from other providers are skipped.
+func claudeThinkingBlocks(reasoning []ReasoningBlock) []anthropic.ContentBlockParamUnion {
+	var blocks []anthropic.ContentBlockParamUnion
+	for _, rb := range reasoning {
+		switch rb.Type {
+		case "thinking":
+			blocks = append(blocks, anthropic.NewThinkingBlock(rb.Signature, rb.Thinking))
+		case "redacted_thinking":
+			blocks = append(blocks, anthropic.NewRedactedThinkingBlock(rb.Data))
+		}
+	}
+	return blocks
+}
+
 func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam {
 	result := make([]anthropic.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -209,7 +289,8 @@ func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam
 }
 
 func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
-	var content string
+	var content, reasoning string
+	var reasoningBlocks []ReasoningBlock
 	var toolCalls []ToolCall
 
 	for _, block := range resp.Content {
@@ -217,6 +298,19 @@ func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
 		case "text":
 			tb := block.AsText()
 			content += tb.Text
+		case "thinking":
+			th := block.AsThinking()
+			reasoning += th.Thinking
+			reasoningBlocks = append(reasoningBlocks, ReasoningBlock{
+				Type:      "thinking",
+				Thinking:  th.Thinking,
+				Signature: th.Signature,
+			})
+		case "redacted_thinking":
+			reasoningBlocks = append(reasoningBlocks, ReasoningBlock{
+				Type: "redacted_thinking",
+				Data: block.AsRedactedThinking().Data,
+			})
 		case "tool_use":
 			tu := block.AsToolUse()
 			var args map[string]interface{}
@@ -242,14 +336,25 @@ func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
 	}
 
 	return &LLMResponse{
-		Content:      content,
-		ToolCalls:    toolCalls,
-		FinishReason: finishReason,
-		Usage: &UsageInfo{
-			PromptTokens:     int(resp.Usage.InputTokens),
-			CompletionTokens: int(resp.Usage.OutputTokens),
-			TotalTokens:      int(resp.Usage.InputTokens + resp.Usage.OutputTokens),
-		},
+		Content:         content,
+		Reasoning:       reasoning,
+		ReasoningBlocks: reasoningBlocks,
+		ToolCalls:       toolCalls,
+		FinishReason:    finishReason,
+		Usage:           claudeUsage(resp.Usage),
+	}
+}
+
+// claudeUsage converts Anthropic usage. input_tokens excludes cached
+// tokens, so PromptTokens adds cache reads and writes back in.
+func claudeUsage(u anthropic.Usage) *UsageInfo {
+	prompt := u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens
+	return &UsageInfo{
+		PromptTokens:     int(prompt),
+		CompletionTokens: int(u.OutputTokens),
+		TotalTokens:      int(prompt + u.OutputTokens),
+		CacheReadTokens:  int(u.CacheReadInputTokens),
+		CacheWriteTokens: int(u.CacheCreationInputTokens),
 	}
 }
 
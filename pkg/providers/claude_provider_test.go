@@ -102,6 +102,74 @@ func TestBuildClaudeParams_WithTools(t *testing.T) {
 	}
 }
 
+func TestBuildClaudeParams_PromptCaching(t *testing.T) {
+	tools := []ToolDefinition{
+		{Type: "function", Function: ToolFunctionDefinition{Name: "a", Parameters: map[string]interface{}{}}},
+		{Type: "function", Function: ToolFunctionDefinition{Name: "b", Parameters: map[string]interface{}{}}},
+	}
+	messages := []Message{
+		{Role: "system", Content: "long system prompt"},
+		{Role: "system", Content: "## Current Time\n2026-10-18 09:41"},
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "reply"},
+		{Role: "user", Content: "second"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Name: "a", Arguments: map[string]interface{}{}}}},
+		{Role: "tool", Content: "result", ToolCallID: "call_1"},
+	}
+	params, err := buildClaudeParams(messages, tools, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"enable_prompt_caching": true,
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+
+	cached := func(cc *anthropic.CacheControlEphemeralParam) bool {
+		return cc != nil && cc.Type == "ephemeral"
+	}
+	if cached(params.Tools[0].GetCacheControl()) || !cached(params.Tools[1].GetCacheControl()) {
+		t.Error("only the last tool should carry a breakpoint")
+	}
+	if !cached(&params.System[0].CacheControl) || cached(&params.System[1].CacheControl) {
+		t.Error("only the stable system block should carry a breakpoint")
+	}
+
+	var marked []int
+	for i, msg := range params.Messages {
+		for _, block := range msg.Content {
+			if cached(block.GetCacheControl()) {
+				marked = append(marked, i)
+			}
+		}
+	}
+	// Last message (tool result) and the previous user turn ("second")
+	if len(marked) != 2 || marked[0] != 2 || marked[1] != 4 {
+		t.Errorf("breakpoints on messages %v, want [2 4]", marked)
+	}
+
+	uncached, _ := buildClaudeParams(messages, tools, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if cached(&uncached.System[0].CacheControl) {
+		t.Error("no breakpoints expected without enable_prompt_caching")
+	}
+}
+
+func TestParseClaudeResponse_CacheUsage(t *testing.T) {
+	resp := &anthropic.Message{
+		Usage: anthropic.Usage{
+			InputTokens:              10,
+			CacheReadInputTokens:     1000,
+			CacheCreationInputTokens: 200,
+			OutputTokens:             5,
+		},
+	}
+	usage := parseClaudeResponse(resp).Usage
+	if usage.PromptTokens != 1210 || usage.TotalTokens != 1215 {
+		t.Errorf("PromptTokens = %d, TotalTokens = %d; want 1210, 1215", usage.PromptTokens, usage.TotalTokens)
+	}
+	if usage.CacheReadTokens != 1000 || usage.CacheWriteTokens != 200 {
+		t.Errorf("cache read/write = %d/%d, want 1000/200", usage.CacheReadTokens, usage.CacheWriteTokens)
+	}
+}
+
 func TestParseClaudeResponse_TextOnly(t *testing.T) {
 	resp := &anthropic.Message{
 		Content: []anthropic.ContentBlockUnion{},
@@ -142,6 +210,72 @@ func TestParseClaudeResponse_StopReasons(t *testing.T) {
 	}
 }
 
+func TestParseClaudeResponse_Thinking(t *testing.T) {
+	var resp anthropic.Message
+	err := json.Unmarshal([]byte(`{
+		"stop_reason": "tool_use",
+		"content": [
+			{"type": "thinking", "thinking": "Need the weather first.", "signature": "sig-1"},
+			{"type": "redacted_thinking", "data": "opaque"},
+			{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "SF"}}
+		]
+	}`), &resp)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	result := parseClaudeResponse(&resp)
+	if result.Reasoning != "Need the weather first." {
+		t.Errorf("Reasoning = %q", result.Reasoning)
+	}
+	if len(result.ReasoningBlocks) != 2 {
+		t.Fatalf("len(ReasoningBlocks) = %d, want 2", len(result.ReasoningBlocks))
+	}
+	if result.ReasoningBlocks[0].Signature != "sig-1" || result.ReasoningBlocks[1].Data != "opaque" {
+		t.Errorf("ReasoningBlocks = %+v", result.ReasoningBlocks)
+	}
+}
+
+func TestBuildClaudeParams_ThinkingReplayedWithToolCalls(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "What's the weather?"},
+		{
+			Role: "assistant",
+			ReasoningBlocks: []ReasoningBlock{
+				{Type: "thinking", Thinking: "Need the weather first.", Signature: "sig-1"},
+				{Type: "reasoning", ID: "rs_1", Data: "codex-only"},
+			},
+			ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: map[string]interface{}{"city": "SF"}}},
+		},
+		{Role: "tool", Content: `{"temp": 72}`, ToolCallID: "call_1"},
+	}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"max_tokens":      1024,
+		"temperature":     0.7,
+		"thinking_budget": 2048,
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+
+	blocks := params.Messages[1].Content
+	if len(blocks) != 2 {
+		t.Fatalf("assistant blocks = %d, want thinking + tool_use", len(blocks))
+	}
+	if blocks[0].OfThinking == nil || blocks[0].OfThinking.Signature != "sig-1" {
+		t.Errorf("first block = %+v, want thinking with signature", blocks[0])
+	}
+	if params.Thinking.OfEnabled == nil || params.Thinking.OfEnabled.BudgetTokens != 2048 {
+		t.Errorf("Thinking = %+v, want enabled with budget 2048", params.Thinking)
+	}
+	if params.MaxTokens <= 2048 {
+		t.Errorf("MaxTokens = %d, want more than the thinking budget", params.MaxTokens)
+	}
+	if params.Temperature.Valid() {
+		t.Error("Temperature should not be set with extended thinking")
+	}
+}
+
 func TestClaudeProvider_ChatRoundTrip(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/v1/messages" {
@@ -9,6 +9,7 @@ import (
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/responses"
+	"github.com/openai/openai-go/v3/shared"
 	"github.com/sipeed/picoclaw/pkg/auth"
 )
 
@@ -75,7 +76,10 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 	for _, msg := range messages {
 		switch msg.Role {
 		case "system":
-			instructions = msg.GetTextContent()
+			if instructions != "" {
+				instructions += "\n\n"
+			}
+			instructions += msg.GetTextContent()
 		case "user":
 			if msg.ToolCallID != "" {
 				inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
@@ -94,6 +98,7 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 			}
 		case "assistant":
 			if len(msg.ToolCalls) > 0 {
+				inputItems = append(inputItems, codexReasoningItems(msg.ReasoningBlocks)...)
 				textContent := msg.GetTextContent()
 				if textContent != "" {
 					inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
@@ -154,6 +159,16 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 		params.Temperature = openai.Opt(temp)
 	}
 
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		params.Reasoning = shared.ReasoningParam{
+			Effort:  shared.ReasoningEffort(effort),
+			Summary: shared.ReasoningSummaryAuto,
+		}
+		// With store=false, reasoning can only be carried across tool
+		// calls as encrypted content.
+		params.Include = []responses.ResponseIncludable{responses.ResponseIncludableReasoningEncryptedContent}
+	}
+
 	if len(tools) > 0 {
 		params.Tools = translateToolsForCodex(tools)
 	}
@@ -161,6 +176,27 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 	return params
 }
 
+// codexReasoningItems converts saved reasoning items back into input items.
+// Only encrypted items can be replayed, since responses are not stored.
+func codexReasoningItems(reasoning []ReasoningBlock) []responses.ResponseInputItemUnionParam {
+	var items []responses.ResponseInputItemUnionParam
+	for _, rb := range reasoning {
+		if rb.Type != "reasoning" || rb.Data == "" {
+			continue
+		}
+		item := &responses.ResponseReasoningItemParam{
+			ID:               rb.ID,
+			Summary:          []responses.ResponseReasoningItemSummaryParam{},
+			EncryptedContent: openai.Opt(rb.Data),
+		}
+		if rb.Thinking != "" {
+			item.Summary = append(item.Summary, responses.ResponseReasoningItemSummaryParam{Text: rb.Thinking})
+		}
+		items = append(items, responses.ResponseInputItemUnionParam{OfReasoning: item})
+	}
+	return items
+}
+
 func translateToolsForCodex(tools []ToolDefinition) []responses.ToolUnionParam {
 	result := make([]responses.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -178,11 +214,32 @@ func translateToolsForCodex(tools []ToolDefinition) []responses.ToolUnionParam {
 }
 
 func parseCodexResponse(resp *responses.Response) *LLMResponse {
-	var content strings.Builder
+	var content, reasoning strings.Builder
+	var reasoningBlocks []ReasoningBlock
 	var toolCalls []ToolCall
 
 	for _, item := range resp.Output {
 		switch item.Type {
+		case "reasoning":
+			var summary strings.Builder
+			for _, s := range item.Summary {
+				if summary.Len() > 0 {
+					summary.WriteString("\n\n")
+				}
+				summary.WriteString(s.Text)
+			}
+			if summary.Len() > 0 {
+				if reasoning.Len() > 0 {
+					reasoning.WriteString("\n\n")
+				}
+				reasoning.WriteString(summary.String())
+			}
+			reasoningBlocks = append(reasoningBlocks, ReasoningBlock{
+				Type:     "reasoning",
+				ID:       item.ID,
+				Thinking: summary.String(),
+				Data:     item.EncryptedContent,
+			})
 		case "message":
 			for _, c := range item.Content {
 				if c.Type == "output_text" {
@@ -220,10 +277,12 @@ func parseCodexResponse(resp *responses.Response) *LLMResponse {
 	}
 
 	return &LLMResponse{
-		Content:      content.String(),
-		ToolCalls:    toolCalls,
-		FinishReason: finishReason,
-		Usage:        usage,
+		Content:         content.String(),
+		Reasoning:       reasoning.String(),
+		ReasoningBlocks: reasoningBlocks,
+		ToolCalls:       toolCalls,
+		FinishReason:    finishReason,
+		Usage:           usage,
 	}
 }
 
@@ -188,6 +188,58 @@ func TestParseCodexResponse_FunctionCall(t *testing.T) {
 	}
 }
 
+func TestParseCodexResponse_Reasoning(t *testing.T) {
+	respJSON := `{
+		"id": "resp_test",
+		"object": "response",
+		"status": "completed",
+		"output": [
+			{
+				"id": "rs_1",
+				"type": "reasoning",
+				"summary": [{"type": "summary_text", "text": "Look up the file."}],
+				"encrypted_content": "enc-1"
+			},
+			{
+				"id": "fc_1",
+				"type": "function_call",
+				"call_id": "call_1",
+				"name": "read_file",
+				"arguments": "{\"path\":\"a.txt\"}",
+				"status": "completed"
+			}
+		]
+	}`
+
+	var resp responses.Response
+	if err := json.Unmarshal([]byte(respJSON), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	result := parseCodexResponse(&resp)
+	if result.Reasoning != "Look up the file." {
+		t.Errorf("Reasoning = %q, want %q", result.Reasoning, "Look up the file.")
+	}
+	if len(result.ReasoningBlocks) != 1 || result.ReasoningBlocks[0].Data != "enc-1" {
+		t.Fatalf("ReasoningBlocks = %+v", result.ReasoningBlocks)
+	}
+
+	// The encrypted item is replayed before the function call
+	messages := []Message{
+		{Role: "user", Content: "read a.txt"},
+		{Role: "assistant", ReasoningBlocks: result.ReasoningBlocks, ToolCalls: result.ToolCalls},
+		{Role: "tool", Content: "hello", ToolCallID: "call_1"},
+	}
+	params := buildCodexParams(messages, nil, "gpt-5", map[string]interface{}{"reasoning_effort": "high"})
+	items := params.Input.OfInputItemList
+	if len(items) != 4 || items[1].OfReasoning == nil || items[1].OfReasoning.ID != "rs_1" {
+		t.Fatalf("input items = %+v, want reasoning item before function call", items)
+	}
+	if params.Reasoning.Effort != "high" || len(params.Include) != 1 {
+		t.Errorf("Reasoning = %+v, Include = %v", params.Reasoning, params.Include)
+	}
+}
+
 func TestCodexProvider_ChatRoundTrip(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/responses" {
@@ -7,6 +7,7 @@
 package providers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -24,10 +25,27 @@ import (
 type HTTPProvider struct {
 	apiKey     string
 	apiBase    string
+	headers    map[string]string
 	httpClient *http.Client
 }
 
 func NewHTTPProvider(apiKey, apiBase, proxy string) *HTTPProvider {
+	return &HTTPProvider{
+		apiKey:     apiKey,
+		apiBase:    strings.TrimRight(apiBase, "/"),
+		httpClient: newHTTPClient(proxy),
+	}
+}
+
+// SetHeaders sets extra HTTP headers sent with every request
+// (e.g. gateway routing or tenant headers).
+func (p *HTTPProvider) SetHeaders(headers map[string]string) {
+	p.headers = headers
+}
+
+// newHTTPClient returns an HTTP client with the default provider timeout,
+// routed through proxy when one is set.
+func newHTTPClient(proxy string) *http.Client {
 	client := &http.Client{
 		Timeout: 120 * time.Second,
 	}
@@ -41,13 +59,12 @@ func NewHTTPProvider(apiKey, apiBase, proxy string) *HTTPProvider {
 		}
 	}
 
-	return &HTTPProvider{
-		apiKey:     apiKey,
-		apiBase:    strings.TrimRight(apiBase, "/"),
-		httpClient: client,
-	}
+	return client
 }
 
+// Chat sends a chat completion request. When options["on_text_delta"] is a
+// func(string), the response is streamed and the callback receives the
+// text as it arrives; the returned response is the same either way.
 func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
 	if p.apiBase == "" {
 		return nil, fmt.Errorf("API base not configured")
@@ -61,6 +78,13 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 		}
 	}
 
+	messages = stripReasoningBlocks(messages)
+	if caching, _ := options["enable_prompt_caching"].(bool); caching && p.passesThroughCacheControl(model) {
+		messages = withCacheBreakpoints(messages)
+	} else {
+		messages = mergeSystemMessages(messages)
+	}
+
 	requestBody := map[string]interface{}{
 		"model":    model,
 		"messages": messages,
@@ -90,6 +114,17 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 		}
 	}
 
+	// OpenAI o-series, DeepSeek and OpenRouter accept reasoning_effort
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		requestBody["reasoning_effort"] = effort
+	}
+
+	onDelta, streaming := options["on_text_delta"].(func(string))
+	if streaming {
+		requestBody["stream"] = true
+		requestBody["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -104,6 +139,9 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 	if p.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+p.apiKey)
 	}
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
@@ -111,6 +149,14 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 	}
 	defer resp.Body.Close()
 
+	if streaming && resp.StatusCode == http.StatusOK {
+		body, err := readChatStream(resp.Body, onDelta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response stream: %w", err)
+		}
+		return p.parseResponse(body)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
@@ -127,8 +173,10 @@ func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 	var apiResponse struct {
 		Choices []struct {
 			Message struct {
-				Content   string `json:"content"`
-				ToolCalls []struct {
+				Content          string `json:"content"`
+				ReasoningContent string `json:"reasoning_content"` // DeepSeek, Qwen, vLLM
+				Reasoning        string `json:"reasoning"`         // OpenRouter
+				ToolCalls        []struct {
 					ID       string `json:"id"`
 					Type     string `json:"type"`
 					Function *struct {
@@ -139,7 +187,14 @@ func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 			} `json:"message"`
 			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
-		Usage *UsageInfo `json:"usage"`
+		Usage *struct {
+			UsageInfo
+			PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"` // DeepSeek
+			PromptTokensDetails  *struct {
+				CachedTokens     int `json:"cached_tokens"`
+				CacheWriteTokens int `json:"cache_write_tokens"` // OpenRouter
+			} `json:"prompt_tokens_details"`
+		} `json:"usage"`
 	}
 
 	if err := json.Unmarshal(body, &apiResponse); err != nil {
@@ -185,14 +240,229 @@ func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 		})
 	}
 
+	reasoning := choice.Message.ReasoningContent
+	if reasoning == "" {
+		reasoning = choice.Message.Reasoning
+	}
+
+	var usage *UsageInfo
+	if u := apiResponse.Usage; u != nil {
+		usage = &u.UsageInfo
+		usage.CacheReadTokens = u.PromptCacheHitTokens
+		if d := u.PromptTokensDetails; d != nil {
+			if d.CachedTokens > 0 {
+				usage.CacheReadTokens = d.CachedTokens
+			}
+			usage.CacheWriteTokens = d.CacheWriteTokens
+		}
+	}
+
 	return &LLMResponse{
 		Content:      choice.Message.Content,
+		Reasoning:    reasoning,
 		ToolCalls:    toolCalls,
 		FinishReason: choice.FinishReason,
-		Usage:        apiResponse.Usage,
+		Usage:        usage,
 	}, nil
 }
 
+// readChatStream reads a streamed chat completion (server-sent events),
+// passing content deltas to onDelta, and reassembles the chunks into the
+// body of an equivalent non-streamed response.
+func readChatStream(r io.Reader, onDelta func(string)) ([]byte, error) {
+	type streamToolCall struct {
+		Index    int    `json:"index"`
+		ID       string `json:"id,omitempty"`
+		Type     string `json:"type,omitempty"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	}
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content          string           `json:"content"`
+				ReasoningContent string           `json:"reasoning_content"`
+				Reasoning        string           `json:"reasoning"`
+				ToolCalls        []streamToolCall `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage json.RawMessage `json:"usage"`
+	}
+
+	var content, reasoning strings.Builder
+	var toolCalls []*streamToolCall
+	var finishReason string
+	var usage json.RawMessage
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		chunk.Choices = nil
+		chunk.Usage = nil
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		if len(chunk.Usage) > 0 && string(chunk.Usage) != "null" {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+		if d := choice.Delta.Content; d != "" {
+			content.WriteString(d)
+			onDelta(d)
+		}
+		reasoning.WriteString(choice.Delta.ReasoningContent)
+		reasoning.WriteString(choice.Delta.Reasoning)
+
+		// Tool calls arrive in pieces keyed by index: the first carries
+		// the ID and name, later ones more of the arguments
+		for _, tc := range choice.Delta.ToolCalls {
+			for len(toolCalls) <= tc.Index {
+				toolCalls = append(toolCalls, &streamToolCall{Index: len(toolCalls)})
+			}
+			call := toolCalls[tc.Index]
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+			call.Function.Name += tc.Function.Name
+			call.Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	message := map[string]interface{}{
+		"content":           content.String(),
+		"reasoning_content": reasoning.String(),
+		"tool_calls":        toolCalls,
+	}
+	body := map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{
+			"message":       message,
+			"finish_reason": finishReason,
+		}},
+	}
+	if usage != nil {
+		body["usage"] = usage
+	}
+	return json.Marshal(body)
+}
+
+// passesThroughCacheControl reports whether cache_control markers reach
+// the model: OpenRouter forwards them to Anthropic models. Other
+// OpenAI-compatible APIs cache automatically or not at all.
+func (p *HTTPProvider) passesThroughCacheControl(model string) bool {
+	if !strings.Contains(p.apiBase, "openrouter") {
+		return false
+	}
+	lowerModel := strings.ToLower(model)
+	return strings.HasPrefix(lowerModel, "anthropic/") || strings.Contains(lowerModel, "claude")
+}
+
+// withCacheBreakpoints returns a copy of messages with Anthropic cache
+// breakpoints on the first system message (the stable prompt), the
+// previous user turn and the last message, mirroring the ClaudeProvider
+// placement.
+func withCacheBreakpoints(messages []Message) []Message {
+	marked := make([]Message, len(messages))
+	copy(marked, messages)
+
+	mark := func(i int) bool {
+		text, ok := marked[i].Content.(string)
+		if !ok || text == "" {
+			return false
+		}
+		marked[i].Content = []ContentBlock{{
+			Type:         "text",
+			Text:         text,
+			CacheControl: &CacheControl{Type: "ephemeral"},
+		}}
+		return true
+	}
+
+	for i := range marked {
+		if marked[i].Role == "system" {
+			mark(i)
+			break
+		}
+	}
+
+	count := 0
+	for i := len(marked) - 1; i >= 0 && count < 2; i-- {
+		if marked[i].Role == "system" {
+			break
+		}
+		if count == 1 && marked[i].Role != "user" {
+			continue
+		}
+		if mark(i) {
+			count++
+		}
+	}
+	return marked
+}
+
+// mergeSystemMessages joins the leading system messages into one, since
+// many chat templates accept a single system message only. Without cache
+// breakpoints there is nothing to gain from keeping them apart.
+func mergeSystemMessages(messages []Message) []Message {
+	n := 0
+	for n < len(messages) && messages[n].Role == "system" {
+		if _, ok := messages[n].Content.(string); !ok {
+			break
+		}
+		n++
+	}
+	if n < 2 {
+		return messages
+	}
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = messages[i].Content.(string)
+	}
+	return append([]Message{{Role: "system", Content: strings.Join(parts, "\n\n")}}, messages[n:]...)
+}
+
+// stripReasoningBlocks drops provider-specific reasoning blocks, which
+// OpenAI-compatible endpoints don't understand. reasoning_content is kept:
+// DeepSeek expects it back on assistant turns that made tool calls.
+func stripReasoningBlocks(messages []Message) []Message {
+	for i := range messages {
+		if len(messages[i].ReasoningBlocks) == 0 {
+			continue
+		}
+		stripped := make([]Message, len(messages))
+		copy(stripped, messages)
+		for j := range stripped {
+			stripped[j].ReasoningBlocks = nil
+		}
+		return stripped
+	}
+	return messages
+}
+
 func (p *HTTPProvider) GetDefaultModel() string {
 	return ""
 }
@@ -219,212 +489,17 @@ func createCodexAuthProvider() (LLMProvider, error) {
 	return NewCodexProviderWithTokenSource(cred.AccessToken, cred.AccountID, createCodexTokenSource()), nil
 }
 
+// CreateProvider builds the provider for the agent's default model.
+// Resolution goes through the config-driven Registry; see Registry.Resolve.
 func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 	model := cfg.Agents.Defaults.Model
-	providerName := strings.ToLower(cfg.Agents.Defaults.Provider)
-
-	var apiKey, apiBase, proxy string
 
-	lowerModel := strings.ToLower(model)
-
-	// First, try to use explicitly configured provider
-	if providerName != "" {
-		switch providerName {
-		case "groq":
-			if cfg.Providers.Groq.APIKey != "" {
-				apiKey = cfg.Providers.Groq.APIKey
-				apiBase = cfg.Providers.Groq.APIBase
-				if apiBase == "" {
-					apiBase = "https://api.groq.com/openai/v1"
-				}
-			}
-		case "openai", "gpt":
-			if cfg.Providers.OpenAI.APIKey != "" || cfg.Providers.OpenAI.AuthMethod != "" {
-				if cfg.Providers.OpenAI.AuthMethod == "oauth" || cfg.Providers.OpenAI.AuthMethod == "token" {
-					return createCodexAuthProvider()
-				}
-				apiKey = cfg.Providers.OpenAI.APIKey
-				apiBase = cfg.Providers.OpenAI.APIBase
-				if apiBase == "" {
-					apiBase = "https://api.openai.com/v1"
-				}
-			}
-		case "anthropic", "claude":
-			if cfg.Providers.Anthropic.APIKey != "" || cfg.Providers.Anthropic.AuthMethod != "" {
-				if cfg.Providers.Anthropic.AuthMethod == "oauth" || cfg.Providers.Anthropic.AuthMethod == "token" {
-					return createClaudeAuthProvider()
-				}
-				// Use ClaudeProvider with API key
-				if cfg.Providers.Anthropic.APIKey != "" {
-					return NewClaudeProvider(cfg.Providers.Anthropic.APIKey), nil
-				}
-			}
-		case "openrouter":
-			if cfg.Providers.OpenRouter.APIKey != "" {
-				apiKey = cfg.Providers.OpenRouter.APIKey
-				if cfg.Providers.OpenRouter.APIBase != "" {
-					apiBase = cfg.Providers.OpenRouter.APIBase
-				} else {
-					apiBase = "https://openrouter.ai/api/v1"
-				}
-			}
-		case "zhipu", "glm":
-			if cfg.Providers.Zhipu.APIKey != "" {
-				apiKey = cfg.Providers.Zhipu.APIKey
-				apiBase = cfg.Providers.Zhipu.APIBase
-				if apiBase == "" {
-					apiBase = "https://open.bigmodel.cn/api/paas/v4"
-				}
-			}
-		case "gemini", "google":
-			if cfg.Providers.Gemini.APIKey != "" {
-				apiKey = cfg.Providers.Gemini.APIKey
-				apiBase = cfg.Providers.Gemini.APIBase
-				if apiBase == "" {
-					apiBase = "https://generativelanguage.googleapis.com/v1beta"
-				}
-			}
-		case "vllm":
-			if cfg.Providers.VLLM.APIBase != "" {
-				apiKey = cfg.Providers.VLLM.APIKey
-				apiBase = cfg.Providers.VLLM.APIBase
-			}
-		case "shengsuanyun":
-			if cfg.Providers.ShengSuanYun.APIKey != "" {
-				apiKey = cfg.Providers.ShengSuanYun.APIKey
-				apiBase = cfg.Providers.ShengSuanYun.APIBase
-				if apiBase == "" {
-					apiBase = "https://router.shengsuanyun.com/api/v1"
-				}
-			}
-		case "claude-cli", "claudecode", "claude-code":
-			workspace := cfg.Agents.Defaults.Workspace
-			if workspace == "" {
-				workspace = "."
-			}
-			return NewClaudeCliProvider(workspace), nil
-		case "deepseek":
-			if cfg.Providers.DeepSeek.APIKey != "" {
-				apiKey = cfg.Providers.DeepSeek.APIKey
-				apiBase = cfg.Providers.DeepSeek.APIBase
-				if apiBase == "" {
-					apiBase = "https://api.deepseek.com/v1"
-				}
-				if model != "deepseek-chat" && model != "deepseek-reasoner" {
-					model = "deepseek-chat"
-				}
-			}
-		case "github_copilot", "copilot":
-			if cfg.Providers.GitHubCopilot.APIBase != "" {
-				apiBase = cfg.Providers.GitHubCopilot.APIBase
-			} else {
-				apiBase = "localhost:4321"
-			}
-			return NewGitHubCopilotProvider(apiBase, cfg.Providers.GitHubCopilot.ConnectMode, model)
-
-		}
-
-	}
-
-	// Fallback: detect provider from model name
-	if apiKey == "" && apiBase == "" {
-		switch {
-		case (strings.Contains(lowerModel, "kimi") || strings.Contains(lowerModel, "moonshot") || strings.HasPrefix(model, "moonshot/")) && cfg.Providers.Moonshot.APIKey != "":
-			apiKey = cfg.Providers.Moonshot.APIKey
-			apiBase = cfg.Providers.Moonshot.APIBase
-			proxy = cfg.Providers.Moonshot.Proxy
-			if apiBase == "" {
-				apiBase = "https://api.moonshot.cn/v1"
-			}
-
-		case strings.HasPrefix(model, "openrouter/") || strings.HasPrefix(model, "anthropic/") || strings.HasPrefix(model, "openai/") || strings.HasPrefix(model, "meta-llama/") || strings.HasPrefix(model, "deepseek/") || strings.HasPrefix(model, "google/"):
-			apiKey = cfg.Providers.OpenRouter.APIKey
-			proxy = cfg.Providers.OpenRouter.Proxy
-			if cfg.Providers.OpenRouter.APIBase != "" {
-				apiBase = cfg.Providers.OpenRouter.APIBase
-			} else {
-				apiBase = "https://openrouter.ai/api/v1"
-			}
-
-		case (strings.Contains(lowerModel, "claude") || strings.HasPrefix(model, "anthropic/")) && (cfg.Providers.Anthropic.APIKey != "" || cfg.Providers.Anthropic.AuthMethod != ""):
-			if cfg.Providers.Anthropic.AuthMethod == "oauth" || cfg.Providers.Anthropic.AuthMethod == "token" {
-				return createClaudeAuthProvider()
-			}
-			// Use ClaudeProvider with API key
-			if cfg.Providers.Anthropic.APIKey != "" {
-				return NewClaudeProvider(cfg.Providers.Anthropic.APIKey), nil
-			}
-
-		case (strings.Contains(lowerModel, "gpt") || strings.HasPrefix(model, "openai/")) && (cfg.Providers.OpenAI.APIKey != "" || cfg.Providers.OpenAI.AuthMethod != ""):
-			if cfg.Providers.OpenAI.AuthMethod == "oauth" || cfg.Providers.OpenAI.AuthMethod == "token" {
-				return createCodexAuthProvider()
-			}
-			apiKey = cfg.Providers.OpenAI.APIKey
-			apiBase = cfg.Providers.OpenAI.APIBase
-			proxy = cfg.Providers.OpenAI.Proxy
-			if apiBase == "" {
-				apiBase = "https://api.openai.com/v1"
-			}
-
-		case (strings.Contains(lowerModel, "gemini") || strings.HasPrefix(model, "google/")) && cfg.Providers.Gemini.APIKey != "":
-			apiKey = cfg.Providers.Gemini.APIKey
-			apiBase = cfg.Providers.Gemini.APIBase
-			proxy = cfg.Providers.Gemini.Proxy
-			if apiBase == "" {
-				apiBase = "https://generativelanguage.googleapis.com/v1beta"
-			}
-
-		case (strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "zhipu") || strings.Contains(lowerModel, "zai")) && cfg.Providers.Zhipu.APIKey != "":
-			apiKey = cfg.Providers.Zhipu.APIKey
-			apiBase = cfg.Providers.Zhipu.APIBase
-			proxy = cfg.Providers.Zhipu.Proxy
-			if apiBase == "" {
-				apiBase = "https://open.bigmodel.cn/api/paas/v4"
-			}
-
-		case (strings.Contains(lowerModel, "groq") || strings.HasPrefix(model, "groq/")) && cfg.Providers.Groq.APIKey != "":
-			apiKey = cfg.Providers.Groq.APIKey
-			apiBase = cfg.Providers.Groq.APIBase
-			proxy = cfg.Providers.Groq.Proxy
-			if apiBase == "" {
-				apiBase = "https://api.groq.com/openai/v1"
-			}
-
-		case (strings.Contains(lowerModel, "nvidia") || strings.HasPrefix(model, "nvidia/")) && cfg.Providers.Nvidia.APIKey != "":
-			apiKey = cfg.Providers.Nvidia.APIKey
-			apiBase = cfg.Providers.Nvidia.APIBase
-			proxy = cfg.Providers.Nvidia.Proxy
-			if apiBase == "" {
-				apiBase = "https://integrate.api.nvidia.com/v1"
-			}
-
-		case cfg.Providers.VLLM.APIBase != "":
-			apiKey = cfg.Providers.VLLM.APIKey
-			apiBase = cfg.Providers.VLLM.APIBase
-			proxy = cfg.Providers.VLLM.Proxy
-
-		default:
-			if cfg.Providers.OpenRouter.APIKey != "" {
-				apiKey = cfg.Providers.OpenRouter.APIKey
-				proxy = cfg.Providers.OpenRouter.Proxy
-				if cfg.Providers.OpenRouter.APIBase != "" {
-					apiBase = cfg.Providers.OpenRouter.APIBase
-				} else {
-					apiBase = "https://openrouter.ai/api/v1"
-				}
-			} else {
-				return nil, fmt.Errorf("no API key configured for model: %s", model)
-			}
-		}
-	}
-
-	if apiKey == "" && !strings.HasPrefix(model, "bedrock/") {
-		return nil, fmt.Errorf("no API key configured for provider (model: %s)", model)
+	provider, upstream, err := NewRegistry(cfg).Resolve(cfg.Agents.Defaults.Provider, model)
+	if err != nil {
+		return nil, err
 	}
-
-	if apiBase == "" {
-		return nil, fmt.Errorf("no API base configured for provider (model: %s)", model)
+	if upstream != model {
+		return &aliasedProvider{LLMProvider: provider, from: model, to: upstream}, nil
 	}
-
-	return NewHTTPProvider(apiKey, apiBase, proxy), nil
+	return provider, nil
 }
@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPProvider_ReasoningContent(t *testing.T) {
+	var reqBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"choices": [{
+				"message": {"content": "42", "reasoning_content": "6 times 7."},
+				"finish_reason": "stop"
+			}]
+		}`))
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("key", server.URL, "")
+	messages := []Message{
+		{Role: "user", Content: "question"},
+		{Role: "assistant", ReasoningContent: "thinking", ReasoningBlocks: []ReasoningBlock{{Type: "thinking", Signature: "sig"}}},
+	}
+	resp, err := p.Chat(t.Context(), messages, nil, "deepseek-reasoner", map[string]interface{}{"reasoning_effort": "high"})
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if resp.Reasoning != "6 times 7." {
+		t.Errorf("Reasoning = %q, want %q", resp.Reasoning, "6 times 7.")
+	}
+	if reqBody["reasoning_effort"] != "high" {
+		t.Errorf("reasoning_effort = %v, want high", reqBody["reasoning_effort"])
+	}
+
+	sent := reqBody["messages"].([]interface{})[1].(map[string]interface{})
+	if _, ok := sent["reasoning_blocks"]; ok {
+		t.Error("reasoning_blocks should not be sent to OpenAI-compatible endpoints")
+	}
+	if sent["reasoning_content"] != "thinking" {
+		t.Errorf("reasoning_content = %v, want thinking", sent["reasoning_content"])
+	}
+	if len(messages[1].ReasoningBlocks) != 1 {
+		t.Error("caller's messages should not be modified")
+	}
+}
+
+func TestHTTPProvider_OpenRouterCacheControl(t *testing.T) {
+	var reqBody struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"choices": [{"message": {"content": "ok"}, "finish_reason": "stop"}],
+			"usage": {
+				"prompt_tokens": 1200, "completion_tokens": 5, "total_tokens": 1205,
+				"prompt_tokens_details": {"cached_tokens": 1000, "cache_write_tokens": 150}
+			}
+		}`))
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("key", server.URL+"/openrouter/api/v1", "")
+	messages := []Message{
+		{Role: "system", Content: "system prompt"},
+		{Role: "system", Content: "current time"},
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "reply"},
+		{Role: "user", Content: "second"},
+	}
+	resp, err := p.Chat(t.Context(), messages, nil, "anthropic/claude-sonnet-4.5", map[string]interface{}{
+		"enable_prompt_caching": true,
+	})
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+
+	for i, want := range []bool{true, false, true, false, true} {
+		_, isBlocks := reqBody.Messages[i]["content"].([]interface{})
+		if isBlocks != want {
+			t.Errorf("message %d has cache_control = %v, want %v", i, isBlocks, want)
+		}
+	}
+	if resp.Usage.CacheReadTokens != 1000 || resp.Usage.CacheWriteTokens != 150 {
+		t.Errorf("cache read/write = %d/%d, want 1000/150", resp.Usage.CacheReadTokens, resp.Usage.CacheWriteTokens)
+	}
+	if _, ok := messages[0].Content.(string); !ok {
+		t.Error("caller's messages should not be modified")
+	}
+}
+
+func TestHTTPProvider_MergesSystemMessages(t *testing.T) {
+	var reqBody struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices": [{"message": {"content": "ok"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("key", server.URL, "")
+	messages := []Message{
+		{Role: "system", Content: "system prompt"},
+		{Role: "system", Content: "current time"},
+		{Role: "user", Content: "hi"},
+	}
+	if _, err := p.Chat(t.Context(), messages, nil, "gpt-4o", nil); err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if len(reqBody.Messages) != 2 || reqBody.Messages[0]["content"] != "system prompt\n\ncurrent time" {
+		t.Errorf("messages = %v", reqBody.Messages)
+	}
+}
+
+func TestHTTPProvider_StreamsTextDeltas(t *testing.T) {
+	var reqBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"choices":[{"delta":{"role":"assistant","content":"Let me "}}]}`,
+			`{"choices":[{"delta":{"content":"check."}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":""}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Oslo\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":4,"total_tokens":14}}`,
+			`[DONE]`,
+		} {
+			w.Write([]byte("data: " + chunk + "\n\n"))
+		}
+	}))
+	defer server.Close()
+
+	var deltas []string
+	p := NewHTTPProvider("key", server.URL, "")
+	resp, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "weather?"}}, nil, "gpt-4o", map[string]interface{}{
+		"on_text_delta": func(delta string) { deltas = append(deltas, delta) },
+	})
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if reqBody["stream"] != true {
+		t.Errorf("stream = %v, want true", reqBody["stream"])
+	}
+	if len(deltas) != 2 || deltas[0] != "Let me " || deltas[1] != "check." {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if resp.Content != "Let me check." || resp.FinishReason != "tool_calls" {
+		t.Errorf("response = %+v", resp)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID != "call_1" || resp.ToolCalls[0].Name != "weather" || resp.ToolCalls[0].Arguments["city"] != "Oslo" {
+		t.Errorf("tool calls = %+v", resp.ToolCalls)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 14 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
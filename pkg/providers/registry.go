@@ -0,0 +1,351 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// Provider types accepted in the "type" field of a provider entry.
+const (
+	ProviderTypeOpenAICompatible = "openai-compatible"
+	ProviderTypeAnthropic        = "anthropic"
+	ProviderTypeCodex            = "codex"
+	ProviderTypeGemini           = "gemini"
+	ProviderTypeCLI              = "cli"
+	ProviderTypeGitHubCopilot    = "github-copilot"
+)
+
+// builtinProvider describes how a legacy ProvidersConfig field maps onto a
+// registry entry.
+type builtinProvider struct {
+	typ     string
+	apiBase string
+}
+
+var builtinProviders = map[string]builtinProvider{
+	"anthropic":      {ProviderTypeAnthropic, "https://api.anthropic.com"},
+	"openai":         {ProviderTypeOpenAICompatible, "https://api.openai.com/v1"},
+	"openrouter":     {ProviderTypeOpenAICompatible, "https://openrouter.ai/api/v1"},
+	"groq":           {ProviderTypeOpenAICompatible, "https://api.groq.com/openai/v1"},
+	"zhipu":          {ProviderTypeOpenAICompatible, "https://open.bigmodel.cn/api/paas/v4"},
+	"vllm":           {ProviderTypeOpenAICompatible, ""},
+	"gemini":         {ProviderTypeGemini, "https://generativelanguage.googleapis.com/v1beta"},
+	"nvidia":         {ProviderTypeOpenAICompatible, "https://integrate.api.nvidia.com/v1"},
+	"moonshot":       {ProviderTypeOpenAICompatible, "https://api.moonshot.cn/v1"},
+	"shengsuanyun":   {ProviderTypeOpenAICompatible, "https://router.shengsuanyun.com/api/v1"},
+	"deepseek":       {ProviderTypeOpenAICompatible, "https://api.deepseek.com/v1"},
+	"github_copilot": {ProviderTypeGitHubCopilot, "localhost:4321"},
+	"claude-cli":     {ProviderTypeCLI, ""},
+}
+
+// providerNameAliases maps historical spellings of agents.defaults.provider
+// onto registry names.
+var providerNameAliases = map[string]string{
+	"gpt":         "openai",
+	"claude":      "anthropic",
+	"glm":         "zhipu",
+	"google":      "gemini",
+	"copilot":     "github_copilot",
+	"claudecode":  "claude-cli",
+	"claude-code": "claude-cli",
+}
+
+// ProviderSpec is a registry entry: a named provider configuration with its
+// type and API base resolved.
+type ProviderSpec struct {
+	Name string
+	config.ProviderConfig
+	builtin bool
+}
+
+// configured reports whether the entry carries enough settings to be used.
+// User-defined entries are always considered configured.
+func (s ProviderSpec) configured() bool {
+	if !s.builtin {
+		return true
+	}
+	switch s.Type {
+	case ProviderTypeCLI, ProviderTypeGitHubCopilot:
+		return true
+	}
+	if s.Name == "vllm" {
+		return s.ProviderConfig.APIBase != ""
+	}
+	return s.APIKey != "" || s.AuthMethod != ""
+}
+
+// ResolveModel maps a model reference to the upstream model ID: a leading
+// "<name>/" is stripped for user-defined entries and aliases are expanded.
+func (s ProviderSpec) ResolveModel(model string) string {
+	if !s.builtin {
+		model = strings.TrimPrefix(model, s.Name+"/")
+	}
+	if upstream, ok := s.Models[model]; ok && upstream != "" {
+		return upstream
+	}
+	if s.Name == "deepseek" && model != "deepseek-chat" && model != "deepseek-reasoner" {
+		return "deepseek-chat"
+	}
+	return model
+}
+
+// Registry holds all provider entries from config, keyed by name, and
+// creates provider instances on demand.
+type Registry struct {
+	workspace string
+	specs     map[string]ProviderSpec
+	mu        sync.Mutex
+	instances map[string]LLMProvider
+}
+
+// NewRegistry builds a registry from the legacy vendor fields and the
+// user-defined entries in cfg.Providers.Named.
+func NewRegistry(cfg *config.Config) *Registry {
+	p := cfg.Providers
+	legacy := map[string]config.ProviderConfig{
+		"anthropic":      p.Anthropic,
+		"openai":         p.OpenAI,
+		"openrouter":     p.OpenRouter,
+		"groq":           p.Groq,
+		"zhipu":          p.Zhipu,
+		"vllm":           p.VLLM,
+		"gemini":         p.Gemini,
+		"nvidia":         p.Nvidia,
+		"moonshot":       p.Moonshot,
+		"shengsuanyun":   p.ShengSuanYun,
+		"deepseek":       p.DeepSeek,
+		"github_copilot": p.GitHubCopilot,
+		"claude-cli":     {},
+	}
+
+	workspace := cfg.Agents.Defaults.Workspace
+	if workspace == "" {
+		workspace = "."
+	}
+
+	r := &Registry{
+		workspace: workspace,
+		specs:     make(map[string]ProviderSpec),
+		instances: make(map[string]LLMProvider),
+	}
+
+	for name, pc := range legacy {
+		def := builtinProviders[name]
+		if pc.Type == "" {
+			pc.Type = def.typ
+			// OAuth / pasted-token logins for OpenAI go through the Codex backend.
+			if name == "openai" && (pc.AuthMethod == "oauth" || pc.AuthMethod == "token") {
+				pc.Type = ProviderTypeCodex
+			}
+		}
+		if pc.APIBase == "" {
+			pc.APIBase = def.apiBase
+		}
+		r.specs[name] = ProviderSpec{Name: name, ProviderConfig: pc, builtin: true}
+	}
+
+	for name, pc := range p.Named {
+		name = strings.ToLower(name)
+		if _, ok := r.specs[name]; ok {
+			continue
+		}
+		if pc.Type == "" {
+			pc.Type = ProviderTypeOpenAICompatible
+		}
+		if pc.Type == "openai" {
+			pc.Type = ProviderTypeOpenAICompatible
+		}
+		r.specs[name] = ProviderSpec{Name: name, ProviderConfig: pc}
+	}
+
+	return r
+}
+
+// Names returns the names of all usable entries, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.specs))
+	for name, spec := range r.specs {
+		if spec.configured() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Spec returns the entry registered under name (or one of its aliases).
+func (r *Registry) Spec(name string) (ProviderSpec, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := providerNameAliases[name]; ok {
+		name = alias
+	}
+	spec, ok := r.specs[name]
+	return spec, ok
+}
+
+// Resolve picks the provider for a (provider, model) pair and returns it
+// together with the upstream model ID to send.
+//
+// An explicitly named, configured provider wins. Otherwise the model may be
+// written as "<name>/<model>" for a user-defined entry, or be an alias
+// declared in exactly one entry's "models". As a last resort the legacy
+// model-name heuristics pick one of the built-in vendors.
+func (r *Registry) Resolve(providerName, model string) (LLMProvider, string, error) {
+	if providerName != "" {
+		if spec, ok := r.Spec(providerName); ok && spec.configured() {
+			return r.instantiate(spec, model)
+		}
+		if _, ok := r.Spec(providerName); !ok {
+			return nil, "", fmt.Errorf("unknown provider %q", providerName)
+		}
+	}
+
+	if idx := strings.Index(model, "/"); idx > 0 {
+		if spec, ok := r.specs[strings.ToLower(model[:idx])]; ok && !spec.builtin {
+			return r.instantiate(spec, model)
+		}
+	}
+
+	var aliasOwners []string
+	for _, name := range r.Names() {
+		if _, ok := r.specs[name].Models[model]; ok {
+			aliasOwners = append(aliasOwners, name)
+		}
+	}
+	switch len(aliasOwners) {
+	case 0:
+	case 1:
+		return r.instantiate(r.specs[aliasOwners[0]], model)
+	default:
+		return nil, "", fmt.Errorf("model alias %q is defined by several providers (%s); set agents.defaults.provider",
+			model, strings.Join(aliasOwners, ", "))
+	}
+
+	name, err := r.detectFromModel(model)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.instantiate(r.specs[name], model)
+}
+
+// detectFromModel guesses a built-in vendor from the model name. Kept for
+// configs written before the registry existed.
+func (r *Registry) detectFromModel(model string) (string, error) {
+	lowerModel := strings.ToLower(model)
+	has := func(name string) bool {
+		return r.specs[name].configured()
+	}
+
+	switch {
+	case (strings.Contains(lowerModel, "kimi") || strings.Contains(lowerModel, "moonshot") || strings.HasPrefix(model, "moonshot/")) && has("moonshot"):
+		return "moonshot", nil
+	case strings.HasPrefix(model, "openrouter/") || strings.HasPrefix(model, "anthropic/") || strings.HasPrefix(model, "openai/") || strings.HasPrefix(model, "meta-llama/") || strings.HasPrefix(model, "deepseek/") || strings.HasPrefix(model, "google/"):
+		return "openrouter", nil
+	case (strings.Contains(lowerModel, "claude") || strings.HasPrefix(model, "anthropic/")) && has("anthropic"):
+		return "anthropic", nil
+	case (strings.Contains(lowerModel, "gpt") || strings.HasPrefix(model, "openai/")) && has("openai"):
+		return "openai", nil
+	case (strings.Contains(lowerModel, "gemini") || strings.HasPrefix(model, "google/")) && has("gemini"):
+		return "gemini", nil
+	case (strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "zhipu") || strings.Contains(lowerModel, "zai")) && has("zhipu"):
+		return "zhipu", nil
+	case (strings.Contains(lowerModel, "groq") || strings.HasPrefix(model, "groq/")) && has("groq"):
+		return "groq", nil
+	case (strings.Contains(lowerModel, "nvidia") || strings.HasPrefix(model, "nvidia/")) && has("nvidia"):
+		return "nvidia", nil
+	case has("vllm"):
+		return "vllm", nil
+	case has("openrouter"):
+		return "openrouter", nil
+	default:
+		return "", fmt.Errorf("no API key configured for model: %s", model)
+	}
+}
+
+// instantiate returns the (cached) provider instance for spec and the
+// upstream model ID for model.
+func (r *Registry) instantiate(spec ProviderSpec, model string) (LLMProvider, string, error) {
+	upstream := spec.ResolveModel(model)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.instances[spec.Name]; ok {
+		return p, upstream, nil
+	}
+
+	p, err := r.build(spec, upstream)
+	if err != nil {
+		return nil, "", err
+	}
+	r.instances[spec.Name] = p
+	return p, upstream, nil
+}
+
+func (r *Registry) build(spec ProviderSpec, model string) (LLMProvider, error) {
+	switch spec.Type {
+	case ProviderTypeAnthropic:
+		if spec.AuthMethod == "oauth" || spec.AuthMethod == "token" {
+			return createClaudeAuthProvider()
+		}
+		if spec.APIKey == "" {
+			return nil, fmt.Errorf("no API key configured for provider %q (model: %s)", spec.Name, model)
+		}
+		opts := []option.RequestOption{
+			option.WithAPIKey(spec.APIKey),
+			option.WithBaseURL(spec.APIBase),
+			option.WithHTTPClient(newHTTPClient(spec.Proxy)),
+		}
+		for k, v := range spec.Headers {
+			opts = append(opts, option.WithHeader(k, v))
+		}
+		return newClaudeProvider(opts...), nil
+
+	case ProviderTypeCodex:
+		if spec.APIKey != "" && spec.AuthMethod == "" {
+			return NewCodexProvider(spec.APIKey, ""), nil
+		}
+		return createCodexAuthProvider()
+
+	case ProviderTypeCLI:
+		return NewClaudeCliProvider(r.workspace), nil
+
+	case ProviderTypeGitHubCopilot:
+		return NewGitHubCopilotProvider(spec.APIBase, spec.ConnectMode, model)
+
+	case ProviderTypeOpenAICompatible, ProviderTypeGemini:
+		if spec.builtin && spec.APIKey == "" && !strings.HasPrefix(model, "bedrock/") {
+			return nil, fmt.Errorf("no API key configured for provider (model: %s)", model)
+		}
+		if spec.APIBase == "" {
+			return nil, fmt.Errorf("no API base configured for provider (model: %s)", model)
+		}
+		p := NewHTTPProvider(spec.APIKey, spec.APIBase, spec.Proxy)
+		p.SetHeaders(spec.Headers)
+		return p, nil
+
+	default:
+		return nil, fmt.Errorf("provider %q: unsupported type %q", spec.Name, spec.Type)
+	}
+}
+
+// aliasedProvider rewrites a configured model alias to its upstream model ID
+// before delegating. It lets callers keep passing the model string from config.
+type aliasedProvider struct {
+	LLMProvider
+	from string
+	to   string
+}
+
+func (p *aliasedProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	if model == p.from {
+		model = p.to
+	}
+	return p.LLMProvider.Chat(ctx, messages, tools, model, options)
+}
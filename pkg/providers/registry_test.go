@@ -0,0 +1,124 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestRegistry_NamedProviderWithAliasAndHeaders(t *testing.T) {
+	var gotModel, gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel, _ = body["model"].(string)
+		gotHeader = r.Header.Get("X-Team")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Provider = "lab-vllm"
+	cfg.Agents.Defaults.Model = "fast"
+	cfg.Providers.Named = map[string]config.ProviderConfig{
+		"lab-vllm": {
+			Type:    "openai-compatible",
+			APIBase: server.URL,
+			Headers: map[string]string{"X-Team": "assistant"},
+			Models:  map[string]string{"fast": "qwen2.5-7b"},
+		},
+	}
+
+	provider, err := CreateProvider(cfg)
+	if err != nil {
+		t.Fatalf("CreateProvider() error = %v", err)
+	}
+
+	resp, err := provider.Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "fast", nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if resp.Content != "ok" {
+		t.Errorf("Content = %q, want %q", resp.Content, "ok")
+	}
+	if gotModel != "qwen2.5-7b" {
+		t.Errorf("upstream model = %q, want %q", gotModel, "qwen2.5-7b")
+	}
+	if gotHeader != "assistant" {
+		t.Errorf("X-Team header = %q, want %q", gotHeader, "assistant")
+	}
+}
+
+func TestRegistry_ResolveByModelPrefix(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.Named = map[string]config.ProviderConfig{
+		"gpu-a": {APIBase: "http://gpu-a:8000/v1"},
+		"gpu-b": {APIBase: "http://gpu-b:8000/v1"},
+	}
+
+	r := NewRegistry(cfg)
+	provider, model, err := r.Resolve("", "gpu-b/llama-3-8b")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if model != "llama-3-8b" {
+		t.Errorf("model = %q, want %q", model, "llama-3-8b")
+	}
+	hp, ok := provider.(*HTTPProvider)
+	if !ok {
+		t.Fatalf("provider = %T, want *HTTPProvider", provider)
+	}
+	if hp.apiBase != "http://gpu-b:8000/v1" {
+		t.Errorf("apiBase = %q, want gpu-b", hp.apiBase)
+	}
+}
+
+func TestRegistry_AmbiguousAlias(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.Named = map[string]config.ProviderConfig{
+		"a": {APIBase: "http://a/v1", Models: map[string]string{"fast": "m1"}},
+		"b": {APIBase: "http://b/v1", Models: map[string]string{"fast": "m2"}},
+	}
+
+	if _, _, err := NewRegistry(cfg).Resolve("", "fast"); err == nil {
+		t.Fatal("Resolve() expected error for alias defined by two providers")
+	}
+}
+
+func TestRegistry_LegacyFieldsMapped(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Model = "glm-4.7"
+	cfg.Providers.Zhipu.APIKey = "zhipu-key"
+
+	provider, model, err := NewRegistry(cfg).Resolve("", "glm-4.7")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if model != "glm-4.7" {
+		t.Errorf("model = %q, want glm-4.7", model)
+	}
+	hp, ok := provider.(*HTTPProvider)
+	if !ok {
+		t.Fatalf("provider = %T, want *HTTPProvider", provider)
+	}
+	if hp.apiBase != "https://open.bigmodel.cn/api/paas/v4" {
+		t.Errorf("apiBase = %q, want zhipu default", hp.apiBase)
+	}
+
+	// Explicit legacy alias name
+	if _, _, err := NewRegistry(cfg).Resolve("glm", "glm-4.7"); err != nil {
+		t.Errorf("Resolve(glm) error = %v", err)
+	}
+}
+
+func TestRegistry_UnknownProvider(t *testing.T) {
+	cfg := config.DefaultConfig()
+	if _, _, err := NewRegistry(cfg).Resolve("does-not-exist", "some-model"); err == nil {
+		t.Fatal("Resolve() expected error for unknown provider")
+	}
+}
@@ -19,24 +19,45 @@ type FunctionCall struct {
 }
 
 type LLMResponse struct {
-	Content      string     `json:"content"`
-	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
-	FinishReason string     `json:"finish_reason"`
-	Usage        *UsageInfo `json:"usage,omitempty"`
+	Content         string           `json:"content"`
+	Reasoning       string           `json:"reasoning,omitempty"` // Model's thinking text, if the provider exposes it
+	ReasoningBlocks []ReasoningBlock `json:"reasoning_blocks,omitempty"`
+	ToolCalls       []ToolCall       `json:"tool_calls,omitempty"`
+	FinishReason    string           `json:"finish_reason"`
+	Usage           *UsageInfo       `json:"usage,omitempty"`
+}
+
+// ReasoningBlock is a provider-specific reasoning item that has to be sent
+// back unchanged with the assistant turn that produced it, e.g. a Claude
+// thinking block with its signature or a Codex encrypted reasoning item.
+type ReasoningBlock struct {
+	Type      string `json:"type"` // "thinking", "redacted_thinking" (Claude) or "reasoning" (Codex)
+	ID        string `json:"id,omitempty"`
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"` // Redacted or encrypted payload
 }
 
 type UsageInfo struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	CacheReadTokens  int `json:"cache_read_tokens,omitempty"`  // Prompt tokens served from the prompt cache
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"` // Prompt tokens written to the prompt cache
 }
 
 // ContentBlock represents a piece of content (text or image)
 type ContentBlock struct {
-	Type      string       `json:"type"` // "text" or "image"
-	Text      string       `json:"text,omitempty"`
-	Source    *ImageSource `json:"source,omitempty"`
-	MediaType string       `json:"media_type,omitempty"` // For image blocks
+	Type         string        `json:"type"` // "text" or "image"
+	Text         string        `json:"text,omitempty"`
+	Source       *ImageSource  `json:"source,omitempty"`
+	MediaType    string        `json:"media_type,omitempty"`    // For image blocks
+	CacheControl *CacheControl `json:"cache_control,omitempty"` // Anthropic prompt cache breakpoint
+}
+
+// CacheControl marks a prompt cache breakpoint ({"type": "ephemeral"}).
+type CacheControl struct {
+	Type string `json:"type"`
 }
 
 // ImageSource represents an image in a content block
@@ -50,10 +71,12 @@ type ImageSource struct {
 type MessageContent interface{}
 
 type Message struct {
-	Role       string         `json:"role"`
-	Content    MessageContent `json:"content"` // Can be string or []ContentBlock
-	ToolCalls  []ToolCall     `json:"tool_calls,omitempty"`
-	ToolCallID string         `json:"tool_call_id,omitempty"`
+	Role             string           `json:"role"`
+	Content          MessageContent   `json:"content"` // Can be string or []ContentBlock
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ReasoningBlocks  []ReasoningBlock `json:"reasoning_blocks,omitempty"`
+	ToolCalls        []ToolCall       `json:"tool_calls,omitempty"`
+	ToolCallID       string           `json:"tool_call_id,omitempty"`
 }
 
 // GetTextContent extracts text content from a Message, handling both string and []ContentBlock types
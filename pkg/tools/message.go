@@ -3,19 +3,30 @@ package tools
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 
+	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
-type SendCallback func(channel, chatID, content string) error
+// SendCallback delivers a message the tool built, with any attachments,
+// reply, edit, reactions and buttons.
+type SendCallback func(msg bus.OutboundMessage) error
+
+// maxButtonsPerRow is how many buttons go on one row before wrapping.
+const maxButtonsPerRow = 3
 
 type MessageTool struct {
-	sendCallback   SendCallback
-	defaultChannel string
-	defaultChatID  string
-	sentInRound    bool // Tracks whether a message was sent in the current processing round
-	disabled       bool // If true, Execute will not send messages (for heartbeat mode)
+	sendCallback     SendCallback
+	workspace        string // Relative attachment paths resolve here
+	restrict         bool   // Only allow attachments inside the workspace
+	defaultChannel   string
+	defaultChatID    string
+	currentMessageID string // Platform ID of the message being handled, for reply_to "current"
+	sentInRound      bool   // Tracks whether a message was sent in the current processing round
+	disabled         bool   // If true, Execute will not send messages (for heartbeat mode)
 }
 
 func NewMessageTool() *MessageTool {
@@ -27,7 +38,7 @@ func (t *MessageTool) Name() string {
 }
 
 func (t *MessageTool) Description() string {
-	return "Send a message to user on a chat channel. Use this when you want to communicate something."
+	return "Send a message to user on a chat channel. Use this when you want to communicate something. Can attach files, images or audio by local path or URL, reply to or react to a message, edit your last message, and offer buttons the user can click."
 }
 
 func (t *MessageTool) Parameters() map[string]interface{} {
@@ -36,7 +47,7 @@ func (t *MessageTool) Parameters() map[string]interface{} {
 		"properties": map[string]interface{}{
 			"content": map[string]interface{}{
 				"type":        "string",
-				"description": "The message content to send",
+				"description": "The message content to send. May be left out when sending only attachments or reactions",
 			},
 			"channel": map[string]interface{}{
 				"type":        "string",
@@ -46,8 +57,67 @@ func (t *MessageTool) Parameters() map[string]interface{} {
 				"type":        "string",
 				"description": "Optional: target chat/user ID",
 			},
+			"attachments": map[string]interface{}{
+				"type":        "array",
+				"description": "Optional: files to send with the message",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"path": map[string]interface{}{
+							"type":        "string",
+							"description": "Local file path (relative to the workspace)",
+						},
+						"url": map[string]interface{}{
+							"type":        "string",
+							"description": "http(s) URL, used when path is empty",
+						},
+						"mime_type": map[string]interface{}{
+							"type":        "string",
+							"description": "Optional: MIME type, guessed from the file name if omitted",
+						},
+						"caption": map[string]interface{}{
+							"type":        "string",
+							"description": "Optional: caption shown with the file",
+						},
+					},
+				},
+			},
+			"reply_to": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: message ID to reply to; \"current\" replies to the message being handled",
+			},
+			"edit_message_id": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: ID of a message you sent to replace instead of sending a new one; \"last\" edits your latest message in the chat",
+			},
+			"reactions": map[string]interface{}{
+				"type":        "array",
+				"description": "Optional: emoji to react with on the reply_to message",
+				"items":       map[string]interface{}{"type": "string"},
+			},
+			"buttons": map[string]interface{}{
+				"type":        "array",
+				"description": "Optional: buttons shown under the message. A click comes back as a user message containing the button's data",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"text": map[string]interface{}{
+							"type":        "string",
+							"description": "Button label",
+						},
+						"data": map[string]interface{}{
+							"type":        "string",
+							"description": "Optional: value sent back when clicked (defaults to the label)",
+						},
+						"url": map[string]interface{}{
+							"type":        "string",
+							"description": "Optional: link to open instead of sending data",
+						},
+					},
+					"required": []string{"text"},
+				},
+			},
 		},
-		"required": []string{"content"},
 	}
 }
 
@@ -57,6 +127,12 @@ func (t *MessageTool) SetContext(channel, chatID string) {
 	t.sentInRound = false // Reset send tracking for new processing round
 }
 
+// SetCurrentMessageID sets the platform ID of the inbound message being
+// handled, which reply_to "current" resolves to.
+func (t *MessageTool) SetCurrentMessageID(messageID string) {
+	t.currentMessageID = messageID
+}
+
 // SetDisabled sets whether the message tool should actually send messages.
 // When disabled=true, Execute will log but not send (used for heartbeat mode).
 func (t *MessageTool) SetDisabled(disabled bool) {
@@ -72,12 +148,37 @@ func (t *MessageTool) SetSendCallback(callback SendCallback) {
 	t.sendCallback = callback
 }
 
+// SetWorkspace sets where relative attachment paths resolve, and whether
+// attachments must stay inside it.
+func (t *MessageTool) SetWorkspace(workspace string, restrict bool) {
+	t.workspace = workspace
+	t.restrict = restrict
+}
+
 func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	attachments, err := t.parseAttachments(args["attachments"])
+	if err != nil {
+		return &ToolResult{ForLLM: err.Error(), IsError: true}
+	}
+
+	buttons, err := parseButtons(args["buttons"])
+	if err != nil {
+		return &ToolResult{ForLLM: err.Error(), IsError: true}
+	}
+	reactions := parseStrings(args["reactions"])
+	replyTo, _ := args["reply_to"].(string)
+	if replyTo == "current" {
+		replyTo = t.currentMessageID
+	}
+	editID, _ := args["edit_message_id"].(string)
+
 	content, ok := args["content"].(string)
-	if !ok {
+	if !ok && len(attachments) == 0 && len(reactions) == 0 {
 		return &ToolResult{ForLLM: "content is required", IsError: true}
 	}
-
+	if len(reactions) > 0 && replyTo == "" {
+		return &ToolResult{ForLLM: "reactions need a reply_to message", IsError: true}
+	}
 	channel, _ := args["channel"].(string)
 	chatID, _ := args["chat_id"].(string)
 
@@ -111,7 +212,17 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 		}
 	}
 
-	if err := t.sendCallback(channel, chatID, content); err != nil {
+	err = t.sendCallback(bus.OutboundMessage{
+		Channel:       channel,
+		ChatID:        chatID,
+		Content:       content,
+		Attachments:   attachments,
+		ReplyTo:       replyTo,
+		EditMessageID: editID,
+		Reactions:     reactions,
+		Buttons:       buttons,
+	})
+	if err != nil {
 		return &ToolResult{
 			ForLLM:  fmt.Sprintf("sending message: %v", err),
 			IsError: true,
@@ -126,3 +237,82 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 		Silent: true,
 	}
 }
+
+// parseAttachments validates the attachments argument. Local paths must
+// exist (and stay inside the workspace when restricted); URLs must be http(s).
+func (t *MessageTool) parseAttachments(raw interface{}) ([]bus.Attachment, error) {
+	items, _ := raw.([]interface{})
+	attachments := make([]bus.Attachment, 0, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("attachments[%d] must be an object", i)
+		}
+		a := bus.Attachment{}
+		a.Path, _ = fields["path"].(string)
+		a.URL, _ = fields["url"].(string)
+		a.MIMEType, _ = fields["mime_type"].(string)
+		a.Caption, _ = fields["caption"].(string)
+
+		switch {
+		case a.Path != "":
+			path, err := validatePath(a.Path, t.workspace, t.restrict)
+			if err != nil {
+				return nil, fmt.Errorf("attachments[%d]: %w", i, err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("attachments[%d]: %w", i, err)
+			}
+			if info.IsDir() {
+				return nil, fmt.Errorf("attachments[%d]: %s is a directory", i, a.Path)
+			}
+			a.Path = path
+		case strings.HasPrefix(a.URL, "http://") || strings.HasPrefix(a.URL, "https://"):
+		default:
+			return nil, fmt.Errorf("attachments[%d] needs a path or an http(s) url", i)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// parseButtons validates the buttons argument and lays the buttons out in
+// rows of maxButtonsPerRow.
+func parseButtons(raw interface{}) ([][]bus.Button, error) {
+	items, _ := raw.([]interface{})
+	var rows [][]bus.Button
+	for i, item := range items {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("buttons[%d] must be an object", i)
+		}
+		b := bus.Button{}
+		b.Text, _ = fields["text"].(string)
+		b.Data, _ = fields["data"].(string)
+		b.URL, _ = fields["url"].(string)
+		if b.Text == "" {
+			return nil, fmt.Errorf("buttons[%d] needs text", i)
+		}
+		if b.URL != "" && !strings.HasPrefix(b.URL, "http://") && !strings.HasPrefix(b.URL, "https://") {
+			return nil, fmt.Errorf("buttons[%d] url must be http(s)", i)
+		}
+		if i%maxButtonsPerRow == 0 {
+			rows = append(rows, nil)
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], b)
+	}
+	return rows, nil
+}
+
+// parseStrings returns the non-empty strings in a JSON array argument.
+func parseStrings(raw interface{}) []string {
+	items, _ := raw.([]interface{})
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
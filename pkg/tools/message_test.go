@@ -3,7 +3,11 @@ package tools
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
 )
 
 func TestMessageTool_Execute_Success(t *testing.T) {
@@ -11,10 +15,10 @@ func TestMessageTool_Execute_Success(t *testing.T) {
 	tool.SetContext("test-channel", "test-chat-id")
 
 	var sentChannel, sentChatID, sentContent string
-	tool.SetSendCallback(func(channel, chatID, content string) error {
-		sentChannel = channel
-		sentChatID = chatID
-		sentContent = content
+	tool.SetSendCallback(func(msg bus.OutboundMessage) error {
+		sentChannel = msg.Channel
+		sentChatID = msg.ChatID
+		sentContent = msg.Content
 		return nil
 	})
 
@@ -63,9 +67,9 @@ func TestMessageTool_Execute_WithCustomChannel(t *testing.T) {
 	tool.SetContext("default-channel", "default-chat-id")
 
 	var sentChannel, sentChatID string
-	tool.SetSendCallback(func(channel, chatID, content string) error {
-		sentChannel = channel
-		sentChatID = chatID
+	tool.SetSendCallback(func(msg bus.OutboundMessage) error {
+		sentChannel = msg.Channel
+		sentChatID = msg.ChatID
 		return nil
 	})
 
@@ -99,7 +103,7 @@ func TestMessageTool_Execute_SendFailure(t *testing.T) {
 	tool.SetContext("test-channel", "test-chat-id")
 
 	sendErr := errors.New("network error")
-	tool.SetSendCallback(func(channel, chatID, content string) error {
+	tool.SetSendCallback(func(msg bus.OutboundMessage) error {
 		return sendErr
 	})
 
@@ -153,7 +157,7 @@ func TestMessageTool_Execute_NoTargetChannel(t *testing.T) {
 	tool := NewMessageTool()
 	// No SetContext called, so defaultChannel and defaultChatID are empty
 
-	tool.SetSendCallback(func(channel, chatID, content string) error {
+	tool.SetSendCallback(func(msg bus.OutboundMessage) error {
 		return nil
 	})
 
@@ -224,10 +228,13 @@ func TestMessageTool_Parameters(t *testing.T) {
 		t.Fatal("Expected properties to be a map")
 	}
 
-	// Check required properties
-	required, ok := params["required"].([]string)
-	if !ok || len(required) != 1 || required[0] != "content" {
-		t.Error("Expected 'content' to be required")
+	// Content may be left out when sending only attachments or reactions
+	if required, ok := params["required"].([]string); ok {
+		for _, name := range required {
+			if name == "content" {
+				t.Error("Expected 'content' not to be required")
+			}
+		}
 	}
 
 	// Check content property
@@ -257,3 +264,92 @@ func TestMessageTool_Parameters(t *testing.T) {
 		t.Error("Expected chat_id type to be 'string'")
 	}
 }
+
+func TestMessageTool_Execute_Attachments(t *testing.T) {
+	workspace := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workspace, "chart.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := NewMessageTool()
+	tool.SetContext("telegram", "42")
+	tool.SetWorkspace(workspace, true)
+
+	var sent []bus.Attachment
+	tool.SetSendCallback(func(msg bus.OutboundMessage) error {
+		sent = msg.Attachments
+		return nil
+	})
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"content": "Here is the chart",
+		"attachments": []interface{}{
+			map[string]interface{}{"path": "chart.png", "caption": "Weekly"},
+			map[string]interface{}{"url": "https://example.com/report.pdf"},
+		},
+	})
+	if result.IsError {
+		t.Fatalf("Execute error: %s", result.ForLLM)
+	}
+	if len(sent) != 2 || sent[0].Path != filepath.Join(workspace, "chart.png") || sent[0].Caption != "Weekly" {
+		t.Errorf("sent attachments = %+v", sent)
+	}
+	if sent[0].Kind() != bus.AttachmentImage || sent[1].MIME() != "application/pdf" {
+		t.Errorf("kinds = %s, %s", sent[0].Kind(), sent[1].MIME())
+	}
+
+	// Files outside a restricted workspace are refused
+	result = tool.Execute(context.Background(), map[string]interface{}{
+		"content":     "secrets",
+		"attachments": []interface{}{map[string]interface{}{"path": "/etc/passwd"}},
+	})
+	if !result.IsError {
+		t.Error("expected error for attachment outside the workspace")
+	}
+}
+
+func TestMessageTool_Execute_RepliesAndButtons(t *testing.T) {
+	tool := NewMessageTool()
+	tool.SetContext("telegram", "42")
+	tool.SetCurrentMessageID("1001")
+
+	var sent bus.OutboundMessage
+	tool.SetSendCallback(func(msg bus.OutboundMessage) error {
+		sent = msg
+		return nil
+	})
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"content":   "Deploy now?",
+		"reply_to":  "current",
+		"reactions": []interface{}{"👀"},
+		"buttons": []interface{}{
+			map[string]interface{}{"text": "Yes", "data": "deploy:yes"},
+			map[string]interface{}{"text": "No"},
+			map[string]interface{}{"text": "Later"},
+			map[string]interface{}{"text": "Docs", "url": "https://example.com/docs"},
+		},
+	})
+	if result.IsError {
+		t.Fatalf("Execute error: %s", result.ForLLM)
+	}
+	if sent.ReplyTo != "1001" || len(sent.Reactions) != 1 || sent.ChatID != "42" {
+		t.Errorf("sent = %+v", sent)
+	}
+	if len(sent.Buttons) != 2 || len(sent.Buttons[0]) != 3 || sent.Buttons[1][0].URL == "" {
+		t.Fatalf("button rows = %+v, want 3 + 1", sent.Buttons)
+	}
+	if got := sent.Buttons[0][1].Payload(); got != "No" {
+		t.Errorf("payload without data = %q, want label", got)
+	}
+
+	// A reaction needs a message to react to
+	tool.SetCurrentMessageID("")
+	result = tool.Execute(context.Background(), map[string]interface{}{
+		"reply_to":  "current",
+		"reactions": []interface{}{"👍"},
+	})
+	if !result.IsError {
+		t.Error("expected error for reaction without a target message")
+	}
+}
@@ -0,0 +1,246 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	mqttDefaultWait = 3 * time.Second
+	mqttMaxWait     = 30 * time.Second
+	// mqttQuiet ends a read once retained messages stop arriving.
+	mqttQuiet       = 500 * time.Millisecond
+	mqttMaxMessages = 100
+	mqttMaxPayload  = 2000
+)
+
+// MQTTToolOptions configures the broker the mqtt tool talks to.
+type MQTTToolOptions struct {
+	Broker      string
+	Username    string
+	Password    string
+	AllowTopics []string // MQTT filters the tool may use; empty allows all
+}
+
+// MQTTTool publishes to and reads from an MQTT broker. Each call uses its
+// own short connection, so the tool holds no state between calls.
+type MQTTTool struct {
+	opts MQTTToolOptions
+}
+
+func NewMQTTTool(opts MQTTToolOptions) *MQTTTool {
+	return &MQTTTool{opts: opts}
+}
+
+func (t *MQTTTool) Name() string {
+	return "mqtt"
+}
+
+func (t *MQTTTool) Description() string {
+	return "Talk to devices over MQTT. Actions: publish (send a payload to a topic, e.g. a command to a light or relay), read (get the retained state on a topic or wildcard filter, and anything published while waiting)."
+}
+
+func (t *MQTTTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"publish", "read"},
+				"description": "publish: send payload to topic. read: collect retained and new messages on topic.",
+			},
+			"topic": map[string]interface{}{
+				"type":        "string",
+				"description": "Topic to publish to, or topic filter to read (may use + and # wildcards), e.g. \"home/livingroom/light/set\"",
+			},
+			"payload": map[string]interface{}{
+				"type":        "string",
+				"description": "Payload to publish, e.g. \"ON\" or a JSON object as a string. Required for publish.",
+			},
+			"retain": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Ask the broker to keep the payload as the topic's current state. Default: false.",
+			},
+			"qos": map[string]interface{}{
+				"type":        "integer",
+				"enum":        []int{0, 1, 2},
+				"description": "Delivery guarantee: 0 at most once, 1 at least once, 2 exactly once. Default: 1.",
+			},
+			"wait_seconds": map[string]interface{}{
+				"type":        "integer",
+				"description": "For read: how long to wait for messages (1-30). Default: 3. Reading stops early once retained messages stop arriving.",
+			},
+		},
+		"required": []string{"action", "topic"},
+	}
+}
+
+func (t *MQTTTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	if t.opts.Broker == "" {
+		return ErrorResult("no MQTT broker configured")
+	}
+	action, _ := args["action"].(string)
+	topic, _ := args["topic"].(string)
+	if topic == "" {
+		return ErrorResult("topic is required")
+	}
+	if !t.allowed(topic) {
+		return ErrorResult(fmt.Sprintf("topic %q is not in the allowed topics", topic))
+	}
+	qos := byte(1)
+	if q, ok := args["qos"].(float64); ok {
+		if q < 0 || q > 2 {
+			return ErrorResult("qos must be 0, 1 or 2")
+		}
+		qos = byte(q)
+	}
+
+	switch action {
+	case "publish":
+		return t.publish(ctx, topic, qos, args)
+	case "read":
+		return t.read(ctx, topic, qos, args)
+	default:
+		return ErrorResult(fmt.Sprintf("unknown action: %s (valid: publish, read)", action))
+	}
+}
+
+func (t *MQTTTool) publish(ctx context.Context, topic string, qos byte, args map[string]interface{}) *ToolResult {
+	if strings.ContainsAny(topic, "+#") {
+		return ErrorResult("cannot publish to a wildcard topic")
+	}
+	payload, ok := args["payload"].(string)
+	if !ok {
+		return ErrorResult("payload is required for publish")
+	}
+	retain, _ := args["retain"].(bool)
+
+	client, err := t.connect(ctx)
+	if err != nil {
+		return ErrorResult(err.Error()).WithError(err)
+	}
+	defer client.Disconnect(250)
+
+	if err := waitToken(ctx, client.Publish(topic, qos, retain, payload)); err != nil {
+		return ErrorResult(fmt.Sprintf("publish to %s failed: %v", topic, err)).WithError(err)
+	}
+	note := ""
+	if retain {
+		note = " (retained)"
+	}
+	return SilentResult(fmt.Sprintf("Published %d bytes to %s%s", len(payload), topic, note))
+}
+
+func (t *MQTTTool) read(ctx context.Context, filter string, qos byte, args map[string]interface{}) *ToolResult {
+	waitFor := mqttDefaultWait
+	if s, ok := args["wait_seconds"].(float64); ok && s > 0 {
+		waitFor = time.Duration(s) * time.Second
+		if waitFor > mqttMaxWait {
+			waitFor = mqttMaxWait
+		}
+	}
+
+	client, err := t.connect(ctx)
+	if err != nil {
+		return ErrorResult(err.Error()).WithError(err)
+	}
+	defer client.Disconnect(250)
+
+	var mu sync.Mutex
+	var lines []string
+	arrived := make(chan struct{}, 1)
+	handler := func(_ paho.Client, msg paho.Message) {
+		payload := string(msg.Payload())
+		if len(payload) > mqttMaxPayload {
+			payload = payload[:mqttMaxPayload] + "... (truncated)"
+		}
+		line := msg.Topic() + ": " + payload
+		if msg.Retained() {
+			line = msg.Topic() + " (retained): " + payload
+		}
+		mu.Lock()
+		if len(lines) < mqttMaxMessages {
+			lines = append(lines, line)
+		}
+		mu.Unlock()
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+	}
+	if err := waitToken(ctx, client.Subscribe(filter, qos, handler)); err != nil {
+		return ErrorResult(fmt.Sprintf("subscribe to %s failed: %v", filter, err)).WithError(err)
+	}
+
+	deadline := time.NewTimer(waitFor)
+	defer deadline.Stop()
+	var quiet <-chan time.Time
+collect:
+	for {
+		select {
+		case <-arrived:
+			quiet = time.After(mqttQuiet)
+		case <-quiet:
+			break collect
+		case <-deadline.C:
+			break collect
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lines) == 0 {
+		return SilentResult(fmt.Sprintf("No messages on %s within %s (no retained state)", filter, waitFor))
+	}
+	return SilentResult(fmt.Sprintf("%d message(s) on %s:\n%s", len(lines), filter, strings.Join(lines, "\n")))
+}
+
+func (t *MQTTTool) connect(ctx context.Context) (paho.Client, error) {
+	opts := paho.NewClientOptions().
+		AddBroker(t.opts.Broker).
+		SetClientID("picoclaw-tool-" + uuid.New().String()[:8]).
+		SetUsername(t.opts.Username).
+		SetPassword(t.opts.Password).
+		SetConnectTimeout(10 * time.Second).
+		SetAutoReconnect(false)
+	client := paho.NewClient(opts)
+	if err := waitToken(ctx, client.Connect()); err != nil {
+		return nil, fmt.Errorf("connect to MQTT broker %s: %w", t.opts.Broker, err)
+	}
+	return client, nil
+}
+
+// allowed reports whether topic, taken literally, falls under one of the
+// allowed filters. A requested wildcard only passes a filter that covers
+// everything it could match.
+func (t *MQTTTool) allowed(topic string) bool {
+	if len(t.opts.AllowTopics) == 0 {
+		return true
+	}
+	for _, filter := range t.opts.AllowTopics {
+		if utils.MQTTTopicMatch(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitToken waits for a paho token, giving up when ctx is done.
+func waitToken(ctx context.Context, token paho.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
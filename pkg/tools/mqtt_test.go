@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+func startTestBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := mochi.New(&mochi.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	server.AddHook(new(auth.AllowHook), nil)
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr})); err != nil {
+		t.Fatalf("add listener: %v", err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, "tcp://" + addr
+}
+
+func TestMQTTTool_PublishAndReadRetained(t *testing.T) {
+	broker, url := startTestBroker(t)
+	broker.Publish("home/kitchen/temp", []byte("21.5"), true, 0)
+	tool := NewMQTTTool(MQTTToolOptions{Broker: url, AllowTopics: []string{"home/#"}})
+	ctx := context.Background()
+
+	result := tool.Execute(ctx, map[string]interface{}{
+		"action": "publish", "topic": "home/lamp/set", "payload": "ON", "retain": true,
+	})
+	if result.IsError || !strings.Contains(result.ForLLM, "home/lamp/set (retained)") {
+		t.Fatalf("publish = %+v", result)
+	}
+
+	result = tool.Execute(ctx, map[string]interface{}{"action": "read", "topic": "home/#", "wait_seconds": float64(2)})
+	if result.IsError {
+		t.Fatalf("read = %+v", result)
+	}
+	for _, want := range []string{"home/kitchen/temp (retained): 21.5", "home/lamp/set (retained): ON"} {
+		if !strings.Contains(result.ForLLM, want) {
+			t.Errorf("read result %q lacks %q", result.ForLLM, want)
+		}
+	}
+
+	result = tool.Execute(ctx, map[string]interface{}{"action": "read", "topic": "home/garage/door", "wait_seconds": float64(1)})
+	if result.IsError || !strings.Contains(result.ForLLM, "No messages") {
+		t.Errorf("empty read = %+v", result)
+	}
+}
+
+func TestMQTTTool_AllowedTopics(t *testing.T) {
+	tool := NewMQTTTool(MQTTToolOptions{Broker: "tcp://127.0.0.1:1", AllowTopics: []string{"home/+/set", "sensors/#"}})
+	for _, topic := range []string{"alarm/disarm", "#", "home/#", "home/a/b/set"} {
+		result := tool.Execute(context.Background(), map[string]interface{}{"action": "read", "topic": topic})
+		if !result.IsError || !strings.Contains(result.ForLLM, "not in the allowed topics") {
+			t.Errorf("topic %q = %+v, want refused", topic, result)
+		}
+	}
+
+	result := tool.Execute(context.Background(), map[string]interface{}{"action": "publish", "topic": "sensors/+", "payload": "x"})
+	if !result.IsError || !strings.Contains(result.ForLLM, "wildcard") {
+		t.Errorf("wildcard publish = %+v", result)
+	}
+}
@@ -8,14 +8,19 @@ import (
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/usage"
 )
 
+// TaskSubagent is the usage ledger task of subagent LLM calls.
+const TaskSubagent = "subagent"
+
 type SubagentTask struct {
 	ID            string
 	Task          string
 	Label         string
 	OriginChannel string
 	OriginChatID  string
+	OriginSender  string
 	Status        string
 	Result        string
 	Created       int64
@@ -31,6 +36,9 @@ type SubagentManager struct {
 	tools         *ToolRegistry
 	maxIterations int
 	nextID        int
+	providerName  string        // Recorded with each call
+	ledger        *usage.Ledger // nil when usage tracking is disabled
+	budget        *usage.Budget
 }
 
 func NewSubagentManager(provider providers.LLMProvider, defaultModel, workspace string, bus *bus.MessageBus) *SubagentManager {
@@ -54,6 +62,45 @@ func (sm *SubagentManager) SetTools(tools *ToolRegistry) {
 	sm.tools = tools
 }
 
+// SetUsage records subagent LLM calls in ledger, and stops a subagent once
+// the sender that asked for it is over budget.
+func (sm *SubagentManager) SetUsage(providerName string, ledger *usage.Ledger, budget *usage.Budget) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.providerName = providerName
+	sm.ledger = ledger
+	sm.budget = budget
+}
+
+// loopConfig returns the tool loop settings for a task asked for by sender
+// on channel/chatID. Caller holds sm.mu.
+func (sm *SubagentManager) loopConfig(channel, chatID, sender string) ToolLoopConfig {
+	budget := sm.budget
+	if sender == "cron" {
+		// Cron jobs are not attributed to a sender
+		budget = nil
+	}
+	return ToolLoopConfig{
+		Provider:      sm.provider,
+		Model:         sm.defaultModel,
+		Tools:         sm.tools,
+		MaxIterations: sm.maxIterations,
+		LLMOptions: map[string]any{
+			"max_tokens":  4096,
+			"temperature": 0.7,
+		},
+		Ledger: sm.ledger,
+		Budget: budget,
+		Usage: usage.Record{
+			Provider: sm.providerName,
+			Task:     TaskSubagent,
+			Channel:  channel,
+			ChatID:   chatID,
+			SenderID: sender,
+		},
+	}
+}
+
 // RegisterTool registers a tool for subagent execution.
 func (sm *SubagentManager) RegisterTool(tool Tool) {
 	sm.mu.Lock()
@@ -61,7 +108,7 @@ func (sm *SubagentManager) RegisterTool(tool Tool) {
 	sm.tools.Register(tool)
 }
 
-func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel, originChatID string, callback AsyncCallback) (string, error) {
+func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel, originChatID, originSender string, callback AsyncCallback) (string, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -74,6 +121,7 @@ func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel
 		Label:         label,
 		OriginChannel: originChannel,
 		OriginChatID:  originChatID,
+		OriginSender:  originSender,
 		Status:        "running",
 		Created:       time.Now().UnixMilli(),
 	}
@@ -121,20 +169,10 @@ After completing the task, provide a clear summary of what was done.`
 
 	// Run tool loop with access to tools
 	sm.mu.RLock()
-	tools := sm.tools
-	maxIter := sm.maxIterations
+	loopConfig := sm.loopConfig(task.OriginChannel, task.OriginChatID, task.OriginSender)
 	sm.mu.RUnlock()
 
-	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
-	}, messages, task.OriginChannel, task.OriginChatID)
+	loopResult, err := RunToolLoop(ctx, loopConfig, messages, task.OriginChannel, task.OriginChatID)
 
 	sm.mu.Lock()
 	var result *ToolResult
@@ -212,6 +250,7 @@ type SubagentTool struct {
 	manager       *SubagentManager
 	originChannel string
 	originChatID  string
+	originSender  string
 }
 
 func NewSubagentTool(manager *SubagentManager) *SubagentTool {
@@ -252,6 +291,11 @@ func (t *SubagentTool) SetContext(channel, chatID string) {
 	t.originChatID = chatID
 }
 
+// SetSender sets who asked for the task, for usage and budgets.
+func (t *SubagentTool) SetSender(senderID string) {
+	t.originSender = senderID
+}
+
 func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
 	task, ok := args["task"].(string)
 	if !ok {
@@ -279,20 +323,10 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 	// Use RunToolLoop to execute with tools (same as async SpawnTool)
 	sm := t.manager
 	sm.mu.RLock()
-	tools := sm.tools
-	maxIter := sm.maxIterations
+	loopConfig := sm.loopConfig(t.originChannel, t.originChatID, t.originSender)
 	sm.mu.RUnlock()
 
-	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
-	}, messages, t.originChannel, t.originChatID)
+	loopResult, err := RunToolLoop(ctx, loopConfig, messages, t.originChannel, t.originChatID)
 
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("Subagent execution failed: %v", err)).WithError(err)
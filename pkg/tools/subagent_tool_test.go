@@ -6,7 +6,9 @@ import (
 	"testing"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/usage"
 )
 
 // MockLLMProvider is a test implementation of LLMProvider
@@ -313,3 +315,42 @@ func TestSubagentTool_ForUserTruncation(t *testing.T) {
 		t.Error("ForLLM should contain reference to original task")
 	}
 }
+
+// usageProvider answers every call and reports the tokens it used
+type usageProvider struct{ MockLLMProvider }
+
+func (p *usageProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	resp, _ := p.MockLLMProvider.Chat(ctx, messages, tools, model, options)
+	resp.Usage = &providers.UsageInfo{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150}
+	return resp, nil
+}
+
+// TestSubagentTool_RecordsUsageAndChecksBudget verifies subagent calls are
+// billed to the sender who asked for them and stop once they are over budget
+func TestSubagentTool_RecordsUsageAndChecksBudget(t *testing.T) {
+	ledger := usage.NewLedger(t.TempDir(), nil)
+	budget := usage.NewBudget(ledger, config.BudgetsConfig{PerSender: config.BudgetLimit{DailyTokens: 100}})
+	manager := NewSubagentManager(&usageProvider{}, "test-model", "/tmp/test", nil)
+	manager.SetUsage("openrouter", ledger, budget)
+
+	tool := NewSubagentTool(manager)
+	tool.SetContext("telegram", "42")
+	tool.SetSender("42")
+
+	if result := tool.Execute(context.Background(), map[string]interface{}{"task": "look it up"}); result.IsError {
+		t.Fatalf("first task failed: %s", result.ForLLM)
+	}
+	records, err := usage.LoadRecords(ledger.Path())
+	if err != nil || len(records) != 1 {
+		t.Fatalf("records = %+v, %v", records, err)
+	}
+	if r := records[0]; r.Task != TaskSubagent || r.SenderID != "42" || r.Provider != "openrouter" || r.TotalTokens != 150 {
+		t.Errorf("record = %+v", r)
+	}
+
+	// The sender has now used 150 of 100 tokens today
+	result := tool.Execute(context.Background(), map[string]interface{}{"task": "again"})
+	if !result.IsError || !strings.Contains(result.ForLLM, "budget") {
+		t.Errorf("over-budget task = %+v", result)
+	}
+}
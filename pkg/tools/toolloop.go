@@ -13,6 +13,7 @@ import (
 
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/usage"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
@@ -23,6 +24,9 @@ type ToolLoopConfig struct {
 	Tools         *ToolRegistry
 	MaxIterations int
 	LLMOptions    map[string]any
+	Ledger        *usage.Ledger // Records every LLM call; nil when usage tracking is disabled
+	Budget        *usage.Budget // Checked before every LLM call; nil means unlimited
+	Usage         usage.Record  // Attribution for recorded calls: provider, task, channel, sender
 }
 
 // ToolLoopResult contains the result of running the tool loop.
@@ -61,7 +65,12 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 			}
 		}
 
-		// 3. Call LLM
+		// 3. Call LLM, unless the sender or channel has used up its budget
+		if config.Budget != nil {
+			if over, period := config.Budget.Check(config.Usage.Channel, config.Usage.SenderID); over {
+				return nil, fmt.Errorf("usage budget exceeded for this %s", period)
+			}
+		}
 		response, err := config.Provider.Chat(ctx, messages, providerToolDefs, config.Model, llmOpts)
 		if err != nil {
 			logger.ErrorCF("toolloop", "LLM call failed",
@@ -71,6 +80,7 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 				})
 			return nil, fmt.Errorf("LLM call failed: %w", err)
 		}
+		recordToolLoopUsage(config, response.Usage)
 
 		// 4. If no tool calls, we're done
 		if len(response.ToolCalls) == 0 {
@@ -97,8 +107,10 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 
 		// 6. Build assistant message with tool calls
 		assistantMsg := providers.Message{
-			Role:    "assistant",
-			Content: response.Content,
+			Role:             "assistant",
+			Content:          response.Content,
+			ReasoningContent: response.Reasoning,
+			ReasoningBlocks:  response.ReasoningBlocks,
 		}
 		for _, tc := range response.ToolCalls {
 			argumentsJSON, _ := json.Marshal(tc.Arguments)
@@ -152,3 +164,20 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 		Iterations: iteration,
 	}, nil
 }
+
+// recordToolLoopUsage writes one LLM call of the loop to the usage ledger.
+func recordToolLoopUsage(config ToolLoopConfig, info *providers.UsageInfo) {
+	if config.Ledger == nil || info == nil {
+		return
+	}
+	rec := config.Usage
+	rec.Model = config.Model
+	rec.PromptTokens = info.PromptTokens
+	rec.CompletionTokens = info.CompletionTokens
+	rec.CacheReadTokens = info.CacheReadTokens
+	rec.CacheWriteTokens = info.CacheWriteTokens
+	rec.TotalTokens = info.TotalTokens
+	if err := config.Ledger.Record(rec); err != nil {
+		logger.WarnCF("toolloop", "Failed to record usage", map[string]any{"error": err.Error()})
+	}
+}
@@ -0,0 +1,87 @@
+package usage
+
+import (
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// Budget periods reported by Check.
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// Budget enforces per-sender and per-channel limits against a Ledger.
+type Budget struct {
+	ledger *Ledger
+	cfg    config.BudgetsConfig
+}
+
+func NewBudget(ledger *Ledger, cfg config.BudgetsConfig) *Budget {
+	return &Budget{ledger: ledger, cfg: cfg}
+}
+
+// Check reports whether the sender or the channel has used up its budget,
+// and for which period (PeriodDay or PeriodMonth).
+func (b *Budget) Check(channel, senderID string) (bool, string) {
+	if senderID != "" {
+		if over, period := b.exceeded(SenderScope(channel, senderID), b.senderLimit(channel, senderID)); over {
+			return true, period
+		}
+	}
+	return b.exceeded(ChannelScope(channel), b.channelLimit(channel))
+}
+
+func (b *Budget) senderLimit(channel, senderID string) config.BudgetLimit {
+	id := senderID
+	if idx := strings.Index(id, "|"); idx > 0 {
+		id = id[:idx]
+	}
+	if limit, ok := b.cfg.Senders[channel+":"+id]; ok {
+		return limit
+	}
+	if limit, ok := b.cfg.Senders[channel+":"+senderID]; ok {
+		return limit
+	}
+	return b.cfg.PerSender
+}
+
+func (b *Budget) channelLimit(channel string) config.BudgetLimit {
+	if limit, ok := b.cfg.Channels[channel]; ok {
+		return limit
+	}
+	return b.cfg.PerChannel
+}
+
+func (b *Budget) exceeded(scope string, limit config.BudgetLimit) (bool, string) {
+	if limit.IsZero() {
+		return false, ""
+	}
+
+	day := b.ledger.Today(scope)
+	if (limit.DailyTokens > 0 && day.Tokens >= limit.DailyTokens) ||
+		(limit.DailyCost > 0 && day.Cost >= limit.DailyCost) {
+		return true, PeriodDay
+	}
+
+	month := b.ledger.ThisMonth(scope)
+	if (limit.MonthlyTokens > 0 && month.Tokens >= limit.MonthlyTokens) ||
+		(limit.MonthlyCost > 0 && month.Cost >= limit.MonthlyCost) {
+		return true, PeriodMonth
+	}
+
+	return false, ""
+}
+
+// BudgetMessage returns the reply sent instead of calling the provider.
+// A configured message overrides the default wording.
+func BudgetMessage(custom, period string) string {
+	if custom != "" {
+		return custom
+	}
+	if period == PeriodMonth {
+		return "Sorry, you've reached your usage limit for this month. I'll be able to help again next month."
+	}
+	return "Sorry, you've reached your usage limit for today. Please try again tomorrow."
+}
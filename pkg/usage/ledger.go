@@ -0,0 +1,299 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// Record is one LLM call in the ledger.
+type Record struct {
+	Time             time.Time `json:"time"`
+	Provider         string    `json:"provider,omitempty"`
+	Model            string    `json:"model"`
+	Task             string    `json:"task,omitempty"`
+	SessionKey       string    `json:"session_key,omitempty"`
+	Channel          string    `json:"channel,omitempty"`
+	ChatID           string    `json:"chat_id,omitempty"`
+	SenderID         string    `json:"sender_id,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CacheReadTokens  int       `json:"cache_read_tokens,omitempty"`  // Part of PromptTokens
+	CacheWriteTokens int       `json:"cache_write_tokens,omitempty"` // Part of PromptTokens
+	TotalTokens      int       `json:"total_tokens"`
+	Cost             float64   `json:"cost"`
+}
+
+// Totals accumulates tokens and cost.
+type Totals struct {
+	Calls  int
+	Tokens int
+	Cost   float64
+}
+
+func (t *Totals) add(r Record) {
+	t.Calls++
+	t.Tokens += r.TotalTokens
+	t.Cost += r.Cost
+}
+
+// Ledger appends usage records to a JSONL file and keeps running daily and
+// monthly totals per sender and per channel for budget checks.
+type Ledger struct {
+	path    string
+	pricing map[string]config.ModelPrice
+	mu      sync.Mutex
+	totals  map[string]*Totals // "<scope>|<period>" -> totals
+	month   string             // Months before this one have been pruned
+}
+
+// NewLedger opens (or creates) the ledger under workspace/usage and loads
+// the current month's totals.
+func NewLedger(workspace string, pricing map[string]config.ModelPrice) *Ledger {
+	dir := filepath.Join(workspace, "usage")
+	os.MkdirAll(dir, 0755)
+
+	l := &Ledger{
+		path:    filepath.Join(dir, "ledger.jsonl"),
+		pricing: pricing,
+		totals:  make(map[string]*Totals),
+		month:   monthKey(time.Now()),
+	}
+
+	records, _ := LoadRecords(l.path)
+	for _, r := range records {
+		if monthKey(r.Time) == l.month {
+			l.accumulate(r)
+		}
+	}
+
+	return l
+}
+
+// Path returns the ledger file path.
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Record prices r, appends it to the ledger and updates running totals.
+func (l *Ledger) Record(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if r.TotalTokens == 0 {
+		r.TotalTokens = r.PromptTokens + r.CompletionTokens
+	}
+	r.Cost = l.price(r)
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage ledger: %w", err)
+	}
+
+	l.accumulate(r)
+	return nil
+}
+
+// price computes the cost of r from the pricing table. The
+// "<provider>/<model>" key wins over the bare model name. Cached prompt
+// tokens use the cache prices when set.
+func (l *Ledger) price(r Record) float64 {
+	p, ok := l.pricing[r.Provider+"/"+r.Model]
+	if !ok {
+		p, ok = l.pricing[r.Model]
+	}
+	if !ok {
+		return 0
+	}
+
+	readPrice, writePrice := p.CacheRead, p.CacheWrite
+	if readPrice == 0 {
+		readPrice = p.Input
+	}
+	if writePrice == 0 {
+		writePrice = p.Input
+	}
+	uncached := r.PromptTokens - r.CacheReadTokens - r.CacheWriteTokens
+
+	return (float64(uncached)*p.Input +
+		float64(r.CacheReadTokens)*readPrice +
+		float64(r.CacheWriteTokens)*writePrice +
+		float64(r.CompletionTokens)*p.Output) / 1e6
+}
+
+// accumulate adds r to the in-memory totals. The first record of a new
+// month drops the totals of earlier ones, which budgets no longer look at.
+// Caller holds l.mu (or owns l).
+func (l *Ledger) accumulate(r Record) {
+	day, month := dayKey(r.Time), monthKey(r.Time)
+	if month > l.month {
+		l.month = month
+		for key := range l.totals {
+			// Day and month periods both start with their month
+			if key[strings.LastIndex(key, "|")+1:] < month {
+				delete(l.totals, key)
+			}
+		}
+	}
+	scopes := []string{ChannelScope(r.Channel)}
+	if r.SenderID != "" {
+		scopes = append(scopes, SenderScope(r.Channel, r.SenderID))
+	}
+	for _, scope := range scopes {
+		for _, period := range []string{day, month} {
+			key := scope + "|" + period
+			t, ok := l.totals[key]
+			if !ok {
+				t = &Totals{}
+				l.totals[key] = t
+			}
+			t.add(r)
+		}
+	}
+}
+
+// Today returns the totals for scope on the current day.
+func (l *Ledger) Today(scope string) Totals {
+	return l.get(scope + "|" + dayKey(time.Now()))
+}
+
+// ThisMonth returns the totals for scope in the current month.
+func (l *Ledger) ThisMonth(scope string) Totals {
+	return l.get(scope + "|" + monthKey(time.Now()))
+}
+
+func (l *Ledger) get(key string) Totals {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.totals[key]; ok {
+		return *t
+	}
+	return Totals{}
+}
+
+// SenderScope identifies a sender on a channel. Compound IDs such as
+// "123456|username" are reduced to the ID part.
+func SenderScope(channel, senderID string) string {
+	if idx := strings.Index(senderID, "|"); idx > 0 {
+		senderID = senderID[:idx]
+	}
+	return "sender:" + channel + ":" + senderID
+}
+
+// ChannelScope identifies a channel.
+func ChannelScope(channel string) string {
+	return "channel:" + channel
+}
+
+func dayKey(t time.Time) string {
+	return t.Local().Format("2006-01-02")
+}
+
+func monthKey(t time.Time) string {
+	return t.Local().Format("2006-01")
+}
+
+// LoadRecords reads every record from a ledger file. Malformed lines are skipped.
+func LoadRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// Summary is one row of a usage report.
+type Summary struct {
+	Key              string
+	Calls            int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	Cost             float64
+}
+
+// Summarize groups records since the given time by "day", "user", "model"
+// or "channel", sorted by key.
+func Summarize(records []Record, groupBy string, since time.Time) ([]Summary, error) {
+	var keyOf func(Record) string
+	switch groupBy {
+	case "day", "":
+		keyOf = func(r Record) string { return dayKey(r.Time) }
+	case "user", "sender":
+		keyOf = func(r Record) string {
+			if r.SenderID == "" {
+				return "(" + r.Task + ")"
+			}
+			return strings.TrimPrefix(SenderScope(r.Channel, r.SenderID), "sender:")
+		}
+	case "model":
+		keyOf = func(r Record) string {
+			if r.Provider == "" {
+				return r.Model
+			}
+			return r.Provider + "/" + r.Model
+		}
+	case "channel":
+		keyOf = func(r Record) string { return r.Channel }
+	default:
+		return nil, fmt.Errorf("unknown grouping %q (use day, user, model or channel)", groupBy)
+	}
+
+	rows := make(map[string]*Summary)
+	for _, r := range records {
+		if r.Time.Before(since) {
+			continue
+		}
+		key := keyOf(r)
+		row, ok := rows[key]
+		if !ok {
+			row = &Summary{Key: key}
+			rows[key] = row
+		}
+		row.Calls++
+		row.PromptTokens += r.PromptTokens
+		row.CompletionTokens += r.CompletionTokens
+		row.TotalTokens += r.TotalTokens
+		row.Cost += r.Cost
+	}
+
+	result := make([]Summary, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
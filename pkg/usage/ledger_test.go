@@ -0,0 +1,143 @@
+package usage
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestLedger_RecordPricesAndPersists(t *testing.T) {
+	dir := t.TempDir()
+	pricing := map[string]config.ModelPrice{
+		"glm-4.7":                {Input: 1, Output: 2},
+		"openrouter/claude-opus": {Input: 15, Output: 75},
+	}
+	l := NewLedger(dir, pricing)
+
+	if err := l.Record(Record{Model: "glm-4.7", Channel: "telegram", SenderID: "42|alice", PromptTokens: 1000, CompletionTokens: 500}); err != nil {
+		t.Fatalf("Record error: %v", err)
+	}
+	if err := l.Record(Record{Provider: "openrouter", Model: "claude-opus", Channel: "telegram", SenderID: "42", PromptTokens: 1000000}); err != nil {
+		t.Fatalf("Record error: %v", err)
+	}
+
+	today := l.Today(SenderScope("telegram", "42"))
+	if today.Calls != 2 {
+		t.Errorf("Calls = %d, want 2", today.Calls)
+	}
+	if today.Tokens != 1001500 {
+		t.Errorf("Tokens = %d, want 1001500", today.Tokens)
+	}
+	wantCost := 0.002 + 15.0
+	if math.Abs(today.Cost-wantCost) > 1e-9 {
+		t.Errorf("Cost = %f, want %f", today.Cost, wantCost)
+	}
+
+	// Totals survive a reload from disk
+	reloaded := NewLedger(dir, pricing)
+	if got := reloaded.ThisMonth(ChannelScope("telegram")); got.Calls != 2 {
+		t.Errorf("reloaded Calls = %d, want 2", got.Calls)
+	}
+}
+
+func TestLedger_PrunesPastMonths(t *testing.T) {
+	l := NewLedger(t.TempDir(), nil)
+	now := time.Now()
+	next := time.Date(now.Year(), now.Month()+1, 1, 9, 0, 0, 0, time.Local)
+
+	l.Record(Record{Model: "m", Channel: "telegram", SenderID: "42", TotalTokens: 10, Time: now})
+	l.Record(Record{Model: "m", Channel: "telegram", SenderID: "42", TotalTokens: 20, Time: next})
+
+	if len(l.totals) != 4 {
+		t.Errorf("kept %d totals, want 4 (day and month for sender and channel)", len(l.totals))
+	}
+	for key := range l.totals {
+		if !strings.HasSuffix(key, "|"+monthKey(next)) && !strings.HasSuffix(key, "|"+dayKey(next)) {
+			t.Errorf("past period kept: %s", key)
+		}
+	}
+}
+
+func TestLedger_CachePricing(t *testing.T) {
+	l := NewLedger(t.TempDir(), map[string]config.ModelPrice{
+		"claude": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+		"other":  {Input: 1, Output: 1},
+	})
+
+	l.Record(Record{Model: "claude", Channel: "cli", PromptTokens: 1000000, CacheReadTokens: 600000, CacheWriteTokens: 200000})
+	want := 0.2*3 + 0.6*0.3 + 0.2*3.75
+	if got := l.Today(ChannelScope("cli")).Cost; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Cost = %f, want %f", got, want)
+	}
+
+	// Without cache prices, cached tokens cost the input price
+	l.Record(Record{Model: "other", Channel: "slack", PromptTokens: 1000000, CacheReadTokens: 500000})
+	if got := l.Today(ChannelScope("slack")).Cost; math.Abs(got-1) > 1e-9 {
+		t.Errorf("Cost = %f, want 1", got)
+	}
+}
+
+func TestSummarize_GroupBy(t *testing.T) {
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	records := []Record{
+		{Time: day1, Model: "a", Channel: "telegram", SenderID: "1", TotalTokens: 10, Cost: 1},
+		{Time: day2, Model: "a", Channel: "telegram", SenderID: "2", TotalTokens: 20, Cost: 2},
+		{Time: day2, Model: "b", Channel: "discord", SenderID: "1", TotalTokens: 30, Cost: 3},
+	}
+
+	byDay, err := Summarize(records, "day", time.Time{})
+	if err != nil {
+		t.Fatalf("Summarize error: %v", err)
+	}
+	if len(byDay) != 2 || byDay[1].TotalTokens != 50 {
+		t.Errorf("by day = %+v", byDay)
+	}
+
+	byModel, _ := Summarize(records, "model", day2)
+	if len(byModel) != 2 || byModel[0].Key != "a" || byModel[0].Calls != 1 {
+		t.Errorf("by model since day2 = %+v", byModel)
+	}
+
+	byUser, _ := Summarize(records, "user", time.Time{})
+	if len(byUser) != 3 {
+		t.Errorf("by user = %+v", byUser)
+	}
+
+	if _, err := Summarize(records, "weekday", time.Time{}); err == nil {
+		t.Error("Summarize expected error for unknown grouping")
+	}
+}
+
+func TestBudget_Check(t *testing.T) {
+	l := NewLedger(t.TempDir(), map[string]config.ModelPrice{"m": {Input: 1000000, Output: 0}})
+	b := NewBudget(l, config.BudgetsConfig{
+		PerSender: config.BudgetLimit{DailyTokens: 100},
+		Senders:   map[string]config.BudgetLimit{"telegram:vip": {}},
+		Channels:  map[string]config.BudgetLimit{"discord": {MonthlyCost: 5}},
+	})
+
+	if over, _ := b.Check("telegram", "42"); over {
+		t.Fatal("fresh sender should not be over budget")
+	}
+
+	l.Record(Record{Model: "x", Channel: "telegram", SenderID: "42|bob", PromptTokens: 100})
+	if over, period := b.Check("telegram", "42|bob"); !over || period != PeriodDay {
+		t.Errorf("Check = %v, %q; want over daily budget", over, period)
+	}
+
+	// Explicit empty override means unlimited
+	l.Record(Record{Model: "x", Channel: "telegram", SenderID: "vip", PromptTokens: 1000})
+	if over, _ := b.Check("telegram", "vip"); over {
+		t.Error("vip sender should be unlimited")
+	}
+
+	// Channel cost budget
+	l.Record(Record{Model: "m", Channel: "discord", PromptTokens: 5})
+	if over, period := b.Check("discord", ""); !over || period != PeriodMonth {
+		t.Errorf("Check(discord) = %v, %q; want over monthly budget", over, period)
+	}
+}
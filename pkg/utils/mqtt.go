@@ -0,0 +1,23 @@
+package utils
+
+import "strings"
+
+// MQTTTopicMatch reports whether topic is covered by an MQTT filter with +
+// and # wildcards. topic may itself be a filter (for a subscription); a +
+// in the filter then doesn't cover a # level, which reaches further.
+func MQTTTopicMatch(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	levels := strings.Split(topic, "/")
+	for i, part := range f {
+		if part == "#" {
+			return true
+		}
+		if i >= len(levels) || levels[i] == "#" {
+			return false
+		}
+		if part != "+" && part != levels[i] {
+			return false
+		}
+	}
+	return len(f) == len(levels)
+}
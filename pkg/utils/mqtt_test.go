@@ -0,0 +1,27 @@
+package utils
+
+import "testing"
+
+func TestMQTTTopicMatch(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"home/#", "home", true},
+		{"home/#", "home/a/b", true},
+		{"home/#", "home/#", true},
+		{"home/+/set", "home/lamp/set", true},
+		{"home/+/set", "home/+/set", true},
+		{"home/+/set", "home/lamp/get", false},
+		{"home/+/set", "home/#", false},
+		{"home/lamp", "home/#", false},
+		{"home/+", "home/a/b", false},
+		{"home/a", "home", false},
+		{"#", "anything/at/all", true},
+	}
+	for _, tt := range tests {
+		if got := MQTTTopicMatch(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("MQTTTopicMatch(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}